@@ -0,0 +1,85 @@
+// Package aggregate helps writing event sourced aggregates on top of eventstore.Store.
+// The aggregates embed Root, change their state only in Apply and raise new events
+// with Raise, the Repository takes care of loading and storing them.
+package aggregate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/makkalot/eskit/lib/types"
+	"reflect"
+)
+
+var (
+	ErrNotFound            = errors.New("aggregate not found")
+	ErrConcurrencyConflict = errors.New("concurrency conflict")
+	ErrUnknownEvent        = errors.New("unknown event")
+	ErrInvalidArgument     = errors.New("invalid argument")
+)
+
+// Aggregate is an event sourced entity, its state is only changed via Apply
+type Aggregate interface {
+	// Apply changes the state with the event. It's called both for the new events and for
+	// the stored ones when the aggregate is loaded, the events are always pointers.
+	Apply(event interface{}) error
+	// PendingEvents returns the events raised since the aggregate was loaded or saved
+	PendingEvents() []interface{}
+	root() *Root
+}
+
+// Root is embedded into the aggregates, it keeps track of their originator and of the
+// events which are not saved yet
+type Root struct {
+	id string
+	// version of the last stored event
+	version uint64
+	pending []interface{}
+}
+
+// ID returns the id of the aggregate, it's empty for new aggregates until they're saved
+// unless it's set with SetID
+func (r *Root) ID() string {
+	return r.id
+}
+
+// SetID sets the id of a new aggregate
+func (r *Root) SetID(id string) {
+	r.id = id
+}
+
+// Version returns the version of the last stored event, new aggregates are at version 0
+func (r *Root) Version() uint64 {
+	return r.version
+}
+
+// Originator returns the originator of the aggregate including the pending events
+func (r *Root) Originator() *types.Originator {
+	return &types.Originator{
+		ID:      r.id,
+		Version: r.version + uint64(len(r.pending)),
+	}
+}
+
+func (r *Root) PendingEvents() []interface{} {
+	return r.pending
+}
+
+func (r *Root) root() *Root {
+	return r
+}
+
+// Raise applies the new event to the aggregate and records it to be stored on the next
+// Repository.Save, event should be a pointer to a registered event
+func Raise(a Aggregate, event interface{}) error {
+	if t := reflect.TypeOf(event); t == nil || t.Kind() != reflect.Ptr {
+		return fmt.Errorf("event %T should be a pointer : %w", event, ErrInvalidArgument)
+	}
+
+	if err := a.Apply(event); err != nil {
+		return err
+	}
+
+	r := a.root()
+	r.pending = append(r.pending, event)
+	return nil
+}
@@ -0,0 +1,106 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// EventNamer can be implemented by the events which want to control the name they're
+// stored under, by default the struct name is used
+type EventNamer interface {
+	EventName() string
+}
+
+// EventRegistry maps the Go types of the events of an aggregate to the names they're
+// stored under, so the stored events can be decoded back to their types
+type EventRegistry struct {
+	mu sync.RWMutex
+	// event name -> go type
+	types map[string]reflect.Type
+	// go type -> event name
+	names map[reflect.Type]string
+}
+
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{
+		types: map[string]reflect.Type{},
+		names: map[reflect.Type]string{},
+	}
+}
+
+// Register registers the types of the events, they can be passed as values or pointers.
+// It fails if another type is already registered with the same name.
+func (r *EventRegistry) Register(events ...interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, event := range events {
+		t := reflect.TypeOf(event)
+		if t == nil {
+			return fmt.Errorf("empty event : %w", ErrInvalidArgument)
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		if t.Kind() != reflect.Struct {
+			return fmt.Errorf("event %s is not a struct : %w", t, ErrInvalidArgument)
+		}
+
+		name := eventNameOf(t)
+		if existing, ok := r.types[name]; ok && existing != t {
+			return fmt.Errorf("event %s is already registered for %s : %w", name, existing, ErrInvalidArgument)
+		}
+
+		r.types[name] = t
+		r.names[t] = name
+	}
+
+	return nil
+}
+
+// NameOf returns the name the event is stored under
+func (r *EventRegistry) NameOf(event interface{}) (string, error) {
+	t := reflect.TypeOf(event)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name, ok := r.names[t]
+	if !ok {
+		return "", fmt.Errorf("%T is not registered : %w", event, ErrUnknownEvent)
+	}
+	return name, nil
+}
+
+// decode decodes the payload of the event name into a pointer of its registered type
+func (r *EventRegistry) decode(name, payload string) (interface{}, error) {
+	r.mu.RLock()
+	t, ok := r.types[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%s is not registered : %w", name, ErrUnknownEvent)
+	}
+
+	event := reflect.New(t).Interface()
+	if err := json.Unmarshal([]byte(payload), event); err != nil {
+		return nil, fmt.Errorf("decoding %s : %v", name, err)
+	}
+
+	return event, nil
+}
+
+func eventNameOf(t reflect.Type) string {
+	if namer, ok := reflect.New(t).Interface().(EventNamer); ok {
+		if name := namer.EventName(); name != "" {
+			return name
+		}
+	}
+	return t.Name()
+}
@@ -0,0 +1,231 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	uuid "github.com/satori/go.uuid"
+	"log"
+	"time"
+)
+
+// Repository loads and saves the aggregates of a type. The events are stored as
+// <aggregateType>.<EventName> so they end up in the aggregateType partition of the log.
+type Repository[A Aggregate] struct {
+	store         eventstore.Store
+	aggregateType string
+	events        *EventRegistry
+	factory       func() A
+	snapshotEvery uint64
+}
+
+// NewRepository creates a repository for the aggregates created by factory, the events
+// of the aggregate should be registered in events
+func NewRepository[A Aggregate](store eventstore.Store, aggregateType string, events *EventRegistry, factory func() A) (*Repository[A], error) {
+	return NewRepositoryWithSnapshots(store, aggregateType, events, factory, 0)
+}
+
+// NewRepositoryWithSnapshots is like NewRepository but when the store implements
+// eventstore.SnapshotStore it stores a snapshot every snapshotEvery versions and loads
+// the aggregates from their latest snapshot. The aggregates are snapshotted as JSON so
+// their state should be in exported fields.
+func NewRepositoryWithSnapshots[A Aggregate](store eventstore.Store, aggregateType string, events *EventRegistry, factory func() A, snapshotEvery uint64) (*Repository[A], error) {
+	if store == nil {
+		return nil, fmt.Errorf("empty store : %w", ErrInvalidArgument)
+	}
+
+	if aggregateType == "" {
+		return nil, fmt.Errorf("empty aggregate type : %w", ErrInvalidArgument)
+	}
+
+	if events == nil || factory == nil {
+		return nil, fmt.Errorf("empty events or factory : %w", ErrInvalidArgument)
+	}
+
+	return &Repository[A]{
+		store:         store,
+		aggregateType: aggregateType,
+		events:        events,
+		factory:       factory,
+		snapshotEvery: snapshotEvery,
+	}, nil
+}
+
+// Load replays the events of the aggregate with the given id, the purged aggregates are
+// not found
+func (r *Repository[A]) Load(id string) (A, error) {
+	var zero A
+	if id == "" {
+		return zero, fmt.Errorf("empty id : %w", ErrInvalidArgument)
+	}
+
+	aggregate := r.factory()
+	root := aggregate.root()
+	root.id = id
+
+	if snapshots, ok := r.snapshotStore(); ok {
+		snapshot, err := snapshots.GetSnapshot(id)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal([]byte(snapshot.Payload), aggregate); err != nil {
+				return zero, fmt.Errorf("restoring the snapshot of %s : %v", id, err)
+			}
+			root.version = snapshot.Originator.Version
+		case errors.Is(err, eventstore.ErrSnapshotNotFound):
+		default:
+			return zero, err
+		}
+	}
+
+	events, err := r.store.Get(&types.Originator{ID: id, Version: root.version + 1}, true)
+	if err != nil {
+		return zero, err
+	}
+
+	for _, e := range events {
+		// the events of a purged stream are tombstones
+		if eventstore.IsTombstone(e) || eventstore.IsPurged(e) {
+			return zero, fmt.Errorf("%s was purged : %w", id, ErrNotFound)
+		}
+
+		if entityType := common.ExtractEntityType(e); entityType != r.aggregateType {
+			return zero, fmt.Errorf("%s is a %s not a %s : %w", id, entityType, r.aggregateType, ErrNotFound)
+		}
+
+		event, err := r.events.decode(common.ExtractEventType(e), e.Payload)
+		if err != nil {
+			return zero, err
+		}
+
+		if err := aggregate.Apply(event); err != nil {
+			return zero, fmt.Errorf("applying %s version %d : %w", e.EventType, e.Originator.Version, err)
+		}
+
+		root.version = e.Originator.Version
+	}
+
+	if root.version == 0 {
+		return zero, fmt.Errorf("%s : %w", id, ErrNotFound)
+	}
+
+	return aggregate, nil
+}
+
+// Save appends the pending events of the aggregate. They're expected to follow the version
+// the aggregate was loaded at, if another writer saved events in the meantime it fails with
+// ErrConcurrencyConflict and the aggregate should be loaded again. When the store is an
+// eventstore.BatchAppender all of the events are stored in one transaction, so a failed
+// Save stores none of them. New aggregates without an id get a generated one.
+func (r *Repository[A]) Save(aggregate A) error {
+	root := aggregate.root()
+	if len(root.pending) == 0 {
+		return nil
+	}
+
+	// resolve all of the names first so an unknown event doesn't store the others
+	names := make([]string, 0, len(root.pending))
+	for _, event := range root.pending {
+		name, err := r.events.NameOf(event)
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+
+	if root.id == "" {
+		root.id = uuid.Must(uuid.NewV4()).String()
+	}
+
+	startVersion := root.version
+	batch := make([]*eventstore.BatchEntry, 0, len(root.pending))
+	for i, event := range root.pending {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("encoding %s : %v", names[i], err)
+		}
+
+		batch = append(batch, &eventstore.BatchEntry{Event: &types.Event{
+			Originator: &types.Originator{ID: root.id, Version: root.version + uint64(i) + 1},
+			EventType:  fmt.Sprintf("%s.%s", r.aggregateType, names[i]),
+			Payload:    string(payload),
+			OccurredOn: time.Now().UTC(),
+		}})
+	}
+
+	if err := r.append(root, batch); err != nil {
+		return err
+	}
+	root.pending = nil
+
+	r.snapshot(aggregate, startVersion)
+	return nil
+}
+
+// append stores the events of the batch, atomically when the store is an
+// eventstore.BatchAppender so a conflict doesn't store some of them. With the other stores
+// the events are appended one by one and the stored ones are not pending anymore.
+func (r *Repository[A]) append(root *Root, batch []*eventstore.BatchEntry) error {
+	if appender, ok := r.store.(eventstore.BatchAppender); ok {
+		if err := appender.AppendBatch(batch); err != nil {
+			if errors.Is(err, eventstore.ErrDuplicate) {
+				return fmt.Errorf("%s version %d : %w", root.id, root.version+1, ErrConcurrencyConflict)
+			}
+			return err
+		}
+		root.version += uint64(len(batch))
+		return nil
+	}
+
+	for i, entry := range batch {
+		if err := r.store.Append(entry.Event); err != nil {
+			root.pending = root.pending[i:]
+			if errors.Is(err, eventstore.ErrDuplicate) {
+				return fmt.Errorf("%s version %d : %w", root.id, entry.Event.Originator.Version, ErrConcurrencyConflict)
+			}
+			return err
+		}
+		root.version = entry.Event.Originator.Version
+	}
+	return nil
+}
+
+// snapshot stores a snapshot when the saved events crossed a multiple of snapshotEvery,
+// the events are already stored so failures are only logged
+func (r *Repository[A]) snapshot(aggregate A, fromVersion uint64) {
+	snapshots, ok := r.snapshotStore()
+	if !ok {
+		return
+	}
+
+	root := aggregate.root()
+	if root.version/r.snapshotEvery == fromVersion/r.snapshotEvery {
+		return
+	}
+
+	payload, err := json.Marshal(aggregate)
+	if err != nil {
+		log.Printf("snapshot of %s failed : %v", root.id, err)
+		return
+	}
+
+	err = snapshots.SaveSnapshot(&eventstore.Snapshot{
+		Originator: &types.Originator{ID: root.id, Version: root.version},
+		Payload:    string(payload),
+		CreatedOn:  time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("snapshot of %s failed : %v", root.id, err)
+	}
+}
+
+func (r *Repository[A]) snapshotStore() (eventstore.SnapshotStore, bool) {
+	if r.snapshotEvery == 0 {
+		return nil, false
+	}
+
+	snapshots, ok := r.store.(eventstore.SnapshotStore)
+	return snapshots, ok
+}
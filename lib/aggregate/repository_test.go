@@ -0,0 +1,265 @@
+package aggregate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type OrderPlaced struct {
+	Customer string
+}
+
+type ItemAdded struct {
+	Item  string
+	Price int
+}
+
+type OrderShipped struct{}
+
+func (OrderShipped) EventName() string {
+	return "Shipped"
+}
+
+type unregisteredEvent struct{}
+
+type Order struct {
+	Root
+	Customer string
+	Items    []string
+	Total    int
+	Shipped  bool
+	// applied counts the events applied since the order was created or loaded
+	applied int
+}
+
+func (o *Order) Apply(event interface{}) error {
+	switch e := event.(type) {
+	case *OrderPlaced:
+		o.Customer = e.Customer
+	case *ItemAdded:
+		if o.Shipped {
+			return errors.New("order is shipped")
+		}
+		o.Items = append(o.Items, e.Item)
+		o.Total += e.Price
+	case *OrderShipped:
+		o.Shipped = true
+	}
+	o.applied++
+	return nil
+}
+
+func newOrderEvents(t *testing.T) *EventRegistry {
+	events := NewEventRegistry()
+	assert.NoError(t, events.Register(OrderPlaced{}, &ItemAdded{}, OrderShipped{}))
+	return events
+}
+
+func TestEventRegistry(t *testing.T) {
+	events := newOrderEvents(t)
+
+	name, err := events.NameOf(&OrderShipped{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Shipped", name)
+
+	name, err = events.NameOf(ItemAdded{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ItemAdded", name)
+
+	_, err = events.NameOf(&unregisteredEvent{})
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+
+	assert.ErrorIs(t, events.Register("not a struct"), ErrInvalidArgument)
+	assert.ErrorIs(t, events.Register(nil), ErrInvalidArgument)
+
+	event, err := events.decode("ItemAdded", `{"Item":"book","Price":10}`)
+	assert.NoError(t, err)
+	assert.Equal(t, &ItemAdded{Item: "book", Price: 10}, event)
+
+	_, err = events.decode("Cancelled", `{}`)
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+}
+
+func TestRepository(t *testing.T) {
+	store := eventstore.NewInMemoryStore()
+	repository, err := NewRepository(store, "Order", newOrderEvents(t), func() *Order { return &Order{} })
+	assert.NoError(t, err)
+
+	_, err = repository.Load("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	order := &Order{}
+	assert.NoError(t, Raise(order, &OrderPlaced{Customer: "john"}))
+	assert.NoError(t, Raise(order, &ItemAdded{Item: "book", Price: 10}))
+	assert.ErrorIs(t, Raise(order, ItemAdded{}), ErrInvalidArgument)
+	assert.Len(t, order.PendingEvents(), 2)
+	assert.Equal(t, uint64(2), order.Originator().Version)
+
+	assert.NoError(t, repository.Save(order))
+	assert.NotEmpty(t, order.ID())
+	assert.Equal(t, uint64(2), order.Version())
+	assert.Empty(t, order.PendingEvents())
+
+	events, err := store.Get(&types.Originator{ID: order.ID()}, false)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "Order.OrderPlaced", events[0].EventType)
+	assert.Equal(t, "Order.ItemAdded", events[1].EventType)
+
+	loaded, err := repository.Load(order.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, "john", loaded.Customer)
+	assert.Equal(t, []string{"book"}, loaded.Items)
+	assert.Equal(t, uint64(2), loaded.Version())
+	assert.Equal(t, 2, loaded.applied)
+
+	t.Run("concurrent saves", func(t *testing.T) {
+		first, err := repository.Load(order.ID())
+		assert.NoError(t, err)
+		second, err := repository.Load(order.ID())
+		assert.NoError(t, err)
+
+		assert.NoError(t, Raise(first, &ItemAdded{Item: "pen", Price: 2}))
+		assert.NoError(t, repository.Save(first))
+
+		assert.NoError(t, Raise(second, &OrderShipped{}))
+		assert.ErrorIs(t, repository.Save(second), ErrConcurrencyConflict)
+		assert.Len(t, second.PendingEvents(), 1)
+
+		reloaded, err := repository.Load(order.ID())
+		assert.NoError(t, err)
+		assert.Equal(t, 12, reloaded.Total)
+		assert.False(t, reloaded.Shipped)
+	})
+
+	t.Run("unknown events are not stored", func(t *testing.T) {
+		loaded, err := repository.Load(order.ID())
+		assert.NoError(t, err)
+
+		assert.NoError(t, Raise(loaded, &OrderShipped{}))
+		assert.NoError(t, Raise(loaded, &unregisteredEvent{}))
+		assert.ErrorIs(t, repository.Save(loaded), ErrUnknownEvent)
+
+		events, err := store.Get(&types.Originator{ID: order.ID()}, false)
+		assert.NoError(t, err)
+		assert.Len(t, events, 3)
+	})
+
+	t.Run("purged aggregates", func(t *testing.T) {
+		purged := &Order{}
+		assert.NoError(t, Raise(purged, &OrderPlaced{Customer: "jane"}))
+		assert.NoError(t, repository.Save(purged))
+
+		_, err := store.(eventstore.Purger).Purge(purged.ID())
+		assert.NoError(t, err)
+
+		_, err = repository.Load(purged.ID())
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("other aggregate types", func(t *testing.T) {
+		assert.NoError(t, store.Append(&types.Event{
+			Originator: &types.Originator{ID: "user-1", Version: 1},
+			EventType:  "User.Created",
+			Payload:    `{}`,
+		}))
+
+		_, err := repository.Load("user-1")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestRepository_Snapshots(t *testing.T) {
+	store := eventstore.NewInMemoryStore()
+	repository, err := NewRepositoryWithSnapshots(store, "Order", newOrderEvents(t), func() *Order { return &Order{} }, 3)
+	assert.NoError(t, err)
+
+	order := &Order{}
+	order.SetID("order-1")
+	assert.NoError(t, Raise(order, &OrderPlaced{Customer: "john"}))
+	assert.NoError(t, Raise(order, &ItemAdded{Item: "book", Price: 10}))
+	assert.NoError(t, repository.Save(order))
+
+	snapshots := store.(eventstore.SnapshotStore)
+	_, err = snapshots.GetSnapshot("order-1")
+	assert.ErrorIs(t, err, eventstore.ErrSnapshotNotFound)
+
+	assert.NoError(t, Raise(order, &ItemAdded{Item: "pen", Price: 2}))
+	assert.NoError(t, Raise(order, &ItemAdded{Item: "cup", Price: 5}))
+	assert.NoError(t, repository.Save(order))
+
+	snapshot, err := snapshots.GetSnapshot("order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), snapshot.Originator.Version)
+
+	// only the events after the snapshot are applied
+	loaded, err := repository.Load("order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, loaded.applied)
+	assert.Equal(t, uint64(4), loaded.Version())
+	assert.Equal(t, 17, loaded.Total)
+	assert.Equal(t, []string{"book", "pen", "cup"}, loaded.Items)
+
+	assert.NoError(t, Raise(loaded, &OrderShipped{}))
+	assert.NoError(t, repository.Save(loaded))
+
+	loaded, err = repository.Load("order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded.applied)
+	assert.True(t, loaded.Shipped)
+
+	// repositories without snapshots replay everything
+	plain, err := NewRepository(store, "Order", newOrderEvents(t), func() *Order { return &Order{} })
+	assert.NoError(t, err)
+
+	loaded, err = plain.Load("order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, loaded.applied)
+	assert.True(t, loaded.Shipped)
+}
+
+// singleAppendFailingStore fails the appends of single events, so only AppendBatch stores
+type singleAppendFailingStore struct {
+	eventstore.StoreWithCleanup
+}
+
+func (s *singleAppendFailingStore) Append(event *types.Event) error {
+	return errors.New("append is not atomic")
+}
+
+func (s *singleAppendFailingStore) AppendBatch(entries []*eventstore.BatchEntry) error {
+	return s.StoreWithCleanup.(eventstore.BatchAppender).AppendBatch(entries)
+}
+
+func TestRepository_SaveBatch(t *testing.T) {
+	store := &singleAppendFailingStore{StoreWithCleanup: eventstore.NewInMemoryStore()}
+	repository, err := NewRepository(store, "Order", newOrderEvents(t), func() *Order { return &Order{} })
+	assert.NoError(t, err)
+
+	order := &Order{}
+	order.SetID("order-1")
+	assert.NoError(t, Raise(order, &OrderPlaced{Customer: "john"}))
+	assert.NoError(t, Raise(order, &ItemAdded{Item: "book", Price: 10}))
+	assert.NoError(t, repository.Save(order))
+	assert.Equal(t, uint64(2), order.Version())
+
+	// another writer stored version 3, none of the two events is stored
+	assert.NoError(t, store.AppendBatch([]*eventstore.BatchEntry{{Event: &types.Event{
+		Originator: &types.Originator{ID: "order-1", Version: 3},
+		EventType:  "Order.ItemAdded",
+		Payload:    `{"Item":"pen","Price":2}`,
+	}}}))
+
+	assert.NoError(t, Raise(order, &ItemAdded{Item: "cup", Price: 5}))
+	assert.NoError(t, Raise(order, &OrderShipped{}))
+	assert.ErrorIs(t, repository.Save(order), ErrConcurrencyConflict)
+	assert.Len(t, order.PendingEvents(), 2)
+
+	events, err := store.Get(&types.Originator{ID: "order-1"}, false)
+	assert.NoError(t, err)
+	assert.Len(t, events, 3)
+}
@@ -0,0 +1,400 @@
+package consumer
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+)
+
+const (
+	// DefaultStallAfter is the stall period of the lag monitor created by NewAdminHandler
+	DefaultStallAfter = time.Minute * 5
+)
+
+// AdminConsumer is a consumer listed by the AdminHandler
+type AdminConsumer struct {
+	*ConsumerLag
+	// Registered is set for the consumers of this process which can be paused
+	Registered bool `json:"registered"`
+	Running    bool `json:"running"`
+	Paused     bool `json:"paused"`
+}
+
+type adminError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// AdminHandler is an http.Handler to operate the consumers, mounted under a prefix
+// like /admin/consumers it serves:
+//
+//	GET  /admin/consumers                      the consumers with their offsets and lag
+//	GET  /admin/consumers?name=X               a single consumer
+//	POST /admin/consumers/reset?name=X&id=N    continue from the entry N
+//	POST /admin/consumers/reset?name=X&asOf=T  continue from the first entry at or after T (RFC 3339)
+//	POST /admin/consumers/reset?name=X&beginning=true
+//	POST /admin/consumers/pause?name=X
+//	POST /admin/consumers/resume?name=X
+//	GET  /admin/consumers/deadletters?name=X   the dead lettered entries of the consumer
+//
+// Only the consumers registered with Register can be paused, they have to be paused
+// before resetting their offset while they're running. The offsets of the consumers which
+// aren't registered are reset in the consumer store, it's refused while another instance
+// holds their leader lease, but a consumer running elsewhere without leader election
+// isn't detected and overwrites the reset offset with its own progress, so it has to be
+// stopped first.
+//
+// With WithToken the requests need an Authorization: Bearer <token> header.
+type AdminHandler struct {
+	storeClient   eventstore.Store
+	consumerStore consumerstore.Store
+	monitor       *LagMonitor
+
+	// requests are authorized with token when tokenRequired is set
+	tokenRequired bool
+	token         string
+
+	mu        sync.Mutex
+	consumers map[string]*AppLogConsumer
+}
+
+// NewAdminHandler creates the handler of the consumers in consumerStore
+func NewAdminHandler(storeClient eventstore.Store, consumerStore consumerstore.Store) (*AdminHandler, error) {
+	monitor, err := NewLagMonitor(storeClient, consumerStore, DefaultStallAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AdminHandler{
+		storeClient:   storeClient,
+		consumerStore: consumerStore,
+		monitor:       monitor,
+		consumers:     map[string]*AppLogConsumer{},
+	}, nil
+}
+
+// WithLagMonitor shares the monitor of the process, so the stalls are detected from all of the checks
+func (h *AdminHandler) WithLagMonitor(monitor *LagMonitor) *AdminHandler {
+	h.monitor = monitor
+	return h
+}
+
+// WithToken requires the requests to carry token as a bearer token, all of them are
+// refused when token is empty so the handler can be mounted before it's configured
+func (h *AdminHandler) WithToken(token string) *AdminHandler {
+	h.tokenRequired = true
+	h.token = token
+	return h
+}
+
+// Register adds the consumers running in this process so they can be paused and resumed
+func (h *AdminHandler) Register(consumers ...*AppLogConsumer) *AdminHandler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, c := range consumers {
+		h.consumers[c.Name()] = c
+	}
+	return h
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+
+	action := path.Base(r.URL.Path)
+	method := http.MethodPost
+	switch action {
+	case "reset", "pause", "resume":
+	case "deadletters":
+		method = http.MethodGet
+	default:
+		action, method = "", http.MethodGet
+	}
+
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if action != "" && name == "" {
+		writeAdminError(w, http.StatusBadRequest, "invalid_request", "Missing consumer name")
+		return
+	}
+
+	switch action {
+	case "":
+		h.list(w, r, name)
+	case "reset":
+		h.reset(w, r, name)
+	case "pause", "resume":
+		h.pause(w, r, name, action == "pause")
+	case "deadletters":
+		h.deadLetters(w, r, name)
+	}
+}
+
+// authorize checks the bearer token of the request, it writes the error and returns
+// false when the request isn't allowed
+func (h *AdminHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if !h.tokenRequired {
+		return true
+	}
+
+	if h.token == "" {
+		writeAdminError(w, http.StatusForbidden, "forbidden", "Consumer administration has no token configured")
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAdminError(w, http.StatusUnauthorized, "unauthorized", "Missing or invalid admin token")
+		return false
+	}
+	return true
+}
+
+func (h *AdminHandler) list(w http.ResponseWriter, r *http.Request, name string) {
+	lags, err := h.monitor.Check(r.Context())
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	byName := map[string]*ConsumerLag{}
+	for _, lag := range lags {
+		byName[lag.Consumer] = lag
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// the registered consumers which didn't save any progress yet are listed too
+	for consumerName := range h.consumers {
+		if _, ok := byName[consumerName]; !ok {
+			lag := &ConsumerLag{Consumer: consumerName}
+			byName[consumerName] = lag
+			lags = append(lags, lag)
+		}
+	}
+
+	sort.Slice(lags, func(i, j int) bool {
+		return lags[i].Consumer < lags[j].Consumer
+	})
+
+	consumers := []*AdminConsumer{}
+	for _, lag := range lags {
+		if name != "" && lag.Consumer != name {
+			continue
+		}
+
+		consumer := &AdminConsumer{ConsumerLag: lag}
+		if c, ok := h.consumers[lag.Consumer]; ok {
+			consumer.Registered, consumer.Running, consumer.Paused = true, c.Running(), c.Paused()
+		}
+		consumers = append(consumers, consumer)
+	}
+
+	if name == "" {
+		writeAdminJSON(w, http.StatusOK, consumers)
+		return
+	}
+
+	if len(consumers) == 0 {
+		writeAdminError(w, http.StatusNotFound, "not_found", "Consumer not found")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, consumers[0])
+}
+
+func (h *AdminHandler) reset(w http.ResponseWriter, r *http.Request, name string) {
+	offset, err := h.resetOffset(r)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	consumer := h.registered(name)
+	if consumer == nil {
+		// the progress of a consumer which doesn't run in this process
+		if !h.checkUnregistered(w, r, name) {
+			return
+		}
+
+		if consumer, err = NewAppLogConsumer(h.storeClient, h.consumerStore, name, FromSaved, "*"); err != nil {
+			writeAdminError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+	}
+
+	if err := consumer.ResetOffset(r.Context(), offset); err != nil {
+		switch {
+		case errors.Is(err, ErrNotPaused):
+			writeAdminError(w, http.StatusConflict, "not_paused", "The consumer has to be paused before resetting its offset")
+		case errors.Is(err, crudstore.InvalidArgumentError):
+			writeAdminError(w, http.StatusNotImplemented, "not_supported", err.Error())
+		default:
+			writeAdminError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		}
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{"consumer": name, "offset": offset})
+}
+
+// checkUnregistered writes an error and returns false when the progress of the consumer
+// name can't be reset from this process, because it's unknown or because another
+// instance holds its leader lease
+func (h *AdminHandler) checkUnregistered(w http.ResponseWriter, r *http.Request, name string) bool {
+	progress, err := h.consumerStore.List(r.Context())
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return false
+	}
+
+	found := false
+	for _, p := range progress {
+		if p.ConsumerId == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		writeAdminError(w, http.StatusNotFound, "not_found", "Consumer not found")
+		return false
+	}
+
+	groupStore, ok := h.consumerStore.(consumerstore.GroupStore)
+	if !ok {
+		return true
+	}
+
+	leases, err := groupStore.Leases(r.Context(), name)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return false
+	}
+	for _, lease := range leases {
+		if lease.Partition == leaderPartition {
+			writeAdminError(w, http.StatusConflict, "running", fmt.Sprintf("The consumer is running in %s, it has to be paused and reset there", lease.Owner))
+			return false
+		}
+	}
+	return true
+}
+
+// resetOffset returns the offset requested by the id, asOf or beginning parameter
+func (h *AdminHandler) resetOffset(r *http.Request) (uint64, error) {
+	query := r.URL.Query()
+	switch {
+	case query.Get("id") != "":
+		id, err := strconv.ParseUint(query.Get("id"), 10, 64)
+		if err != nil || id == 0 {
+			return 0, fmt.Errorf("invalid id %s", query.Get("id"))
+		}
+		return id - 1, nil
+
+	case query.Get("asOf") != "":
+		asOf, err := time.Parse(time.RFC3339, query.Get("asOf"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid asOf %s, expected RFC 3339", query.Get("asOf"))
+		}
+		return offsetAt(r.Context(), h.storeClient, asOf)
+
+	case query.Get("beginning") == "true":
+		return 0, nil
+
+	default:
+		return 0, errors.New("missing id, asOf or beginning")
+	}
+}
+
+// offsetAt returns the offset before the first entry whose event occurred at or after asOf,
+// the head of the log when there's none
+func offsetAt(ctx context.Context, storeClient eventstore.Store, asOf time.Time) (uint64, error) {
+	var offset uint64
+	for ctx.Err() == nil {
+		results, err := storeClient.Logs(offset+1, 1000, "")
+		if err != nil {
+			return 0, fmt.Errorf("fetch logs : %w", err)
+		}
+
+		if len(results) == 0 {
+			return offset, nil
+		}
+
+		for _, entry := range results {
+			if !entry.Event.OccurredOn.Before(asOf) {
+				return entry.ID - 1, nil
+			}
+			offset = entry.ID
+		}
+	}
+	return 0, ctx.Err()
+}
+
+func (h *AdminHandler) pause(w http.ResponseWriter, r *http.Request, name string, pause bool) {
+	consumer := h.registered(name)
+	if consumer == nil {
+		writeAdminError(w, http.StatusNotFound, "not_found", "Consumer is not registered in this process")
+		return
+	}
+
+	if pause {
+		consumer.Pause()
+	} else {
+		consumer.Resume()
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{"consumer": name, "paused": consumer.Paused()})
+}
+
+func (h *AdminHandler) deadLetters(w http.ResponseWriter, r *http.Request, name string) {
+	deadLetters, ok := h.consumerStore.(consumerstore.DeadLetterStore)
+	if !ok {
+		writeAdminError(w, http.StatusNotImplemented, "not_supported", "The consumer store doesn't keep dead letters")
+		return
+	}
+
+	letters, err := deadLetters.ListDeadLetters(r.Context(), name)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	if letters == nil {
+		letters = []*consumerstore.DeadLetter{}
+	}
+	writeAdminJSON(w, http.StatusOK, letters)
+}
+
+func (h *AdminHandler) registered(name string) *AppLogConsumer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.consumers[name]
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err string, message string) {
+	writeAdminJSON(w, status, adminError{Error: err, Message: message})
+}
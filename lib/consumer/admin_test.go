@@ -0,0 +1,197 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func adminRequest(t *testing.T, handler http.Handler, method, url string, result interface{}) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, url, nil))
+	if result != nil {
+		assert.NoError(t, json.NewDecoder(recorder.Body).Decode(result))
+	}
+	return recorder.Code
+}
+
+func TestAdminHandler_ListAndReset(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendInvoiceEvents(t, estore, 5)
+
+	ctx := context.Background()
+	assert.NoError(t, consumerStore.LogConsume(ctx, &consumerstore.AppLogConsumeProgress{ConsumerId: "billing", Offset: 2}))
+	assert.NoError(t, consumerStore.SaveDeadLetter(ctx, &consumerstore.DeadLetter{
+		ID:         "billing/2",
+		ConsumerId: "billing",
+		EntryID:    2,
+		Error:      "external api is down",
+	}))
+
+	handler, err := NewAdminHandler(estore, consumerStore)
+	assert.NoError(t, err)
+
+	var consumers []*AdminConsumer
+	assert.Equal(t, http.StatusOK, adminRequest(t, handler, http.MethodGet, "/admin/consumers", &consumers))
+	assert.Len(t, consumers, 1)
+	assert.Equal(t, "billing", consumers[0].Consumer)
+	assert.Equal(t, uint64(3), consumers[0].Entries)
+	assert.False(t, consumers[0].Registered)
+
+	assert.Equal(t, http.StatusNotFound, adminRequest(t, handler, http.MethodGet, "/admin/consumers?name=audit", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, adminRequest(t, handler, http.MethodGet, "/admin/consumers/reset?name=billing&id=1", nil))
+	assert.Equal(t, http.StatusBadRequest, adminRequest(t, handler, http.MethodPost, "/admin/consumers/reset?name=billing", nil))
+	assert.Equal(t, http.StatusNotFound, adminRequest(t, handler, http.MethodPost, "/admin/consumers/reset?name=audit&id=1", nil))
+
+	// another instance is the leader of the consumer
+	acquired, err := consumerStore.AcquireLease(ctx, "billing", leaderPartition, "other-instance", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, http.StatusConflict, adminRequest(t, handler, http.MethodPost, "/admin/consumers/reset?name=billing&id=5", nil))
+	assert.NoError(t, consumerStore.ReleaseLease(ctx, "billing", leaderPartition, "other-instance"))
+
+	assert.Equal(t, http.StatusOK, adminRequest(t, handler, http.MethodPost, "/admin/consumers/reset?name=billing&id=5", nil))
+	progress, err := consumerStore.GetLogConsume(ctx, "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), progress.Offset, "continues from the entry 5")
+
+	asOf := time.Now().UTC().Add(time.Hour).Format(time.RFC3339)
+	assert.Equal(t, http.StatusOK, adminRequest(t, handler, http.MethodPost, "/admin/consumers/reset?name=billing&asOf="+asOf, nil))
+	progress, err = consumerStore.GetLogConsume(ctx, "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), progress.Offset, "nothing happened after asOf")
+
+	assert.Equal(t, http.StatusOK, adminRequest(t, handler, http.MethodPost, "/admin/consumers/reset?name=billing&beginning=true", nil))
+	_, err = consumerStore.GetLogConsume(ctx, "billing")
+	assert.True(t, errors.Is(err, crudstore.RecordNotFound))
+
+	var letters []*consumerstore.DeadLetter
+	assert.Equal(t, http.StatusOK, adminRequest(t, handler, http.MethodGet, "/admin/consumers/deadletters?name=billing", &letters))
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "external api is down", letters[0].Error)
+}
+
+func TestAdminHandler_PauseAndReset(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendInvoiceEvents(t, estore, 4)
+
+	consumer, err := NewAppLogConsumer(estore, consumerStore, "billing", FromSaved, "*")
+	assert.NoError(t, err)
+
+	handler, err := NewAdminHandler(estore, consumerStore)
+	assert.NoError(t, err)
+	handler.Register(consumer)
+
+	// closed when the consumer blocks before the entry 4
+	blocked := make(chan struct{})
+	var blockedOnce sync.Once
+	consumer.control.onBlocked = func() {
+		blockedOnce.Do(func() { close(blocked) })
+	}
+
+	var mu sync.Mutex
+	var consumed []uint64
+	pausedOnce := false
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.Consume(ctx, func(entry *types.AppLogEntry) error {
+			mu.Lock()
+			defer mu.Unlock()
+			consumed = append(consumed, entry.ID)
+			if entry.ID == 1 && !pausedOnce {
+				assert.Equal(t, http.StatusConflict, adminRequest(t, handler, http.MethodPost, "/admin/consumers/reset?name=billing&id=2", nil))
+			}
+			// paused while the entry 3 is processed, so it stops before the entry 4
+			if entry.ID == 3 && !pausedOnce {
+				pausedOnce = true
+				assert.Equal(t, http.StatusOK, adminRequest(t, handler, http.MethodPost, "/admin/consumers/pause?name=billing", nil))
+			}
+			return nil
+		})
+	}()
+
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(consumed)
+	}
+	select {
+	case <-blocked:
+	case <-time.After(time.Second * 3):
+		t.Fatal("consumer didn't block while paused")
+	}
+	assert.Equal(t, 3, count(), "paused before the entry 4")
+
+	progress, err := consumerStore.GetLogConsume(ctx, "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), progress.Offset)
+
+	var listed AdminConsumer
+	assert.Equal(t, http.StatusOK, adminRequest(t, handler, http.MethodGet, "/admin/consumers?name=billing", &listed))
+	assert.True(t, listed.Registered)
+	assert.True(t, listed.Running)
+	assert.True(t, listed.Paused)
+	assert.Equal(t, uint64(1), listed.Entries)
+
+	assert.Equal(t, http.StatusOK, adminRequest(t, handler, http.MethodPost, "/admin/consumers/reset?name=billing&id=2", nil))
+
+	var resumed map[string]interface{}
+	assert.Equal(t, http.StatusOK, adminRequest(t, handler, http.MethodPost, "/admin/consumers/resume?name=billing", &resumed))
+	assert.Equal(t, false, resumed["paused"])
+
+	assert.Eventually(t, func() bool { return count() == 6 }, time.Second*3, time.Millisecond*20)
+	mu.Lock()
+	assert.Equal(t, []uint64{1, 2, 3, 2, 3, 4}, consumed, "continued from the reset offset")
+	mu.Unlock()
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.False(t, consumer.Running())
+
+	assert.Equal(t, http.StatusNotFound, adminRequest(t, handler, http.MethodPost, "/admin/consumers/pause?name=audit", nil))
+}
+
+func TestAdminHandler_Token(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+
+	handler, err := NewAdminHandler(estore, consumerStore)
+	assert.NoError(t, err)
+
+	request := func(authorization string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/consumers", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request(""))
+
+	// no token configured
+	handler.WithToken("")
+	assert.Equal(t, http.StatusForbidden, request(""))
+	assert.Equal(t, http.StatusForbidden, request("Bearer "))
+
+	handler.WithToken("secret")
+	assert.Equal(t, http.StatusUnauthorized, request(""))
+	assert.Equal(t, http.StatusUnauthorized, request("Bearer wrong"))
+	assert.Equal(t, http.StatusUnauthorized, request("secret"))
+	assert.Equal(t, http.StatusOK, request("Bearer secret"))
+}
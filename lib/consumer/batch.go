@@ -0,0 +1,187 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/types"
+)
+
+// ConsumeBatchCB processes the entries of a batch in the order of the log
+type ConsumeBatchCB func(entries []*types.AppLogEntry) error
+
+// BatchError is returned by a ConsumeBatchCB which processed the first Processed entries
+// of the batch and failed on the next one, so only the rest is redelivered
+type BatchError struct {
+	Processed int
+	Err       error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch failed after %d entries : %v", e.Processed, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ConsumeBatch calls cb with up to maxSize entries, a batch is delivered when it's full
+// or maxWait passed since its first entry was read, the logs are fetched in pages of
+// maxSize. The offset is saved once per batch after cb returns, so the entries of a
+// failing batch are delivered again. When cb fails it can return a BatchError to save
+// the progress of the entries it processed. Without a retry policy the error stops the
+// consumer like Consume does, with one the rest of the batch is retried and when the
+// retries run out the failing entry goes to the dead letters, or all of the remaining
+// ones when cb didn't return a BatchError.
+func (consumer *AppLogConsumer) ConsumeBatch(ctx context.Context, maxSize int, maxWait time.Duration, cb ConsumeBatchCB) error {
+	if maxSize <= 0 || maxWait < 0 {
+		return fmt.Errorf("invalid batch size %d or wait %s : %w", maxSize, maxWait, crudstore.InvalidArgumentError)
+	}
+
+	return consumer.run(ctx, func(ctx context.Context) error {
+		return consumer.consumeBatch(ctx, maxSize, maxWait, cb)
+	})
+}
+
+func (consumer *AppLogConsumer) consumeBatch(ctx context.Context, maxSize int, maxWait time.Duration, cb ConsumeBatchCB) error {
+	fromID, err := consumer.startOffset(ctx)
+	if err != nil {
+		return err
+	}
+
+	var batch []*types.AppLogEntry
+	var deadline time.Time
+
+	for {
+		// the entries of an unfinished batch are delivered again on the next start
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		pageSize := maxSize - len(batch)
+		results, err := consumer.storeClient.Logs(fromID, uint32(pageSize), "")
+		if err != nil {
+			return fmt.Errorf("fetch logs : %w", err)
+		}
+
+		for _, r := range results {
+			fromID = r.ID + 1
+			if !common.IsEventCompliant(r.Event, consumer.selector) {
+				continue
+			}
+
+			if len(batch) == 0 {
+				deadline = time.Now().Add(maxWait)
+			}
+			batch = append(batch, r)
+		}
+
+		if len(batch) > 0 && (len(batch) >= maxSize || !time.Now().Before(deadline)) {
+			if err := consumer.waitResumed(ctx); err != nil {
+				if errors.Is(err, errConsumerReset) {
+					return err
+				}
+				return nil
+			}
+
+			last, err := consumer.handleBatch(ctx, batch, cb)
+			if last > 0 {
+				if err := common.RetryShort(func() error {
+					return consumer.SaveProgress(ctx, last)
+				}); err != nil {
+					return err
+				}
+			}
+
+			if err != nil {
+				if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+					return nil
+				}
+				return err
+			}
+
+			batch = nil
+			continue
+		}
+
+		if len(results) < pageSize {
+			wait := time.Millisecond * 100
+			if len(batch) > 0 && time.Until(deadline) < wait {
+				wait = time.Until(deadline)
+			}
+			sleepContext(ctx, wait)
+		}
+	}
+}
+
+// handleBatch calls cb for the batch with the retry policy of the consumer, it returns
+// the ID of the last entry which was processed or sent to the dead letters
+func (consumer *AppLogConsumer) handleBatch(ctx context.Context, batch []*types.AppLogEntry, cb ConsumeBatchCB) (uint64, error) {
+	policy := RetryPolicy{MaxAttempts: 1}
+	var deadLetters consumerstore.DeadLetterStore
+	if consumer.retry != nil {
+		policy, deadLetters = consumer.retry.policy, consumer.retry.deadLetters
+	}
+
+	var last uint64
+	remaining := batch
+	for len(remaining) > 0 {
+		positional := false
+		attempts, err := policy.run(ctx, consumer.name, func() error {
+			err := cb(remaining)
+			if err == nil {
+				last = remaining[len(remaining)-1].ID
+				remaining = nil
+				return nil
+			}
+
+			var batchErr *BatchError
+			if !errors.As(err, &batchErr) {
+				positional = false
+				return err
+			}
+
+			processed := batchErr.Processed
+			if processed < 0 || processed >= len(remaining) {
+				processed = 0
+			}
+			if processed > 0 {
+				last = remaining[processed-1].ID
+				remaining = remaining[processed:]
+			}
+
+			positional = true
+			if batchErr.Err == nil {
+				return errors.New(batchErr.Error())
+			}
+			return batchErr.Err
+		})
+		if err == nil {
+			return last, nil
+		}
+
+		if isConsumerStopError(err) || errors.Is(err, context.Canceled) || deadLetters == nil {
+			return last, err
+		}
+
+		failed := remaining
+		if positional {
+			failed = remaining[:1]
+		}
+
+		for _, entry := range failed {
+			if err := consumer.saveDeadLetter(ctx, entry, err, attempts); err != nil {
+				return last, err
+			}
+			last = entry.ID
+		}
+		remaining = remaining[len(failed):]
+	}
+
+	return last, nil
+}
@@ -0,0 +1,135 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingConsumerStore counts the progress writes
+type countingConsumerStore struct {
+	*consumerstore.InMemoryConsumerApiProvider
+	saves int
+}
+
+func (s *countingConsumerStore) LogConsume(ctx context.Context, request *consumerstore.AppLogConsumeProgress) error {
+	s.saves++
+	return s.InMemoryConsumerApiProvider.LogConsume(ctx, request)
+}
+
+func entryIDs(entries []*types.AppLogEntry) []uint64 {
+	var ids []uint64
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestAppLogConsumer_ConsumeBatch(t *testing.T) {
+	consumerStore := &countingConsumerStore{InMemoryConsumerApiProvider: consumerstore.NewInMemoryConsumerApiProvider()}
+	estore := eventstore.NewInMemoryStore()
+	appendInvoiceEvents(t, estore, 25)
+
+	consumer, err := NewAppLogConsumer(estore, consumerStore, "billing", FromBeginning, "*")
+	assert.NoError(t, err)
+
+	var sizes []int
+	consumed := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	err = consumer.ConsumeBatch(ctx, 10, time.Millisecond*50, func(entries []*types.AppLogEntry) error {
+		sizes = append(sizes, len(entries))
+		consumed += len(entries)
+		if consumed == 25 {
+			cancel()
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 10, 5}, sizes, "the last batch is delivered after maxWait")
+	assert.Equal(t, 3, consumerStore.saves, "the offset is saved once per batch")
+
+	progress, err := consumerStore.GetLogConsume(context.Background(), "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(25), progress.Offset)
+
+	t.Run("invalid arguments", func(tt *testing.T) {
+		err := consumer.ConsumeBatch(context.Background(), 0, time.Second, func(entries []*types.AppLogEntry) error { return nil })
+		assert.Error(tt, err)
+	})
+}
+
+func TestAppLogConsumer_ConsumeBatchPartialFailure(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendInvoiceEvents(t, estore, 6)
+
+	consumer, err := NewAppLogConsumer(estore, consumerStore, "billing", FromSaved, "*")
+	assert.NoError(t, err)
+
+	err = consumer.ConsumeBatch(context.Background(), 5, time.Millisecond*10, func(entries []*types.AppLogEntry) error {
+		return &BatchError{Processed: 3, Err: fmt.Errorf("failed")}
+	})
+	assert.EqualError(t, err, "failed")
+
+	progress, err := consumerStore.GetLogConsume(context.Background(), "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), progress.Offset, "the processed entries are saved")
+
+	t.Run("without position", func(tt *testing.T) {
+		var delivered []uint64
+		err = consumer.ConsumeBatch(context.Background(), 5, time.Millisecond*10, func(entries []*types.AppLogEntry) error {
+			delivered = entryIDs(entries)
+			return fmt.Errorf("failed")
+		})
+		assert.EqualError(tt, err, "failed")
+		assert.Equal(tt, []uint64{4, 5, 6}, delivered)
+
+		progress, err := consumerStore.GetLogConsume(context.Background(), "billing")
+		assert.NoError(tt, err)
+		assert.Equal(tt, uint64(3), progress.Offset, "the failed batch is delivered again")
+	})
+}
+
+func TestAppLogConsumer_ConsumeBatchDeadLetters(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendInvoiceEvents(t, estore, 5)
+
+	consumer, err := NewAppLogConsumer(estore, consumerStore, "billing", FromBeginning, "*")
+	assert.NoError(t, err)
+	consumer.WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}, consumerStore)
+
+	var calls [][]uint64
+	ctx, cancel := context.WithCancel(context.Background())
+	err = consumer.ConsumeBatch(ctx, 5, time.Millisecond*10, func(entries []*types.AppLogEntry) error {
+		calls = append(calls, entryIDs(entries))
+		for i, e := range entries {
+			if e.ID == 2 {
+				return &BatchError{Processed: i, Err: fmt.Errorf("poison")}
+			}
+		}
+		cancel()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]uint64{{1, 2, 3, 4, 5}, {2, 3, 4, 5}, {3, 4, 5}}, calls,
+		"the rest of the batch is retried and the failing entry skipped after the retries")
+
+	letters, err := consumer.DeadLetters(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, uint64(2), letters[0].Entry.ID)
+		assert.Equal(t, "poison", letters[0].Error)
+		assert.Equal(t, 2, letters[0].Attempts)
+	}
+
+	progress, err := consumerStore.GetLogConsume(context.Background(), "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), progress.Offset)
+}
@@ -24,6 +24,12 @@ type AppLogConsumer struct {
 	consumerStore consumerstore.Store
 	storeClient   eventstore.Store
 	selector      string
+	// election is set when only the leader of the instances with the same name consumes
+	election *leaderElection
+	// retry is set when the failing callbacks are retried
+	retry *retryState
+	// control pauses and resets the running consumer
+	control control
 }
 
 const (
@@ -39,6 +45,8 @@ var (
 )
 
 type ConsumeCB func(entry *types.AppLogEntry) error
+// ConsumeCrudCb is called by the CrudConsumer with the decoded states of an entity before
+// and after a change, oldMessage is nil for creations and newMessage for deletions
 type ConsumeCrudCb func(entityType string, oldMessage, newMessage interface{})
 
 func NewAppLogConsumer(storeClient eventstore.Store, consumerStore consumerstore.Store, name string, offset LogOffset, selector string) (*AppLogConsumer, error) {
@@ -54,6 +62,16 @@ func NewAppLogConsumer(storeClient eventstore.Store, consumerStore consumerstore
 // Consume starts consuming entries on cb
 // success the offset is saved to the server so on crash continues
 func (consumer *AppLogConsumer) Consume(ctx context.Context, cb ConsumeCB) error {
+	return consumer.run(ctx, func(ctx context.Context) error {
+		return consumer.consume(ctx, cb)
+	})
+}
+
+func (consumer *AppLogConsumer) consume(ctx context.Context, cb ConsumeCB) error {
+	// stops the stream when the callback fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	ch, chErr, err := consumer.Stream(ctx)
 	if err != nil {
 		return err
@@ -63,9 +81,22 @@ func (consumer *AppLogConsumer) Consume(ctx context.Context, cb ConsumeCB) error
 		select {
 		case entry, ok := <-ch:
 			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
 				return io.EOF
 			}
-			if err := cb(entry); err != nil {
+			if err := consumer.waitResumed(ctx); err != nil {
+				if errors.Is(err, errConsumerReset) {
+					return err
+				}
+				return nil
+			}
+			if err := consumer.handle(ctx, entry, cb); err != nil {
+				// the entry is consumed again on the next start
+				if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+					return nil
+				}
 				return err
 			}
 
@@ -90,30 +121,14 @@ func (consumer *AppLogConsumer) Consume(ctx context.Context, cb ConsumeCB) error
 }
 
 func (consumer *AppLogConsumer) Stream(ctx context.Context) (chan *types.AppLogEntry, chan error, error) {
-	var fromID uint64
-	if consumer.offset == FromBeginning {
-		fromID = 1
-	} else if consumer.offset == FromSaved {
-		resp, err := consumer.consumerStore.GetLogConsume(
-			ctx,
-			consumer.name,
-		)
-		if err != nil {
-			if !errors.Is(err, crudstore.RecordNotFound) {
-				return nil, nil, err
-			}
-			fromID = 1
-		} else {
-			fromID = resp.Offset + 1
-		}
-
-		log.Println("starting the consuming from offset : ", fromID)
-	} else {
-		return nil, nil, fmt.Errorf("invalid offset supplied")
+	fromID, err := consumer.startOffset(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	ch := make(chan *types.AppLogEntry)
-	chErr := make(chan error)
+	// buffered so the goroutine can exit after the consumer returned
+	chErr := make(chan error, 1)
 	lastIDInt := fromID
 
 	go func() {
@@ -128,6 +143,7 @@ func (consumer *AppLogConsumer) Stream(ctx context.Context) (chan *types.AppLogE
 			select {
 			case <- ctx.Done():
 				chErr <- ctx.Err()
+				return
 			default:
 
 			}
@@ -145,7 +161,11 @@ func (consumer *AppLogConsumer) Stream(ctx context.Context) (chan *types.AppLogE
 
 			for _, r := range results {
 				if common.IsEventCompliant(r.Event, consumer.selector) {
-					ch <- r
+					select {
+					case ch <- r:
+					case <-ctx.Done():
+						return
+					}
 				}
 
 				nextID := results[len(results)-1].ID
@@ -158,6 +178,34 @@ func (consumer *AppLogConsumer) Stream(ctx context.Context) (chan *types.AppLogE
 	return ch, chErr, nil
 }
 
+// startOffset returns the first log ID to consume
+func (consumer *AppLogConsumer) startOffset(ctx context.Context) (uint64, error) {
+	// a loop restarted after a reset continues from the saved progress
+	if !consumer.restartedFromSaved() {
+		if consumer.offset == FromBeginning {
+			return 1, nil
+		} else if consumer.offset != FromSaved {
+			return 0, fmt.Errorf("invalid offset supplied")
+		}
+	}
+
+	fromID := uint64(1)
+	resp, err := consumer.consumerStore.GetLogConsume(
+		ctx,
+		consumer.name,
+	)
+	if err != nil {
+		if !errors.Is(err, crudstore.RecordNotFound) {
+			return 0, err
+		}
+	} else {
+		fromID = resp.Offset + 1
+	}
+
+	log.Println("starting the consuming from offset : ", fromID)
+	return fromID, nil
+}
+
 func (consumer *AppLogConsumer) SaveProgress(ctx context.Context, offset uint64) error {
 	err := consumer.consumerStore.LogConsume(ctx, &consumerstore.AppLogConsumeProgress{
 		ConsumerId: consumer.name,
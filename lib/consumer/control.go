@@ -0,0 +1,230 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+)
+
+var (
+	// ErrNotPaused is returned when the offset of a running consumer is reset without pausing it
+	ErrNotPaused = errors.New("consumer is not paused")
+
+	// errConsumerReset stops the consuming loop so it's restarted from the reset offset
+	errConsumerReset = errors.New("consumer offset was reset")
+)
+
+// control pauses and resets a running consumer, the zero value is a consumer which runs
+type control struct {
+	mu      sync.Mutex
+	running bool
+	paused  bool
+	// resumed is closed when a paused consumer is resumed
+	resumed chan struct{}
+	// reset is the offset a paused consumer continues from when it's resumed, it's
+	// saved already and only restarts the consuming loop
+	reset *uint64
+	// cancel stops the current consuming loop so a reset applies on resume even when
+	// there's nothing new in the log
+	cancel context.CancelFunc
+	// fromSaved makes the restarted loop continue from the saved progress regardless of
+	// the offset the consumer was created with
+	fromSaved bool
+	// onBlocked is called when the consuming loop blocks because it's paused, it's a test hook
+	onBlocked func()
+}
+
+// Name returns the name the progress of the consumer is saved with
+func (consumer *AppLogConsumer) Name() string {
+	return consumer.name
+}
+
+// Pause stops a running consumer before its next entry, the entries which are already
+// being processed are finished
+func (consumer *AppLogConsumer) Pause() {
+	c := &consumer.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.paused {
+		c.paused = true
+		c.resumed = make(chan struct{})
+	}
+}
+
+// Resume continues a paused consumer, from the reset offset when it was reset meanwhile
+func (consumer *AppLogConsumer) Resume() {
+	c := &consumer.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+		if c.reset != nil && c.cancel != nil {
+			c.cancel()
+		}
+	}
+}
+
+// Paused returns true when the consumer is paused
+func (consumer *AppLogConsumer) Paused() bool {
+	c := &consumer.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// Running returns true while one of the Consume methods runs, a consumer with leader
+// election only runs while it's the leader
+func (consumer *AppLogConsumer) Running() bool {
+	c := &consumer.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+// ResetOffset moves the saved progress so the consumer continues after offset, 0 starts
+// from the beginning of the log. A running consumer has to be paused first, it continues
+// from offset when it's resumed. The consumers created with FromBeginning always start
+// from the beginning when they're started again.
+func (consumer *AppLogConsumer) ResetOffset(ctx context.Context, offset uint64) error {
+	c := &consumer.control
+	c.mu.Lock()
+	running := c.running
+	if running && !c.paused {
+		c.mu.Unlock()
+		return fmt.Errorf("resetting %s : %w", consumer.name, ErrNotPaused)
+	}
+	c.mu.Unlock()
+
+	// saved right away so the reset isn't lost when the consumer stops before it's resumed
+	if err := consumer.saveOffset(ctx, offset); err != nil {
+		return err
+	}
+
+	if running {
+		c.mu.Lock()
+		if c.running {
+			c.reset = &offset
+		}
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// saveOffset replaces the saved progress with offset
+func (consumer *AppLogConsumer) saveOffset(ctx context.Context, offset uint64) error {
+	if offset > 0 {
+		return common.RetryShort(func() error {
+			return consumer.SaveProgress(ctx, offset)
+		})
+	}
+
+	resetter, ok := consumer.consumerStore.(consumerstore.ProgressResetter)
+	if !ok {
+		return fmt.Errorf("consumer store can't reset progress : %w", crudstore.InvalidArgumentError)
+	}
+	return resetter.ResetLogConsume(ctx, consumer.name)
+}
+
+// waitResumed blocks while the consumer is paused, it returns errConsumerReset when the
+// offset was reset while it was paused
+func (consumer *AppLogConsumer) waitResumed(ctx context.Context) error {
+	c := &consumer.control
+	c.mu.Lock()
+	paused, resumed, onBlocked := c.paused, c.resumed, c.onBlocked
+	c.mu.Unlock()
+
+	if paused {
+		if onBlocked != nil {
+			onBlocked()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resumed:
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reset != nil {
+		return errConsumerReset
+	}
+	return nil
+}
+
+// run calls consume as the leader when the consumer has leader election, it's started
+// again from the saved progress when the offset was reset while it was paused
+func (consumer *AppLogConsumer) run(ctx context.Context, consume func(ctx context.Context) error) error {
+	if consumer.election != nil {
+		return consumer.consumeAsLeader(ctx, func(ctx context.Context) error {
+			return consumer.runResettable(ctx, consume)
+		})
+	}
+	return consumer.runResettable(ctx, consume)
+}
+
+func (consumer *AppLogConsumer) runResettable(ctx context.Context, consume func(ctx context.Context) error) error {
+	c := &consumer.control
+	c.mu.Lock()
+	c.running = true
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.running = false
+		c.reset = nil
+		c.cancel = nil
+		c.fromSaved = false
+		c.mu.Unlock()
+	}()
+
+	for {
+		loopCtx, cancel := context.WithCancel(ctx)
+		c.mu.Lock()
+		c.cancel = cancel
+		c.mu.Unlock()
+
+		err := consume(loopCtx)
+		cancel()
+
+		c.mu.Lock()
+		reset := c.reset
+		c.reset = nil
+		c.cancel = nil
+		c.mu.Unlock()
+
+		// the loop is cancelled by Resume when it was reset, other errors stop the consumer
+		restart := err == nil || errors.Is(err, errConsumerReset) || errors.Is(err, context.Canceled)
+		if reset == nil || ctx.Err() != nil || !restart {
+			if errors.Is(err, errConsumerReset) {
+				return nil
+			}
+			return err
+		}
+
+		// saved again as the entries in flight while it was paused may have moved the progress
+		if err := consumer.saveOffset(ctx, *reset); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		c.fromSaved = true
+		c.mu.Unlock()
+	}
+}
+
+// restartedFromSaved returns true when the consuming loop was restarted after a reset
+func (consumer *AppLogConsumer) restartedFromSaved() bool {
+	c := &consumer.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fromSaved
+}
@@ -0,0 +1,84 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppLogConsumer_ResetIdle(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendInvoiceEvents(t, estore, 3)
+
+	consumer, err := NewAppLogConsumer(estore, consumerStore, "billing", FromBeginning, "*")
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	var consumed []uint64
+	// closed when the entry 3 is consumed for the given time
+	consumedLast := []chan struct{}{make(chan struct{}), make(chan struct{}), make(chan struct{})}
+	start := func(ctx context.Context) chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- consumer.Consume(ctx, func(entry *types.AppLogEntry) error {
+				mu.Lock()
+				defer mu.Unlock()
+				consumed = append(consumed, entry.ID)
+				if entry.ID == 3 {
+					last := 0
+					for _, id := range consumed {
+						if id == 3 {
+							last++
+						}
+					}
+					close(consumedLast[last-1])
+				}
+				return nil
+			})
+		}()
+		return done
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := start(ctx)
+	waitFor(t, consumedLast[0], "consumer didn't reach the end of the log")
+	assert.Eventually(t, func() bool { return savedOffset(t, consumerStore, "billing") == 3 }, time.Second*3, time.Millisecond*20)
+
+	// nothing new in the log, the reset applies when it's resumed
+	consumer.Pause()
+	assert.NoError(t, consumer.ResetOffset(ctx, 1))
+	assert.Equal(t, uint64(1), savedOffset(t, consumerStore, "billing"), "saved before it's resumed")
+	consumer.Resume()
+
+	waitFor(t, consumedLast[1], "consumer didn't continue from the reset offset")
+	mu.Lock()
+	assert.Equal(t, []uint64{1, 2, 3, 2, 3}, consumed)
+	mu.Unlock()
+
+	// stopped before it's resumed, the reset is kept
+	consumer.Pause()
+	assert.NoError(t, consumer.ResetOffset(ctx, 2))
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, uint64(2), savedOffset(t, consumerStore, "billing"))
+
+	// FromBeginning still starts from the beginning after a reset
+	consumer.Resume()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	done = start(ctx)
+	waitFor(t, consumedLast[2], "consumer didn't start again")
+	mu.Lock()
+	assert.Equal(t, []uint64{1, 2, 3, 2, 3, 1, 2, 3}, consumed)
+	mu.Unlock()
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,151 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+)
+
+// CrudConsumer tails the application log of a crud entity type and calls back with the
+// state of the entity before and after every change, decoded into its registered Go type.
+// The old state is nil for creations and the new one for deletions.
+type CrudConsumer struct {
+	consumer   *AppLogConsumer
+	client     crudstore.Client
+	entityType string
+	goType     reflect.Type
+	// partitions are the entity type and its aliases
+	partitions map[string]bool
+}
+
+// NewCrudConsumer creates a consumer for entityType which resolves the Go types from
+// the crudstore.DefaultRegistry
+func NewCrudConsumer(storeClient eventstore.Store, consumerStore consumerstore.Store, name string, offset LogOffset, entityType string) (*CrudConsumer, error) {
+	return NewCrudConsumerWithRegistry(storeClient, consumerStore, name, offset, entityType, crudstore.DefaultRegistry)
+}
+
+// NewCrudConsumerWithRegistry creates a consumer for entityType, it should be registered
+// in the registry so the states can be decoded
+func NewCrudConsumerWithRegistry(storeClient eventstore.Store, consumerStore consumerstore.Store, name string, offset LogOffset, entityType string, registry *crudstore.EntityRegistry) (*CrudConsumer, error) {
+	if registry == nil {
+		return nil, fmt.Errorf("empty registry")
+	}
+
+	entityType = registry.Resolve(entityType)
+	goType, ok := registry.TypeOf(entityType)
+	if !ok {
+		return nil, fmt.Errorf("entity type %s is not registered : %w", entityType, crudstore.InvalidArgumentError)
+	}
+
+	crudStore, err := crudstore.NewCrudStoreProviderWithRegistry(context.Background(), storeClient, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := NewAppLogConsumer(storeClient, consumerStore, name, offset, "*")
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := map[string]bool{entityType: true}
+	for _, alias := range registry.Aliases(entityType) {
+		partitions[alias] = true
+	}
+
+	return &CrudConsumer{
+		consumer:   consumer,
+		client:     crudstore.NewClientWithStore(crudStore),
+		entityType: entityType,
+		goType:     goType,
+		partitions: partitions,
+	}, nil
+}
+
+// Consume calls cb for every change of the entities, the offset is saved after each
+// entry like AppLogConsumer.Consume does. The events which don't change the state and
+// the erased ones of purged entities are skipped.
+func (c *CrudConsumer) Consume(ctx context.Context, cb ConsumeCrudCb) error {
+	return c.consumer.Consume(ctx, func(entry *types.AppLogEntry) error {
+		if !c.partitions[common.ExtractEntityType(entry.Event)] {
+			return nil
+		}
+
+		if eventstore.IsTombstone(entry.Event) || eventstore.IsPurged(entry.Event) {
+			return nil
+		}
+
+		oldMessage, newMessage, changed, err := c.states(entry.Event)
+		if err != nil {
+			return fmt.Errorf("reconstructing %s : %w", entry.Event.Originator.ID, err)
+		}
+
+		if changed {
+			cb(c.entityType, oldMessage, newMessage)
+		}
+		return nil
+	})
+}
+
+// states returns the state of the entity before and after the event, nil when the
+// entity didn't exist or was deleted
+func (c *CrudConsumer) states(event *types.Event) (interface{}, interface{}, bool, error) {
+	var oldMessage interface{}
+	if event.Originator.Version > 1 {
+		msg, err := c.stateAt(event.Originator.ID, event.Originator.Version-1)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		oldMessage = msg
+	}
+
+	newMessage, err := c.stateAt(event.Originator.ID, event.Originator.Version)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if oldMessage == nil && newMessage == nil {
+		return nil, nil, false, nil
+	}
+
+	// the originators differ even when nothing else changed so only the states are compared
+	if oldMessage != nil && newMessage != nil && reflect.DeepEqual(c.withoutOriginator(oldMessage), c.withoutOriginator(newMessage)) {
+		return nil, nil, false, nil
+	}
+
+	return oldMessage, newMessage, true, nil
+}
+
+func (c *CrudConsumer) stateAt(originatorID string, version uint64) (interface{}, error) {
+	msg := reflect.New(c.goType).Interface()
+	err := c.client.Get(&types.Originator{ID: originatorID, Version: version}, msg, false)
+	if crudstore.IsErrNotFound(err) || crudstore.IsErrDeleted(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// withoutOriginator returns a copy of the state with an empty Originator field
+func (c *CrudConsumer) withoutOriginator(msg interface{}) interface{} {
+	copied := reflect.New(c.goType).Elem()
+	copied.Set(reflect.ValueOf(msg).Elem())
+
+	if copied.Kind() != reflect.Struct {
+		return copied.Interface()
+	}
+
+	if field := copied.FieldByName("Originator"); field.IsValid() && field.CanSet() {
+		field.Set(reflect.Zero(field.Type()))
+	}
+
+	return copied.Interface()
+}
@@ -0,0 +1,115 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type Camera struct {
+	Originator *types.Originator
+	Name       string
+	Gain       int
+}
+
+type crudChange struct {
+	entityType string
+	old, new   *Camera
+}
+
+func TestCrudConsumer(t *testing.T) {
+	registry := crudstore.NewEntityRegistry()
+	_, err := registry.Register(Camera{}, "Cam")
+	assert.NoError(t, err)
+
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+
+	_, err = NewCrudConsumerWithRegistry(estore, consumerStore, "cameras", FromBeginning, "Unknown", registry)
+	assert.ErrorIs(t, err, crudstore.InvalidArgumentError)
+
+	crudStore, err := crudstore.NewCrudStoreProviderWithRegistry(context.Background(), estore, registry)
+	assert.NoError(t, err)
+	client := crudstore.NewClientWithStore(crudStore)
+
+	// the events stored under the old name are consumed as well
+	assert.NoError(t, estore.Append(&types.Event{
+		Originator: &types.Originator{ID: "cam-0", Version: 1},
+		EventType:  "Cam.Created",
+		Payload:    `{"Name":"legacy"}`,
+		OccurredOn: time.Now().UTC(),
+	}))
+
+	camera := &Camera{Name: "entrance", Gain: 1}
+	_, err = client.Create(camera)
+	assert.NoError(t, err)
+
+	assert.NoError(t, estore.Append(&types.Event{
+		Originator: &types.Originator{ID: "order-1", Version: 1},
+		EventType:  "Order.Created",
+		Payload:    `{}`,
+		OccurredOn: time.Now().UTC(),
+	}))
+
+	camera.Gain = 2
+	_, err = client.Update(camera)
+	assert.NoError(t, err)
+
+	// nothing changes so there's no callback
+	_, err = client.Update(camera)
+	assert.NoError(t, err)
+
+	_, err = client.Delete(&types.Originator{ID: camera.Originator.ID}, &Camera{})
+	assert.NoError(t, err)
+
+	consumer, err := NewCrudConsumerWithRegistry(estore, consumerStore, "cameras", FromBeginning, "Camera", registry)
+	assert.NoError(t, err)
+
+	var changes []crudChange
+	ctx, cancel := context.WithCancel(context.Background())
+	err = consumer.Consume(ctx, func(entityType string, oldMessage, newMessage interface{}) {
+		change := crudChange{entityType: entityType}
+		if oldMessage != nil {
+			change.old = oldMessage.(*Camera)
+		}
+		if newMessage != nil {
+			change.new = newMessage.(*Camera)
+		}
+		changes = append(changes, change)
+
+		if len(changes) == 4 {
+			cancel()
+		}
+	})
+	assert.NoError(t, err)
+	assert.Len(t, changes, 4)
+
+	for _, change := range changes {
+		assert.Equal(t, "Camera", change.entityType)
+	}
+
+	// creations
+	assert.Nil(t, changes[0].old)
+	assert.Equal(t, "legacy", changes[0].new.Name)
+	assert.Nil(t, changes[1].old)
+	assert.Equal(t, &Camera{Originator: &types.Originator{ID: camera.Originator.ID, Version: 1}, Name: "entrance", Gain: 1}, changes[1].new)
+
+	// update
+	assert.Equal(t, 1, changes[2].old.Gain)
+	assert.Equal(t, 2, changes[2].new.Gain)
+	assert.Equal(t, uint64(2), changes[2].new.Originator.Version)
+
+	// deletion
+	assert.Equal(t, 2, changes[3].old.Gain)
+	assert.Nil(t, changes[3].new)
+
+	progress, err := consumerStore.GetLogConsume(context.Background(), "cameras")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(6), progress.Offset)
+}
@@ -0,0 +1,427 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// DefaultLeaseTTL is how long a member keeps its partitions without renewing them
+	DefaultLeaseTTL = time.Second * 10
+)
+
+var (
+	groupOwnedPartitions = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eskit_consumers_group_partitions",
+			Help: "Number of partitions owned by a consumer group member",
+		}, []string{
+			"group", "member",
+		})
+
+	groupRebalanceCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "eskit_consumers_group_rebalance_count",
+			Help: "Number of times the partitions of a consumer group member changed",
+		}, []string{
+			"group", "member",
+		})
+)
+
+// Partitioner splits the application log into the partitions which are assigned to the
+// members of a consumer group
+type Partitioner interface {
+	// Partitions returns all of the partitions
+	Partitions() []string
+	// PartitionOf returns the partition of the entry, an empty one when the entry isn't consumed
+	PartitionOf(entry *types.AppLogEntry) string
+}
+
+// EntityTypePartitioner has a partition per entity type, the entries of the other
+// entity types are skipped
+type EntityTypePartitioner struct {
+	EntityTypes []string
+}
+
+func (p *EntityTypePartitioner) Partitions() []string {
+	return p.EntityTypes
+}
+
+func (p *EntityTypePartitioner) PartitionOf(entry *types.AppLogEntry) string {
+	entityType := common.ExtractEntityType(entry.Event)
+	for _, t := range p.EntityTypes {
+		if t == entityType {
+			return entityType
+		}
+	}
+	return ""
+}
+
+// HashPartitioner splits the entries into buckets by the hash of the originator ID so
+// the events of an entity are always in the same partition
+type HashPartitioner struct {
+	Buckets int
+}
+
+func (p *HashPartitioner) Partitions() []string {
+	partitions := make([]string, p.Buckets)
+	for i := range partitions {
+		partitions[i] = fmt.Sprintf("bucket-%d", i)
+	}
+	return partitions
+}
+
+func (p *HashPartitioner) PartitionOf(entry *types.AppLogEntry) string {
+	h := fnv.New32a()
+	h.Write([]byte(entry.Event.Originator.ID))
+	return fmt.Sprintf("bucket-%d", h.Sum32()%uint32(p.Buckets))
+}
+
+// GroupConsumer is a member of a consumer group, the partitions of the log are spread
+// over the live members and each member only receives the entries of the partitions it
+// holds a lease for. The progress is saved per partition so a partition continues from
+// where its previous owner stopped.
+type GroupConsumer struct {
+	group         string
+	memberID      string
+	storeClient   eventstore.Store
+	consumerStore consumerstore.Store
+	groupStore    consumerstore.GroupStore
+	partitioner   Partitioner
+	leaseTTL      time.Duration
+
+	mu sync.Mutex
+	// owned are the partitions with the expiry time of their lease
+	owned map[string]time.Time
+	// offsets are the last consumed log IDs of the owned partitions
+	offsets map[string]uint64
+}
+
+// NewGroupConsumer creates a member of group with the DefaultLeaseTTL, consumerStore
+// should implement consumerstore.GroupStore
+func NewGroupConsumer(storeClient eventstore.Store, consumerStore consumerstore.Store, group, memberID string, partitioner Partitioner) (*GroupConsumer, error) {
+	return NewGroupConsumerWithLeaseTTL(storeClient, consumerStore, group, memberID, partitioner, DefaultLeaseTTL)
+}
+
+// NewGroupConsumerWithLeaseTTL creates a member of group, a member which doesn't renew its
+// registration and leases within leaseTTL is considered dead and its partitions are reassigned
+func NewGroupConsumerWithLeaseTTL(storeClient eventstore.Store, consumerStore consumerstore.Store, group, memberID string, partitioner Partitioner, leaseTTL time.Duration) (*GroupConsumer, error) {
+	if group == "" || memberID == "" {
+		return nil, fmt.Errorf("missing group or member id : %w", crudstore.InvalidArgumentError)
+	}
+
+	if partitioner == nil || len(partitioner.Partitions()) == 0 {
+		return nil, fmt.Errorf("missing partitions : %w", crudstore.InvalidArgumentError)
+	}
+
+	if leaseTTL <= 0 {
+		return nil, fmt.Errorf("invalid lease ttl %s : %w", leaseTTL, crudstore.InvalidArgumentError)
+	}
+
+	groupStore, ok := consumerStore.(consumerstore.GroupStore)
+	if !ok {
+		return nil, fmt.Errorf("consumer store doesn't support consumer groups")
+	}
+
+	return &GroupConsumer{
+		group:         group,
+		memberID:      memberID,
+		storeClient:   storeClient,
+		consumerStore: consumerStore,
+		groupStore:    groupStore,
+		partitioner:   partitioner,
+		leaseTTL:      leaseTTL,
+		owned:         map[string]time.Time{},
+		offsets:       map[string]uint64{},
+	}, nil
+}
+
+// Consume calls cb for the entries of the owned partitions until ctx is done, on return
+// the member leaves the group so its partitions are reassigned without waiting for the
+// leases to expire. An error of cb stops the consumer like AppLogConsumer.Consume does.
+func (c *GroupConsumer) Consume(ctx context.Context, cb ConsumeCB) error {
+	defer c.leave()
+
+	heartbeat := c.leaseTTL / 3
+	var nextHeartbeat time.Time
+	var fromID uint64
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if !time.Now().Before(nextHeartbeat) {
+			changed, err := c.rebalance(ctx)
+			if err != nil {
+				log.Printf("consumer group %s member %s rebalance : %v", c.group, c.memberID, err)
+			}
+			if changed {
+				fromID = c.nextOffset()
+			}
+			nextHeartbeat = time.Now().Add(heartbeat)
+		}
+
+		if len(c.Partitions()) == 0 {
+			sleepContext(ctx, time.Until(nextHeartbeat))
+			continue
+		}
+
+		results, err := c.storeClient.Logs(fromID, 10, "")
+		if err != nil {
+			log.Printf("consumer group %s member %s fetch logs : %v", c.group, c.memberID, err)
+			sleepContext(ctx, time.Millisecond*100)
+			continue
+		}
+
+		if len(results) == 0 {
+			sleepContext(ctx, time.Millisecond*100)
+			continue
+		}
+
+		for _, entry := range results {
+			if err := c.consumeEntry(ctx, entry, cb); err != nil {
+				if errors.Is(err, context.Canceled) {
+					return nil
+				}
+				return err
+			}
+			fromID = entry.ID + 1
+		}
+	}
+}
+
+// Partitions returns the partitions the member currently owns
+func (c *GroupConsumer) Partitions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var partitions []string
+	for partition, expiresAt := range c.owned {
+		if now.Before(expiresAt) {
+			partitions = append(partitions, partition)
+		}
+	}
+	sort.Strings(partitions)
+	return partitions
+}
+
+// ProgressID returns the consumer id the progress of the partition is saved with
+func (c *GroupConsumer) ProgressID(partition string) string {
+	return fmt.Sprintf("%s/%s", c.group, partition)
+}
+
+func (c *GroupConsumer) consumeEntry(ctx context.Context, entry *types.AppLogEntry, cb ConsumeCB) error {
+	partition := c.partitioner.PartitionOf(entry)
+	if partition == "" || !c.owns(partition) {
+		return nil
+	}
+
+	c.mu.Lock()
+	consumed := entry.ID <= c.offsets[partition]
+	c.mu.Unlock()
+	if consumed {
+		return nil
+	}
+
+	if err := cb(entry); err != nil {
+		return err
+	}
+
+	if err := common.RetryShort(func() error {
+		return c.consumerStore.LogConsume(ctx, &consumerstore.AppLogConsumeProgress{
+			ConsumerId: c.ProgressID(partition),
+			Offset:     entry.ID,
+		})
+	}); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.offsets[partition] = entry.ID
+	c.mu.Unlock()
+	return nil
+}
+
+// rebalance renews the registration of the member, computes its share of the partitions
+// from the live members and acquires or releases the leases accordingly. It returns true
+// when the owned partitions changed.
+func (c *GroupConsumer) rebalance(ctx context.Context) (bool, error) {
+	before := c.Partitions()
+	wasOwned := map[string]bool{}
+	for _, partition := range before {
+		wasOwned[partition] = true
+	}
+
+	if err := c.groupStore.Heartbeat(ctx, c.group, c.memberID, c.leaseTTL); err != nil {
+		return false, fmt.Errorf("heartbeat : %w", err)
+	}
+
+	members, err := c.groupStore.Members(ctx, c.group)
+	if err != nil {
+		return false, fmt.Errorf("members : %w", err)
+	}
+
+	desired := assignPartitions(c.partitioner.Partitions(), members, c.memberID)
+
+	c.mu.Lock()
+	var released []string
+	for partition := range c.owned {
+		if !desired[partition] {
+			delete(c.owned, partition)
+			delete(c.offsets, partition)
+			released = append(released, partition)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, partition := range released {
+		if err := c.groupStore.ReleaseLease(ctx, c.group, partition, c.memberID); err != nil {
+			return false, fmt.Errorf("release %s : %w", partition, err)
+		}
+	}
+
+	for partition := range desired {
+		expiresAt := time.Now().Add(c.leaseTTL)
+		acquired, err := c.groupStore.AcquireLease(ctx, c.group, partition, c.memberID, c.leaseTTL)
+		if err != nil {
+			return false, fmt.Errorf("acquire %s : %w", partition, err)
+		}
+
+		if !acquired {
+			// the previous owner didn't release it yet, it's retried on the next heartbeat
+			c.mu.Lock()
+			delete(c.owned, partition)
+			c.mu.Unlock()
+			continue
+		}
+
+		var offset uint64
+		if !wasOwned[partition] {
+			offset, err = c.loadOffset(ctx, partition)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		c.mu.Lock()
+		if !wasOwned[partition] {
+			// another member might have consumed it since it was owned last time
+			c.offsets[partition] = offset
+		}
+		c.owned[partition] = expiresAt
+		c.mu.Unlock()
+	}
+
+	after := c.Partitions()
+	groupOwnedPartitions.With(prometheus.Labels{"group": c.group, "member": c.memberID}).Set(float64(len(after)))
+
+	changed := !equalStrings(before, after)
+	if changed {
+		groupRebalanceCount.With(prometheus.Labels{"group": c.group, "member": c.memberID}).Inc()
+		log.Printf("consumer group %s member %s owns partitions : %v", c.group, c.memberID, after)
+	}
+
+	return changed, nil
+}
+
+func (c *GroupConsumer) loadOffset(ctx context.Context, partition string) (uint64, error) {
+	progress, err := c.consumerStore.GetLogConsume(ctx, c.ProgressID(partition))
+	if err != nil {
+		if errors.Is(err, crudstore.RecordNotFound) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("progress of %s : %w", partition, err)
+	}
+	return progress.Offset, nil
+}
+
+// nextOffset is the log ID the member should read from to not miss any entry of its partitions
+func (c *GroupConsumer) nextOffset() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var offset uint64
+	first := true
+	for partition := range c.owned {
+		if first || c.offsets[partition] < offset {
+			offset = c.offsets[partition]
+			first = false
+		}
+	}
+	return offset + 1
+}
+
+// owns checks the lease is still valid so a member which couldn't renew its leases
+// stops before the partitions are given to another member
+func (c *GroupConsumer) owns(partition string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt, ok := c.owned[partition]
+	return ok && time.Now().Before(expiresAt)
+}
+
+func (c *GroupConsumer) leave() {
+	c.mu.Lock()
+	c.owned = map[string]time.Time{}
+	c.offsets = map[string]uint64{}
+	c.mu.Unlock()
+
+	groupOwnedPartitions.With(prometheus.Labels{"group": c.group, "member": c.memberID}).Set(0)
+	if err := c.groupStore.Leave(context.Background(), c.group, c.memberID); err != nil {
+		log.Printf("consumer group %s member %s leave : %v", c.group, c.memberID, err)
+	}
+}
+
+// assignPartitions spreads the sorted partitions over the sorted members round robin so
+// every member computes the same assignment, it returns the share of memberID
+func assignPartitions(partitions []string, members []*consumerstore.GroupMember, memberID string) map[string]bool {
+	sortedPartitions := append([]string(nil), partitions...)
+	sort.Strings(sortedPartitions)
+
+	var memberIDs []string
+	for _, m := range members {
+		memberIDs = append(memberIDs, m.MemberID)
+	}
+	sort.Strings(memberIDs)
+
+	assigned := map[string]bool{}
+	if len(memberIDs) == 0 {
+		return assigned
+	}
+
+	for i, partition := range sortedPartitions {
+		if memberIDs[i%len(memberIDs)] == memberID {
+			assigned[partition] = true
+		}
+	}
+	return assigned
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
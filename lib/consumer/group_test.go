@@ -0,0 +1,143 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssignPartitions(t *testing.T) {
+	members := []*consumerstore.GroupMember{{MemberID: "b"}, {MemberID: "a"}}
+	partitions := []string{"p3", "p1", "p2"}
+
+	assert.Equal(t, map[string]bool{"p1": true, "p3": true}, assignPartitions(partitions, members, "a"))
+	assert.Equal(t, map[string]bool{"p2": true}, assignPartitions(partitions, members, "b"))
+	assert.Empty(t, assignPartitions(partitions, members, "c"))
+	assert.Empty(t, assignPartitions(partitions, nil, "a"))
+}
+
+func TestGroupConsumer(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+
+	for i := 0; i < 20; i++ {
+		err := estore.Append(&types.Event{
+			Originator: &types.Originator{ID: fmt.Sprintf("originator%d", i), Version: 1},
+			EventType:  "User.Created",
+			Payload:    "{}",
+			OccurredOn: time.Now().UTC(),
+		})
+		assert.NoError(t, err)
+	}
+
+	partitioner := &HashPartitioner{Buckets: 4}
+	ttl := time.Millisecond * 300
+
+	var mu sync.Mutex
+	consumed := map[uint64][]string{}
+	start := func(ctx context.Context, memberID string) (*GroupConsumer, chan error) {
+		member, err := NewGroupConsumerWithLeaseTTL(estore, consumerStore, "users", memberID, partitioner, ttl)
+		assert.NoError(t, err)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- member.Consume(ctx, func(entry *types.AppLogEntry) error {
+				mu.Lock()
+				defer mu.Unlock()
+				consumed[entry.ID] = append(consumed[entry.ID], memberID)
+				return nil
+			})
+		}()
+		return member, done
+	}
+
+	ctxOne, cancelOne := context.WithCancel(context.Background())
+	defer cancelOne()
+	ctxTwo, cancelTwo := context.WithCancel(context.Background())
+
+	one, doneOne := start(ctxOne, "one")
+	two, doneTwo := start(ctxTwo, "two")
+
+	assert.Eventually(t, func() bool {
+		return len(one.Partitions()) == 2 && len(two.Partitions()) == 2
+	}, time.Second*3, time.Millisecond*20, "partitions are split between the members")
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(consumed) == 20
+	}, time.Second*3, time.Millisecond*20)
+
+	mu.Lock()
+	for id, members := range consumed {
+		assert.Len(t, members, 1, "entry %d consumed more than once", id)
+	}
+	mu.Unlock()
+
+	for _, partition := range partitioner.Partitions() {
+		progress, err := consumerStore.GetLogConsume(context.Background(), fmt.Sprintf("users/%s", partition))
+		if assert.NoError(t, err) {
+			assert.NotZero(t, progress.Offset)
+		}
+	}
+
+	t.Run("member leaves", func(tt *testing.T) {
+		cancelTwo()
+		assert.NoError(tt, <-doneTwo)
+
+		assert.Eventually(tt, func() bool {
+			return len(one.Partitions()) == 4
+		}, time.Second*3, time.Millisecond*20, "partitions are taken over")
+
+		mu.Lock()
+		assert.Len(tt, consumed, 20, "the saved progress is used")
+		mu.Unlock()
+	})
+
+	t.Run("dead member", func(tt *testing.T) {
+		ctx := context.Background()
+		assert.NoError(tt, consumerStore.Heartbeat(ctx, "users", "dead", ttl))
+
+		assert.Eventually(tt, func() bool {
+			return len(one.Partitions()) == 2
+		}, time.Second*3, time.Millisecond*20, "partitions are given to the new member")
+
+		for _, partition := range partitioner.Partitions() {
+			if acquired, _ := consumerStore.AcquireLease(ctx, "users", partition, "dead", ttl); acquired {
+				break
+			}
+		}
+
+		assert.Eventually(tt, func() bool {
+			return len(one.Partitions()) == 4
+		}, time.Second*3, time.Millisecond*20, "the leases of the dead member expire")
+	})
+
+	cancelOne()
+	assert.NoError(t, <-doneOne)
+
+	members, err := consumerStore.Members(context.Background(), "users")
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+func TestNewGroupConsumerValidation(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+
+	_, err := NewGroupConsumer(estore, consumerStore, "", "one", &HashPartitioner{Buckets: 2})
+	assert.Error(t, err)
+
+	_, err = NewGroupConsumer(estore, consumerStore, "users", "one", &HashPartitioner{})
+	assert.Error(t, err)
+
+	_, err = NewGroupConsumerWithLeaseTTL(estore, consumerStore, "users", "one", &EntityTypePartitioner{EntityTypes: []string{"User"}}, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,219 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// ErrConsumerStalled is returned by the health check when a consumer is stalled
+	ErrConsumerStalled = errors.New("consumer stalled")
+
+	consumerLagEntries = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eskit_consumers_lag_entries",
+			Help: "Number of log entries the consumer is behind the head of the log",
+		}, []string{
+			"consumer_name",
+		})
+
+	consumerLagSeconds = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eskit_consumers_lag_seconds",
+			Help: "Time between the last consumed entry and the head of the log",
+		}, []string{
+			"consumer_name",
+		})
+
+	consumerStalled = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eskit_consumers_stalled",
+			Help: "1 when the lag of the consumer grows without progress",
+		}, []string{
+			"consumer_name",
+		})
+)
+
+// ConsumerLag is how far a consumer is behind the head of the log
+type ConsumerLag struct {
+	Consumer string `json:"consumer"`
+	Offset   uint64 `json:"offset"`
+	Head     uint64 `json:"head"`
+	// Entries is the number of log entries after the offset
+	Entries uint64 `json:"entries"`
+	// Behind is the time between the event of the last consumed entry and the one of the head
+	Behind time.Duration `json:"behind"`
+	// LastProgress is when the monitor saw the offset change the last time
+	LastProgress time.Time `json:"lastProgress"`
+	Stalled      bool      `json:"stalled"`
+}
+
+// lagState is what the monitor remembers about a consumer between the checks
+type lagState struct {
+	offset       uint64
+	entries      uint64
+	lastProgress time.Time
+}
+
+// LagMonitor computes the lag of all of the consumers in a consumer store from their saved
+// progress, so it works the same for every consumerstore.Store implementation. A consumer
+// is stalled when its lag grew while its offset didn't move for stallAfter.
+type LagMonitor struct {
+	storeClient   eventstore.Store
+	consumerStore consumerstore.Store
+	stallAfter    time.Duration
+
+	mu     sync.Mutex
+	states map[string]*lagState
+	now    func() time.Time
+}
+
+// NewLagMonitor creates the monitor of the consumers in consumerStore
+func NewLagMonitor(storeClient eventstore.Store, consumerStore consumerstore.Store, stallAfter time.Duration) (*LagMonitor, error) {
+	if storeClient == nil || consumerStore == nil || stallAfter <= 0 {
+		return nil, fmt.Errorf("missing stores or invalid stall period %s : %w", stallAfter, crudstore.InvalidArgumentError)
+	}
+
+	return &LagMonitor{
+		storeClient:   storeClient,
+		consumerStore: consumerStore,
+		stallAfter:    stallAfter,
+		states:        map[string]*lagState{},
+		now:           time.Now,
+	}, nil
+}
+
+// Check computes the lag of the consumers sorted by their name and exports it as metrics
+func (m *LagMonitor) Check(ctx context.Context) ([]*ConsumerLag, error) {
+	progress, err := m.consumerStore.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing consumers : %w", err)
+	}
+
+	head, err := eventstore.LogHead(m.storeClient)
+	if err != nil {
+		return nil, err
+	}
+
+	headEntry, err := m.entryAt(head)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var lags []*ConsumerLag
+	for _, p := range progress {
+		lag := &ConsumerLag{
+			Consumer: p.ConsumerId,
+			Offset:   p.Offset,
+			Head:     head,
+		}
+
+		if p.Offset < head {
+			lag.Entries = head - p.Offset
+
+			// the consumers which didn't consume anything are behind the first entry
+			consumed, err := m.entryAt(p.Offset)
+			if err != nil {
+				return nil, err
+			}
+			if consumed != nil && headEntry != nil {
+				lag.Behind = headEntry.Event.OccurredOn.Sub(consumed.Event.OccurredOn)
+			}
+		}
+
+		state, ok := m.states[p.ConsumerId]
+		if !ok || state.offset != p.Offset {
+			state = &lagState{offset: p.Offset, entries: lag.Entries, lastProgress: now}
+			m.states[p.ConsumerId] = state
+		}
+
+		lag.LastProgress = state.lastProgress
+		lag.Stalled = lag.Entries > state.entries && now.Sub(state.lastProgress) >= m.stallAfter
+
+		labels := prometheus.Labels{"consumer_name": p.ConsumerId}
+		consumerLagEntries.With(labels).Set(float64(lag.Entries))
+		consumerLagSeconds.With(labels).Set(lag.Behind.Seconds())
+		if lag.Stalled {
+			consumerStalled.With(labels).Set(1)
+		} else {
+			consumerStalled.With(labels).Set(0)
+		}
+
+		lags = append(lags, lag)
+	}
+
+	sort.Slice(lags, func(i, j int) bool {
+		return lags[i].Consumer < lags[j].Consumer
+	})
+	return lags, nil
+}
+
+// Healthy runs a check and returns an ErrConsumerStalled error naming the stalled consumers
+func (m *LagMonitor) Healthy(ctx context.Context) error {
+	lags, err := m.Check(ctx)
+	if err != nil {
+		return err
+	}
+
+	var stalled []string
+	for _, lag := range lags {
+		if lag.Stalled {
+			stalled = append(stalled, fmt.Sprintf("%s (%d entries behind since %s)", lag.Consumer, lag.Entries, lag.LastProgress.Format(time.RFC3339)))
+		}
+	}
+
+	if len(stalled) > 0 {
+		return fmt.Errorf("%s : %w", strings.Join(stalled, ", "), ErrConsumerStalled)
+	}
+	return nil
+}
+
+// Run checks the consumers every interval until ctx is done, the progress of the
+// consumers is only noticed by the checks so interval should be well below the stall period
+func (m *LagMonitor) Run(ctx context.Context, interval time.Duration) error {
+	for {
+		if _, err := m.Check(ctx); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// entryAt returns the entry id, or the first one after it when it was purged, nil when
+// there's no such entry
+func (m *LagMonitor) entryAt(id uint64) (*types.AppLogEntry, error) {
+	if id == 0 {
+		id = 1
+	}
+
+	results, err := m.storeClient.Logs(id, 1, "")
+	if err != nil {
+		return nil, fmt.Errorf("fetch logs : %w", err)
+	}
+
+	if len(results) == 0 {
+		return nil, nil
+	}
+	return results[0], nil
+}
@@ -0,0 +1,68 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLagMonitor(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	appendAt := func(id string, at time.Time) {
+		assert.NoError(t, estore.Append(&types.Event{
+			Originator: &types.Originator{ID: id, Version: 1},
+			EventType:  "Invoice.Created",
+			Payload:    "{}",
+			OccurredOn: at,
+		}))
+	}
+	for i, id := range []string{"a", "b", "c", "d"} {
+		appendAt(id, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	ctx := context.Background()
+	assert.NoError(t, consumerStore.LogConsume(ctx, &consumerstore.AppLogConsumeProgress{ConsumerId: "billing", Offset: 2}))
+	assert.NoError(t, consumerStore.LogConsume(ctx, &consumerstore.AppLogConsumeProgress{ConsumerId: "audit", Offset: 4}))
+
+	monitor, err := NewLagMonitor(estore, consumerStore, time.Minute)
+	assert.NoError(t, err)
+	now := start.Add(time.Hour)
+	monitor.now = func() time.Time { return now }
+
+	lags, err := monitor.Check(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, lags, 2)
+	assert.Equal(t, "audit", lags[0].Consumer)
+	assert.Equal(t, uint64(0), lags[0].Entries)
+	assert.Equal(t, time.Duration(0), lags[0].Behind)
+
+	assert.Equal(t, "billing", lags[1].Consumer)
+	assert.Equal(t, uint64(2), lags[1].Entries)
+	assert.Equal(t, time.Minute*2, lags[1].Behind)
+	assert.False(t, lags[1].Stalled)
+
+	// no progress but the lag didn't grow, the log is quiet
+	now = now.Add(time.Minute * 5)
+	assert.NoError(t, monitor.Healthy(ctx))
+
+	// the lag grows while billing doesn't move
+	appendAt("e", start.Add(time.Minute*10))
+	assert.NoError(t, consumerStore.LogConsume(ctx, &consumerstore.AppLogConsumeProgress{ConsumerId: "audit", Offset: 5}))
+	err = monitor.Healthy(ctx)
+	assert.True(t, errors.Is(err, ErrConsumerStalled))
+	assert.Contains(t, err.Error(), "billing")
+	assert.NotContains(t, err.Error(), "audit", "audit kept up")
+
+	// progress resets the stall
+	assert.NoError(t, consumerStore.LogConsume(ctx, &consumerstore.AppLogConsumeProgress{ConsumerId: "billing", Offset: 3}))
+	assert.NoError(t, monitor.Healthy(ctx))
+}
@@ -0,0 +1,196 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// leaderPartition is the lease the instances of a consumer compete for
+const leaderPartition = "leader"
+
+var (
+	consumerLeader = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eskit_consumers_leader",
+			Help: "1 when the consumer instance is the active leader",
+		}, []string{
+			"consumer_name", "member",
+		})
+
+	leadershipChangeCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "eskit_consumers_leadership_change_count",
+			Help: "Number of times the consumer instance gained or lost the leadership",
+		}, []string{
+			"consumer_name", "member",
+		})
+)
+
+// leaderElection keeps a single active instance of a consumer with a lease in the
+// consumer store, the other instances are standbys waiting for the lease to be free
+type leaderElection struct {
+	groupStore consumerstore.GroupStore
+	memberID   string
+	leaseTTL   time.Duration
+
+	mu     sync.Mutex
+	leader bool
+}
+
+// NewAppLogConsumerWithLeaderElection creates a consumer which only consumes while it's the
+// leader of the instances with the same name, memberID should be unique per instance.
+// When the leader stops renewing its lease a standby takes over within leaseTTL and
+// resumes from the saved progress, so offset FromBeginning only applies when nothing is saved.
+func NewAppLogConsumerWithLeaderElection(storeClient eventstore.Store, consumerStore consumerstore.Store, name string, selector string, memberID string, leaseTTL time.Duration) (*AppLogConsumer, error) {
+	if name == "" || memberID == "" {
+		return nil, fmt.Errorf("missing name or member id : %w", crudstore.InvalidArgumentError)
+	}
+
+	if leaseTTL <= 0 {
+		return nil, fmt.Errorf("invalid lease ttl %s : %w", leaseTTL, crudstore.InvalidArgumentError)
+	}
+
+	groupStore, ok := consumerStore.(consumerstore.GroupStore)
+	if !ok {
+		return nil, fmt.Errorf("consumer store doesn't support leases")
+	}
+
+	consumer, err := NewAppLogConsumer(storeClient, consumerStore, name, FromSaved, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer.election = &leaderElection{
+		groupStore: groupStore,
+		memberID:   memberID,
+		leaseTTL:   leaseTTL,
+	}
+	return consumer, nil
+}
+
+// IsLeader returns true when the consumer was created with leader election and is
+// currently the active instance
+func (consumer *AppLogConsumer) IsLeader() bool {
+	if consumer.election == nil {
+		return false
+	}
+
+	consumer.election.mu.Lock()
+	defer consumer.election.mu.Unlock()
+	return consumer.election.leader
+}
+
+// consumeAsLeader waits for the leadership and runs consume until it's lost or ctx is done,
+// a standby tries to acquire the lease a few times per leaseTTL
+func (consumer *AppLogConsumer) consumeAsLeader(ctx context.Context, consume func(ctx context.Context) error) error {
+	election := consumer.election
+	interval := election.leaseTTL / 3
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		acquired, err := election.groupStore.AcquireLease(ctx, consumer.name, leaderPartition, election.memberID, election.leaseTTL)
+		if err != nil {
+			log.Printf("consumer %s member %s acquire leadership : %v", consumer.name, election.memberID, err)
+		}
+
+		if !acquired {
+			sleepContext(ctx, interval)
+			continue
+		}
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+		renewDone := make(chan struct{})
+		go func() {
+			defer close(renewDone)
+			consumer.renewLeadership(leaderCtx, cancel, time.Now().Add(election.leaseTTL))
+		}()
+
+		consumer.setLeader(true)
+		err = consume(leaderCtx)
+		cancel()
+		<-renewDone
+		consumer.setLeader(false)
+
+		if err := election.groupStore.ReleaseLease(context.Background(), consumer.name, leaderPartition, election.memberID); err != nil {
+			log.Printf("consumer %s member %s release leadership : %v", consumer.name, election.memberID, err)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// renewLeadership extends the lease until ctx is done, it cancels the consumption when
+// the lease was taken over or couldn't be renewed before it's about to expire
+func (consumer *AppLogConsumer) renewLeadership(ctx context.Context, cancel context.CancelFunc, expiresAt time.Time) {
+	election := consumer.election
+	interval := election.leaseTTL / 3
+
+	for {
+		sleepContext(ctx, interval)
+		if ctx.Err() != nil {
+			return
+		}
+
+		renewedAt := time.Now()
+		acquired, err := election.groupStore.AcquireLease(ctx, consumer.name, leaderPartition, election.memberID, election.leaseTTL)
+		switch {
+		case err == nil && acquired:
+			expiresAt = renewedAt.Add(election.leaseTTL)
+		case err == nil:
+			log.Printf("consumer %s member %s lost the leadership", consumer.name, election.memberID)
+			cancel()
+			return
+		default:
+			log.Printf("consumer %s member %s renew leadership : %v", consumer.name, election.memberID, err)
+			if time.Until(expiresAt) <= interval {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func (consumer *AppLogConsumer) setLeader(leader bool) {
+	election := consumer.election
+	election.mu.Lock()
+	election.leader = leader
+	election.mu.Unlock()
+
+	labels := prometheus.Labels{"consumer_name": consumer.name, "member": election.memberID}
+	leadershipChangeCount.With(labels).Inc()
+	if leader {
+		consumerLeader.With(labels).Set(1)
+		log.Printf("consumer %s member %s is the leader", consumer.name, election.memberID)
+	} else {
+		consumerLeader.With(labels).Set(0)
+	}
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,146 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func appendInvoiceEvents(t *testing.T, estore eventstore.Store, count int) {
+	for i := 0; i < count; i++ {
+		err := estore.Append(&types.Event{
+			Originator: &types.Originator{ID: fmt.Sprintf("originator%d", i), Version: 1},
+			EventType:  "Invoice.Created",
+			Payload:    "{}",
+			OccurredOn: time.Now().UTC(),
+		})
+		assert.NoError(t, err)
+	}
+}
+
+func TestLeaderElectionFailover(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendInvoiceEvents(t, estore, 5)
+
+	ttl := time.Millisecond * 300
+
+	// a leader which died after consuming the first 3 entries
+	ctx := context.Background()
+	acquired, err := consumerStore.AcquireLease(ctx, "billing", leaderPartition, "dead", ttl)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	assert.NoError(t, consumerStore.LogConsume(ctx, &consumerstore.AppLogConsumeProgress{ConsumerId: "billing", Offset: 3}))
+
+	standby, err := NewAppLogConsumerWithLeaderElection(estore, consumerStore, "billing", "*", "standby", ttl)
+	assert.NoError(t, err)
+	assert.False(t, standby.IsLeader())
+
+	var mu sync.Mutex
+	var consumed []uint64
+
+	ctxStandby, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	started := time.Now()
+	go func() {
+		done <- standby.Consume(ctxStandby, func(entry *types.AppLogEntry) error {
+			mu.Lock()
+			defer mu.Unlock()
+			consumed = append(consumed, entry.ID)
+			return nil
+		})
+	}()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(consumed) == 2
+	}, time.Second*3, time.Millisecond*20)
+	assert.True(t, standby.IsLeader())
+	assert.Less(t, time.Since(started), time.Second*2)
+
+	mu.Lock()
+	assert.Equal(t, []uint64{4, 5}, consumed, "resumed from the saved offset")
+	mu.Unlock()
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.False(t, standby.IsLeader())
+
+	leases, err := consumerStore.Leases(ctx, "billing")
+	assert.NoError(t, err)
+	assert.Empty(t, leases, "released on stop")
+}
+
+func TestLeaderElectionSingleActive(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendInvoiceEvents(t, estore, 5)
+
+	ttl := time.Millisecond * 300
+
+	var mu sync.Mutex
+	consumed := map[string][]uint64{}
+	start := func(ctx context.Context, memberID string) (*AppLogConsumer, chan error) {
+		consumer, err := NewAppLogConsumerWithLeaderElection(estore, consumerStore, "billing", "*", memberID, ttl)
+		assert.NoError(t, err)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- consumer.Consume(ctx, func(entry *types.AppLogEntry) error {
+				mu.Lock()
+				defer mu.Unlock()
+				consumed[memberID] = append(consumed[memberID], entry.ID)
+				return nil
+			})
+		}()
+		return consumer, done
+	}
+
+	ctxOne, cancelOne := context.WithCancel(context.Background())
+	one, doneOne := start(ctxOne, "one")
+	assert.Eventually(t, one.IsLeader, time.Second, time.Millisecond*10)
+
+	ctxTwo, cancelTwo := context.WithCancel(context.Background())
+	defer cancelTwo()
+	two, doneTwo := start(ctxTwo, "two")
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(consumed["one"]) == 5
+	}, time.Second*3, time.Millisecond*20)
+
+	time.Sleep(ttl)
+	assert.False(t, two.IsLeader(), "standby while the leader renews its lease")
+
+	cancelOne()
+	assert.NoError(t, <-doneOne)
+	assert.Eventually(t, two.IsLeader, time.Second*2, time.Millisecond*10)
+
+	time.Sleep(ttl / 2)
+	mu.Lock()
+	assert.Empty(t, consumed["two"], "the new leader continues from the saved offset")
+	mu.Unlock()
+
+	cancelTwo()
+	assert.NoError(t, <-doneTwo)
+}
+
+func TestNewAppLogConsumerWithLeaderElectionValidation(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+
+	_, err := NewAppLogConsumerWithLeaderElection(estore, consumerStore, "billing", "*", "", time.Second)
+	assert.Error(t, err)
+
+	_, err = NewAppLogConsumerWithLeaderElection(estore, consumerStore, "billing", "*", "one", 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,227 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/types"
+)
+
+const (
+	// parallelPageSize is the number of log entries fetched at once by ConsumeParallel
+	parallelPageSize = 100
+	// parallelQueueSize is the number of entries waiting for a worker
+	parallelQueueSize = 16
+	// parallelCommitInterval is how often ConsumeParallel saves the low watermark
+	parallelCommitInterval = time.Millisecond * 100
+)
+
+// watermark tracks the entries in flight, the low watermark is the last entry which
+// was processed together with all of the entries before it
+type watermark struct {
+	mu sync.Mutex
+	// inflight are the dispatched entries in the order of the log
+	inflight []uint64
+	done     map[uint64]bool
+	low      uint64
+}
+
+func newWatermark(low uint64) *watermark {
+	return &watermark{
+		done: map[uint64]bool{},
+		low:  low,
+	}
+}
+
+func (w *watermark) dispatched(id uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inflight = append(w.inflight, id)
+}
+
+func (w *watermark) completed(id uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.done[id] = true
+	for len(w.inflight) > 0 && w.done[w.inflight[0]] {
+		w.low = w.inflight[0]
+		delete(w.done, w.low)
+		w.inflight = w.inflight[1:]
+	}
+}
+
+func (w *watermark) offset() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.low
+}
+
+// ConsumeParallel calls cb from workers goroutines, the entries of an originator always
+// go to the same worker so they're processed in order while different originators are
+// processed concurrently. The saved offset is the low watermark of the processed entries
+// so after a crash the entries which weren't processed are delivered again, together
+// with some which were. The first error of cb stops all of the workers and is returned,
+// the retry policy of the consumer applies per entry.
+func (consumer *AppLogConsumer) ConsumeParallel(ctx context.Context, workers int, cb ConsumeCB) error {
+	if workers <= 0 {
+		return fmt.Errorf("invalid number of workers %d : %w", workers, crudstore.InvalidArgumentError)
+	}
+
+	return consumer.run(ctx, func(ctx context.Context) error {
+		return consumer.consumeParallel(ctx, workers, cb)
+	})
+}
+
+func (consumer *AppLogConsumer) consumeParallel(ctx context.Context, workers int, cb ConsumeCB) error {
+	fromID, err := consumer.startOffset(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	marks := newWatermark(fromID - 1)
+	// only the first error is kept
+	errCh := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+		cancel()
+	}
+
+	queues := make([]chan *types.AppLogEntry, workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan *types.AppLogEntry, parallelQueueSize)
+		wg.Add(1)
+		go func(queue chan *types.AppLogEntry) {
+			defer wg.Done()
+			for entry := range queue {
+				// the rest of the queue is delivered again on the next start
+				if ctx.Err() != nil {
+					continue
+				}
+
+				if err := consumer.handle(ctx, entry, cb); err != nil {
+					if ctx.Err() == nil || !errors.Is(err, context.Canceled) {
+						fail(err)
+					}
+					continue
+				}
+				marks.completed(entry.ID)
+			}
+		}(queues[i])
+	}
+
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		defer func() {
+			for _, queue := range queues {
+				close(queue)
+			}
+		}()
+
+		if err := consumer.dispatch(ctx, fromID, queues, marks); err != nil {
+			fail(err)
+		}
+	}()
+
+	saved := marks.offset()
+	commit := func() error {
+		offset := marks.offset()
+		if offset <= saved {
+			return nil
+		}
+
+		if err := common.RetryShort(func() error {
+			return consumer.SaveProgress(context.Background(), offset)
+		}); err != nil {
+			return err
+		}
+		saved = offset
+		return nil
+	}
+
+	ticker := time.NewTicker(parallelCommitInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		select {
+		case <-ticker.C:
+			if err := commit(); err != nil {
+				fail(err)
+			}
+		case <-ctx.Done():
+		}
+	}
+
+	// the entries in flight are finished before the last commit
+	<-readerDone
+	wg.Wait()
+	if err := commit(); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// dispatch reads the log from fromID and queues the entries to the workers by their
+// originator until ctx is done
+func (consumer *AppLogConsumer) dispatch(ctx context.Context, fromID uint64, queues []chan *types.AppLogEntry, marks *watermark) error {
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		results, err := consumer.storeClient.Logs(fromID, parallelPageSize, "")
+		if err != nil {
+			return fmt.Errorf("fetch logs : %w", err)
+		}
+
+		if len(results) == 0 {
+			sleepContext(ctx, time.Millisecond*100)
+			continue
+		}
+
+		for _, entry := range results {
+			fromID = entry.ID + 1
+			marks.dispatched(entry.ID)
+
+			if !common.IsEventCompliant(entry.Event, consumer.selector) {
+				marks.completed(entry.ID)
+				continue
+			}
+
+			if err := consumer.waitResumed(ctx); err != nil {
+				if errors.Is(err, errConsumerReset) {
+					return err
+				}
+				return nil
+			}
+
+			h := fnv.New32a()
+			h.Write([]byte(entry.Event.Originator.ID))
+			select {
+			case queues[h.Sum32()%uint32(len(queues))] <- entry:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
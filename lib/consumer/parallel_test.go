@@ -0,0 +1,169 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// appendVersionedEvents appends versions events for each of the originators interleaved
+func appendVersionedEvents(t *testing.T, estore eventstore.Store, originators, versions int) {
+	for v := 1; v <= versions; v++ {
+		for o := 0; o < originators; o++ {
+			err := estore.Append(&types.Event{
+				Originator: &types.Originator{ID: fmt.Sprintf("originator%d", o), Version: uint64(v)},
+				EventType:  "Invoice.Updated",
+				Payload:    "{}",
+				OccurredOn: time.Now().UTC(),
+			})
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestWatermark(t *testing.T) {
+	marks := newWatermark(4)
+	for _, id := range []uint64{5, 6, 7} {
+		marks.dispatched(id)
+	}
+
+	marks.completed(6)
+	assert.Equal(t, uint64(4), marks.offset(), "5 is still in flight")
+
+	marks.completed(5)
+	assert.Equal(t, uint64(6), marks.offset())
+
+	marks.completed(7)
+	assert.Equal(t, uint64(7), marks.offset())
+}
+
+// savedOffset returns the saved progress of the consumer, 0 when there's none
+func savedOffset(t *testing.T, consumerStore consumerstore.Store, name string) uint64 {
+	progress, err := consumerStore.GetLogConsume(context.Background(), name)
+	if errors.Is(err, crudstore.RecordNotFound) {
+		return 0
+	}
+	assert.NoError(t, err)
+	return progress.Offset
+}
+
+// waitFor fails the test when ch isn't closed in time
+func waitFor(t *testing.T, ch <-chan struct{}, msg string) {
+	select {
+	case <-ch:
+	case <-time.After(time.Second * 3):
+		t.Error(msg)
+	}
+}
+
+func TestAppLogConsumer_ConsumeParallel(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendVersionedEvents(t, estore, 8, 5)
+
+	consumer, err := NewAppLogConsumer(estore, consumerStore, "billing", FromBeginning, "*")
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	versions := map[string][]uint64{}
+	consumed := 0
+	// the entry 1 of originator0 is held until the entry 2 of originator1 is processed
+	// by another worker
+	second := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = consumer.ConsumeParallel(ctx, 4, func(entry *types.AppLogEntry) error {
+		if entry.ID == 1 {
+			waitFor(t, second, "the originators aren't processed concurrently")
+			assert.Equal(t, uint64(0), savedOffset(t, consumerStore, "billing"), "the entry 1 is in flight")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		versions[entry.Event.Originator.ID] = append(versions[entry.Event.Originator.ID], entry.Event.Originator.Version)
+		if entry.ID == 2 {
+			close(second)
+		}
+		consumed++
+		if consumed == 40 {
+			cancel()
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, versions, 8)
+	for id, v := range versions {
+		assert.Equal(t, []uint64{1, 2, 3, 4, 5}, v, "%s in order", id)
+	}
+	assert.Equal(t, uint64(40), savedOffset(t, consumerStore, "billing"))
+}
+
+func TestAppLogConsumer_ConsumeParallelLowWatermark(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendVersionedEvents(t, estore, 4, 5)
+
+	consumer, err := NewAppLogConsumer(estore, consumerStore, "billing", FromSaved, "*")
+	assert.NoError(t, err)
+
+	// the entry 6 of originator1 fails after the entries 7 and 8 of the other originators
+	// were processed by their workers
+	failing := uint64(6)
+	var mu sync.Mutex
+	var processed []uint64
+	ahead := map[uint64]chan struct{}{7: make(chan struct{}), 8: make(chan struct{})}
+	err = consumer.ConsumeParallel(context.Background(), 4, func(entry *types.AppLogEntry) error {
+		if entry.ID == failing {
+			for _, ch := range ahead {
+				waitFor(t, ch, "the other originators didn't go on")
+			}
+
+			// the workers are ahead, still the committed offset stays before the entry 6
+			assert.Eventually(t, func() bool {
+				return savedOffset(t, consumerStore, "billing") == failing-1
+			}, time.Second*3, time.Millisecond*10)
+			return errors.New("external api is down")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, entry.ID)
+		if ch, ok := ahead[entry.ID]; ok {
+			close(ch)
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "external api is down")
+
+	mu.Lock()
+	assert.Contains(t, processed, uint64(7))
+	assert.Contains(t, processed, uint64(8))
+	mu.Unlock()
+
+	assert.Equal(t, failing-1, savedOffset(t, consumerStore, "billing"), "the offset stops before the failed entry")
+
+	// the failed entry is delivered again after a restart
+	var redelivered []uint64
+	ctx, cancel := context.WithCancel(context.Background())
+	err = consumer.ConsumeParallel(ctx, 4, func(entry *types.AppLogEntry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		redelivered = append(redelivered, entry.ID)
+		if entry.ID == failing {
+			cancel()
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, redelivered, failing)
+}
@@ -0,0 +1,279 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// NonRetryableError is wrapped by the callback errors which should not be retried,
+	// the entry goes to the dead letters right away
+	NonRetryableError = errors.New("non retryable error")
+
+	// DefaultRetryPolicy retries a failing callback 5 times in about 1.5 seconds
+	DefaultRetryPolicy = RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Millisecond * 100,
+		MaxBackoff:     time.Second * 10,
+		Multiplier:     2,
+	}
+
+	consumerRetryCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "eskit_consumers_retry_count",
+			Help: "Number of retried consumer callbacks",
+		}, []string{
+			"consumer_name",
+		})
+
+	consumerDeadLetterCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "eskit_consumers_deadletter_count",
+			Help: "Number of entries sent to the dead letters",
+		}, []string{
+			"consumer_name",
+		})
+)
+
+// RetryPolicy decides how many times and how often a failing callback is retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of calls including the first one, values below 1 are 1
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, it's multiplied by Multiplier
+	// for every next one up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Retryable classifies the errors, when nil all of them are retried except the
+	// ones wrapping NonRetryableError
+	Retryable func(err error) bool
+}
+
+// retryState is the retry configuration of a consumer
+type retryState struct {
+	policy      RetryPolicy
+	deadLetters consumerstore.DeadLetterStore
+}
+
+// WithRetryPolicy makes the consumer retry the failing callbacks with policy, the
+// entries still failing are saved to deadLetters and the consumer moves on. When
+// deadLetters is nil the consumer stops with the last error like it does without a policy.
+// FatalConsumerError and StopConsumerError are never retried.
+func (consumer *AppLogConsumer) WithRetryPolicy(policy RetryPolicy, deadLetters consumerstore.DeadLetterStore) *AppLogConsumer {
+	consumer.retry = &retryState{
+		policy:      policy,
+		deadLetters: deadLetters,
+	}
+	return consumer
+}
+
+// handle calls cb for the entry with the retry policy of the consumer
+func (consumer *AppLogConsumer) handle(ctx context.Context, entry *types.AppLogEntry, cb ConsumeCB) error {
+	if consumer.retry == nil {
+		return cb(entry)
+	}
+
+	attempts, err := consumer.retry.policy.run(ctx, consumer.name, func() error {
+		return cb(entry)
+	})
+	if err == nil {
+		return nil
+	}
+
+	if isConsumerStopError(err) || errors.Is(err, context.Canceled) || consumer.retry.deadLetters == nil {
+		return err
+	}
+
+	return consumer.saveDeadLetter(ctx, entry, err, attempts)
+}
+
+func (consumer *AppLogConsumer) saveDeadLetter(ctx context.Context, entry *types.AppLogEntry, cause error, attempts int) error {
+	now := time.Now().UTC()
+	letter := &consumerstore.DeadLetter{
+		ID:         consumer.deadLetterID(entry),
+		ConsumerId: consumer.name,
+		EntryID:    entry.ID,
+		Error:      cause.Error(),
+		Attempts:   attempts,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err := common.RetryShort(func() error {
+		return consumer.retry.deadLetters.SaveDeadLetter(ctx, letter)
+	}); err != nil {
+		return fmt.Errorf("saving dead letter of %d : %w", entry.ID, err)
+	}
+
+	consumerDeadLetterCount.With(prometheus.Labels{"consumer_name": consumer.name}).Inc()
+	log.Printf("consumer %s sent entry %d to the dead letters after %d attempts : %v", consumer.name, entry.ID, attempts, cause)
+	return nil
+}
+
+// DeadLetters returns the entries the consumer gave up on
+func (consumer *AppLogConsumer) DeadLetters(ctx context.Context) ([]*consumerstore.DeadLetter, error) {
+	deadLetters, err := consumer.deadLetterStore()
+	if err != nil {
+		return nil, err
+	}
+
+	letters, err := deadLetters.ListDeadLetters(ctx, consumer.name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, letter := range letters {
+		if err := consumer.loadDeadLetterEntry(letter); err != nil {
+			return nil, err
+		}
+	}
+	return letters, nil
+}
+
+// DeadLetter returns the dead letter with id
+func (consumer *AppLogConsumer) DeadLetter(ctx context.Context, id string) (*consumerstore.DeadLetter, error) {
+	deadLetters, err := consumer.deadLetterStore()
+	if err != nil {
+		return nil, err
+	}
+
+	letter, err := deadLetters.GetDeadLetter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := consumer.loadDeadLetterEntry(letter); err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+// loadDeadLetterEntry reads the entry of the letter from the log, the entries of the
+// purged entities are erased there
+func (consumer *AppLogConsumer) loadDeadLetterEntry(letter *consumerstore.DeadLetter) error {
+	results, err := consumer.storeClient.Logs(letter.EntryID, 1, "")
+	if err != nil {
+		return fmt.Errorf("fetch logs : %w", err)
+	}
+
+	if len(results) == 0 || results[0].ID != letter.EntryID {
+		return fmt.Errorf("entry %d of dead letter %s : %w", letter.EntryID, letter.ID, crudstore.RecordNotFound)
+	}
+
+	letter.Entry = results[0]
+	return nil
+}
+
+// ReplayDeadLetter calls cb for the entry of the dead letter with the retry policy, it's
+// removed on success and updated with the new error otherwise
+func (consumer *AppLogConsumer) ReplayDeadLetter(ctx context.Context, id string, cb ConsumeCB) error {
+	deadLetters, err := consumer.deadLetterStore()
+	if err != nil {
+		return err
+	}
+
+	letter, err := deadLetters.GetDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := consumer.loadDeadLetterEntry(letter); err != nil {
+		return err
+	}
+
+	attempts, replayErr := consumer.retry.policy.run(ctx, consumer.name, func() error {
+		return cb(letter.Entry)
+	})
+	if replayErr == nil {
+		return deadLetters.DeleteDeadLetter(ctx, id)
+	}
+
+	letter.Error = replayErr.Error()
+	letter.Attempts += attempts
+	letter.UpdatedAt = time.Now().UTC()
+	if err := deadLetters.SaveDeadLetter(ctx, letter); err != nil {
+		return fmt.Errorf("updating dead letter %s : %w", id, err)
+	}
+
+	return replayErr
+}
+
+// DiscardDeadLetter removes the dead letter without processing it
+func (consumer *AppLogConsumer) DiscardDeadLetter(ctx context.Context, id string) error {
+	deadLetters, err := consumer.deadLetterStore()
+	if err != nil {
+		return err
+	}
+	return deadLetters.DeleteDeadLetter(ctx, id)
+}
+
+func (consumer *AppLogConsumer) deadLetterStore() (consumerstore.DeadLetterStore, error) {
+	if consumer.retry == nil || consumer.retry.deadLetters == nil {
+		return nil, fmt.Errorf("consumer %s has no dead letter store", consumer.name)
+	}
+	return consumer.retry.deadLetters, nil
+}
+
+// deadLetterID is the same for an entry so it's not duplicated when the consumer
+// crashes before saving its progress
+func (consumer *AppLogConsumer) deadLetterID(entry *types.AppLogEntry) string {
+	return fmt.Sprintf("%s/%d", consumer.name, entry.ID)
+}
+
+// run calls fn until it succeeds, fails with an error which shouldn't be retried or runs
+// out of attempts, it returns the number of calls with the last error
+func (p RetryPolicy) run(ctx context.Context, consumerName string, fn func() error) (int, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= maxAttempts || isConsumerStopError(err) || !p.retryable(err) {
+			return attempt, err
+		}
+
+		consumerRetryCount.With(prometheus.Labels{"consumer_name": consumerName}).Inc()
+		sleepContext(ctx, p.backoff(attempt))
+		if ctx.Err() != nil {
+			return attempt, ctx.Err()
+		}
+	}
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return !errors.Is(err, NonRetryableError)
+}
+
+// backoff returns the wait after the attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		if p.Multiplier > 1 {
+			backoff *= p.Multiplier
+		}
+		if p.MaxBackoff > 0 && backoff >= float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+	return time.Duration(backoff)
+}
+
+func isConsumerStopError(err error) bool {
+	return errors.Is(err, FatalConsumerError) || errors.Is(err, StopConsumerError)
+}
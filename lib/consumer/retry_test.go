@@ -0,0 +1,149 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Millisecond * 100, MaxBackoff: time.Second, Multiplier: 2}
+
+	assert.Equal(t, time.Millisecond*100, policy.backoff(1))
+	assert.Equal(t, time.Millisecond*200, policy.backoff(2))
+	assert.Equal(t, time.Millisecond*800, policy.backoff(4))
+	assert.Equal(t, time.Second, policy.backoff(5))
+	assert.Equal(t, time.Second, policy.backoff(50))
+}
+
+func TestAppLogConsumerDeadLetters(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendInvoiceEvents(t, estore, 4)
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+	consumer, err := NewAppLogConsumer(estore, consumerStore, "billing", FromBeginning, "*")
+	assert.NoError(t, err)
+	consumer.WithRetryPolicy(policy, consumerStore)
+
+	attempts := map[uint64]int{}
+	ctx, cancel := context.WithCancel(context.Background())
+	err = consumer.Consume(ctx, func(entry *types.AppLogEntry) error {
+		attempts[entry.ID]++
+		switch entry.ID {
+		case 1:
+			if attempts[entry.ID] < 3 {
+				return fmt.Errorf("temporary")
+			}
+		case 2:
+			return fmt.Errorf("poison")
+		case 3:
+			return fmt.Errorf("invalid payload : %w", NonRetryableError)
+		case 4:
+			cancel()
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[uint64]int{1: 3, 2: 3, 3: 1, 4: 1}, attempts)
+
+	progress, err := consumerStore.GetLogConsume(context.Background(), "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), progress.Offset, "moved on after the dead letters")
+
+	ctx = context.Background()
+	letters, err := consumer.DeadLetters(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, letters, 2) {
+		assert.Equal(t, "billing/2", letters[0].ID)
+		assert.Equal(t, uint64(2), letters[0].Entry.ID)
+		assert.Equal(t, "poison", letters[0].Error)
+		assert.Equal(t, 3, letters[0].Attempts)
+
+		assert.Equal(t, "billing/3", letters[1].ID)
+		assert.Equal(t, 1, letters[1].Attempts)
+	}
+
+	t.Run("replay failing", func(tt *testing.T) {
+		err := consumer.ReplayDeadLetter(ctx, "billing/2", func(entry *types.AppLogEntry) error {
+			return fmt.Errorf("still poison")
+		})
+		assert.EqualError(tt, err, "still poison")
+
+		letter, err := consumer.DeadLetter(ctx, "billing/2")
+		assert.NoError(tt, err)
+		assert.Equal(tt, "still poison", letter.Error)
+		assert.Equal(tt, 6, letter.Attempts)
+	})
+
+	t.Run("replay", func(tt *testing.T) {
+		var replayed uint64
+		err := consumer.ReplayDeadLetter(ctx, "billing/2", func(entry *types.AppLogEntry) error {
+			replayed = entry.ID
+			return nil
+		})
+		assert.NoError(tt, err)
+		assert.Equal(tt, uint64(2), replayed)
+
+		_, err = consumer.DeadLetter(ctx, "billing/2")
+		assert.ErrorIs(tt, err, crudstore.RecordNotFound)
+	})
+
+	t.Run("purged entries", func(tt *testing.T) {
+		// entry 3 is the event of originator2, the dead letter doesn't keep its payload
+		_, err := estore.(eventstore.Purger).Purge("originator2")
+		assert.NoError(tt, err)
+
+		letter, err := consumer.DeadLetter(ctx, "billing/3")
+		assert.NoError(tt, err)
+		assert.Equal(tt, uint64(3), letter.EntryID)
+		assert.True(tt, eventstore.IsTombstone(letter.Entry.Event))
+	})
+
+	t.Run("discard", func(tt *testing.T) {
+		assert.NoError(tt, consumer.DiscardDeadLetter(ctx, "billing/3"))
+		assert.ErrorIs(tt, consumer.DiscardDeadLetter(ctx, "billing/3"), crudstore.RecordNotFound)
+
+		letters, err := consumer.DeadLetters(ctx)
+		assert.NoError(tt, err)
+		assert.Empty(tt, letters)
+	})
+}
+
+func TestAppLogConsumerRetryWithoutDeadLetters(t *testing.T) {
+	consumerStore := consumerstore.NewInMemoryConsumerApiProvider()
+	estore := eventstore.NewInMemoryStore()
+	appendInvoiceEvents(t, estore, 1)
+
+	consumer, err := NewAppLogConsumer(estore, consumerStore, "billing", FromBeginning, "*")
+	assert.NoError(t, err)
+	consumer.WithRetryPolicy(RetryPolicy{MaxAttempts: 2}, nil)
+
+	attempts := 0
+	err = consumer.Consume(context.Background(), func(entry *types.AppLogEntry) error {
+		attempts++
+		return fmt.Errorf("poison")
+	})
+	assert.EqualError(t, err, "poison")
+	assert.Equal(t, 2, attempts)
+
+	_, err = consumer.DeadLetters(context.Background())
+	assert.Error(t, err)
+
+	t.Run("stop errors are not retried", func(tt *testing.T) {
+		attempts = 0
+		err = consumer.Consume(context.Background(), func(entry *types.AppLogEntry) error {
+			attempts++
+			return fmt.Errorf("exit %w", StopConsumerError)
+		})
+		assert.ErrorIs(tt, err, StopConsumerError)
+		assert.Equal(tt, 1, attempts)
+	})
+}
@@ -0,0 +1,36 @@
+package consumerstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/makkalot/eskit/lib/types"
+)
+
+// DeadLetter is an application log entry a consumer gave up on with the last error. The
+// stores only keep the ID of the entry so a purged entity isn't kept in the dead letters,
+// the consumer reads the entry from the event store.
+type DeadLetter struct {
+	ID         string `json:"id"`
+	ConsumerId string `json:"consumer_id"`
+	EntryID    uint64 `json:"entry_id"`
+	// Entry is read from the event store, it's not kept by the DeadLetterStore
+	Entry     *types.AppLogEntry `json:"entry,omitempty"`
+	Error     string             `json:"error"`
+	Attempts  int                `json:"attempts"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// DeadLetterStore is implemented by the stores which can keep the entries the consumers
+// failed to process
+type DeadLetterStore interface {
+	// SaveDeadLetter creates or replaces the dead letter with the same ID, its Entry isn't saved
+	SaveDeadLetter(ctx context.Context, letter *DeadLetter) error
+	// GetDeadLetter returns crudstore.RecordNotFound when there is no dead letter with id
+	GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error)
+	// ListDeadLetters returns the dead letters of the consumer ordered by their log entry
+	ListDeadLetters(ctx context.Context, consumerID string) ([]*DeadLetter, error)
+	// DeleteDeadLetter removes the dead letter, it returns crudstore.RecordNotFound when it doesn't exist
+	DeleteDeadLetter(ctx context.Context, id string) error
+}
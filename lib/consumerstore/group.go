@@ -0,0 +1,41 @@
+package consumerstore
+
+import (
+	"context"
+	"time"
+)
+
+// GroupMember is a live member of a consumer group
+type GroupMember struct {
+	Group     string    `json:"group"`
+	MemberID  string    `json:"member_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PartitionLease is the time bounded ownership of a partition of a consumer group
+type PartitionLease struct {
+	Group     string    `json:"group"`
+	Partition string    `json:"partition"`
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GroupStore is implemented by the stores which can coordinate consumer groups, the members
+// register with Heartbeat and are considered dead when they don't renew it within the ttl.
+// The expiry times are computed with the clock of the caller so the members should
+// have roughly synchronized clocks.
+type GroupStore interface {
+	// Heartbeat registers the member or extends its registration by ttl
+	Heartbeat(ctx context.Context, group, memberID string, ttl time.Duration) error
+	// Leave removes the member and releases its leases
+	Leave(ctx context.Context, group, memberID string) error
+	// Members returns the live members of the group sorted by their IDs
+	Members(ctx context.Context, group string) ([]*GroupMember, error)
+	// AcquireLease gives the partition to the member for ttl, it returns false when another
+	// member holds a lease which is not expired. The owner renews the lease by acquiring it again.
+	AcquireLease(ctx context.Context, group, partition, memberID string, ttl time.Duration) (bool, error)
+	// ReleaseLease gives up the partition if the member owns it
+	ReleaseLease(ctx context.Context, group, partition, memberID string) error
+	// Leases returns the leases of the group which are not expired
+	Leases(ctx context.Context, group string) ([]*PartitionLease, error)
+}
@@ -0,0 +1,78 @@
+package consumerstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryConsumerApiProvider_Groups(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryConsumerApiProvider()
+
+	err := store.Heartbeat(ctx, "", "one", time.Second)
+	assert.EqualError(t, err, "missing group or member id")
+
+	assert.NoError(t, store.Heartbeat(ctx, "group", "two", time.Minute))
+	assert.NoError(t, store.Heartbeat(ctx, "group", "one", time.Minute))
+	assert.NoError(t, store.Heartbeat(ctx, "group", "dead", -time.Second))
+	assert.NoError(t, store.Heartbeat(ctx, "other", "three", time.Minute))
+
+	members, err := store.Members(ctx, "group")
+	assert.NoError(t, err)
+	assert.Len(t, members, 2)
+	assert.Equal(t, "one", members[0].MemberID)
+	assert.Equal(t, "two", members[1].MemberID)
+
+	t.Run("leases", func(tt *testing.T) {
+		acquired, err := store.AcquireLease(ctx, "group", "p1", "one", time.Minute)
+		assert.NoError(tt, err)
+		assert.True(tt, acquired)
+
+		acquired, err = store.AcquireLease(ctx, "group", "p1", "two", time.Minute)
+		assert.NoError(tt, err)
+		assert.False(tt, acquired, "held by another member")
+
+		acquired, err = store.AcquireLease(ctx, "group", "p1", "one", time.Minute)
+		assert.NoError(tt, err)
+		assert.True(tt, acquired, "renewed by the owner")
+
+		assert.NoError(tt, store.ReleaseLease(ctx, "group", "p1", "two"))
+		leases, err := store.Leases(ctx, "group")
+		assert.NoError(tt, err)
+		assert.Len(tt, leases, 1)
+		assert.Equal(tt, "one", leases[0].Owner)
+
+		assert.NoError(tt, store.ReleaseLease(ctx, "group", "p1", "one"))
+		acquired, err = store.AcquireLease(ctx, "group", "p1", "two", time.Minute)
+		assert.NoError(tt, err)
+		assert.True(tt, acquired, "released")
+	})
+
+	t.Run("expired lease", func(tt *testing.T) {
+		acquired, err := store.AcquireLease(ctx, "group", "p2", "dead", -time.Second)
+		assert.NoError(tt, err)
+		assert.True(tt, acquired)
+
+		acquired, err = store.AcquireLease(ctx, "group", "p2", "one", time.Minute)
+		assert.NoError(tt, err)
+		assert.True(tt, acquired)
+	})
+
+	t.Run("leave", func(tt *testing.T) {
+		assert.NoError(tt, store.Leave(ctx, "group", "one"))
+
+		members, err := store.Members(ctx, "group")
+		assert.NoError(tt, err)
+		assert.Len(tt, members, 1)
+		assert.Equal(tt, "two", members[0].MemberID)
+
+		leases, err := store.Leases(ctx, "group")
+		assert.NoError(tt, err)
+		assert.Len(tt, leases, 1)
+		assert.Equal(tt, "p1", leases[0].Partition)
+		assert.Equal(tt, "two", leases[0].Owner)
+	})
+}
@@ -3,21 +3,41 @@ package consumerstore
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/makkalot/eskit/lib/crudstore"
 )
 
 type InMemoryConsumerApiProvider struct {
+	mu       sync.Mutex
 	progress map[string]uint64
+	// members are the expiry times of the group members by group and member ID
+	members map[string]map[string]time.Time
+	// leases are the partition leases by group and partition
+	leases map[string]map[string]*PartitionLease
+	// deadLetters are the dead letters by ID
+	deadLetters map[string]*DeadLetter
 }
 
 func NewInMemoryConsumerApiProvider() *InMemoryConsumerApiProvider {
 	return &InMemoryConsumerApiProvider{
-		progress: map[string]uint64{},
+		progress:    map[string]uint64{},
+		members:     map[string]map[string]time.Time{},
+		leases:      map[string]map[string]*PartitionLease{},
+		deadLetters: map[string]*DeadLetter{},
 	}
 }
 
 func (consumer *InMemoryConsumerApiProvider) Cleanup() {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
 	consumer.progress = map[string]uint64{}
+	consumer.members = map[string]map[string]time.Time{}
+	consumer.leases = map[string]map[string]*PartitionLease{}
+	consumer.deadLetters = map[string]*DeadLetter{}
 }
 
 func (consumer *InMemoryConsumerApiProvider) LogConsume(ctx context.Context, request *AppLogConsumeProgress) error {
@@ -29,6 +49,9 @@ func (consumer *InMemoryConsumerApiProvider) LogConsume(ctx context.Context, req
 		return fmt.Errorf("missing offset")
 	}
 
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
 	consumer.progress[request.ConsumerId] = request.Offset
 
 	return nil
@@ -39,6 +62,9 @@ func (consumer *InMemoryConsumerApiProvider) GetLogConsume(ctx context.Context,
 		return nil, fmt.Errorf("missing consumer id")
 	}
 
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
 	offset, exists := consumer.progress[consumerID]
 	if !exists || offset == 0 {
 		return nil, crudstore.RecordNotFound
@@ -51,6 +77,8 @@ func (consumer *InMemoryConsumerApiProvider) GetLogConsume(ctx context.Context,
 }
 
 func (consumer *InMemoryConsumerApiProvider) List(ctx context.Context) ([]*AppLogConsumeProgress, error) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
 
 	var results []*AppLogConsumeProgress
 	for consumerID, offset := range consumer.progress {
@@ -62,3 +90,191 @@ func (consumer *InMemoryConsumerApiProvider) List(ctx context.Context) ([]*AppLo
 
 	return results, nil
 }
+
+func (consumer *InMemoryConsumerApiProvider) ResetLogConsume(ctx context.Context, consumerID string) error {
+	if consumerID == "" {
+		return fmt.Errorf("missing consumer id")
+	}
+
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	delete(consumer.progress, consumerID)
+	return nil
+}
+
+func (consumer *InMemoryConsumerApiProvider) Heartbeat(ctx context.Context, group, memberID string, ttl time.Duration) error {
+	if group == "" || memberID == "" {
+		return fmt.Errorf("missing group or member id")
+	}
+
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	if consumer.members[group] == nil {
+		consumer.members[group] = map[string]time.Time{}
+	}
+	consumer.members[group][memberID] = time.Now().UTC().Add(ttl)
+
+	return nil
+}
+
+func (consumer *InMemoryConsumerApiProvider) Leave(ctx context.Context, group, memberID string) error {
+	if group == "" || memberID == "" {
+		return fmt.Errorf("missing group or member id")
+	}
+
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	delete(consumer.members[group], memberID)
+	for partition, lease := range consumer.leases[group] {
+		if lease.Owner == memberID {
+			delete(consumer.leases[group], partition)
+		}
+	}
+
+	return nil
+}
+
+func (consumer *InMemoryConsumerApiProvider) Members(ctx context.Context, group string) ([]*GroupMember, error) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	now := time.Now().UTC()
+	var results []*GroupMember
+	for memberID, expiresAt := range consumer.members[group] {
+		if expiresAt.Before(now) {
+			continue
+		}
+		results = append(results, &GroupMember{
+			Group:     group,
+			MemberID:  memberID,
+			ExpiresAt: expiresAt,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].MemberID < results[j].MemberID
+	})
+
+	return results, nil
+}
+
+func (consumer *InMemoryConsumerApiProvider) AcquireLease(ctx context.Context, group, partition, memberID string, ttl time.Duration) (bool, error) {
+	if group == "" || partition == "" || memberID == "" {
+		return false, fmt.Errorf("missing group, partition or member id")
+	}
+
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	now := time.Now().UTC()
+	if lease, ok := consumer.leases[group][partition]; ok && lease.Owner != memberID && !lease.ExpiresAt.Before(now) {
+		return false, nil
+	}
+
+	if consumer.leases[group] == nil {
+		consumer.leases[group] = map[string]*PartitionLease{}
+	}
+	consumer.leases[group][partition] = &PartitionLease{
+		Group:     group,
+		Partition: partition,
+		Owner:     memberID,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	return true, nil
+}
+
+func (consumer *InMemoryConsumerApiProvider) ReleaseLease(ctx context.Context, group, partition, memberID string) error {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	if lease, ok := consumer.leases[group][partition]; ok && lease.Owner == memberID {
+		delete(consumer.leases[group], partition)
+	}
+
+	return nil
+}
+
+func (consumer *InMemoryConsumerApiProvider) Leases(ctx context.Context, group string) ([]*PartitionLease, error) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	now := time.Now().UTC()
+	var results []*PartitionLease
+	for _, lease := range consumer.leases[group] {
+		if lease.ExpiresAt.Before(now) {
+			continue
+		}
+		copied := *lease
+		results = append(results, &copied)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Partition < results[j].Partition
+	})
+
+	return results, nil
+}
+
+func (consumer *InMemoryConsumerApiProvider) SaveDeadLetter(ctx context.Context, letter *DeadLetter) error {
+	if letter.ID == "" || letter.ConsumerId == "" || letter.EntryID == 0 {
+		return fmt.Errorf("missing id, consumer id or entry id")
+	}
+
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	copied := *letter
+	copied.Entry = nil
+	consumer.deadLetters[letter.ID] = &copied
+
+	return nil
+}
+
+func (consumer *InMemoryConsumerApiProvider) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	letter, ok := consumer.deadLetters[id]
+	if !ok {
+		return nil, crudstore.RecordNotFound
+	}
+
+	copied := *letter
+	return &copied, nil
+}
+
+func (consumer *InMemoryConsumerApiProvider) ListDeadLetters(ctx context.Context, consumerID string) ([]*DeadLetter, error) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	var results []*DeadLetter
+	for _, letter := range consumer.deadLetters {
+		if letter.ConsumerId != consumerID {
+			continue
+		}
+		copied := *letter
+		results = append(results, &copied)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].EntryID < results[j].EntryID
+	})
+
+	return results, nil
+}
+
+func (consumer *InMemoryConsumerApiProvider) DeleteDeadLetter(ctx context.Context, id string) error {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	if _, ok := consumer.deadLetters[id]; !ok {
+		return crudstore.RecordNotFound
+	}
+
+	delete(consumer.deadLetters, id)
+	return nil
+}
@@ -2,6 +2,7 @@ package consumerstore
 
 import (
 	"context"
+	"github.com/makkalot/eskit/lib/crudstore"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -64,3 +65,31 @@ func TestInMemoryConsumerApiProvider_LogConsume(t *testing.T) {
 		assert.Len(tt, found, len(expected), "some entries were not found")
 	})
 }
+
+func TestInMemoryConsumerApiProvider_DeadLetters(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryConsumerApiProvider()
+
+	err := store.SaveDeadLetter(ctx, &DeadLetter{ID: "one/1"})
+	assert.EqualError(t, err, "missing id, consumer id or entry id")
+
+	assert.NoError(t, store.SaveDeadLetter(ctx, &DeadLetter{ID: "one/7", ConsumerId: "one", EntryID: 7, Error: "failed"}))
+	assert.NoError(t, store.SaveDeadLetter(ctx, &DeadLetter{ID: "one/3", ConsumerId: "one", EntryID: 3}))
+	assert.NoError(t, store.SaveDeadLetter(ctx, &DeadLetter{ID: "two/1", ConsumerId: "two", EntryID: 1}))
+
+	letters, err := store.ListDeadLetters(ctx, "one")
+	assert.NoError(t, err)
+	if assert.Len(t, letters, 2) {
+		assert.Equal(t, "one/3", letters[0].ID)
+		assert.Equal(t, "one/7", letters[1].ID)
+	}
+
+	letter, err := store.GetDeadLetter(ctx, "one/7")
+	assert.NoError(t, err)
+	assert.Equal(t, "failed", letter.Error)
+
+	assert.NoError(t, store.DeleteDeadLetter(ctx, "one/7"))
+	_, err = store.GetDeadLetter(ctx, "one/7")
+	assert.ErrorIs(t, err, crudstore.RecordNotFound)
+	assert.ErrorIs(t, store.DeleteDeadLetter(ctx, "one/7"), crudstore.RecordNotFound)
+}
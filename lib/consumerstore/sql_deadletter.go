@@ -0,0 +1,91 @@
+package consumerstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/makkalot/eskit/lib/crudstore"
+)
+
+// DeadLetterEntry is a dead letter with the ID of its application log entry
+type DeadLetterEntry struct {
+	ID         string    `gorm:"primary_key"`
+	ConsumerID string    `gorm:"index; not null"`
+	LogID      uint64    `gorm:"type:bigint; not null"`
+	Error      string    `gorm:"type:text"`
+	Attempts   int       `gorm:"not null"`
+	CreatedAt  time.Time `gorm:"not null"`
+	UpdatedAt  time.Time `gorm:"not null"`
+}
+
+func (consumer *SQLConsumerApiProvider) SaveDeadLetter(ctx context.Context, letter *DeadLetter) error {
+	if letter.ID == "" || letter.ConsumerId == "" || letter.EntryID == 0 {
+		return fmt.Errorf("missing id, consumer id or entry id")
+	}
+
+	if result := consumer.db.Save(&DeadLetterEntry{
+		ID:         letter.ID,
+		ConsumerID: letter.ConsumerId,
+		LogID:      letter.EntryID,
+		Error:      letter.Error,
+		Attempts:   letter.Attempts,
+		CreatedAt:  letter.CreatedAt,
+		UpdatedAt:  letter.UpdatedAt,
+	}); result.Error != nil {
+		return fmt.Errorf("saving dead letter failed : %v", result.Error)
+	}
+
+	return nil
+}
+
+func (consumer *SQLConsumerApiProvider) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	entry := &DeadLetterEntry{}
+	if result := consumer.db.Where("id = ?", id).First(entry); result.Error != nil {
+		if result.RecordNotFound() {
+			return nil, crudstore.RecordNotFound
+		}
+		return nil, fmt.Errorf("fetching dead letter failed : %v", result.Error)
+	}
+
+	return entry.toDeadLetter(), nil
+}
+
+func (consumer *SQLConsumerApiProvider) ListDeadLetters(ctx context.Context, consumerID string) ([]*DeadLetter, error) {
+	var entries []*DeadLetterEntry
+	if result := consumer.db.Where("consumer_id = ?", consumerID).Order("log_id").Find(&entries); result.Error != nil {
+		return nil, fmt.Errorf("fetching dead letters failed : %v", result.Error)
+	}
+
+	var results []*DeadLetter
+	for _, e := range entries {
+		results = append(results, e.toDeadLetter())
+	}
+
+	return results, nil
+}
+
+func (consumer *SQLConsumerApiProvider) DeleteDeadLetter(ctx context.Context, id string) error {
+	result := consumer.db.Where("id = ?", id).Delete(&DeadLetterEntry{})
+	if result.Error != nil {
+		return fmt.Errorf("deleting dead letter failed : %v", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return crudstore.RecordNotFound
+	}
+
+	return nil
+}
+
+func (e *DeadLetterEntry) toDeadLetter() *DeadLetter {
+	return &DeadLetter{
+		ID:         e.ID,
+		ConsumerId: e.ConsumerID,
+		EntryID:    e.LogID,
+		Error:      e.Error,
+		Attempts:   e.Attempts,
+		CreatedAt:  e.CreatedAt,
+		UpdatedAt:  e.UpdatedAt,
+	}
+}
@@ -0,0 +1,166 @@
+package consumerstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ConsumerGroupMember is the registration of a consumer group member
+type ConsumerGroupMember struct {
+	GroupName string    `gorm:"primary_key"`
+	MemberID  string    `gorm:"primary_key"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// ConsumerGroupLease is the ownership of a partition of a consumer group
+type ConsumerGroupLease struct {
+	GroupName     string    `gorm:"primary_key"`
+	PartitionName string    `gorm:"primary_key"`
+	Owner         string    `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"not null"`
+}
+
+func (consumer *SQLConsumerApiProvider) Heartbeat(ctx context.Context, group, memberID string, ttl time.Duration) error {
+	if group == "" || memberID == "" {
+		return fmt.Errorf("missing group or member id")
+	}
+
+	expiresAt := time.Now().UTC().Add(ttl)
+	result := consumer.db.Model(&ConsumerGroupMember{}).
+		Where("group_name = ? AND member_id = ?", group, memberID).
+		Update("expires_at", expiresAt)
+	if result.Error != nil {
+		return fmt.Errorf("updating member failed : %v", result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	if result := consumer.db.Create(&ConsumerGroupMember{
+		GroupName: group,
+		MemberID:  memberID,
+		ExpiresAt: expiresAt,
+	}); result.Error != nil {
+		return fmt.Errorf("registering member failed : %v", result.Error)
+	}
+
+	return nil
+}
+
+func (consumer *SQLConsumerApiProvider) Leave(ctx context.Context, group, memberID string) error {
+	if group == "" || memberID == "" {
+		return fmt.Errorf("missing group or member id")
+	}
+
+	tx := consumer.db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("starting transaction : %v", tx.Error)
+	}
+
+	if result := tx.Where("group_name = ? AND member_id = ?", group, memberID).Delete(&ConsumerGroupMember{}); result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("removing member failed : %v", result.Error)
+	}
+
+	if result := tx.Where("group_name = ? AND owner = ?", group, memberID).Delete(&ConsumerGroupLease{}); result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("releasing leases failed : %v", result.Error)
+	}
+
+	if result := tx.Commit(); result.Error != nil {
+		return fmt.Errorf("commit failed : %v", result.Error)
+	}
+
+	return nil
+}
+
+func (consumer *SQLConsumerApiProvider) Members(ctx context.Context, group string) ([]*GroupMember, error) {
+	var entries []*ConsumerGroupMember
+	if result := consumer.db.Where("group_name = ? AND expires_at >= ?", group, time.Now().UTC()).Order("member_id").Find(&entries); result.Error != nil {
+		return nil, fmt.Errorf("fetching members failed : %v", result.Error)
+	}
+
+	var results []*GroupMember
+	for _, e := range entries {
+		results = append(results, &GroupMember{
+			Group:     e.GroupName,
+			MemberID:  e.MemberID,
+			ExpiresAt: e.ExpiresAt,
+		})
+	}
+
+	return results, nil
+}
+
+// AcquireLease takes over the lease with a conditional update so only one of the
+// competing members gets it, a missing lease is created
+func (consumer *SQLConsumerApiProvider) AcquireLease(ctx context.Context, group, partition, memberID string, ttl time.Duration) (bool, error) {
+	if group == "" || partition == "" || memberID == "" {
+		return false, fmt.Errorf("missing group, partition or member id")
+	}
+
+	now := time.Now().UTC()
+	result := consumer.db.Model(&ConsumerGroupLease{}).
+		Where("group_name = ? AND partition_name = ? AND (owner = ? OR expires_at < ?)", group, partition, memberID, now).
+		Updates(map[string]interface{}{"owner": memberID, "expires_at": now.Add(ttl)})
+	if result.Error != nil {
+		return false, fmt.Errorf("updating lease failed : %v", result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	var count int
+	if result := consumer.db.Model(&ConsumerGroupLease{}).Where("group_name = ? AND partition_name = ?", group, partition).Count(&count); result.Error != nil {
+		return false, fmt.Errorf("fetching lease failed : %v", result.Error)
+	}
+
+	if count > 0 {
+		return false, nil
+	}
+
+	if result := consumer.db.Create(&ConsumerGroupLease{
+		GroupName:     group,
+		PartitionName: partition,
+		Owner:         memberID,
+		ExpiresAt:     now.Add(ttl),
+	}); result.Error != nil {
+		// another member created it in the meantime
+		if check := consumer.db.Model(&ConsumerGroupLease{}).Where("group_name = ? AND partition_name = ?", group, partition).Count(&count); check.Error == nil && count > 0 {
+			return false, nil
+		}
+		return false, fmt.Errorf("creating lease failed : %v", result.Error)
+	}
+
+	return true, nil
+}
+
+func (consumer *SQLConsumerApiProvider) ReleaseLease(ctx context.Context, group, partition, memberID string) error {
+	if result := consumer.db.Where("group_name = ? AND partition_name = ? AND owner = ?", group, partition, memberID).Delete(&ConsumerGroupLease{}); result.Error != nil {
+		return fmt.Errorf("releasing lease failed : %v", result.Error)
+	}
+
+	return nil
+}
+
+func (consumer *SQLConsumerApiProvider) Leases(ctx context.Context, group string) ([]*PartitionLease, error) {
+	var entries []*ConsumerGroupLease
+	if result := consumer.db.Where("group_name = ? AND expires_at >= ?", group, time.Now().UTC()).Order("partition_name").Find(&entries); result.Error != nil {
+		return nil, fmt.Errorf("fetching leases failed : %v", result.Error)
+	}
+
+	var results []*PartitionLease
+	for _, e := range entries {
+		results = append(results, &PartitionLease{
+			Group:     e.GroupName,
+			Partition: e.PartitionName,
+			Owner:     e.Owner,
+			ExpiresAt: e.ExpiresAt,
+		})
+	}
+
+	return results, nil
+}
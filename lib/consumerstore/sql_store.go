@@ -63,7 +63,7 @@ func NewSQLConsumerApiProvider(dbURI string) (Store, error) {
 		return nil, err
 	}
 
-	if result := db.AutoMigrate(&ConsumerEntry{}); result.Error != nil {
+	if result := db.AutoMigrate(&ConsumerEntry{}, &ConsumerGroupMember{}, &ConsumerGroupLease{}, &DeadLetterEntry{}); result.Error != nil {
 		return nil, result.Error
 	}
 
@@ -131,7 +131,7 @@ func (consumer *SQLConsumerApiProvider) GetLogConsume(ctx context.Context, consu
 func (consumer *SQLConsumerApiProvider) List(ctx context.Context) ([]*AppLogConsumeProgress, error) {
 
 	entries := []*ConsumerEntry{}
-	if result := consumer.db.Find(entries); result.Error != nil {
+	if result := consumer.db.Find(&entries); result.Error != nil {
 		return nil, fmt.Errorf("fetching consumers progress failed : %v", result.Error)
 	}
 
@@ -145,3 +145,15 @@ func (consumer *SQLConsumerApiProvider) List(ctx context.Context) ([]*AppLogCons
 
 	return results, nil
 }
+
+func (consumer *SQLConsumerApiProvider) ResetLogConsume(ctx context.Context, consumerID string) error {
+	if consumerID == "" {
+		return fmt.Errorf("missing consumer id")
+	}
+
+	if result := consumer.db.Where("id = ?", consumerID).Delete(&ConsumerEntry{}); result.Error != nil {
+		return fmt.Errorf("resetting record failed : %v", result.Error)
+	}
+
+	return nil
+}
@@ -14,3 +14,10 @@ type Store interface {
 	GetLogConsume(ctx context.Context, consumerID string) (*AppLogConsumeProgress, error)
 	List(ctx context.Context) ([]*AppLogConsumeProgress, error)
 }
+
+// ProgressResetter is implemented by the stores which can remove the progress of a
+// consumer, so it starts from the beginning of the log the next time
+type ProgressResetter interface {
+	// ResetLogConsume removes the progress of the consumer, it's a no-op when there is none
+	ResetLogConsume(ctx context.Context, consumerID string) error
+}
@@ -0,0 +1,28 @@
+package crudrest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// DefaultAsOfListSize and MaxAsOfListSize bound the lists of the asOf requests
+	DefaultAsOfListSize = 100
+	MaxAsOfListSize     = 1000
+)
+
+// AsOfListSize returns the size parameter of the asOf lists, they replay the entities so
+// they're always paginated
+func AsOfListSize(r *http.Request) (int, error) {
+	sizeStr := r.URL.Query().Get("size")
+	if sizeStr == "" {
+		return DefaultAsOfListSize, nil
+	}
+
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil || size < 1 || size > MaxAsOfListSize {
+		return 0, fmt.Errorf("Invalid 'size' parameter, it should be between 1 and %d", MaxAsOfListSize)
+	}
+	return size, nil
+}
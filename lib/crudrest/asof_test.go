@@ -0,0 +1,23 @@
+package crudrest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsOfListSize(t *testing.T) {
+	size, err := AsOfListSize(httptest.NewRequest("GET", "/v1/users?asOf=2024-03-01T09:00:00Z", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultAsOfListSize, size)
+
+	size, err = AsOfListSize(httptest.NewRequest("GET", "/v1/users?size=20", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 20, size)
+
+	for _, invalid := range []string{"0", "1001", "many"} {
+		_, err = AsOfListSize(httptest.NewRequest("GET", "/v1/users?size="+invalid, nil))
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,169 @@
+package crudrest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+)
+
+// MaxBulkOperations limits the size of a single bulk request
+const MaxBulkOperations = 10000
+
+// BulkOperation is a single change of a bulk request, Data is the create or update
+// request of the entity. Updates and deletes need the id and version.
+type BulkOperation struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id,omitempty"`
+	Version uint64          `json:"version,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// BulkResult is the outcome of a single operation, Error is the error code of the failed ones
+type BulkResult struct {
+	Originator *types.Originator `json:"originator,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Message    string            `json:"message,omitempty"`
+}
+
+// BulkEntity decodes the bulk operations of the entity T
+type BulkEntity[T any] struct {
+	// Create returns the entity to create from the data of a create operation
+	Create func(data json.RawMessage) (*T, error)
+	// Update applies the data of an update operation to the current state of the entity
+	Update func(entity *T, data json.RawMessage) error
+}
+
+// ApplyBulk applies all of the operations atomically with client, either every one of them
+// is stored or none. The results are in the request order and status is the HTTP status
+// of the response. The error is returned with nil results when nothing could be applied.
+// An entity can only be changed by one operation of the request, the updates and deletes
+// are checked against the version stored before the request.
+func ApplyBulk[T any](client crudstore.Client, operations []*BulkOperation, entity BulkEntity[T]) ([]*BulkResult, int, error) {
+	if len(operations) == 0 || len(operations) > MaxBulkOperations {
+		return nil, http.StatusBadRequest, fmt.Errorf("the request should have between 1 and %d operations : %w", MaxBulkOperations, crudstore.InvalidArgumentError)
+	}
+
+	batch := client.Batch()
+	prepareErrs := make([]error, len(operations))
+	var firstErr error
+	changed := map[string]bool{}
+	for i, op := range operations {
+		err := checkDuplicate(changed, op)
+		if err == nil {
+			err = addBulkOperation(client, batch, op, entity)
+		}
+		if err != nil {
+			prepareErrs[i] = err
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	var results []*crudstore.BatchResult
+	err := firstErr
+	if firstErr == nil {
+		results, err = batch.Commit()
+	} else {
+		for _, prepareErr := range prepareErrs {
+			if prepareErr == nil {
+				prepareErr = crudstore.BatchAborted
+			}
+			results = append(results, &crudstore.BatchResult{Err: prepareErr})
+		}
+	}
+
+	if err != nil && results == nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	bulkResults := make([]*BulkResult, 0, len(results))
+	for _, result := range results {
+		bulkResult := &BulkResult{Originator: result.Originator}
+		if result.Err != nil {
+			_, bulkResult.Error = BulkErrorStatus(result.Err)
+			bulkResult.Message = result.Err.Error()
+		}
+		bulkResults = append(bulkResults, bulkResult)
+	}
+
+	if err != nil {
+		status, _ := BulkErrorStatus(err)
+		return bulkResults, status, err
+	}
+
+	return bulkResults, http.StatusOK, nil
+}
+
+// checkDuplicate fails when the entity of op was changed by an earlier operation, the
+// second change would always conflict with the version the first one stores
+func checkDuplicate(changed map[string]bool, op *BulkOperation) error {
+	if (op.Op != "update" && op.Op != "delete") || op.ID == "" {
+		return nil
+	}
+
+	if changed[op.ID] {
+		return fmt.Errorf("%s is changed by more than one operation : %w", op.ID, crudstore.InvalidArgumentError)
+	}
+	changed[op.ID] = true
+	return nil
+}
+
+// addBulkOperation adds the operation to the batch, the updates are applied to the
+// current state of the entity
+func addBulkOperation[T any](client crudstore.Client, batch *crudstore.Batch, op *BulkOperation, entity BulkEntity[T]) error {
+	switch op.Op {
+	case "create":
+		msg, err := entity.Create(op.Data)
+		if err != nil {
+			return fmt.Errorf("invalid create data : %w", crudstore.InvalidArgumentError)
+		}
+
+		batch.Create(msg)
+	case "update":
+		if op.ID == "" || op.Version == 0 {
+			return fmt.Errorf("update needs the id and version : %w", crudstore.InvalidArgumentError)
+		}
+
+		msg := new(T)
+		if err := client.Get(&types.Originator{ID: op.ID, Version: op.Version}, msg, false); err != nil {
+			return err
+		}
+
+		if err := entity.Update(msg, op.Data); err != nil {
+			return fmt.Errorf("invalid update data : %w", crudstore.InvalidArgumentError)
+		}
+		batch.Update(msg)
+	case "delete":
+		if op.ID == "" || op.Version == 0 {
+			return fmt.Errorf("delete needs the id and version : %w", crudstore.InvalidArgumentError)
+		}
+
+		batch.Delete(&types.Originator{ID: op.ID, Version: op.Version}, new(T))
+	default:
+		return fmt.Errorf("unknown operation %q : %w", op.Op, crudstore.InvalidArgumentError)
+	}
+
+	return nil
+}
+
+// BulkErrorStatus maps the error of a bulk operation to its HTTP status and error code
+func BulkErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, crudstore.BatchAborted):
+		return http.StatusConflict, "aborted"
+	case errors.Is(err, crudstore.RecordNotFound) || errors.Is(err, crudstore.RecordDeleted):
+		return http.StatusNotFound, "not_found"
+	case errors.Is(err, crudstore.InvalidArgumentError):
+		return http.StatusBadRequest, "invalid_request"
+	case errors.Is(err, eventstore.ErrDuplicate):
+		return http.StatusConflict, "conflict"
+	default:
+		return http.StatusInternalServerError, "internal_error"
+	}
+}
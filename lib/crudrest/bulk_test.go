@@ -0,0 +1,117 @@
+package crudrest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type Note struct {
+	Originator *types.Originator
+	Text       string
+}
+
+var noteEntity = BulkEntity[Note]{
+	Create: func(data json.RawMessage) (*Note, error) {
+		note := &Note{}
+		return note, json.Unmarshal(data, note)
+	},
+	Update: func(note *Note, data json.RawMessage) error {
+		return json.Unmarshal(data, note)
+	},
+}
+
+func newNoteClient(t *testing.T) crudstore.Client {
+	store, err := crudstore.NewCrudStoreProvider(context.Background(), eventstore.NewInMemoryStore())
+	assert.NoError(t, err)
+	return crudstore.NewClientWithStore(store)
+}
+
+func TestApplyBulk(t *testing.T) {
+	client := newNoteClient(t)
+
+	results, status, err := ApplyBulk(client, []*BulkOperation{
+		{Op: "create", Data: json.RawMessage(`{"Text":"first"}`)},
+		{Op: "create", Data: json.RawMessage(`{"Text":"second"}`)},
+	}, noteEntity)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, results, 2)
+	first, second := results[0].Originator, results[1].Originator
+	assert.Equal(t, uint64(1), first.Version)
+
+	results, status, err = ApplyBulk(client, []*BulkOperation{
+		{Op: "update", ID: first.ID, Version: 1, Data: json.RawMessage(`{"Text":"updated"}`)},
+		{Op: "delete", ID: second.ID, Version: 1},
+	}, noteEntity)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, uint64(2), results[0].Originator.Version)
+
+	note := &Note{}
+	assert.NoError(t, client.Get(&types.Originator{ID: first.ID}, note, false))
+	assert.Equal(t, "updated", note.Text)
+	assert.True(t, crudstore.IsErrDeleted(client.Get(&types.Originator{ID: second.ID}, &Note{}, false)))
+
+	t.Run("rollback", func(t *testing.T) {
+		results, status, err := ApplyBulk(client, []*BulkOperation{
+			{Op: "create", Data: json.RawMessage(`{"Text":"not stored"}`)},
+			{Op: "update", ID: first.ID, Version: 1, Data: json.RawMessage(`{"Text":"stale"}`)},
+		}, noteEntity)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusConflict, status)
+		assert.Len(t, results, 2)
+		assert.Equal(t, "aborted", results[0].Error)
+		assert.Equal(t, "conflict", results[1].Error)
+
+		var notes []*Note
+		_, err = client.ListWithPagination(&notes, "", 10)
+		assert.NoError(t, err)
+		assert.Len(t, notes, 1)
+		assert.Equal(t, "updated", notes[0].Text)
+	})
+
+	t.Run("duplicate ids", func(t *testing.T) {
+		results, status, err := ApplyBulk(client, []*BulkOperation{
+			{Op: "update", ID: first.ID, Version: 2, Data: json.RawMessage(`{"Text":"once"}`)},
+			{Op: "update", ID: first.ID, Version: 2, Data: json.RawMessage(`{"Text":"twice"}`)},
+		}, noteEntity)
+		assert.ErrorIs(t, err, crudstore.InvalidArgumentError)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, "aborted", results[0].Error)
+		assert.Equal(t, "invalid_request", results[1].Error)
+		assert.Contains(t, results[1].Message, "more than one operation")
+
+		results, _, err = ApplyBulk(client, []*BulkOperation{
+			{Op: "update", ID: first.ID, Version: 2, Data: json.RawMessage(`{"Text":"once"}`)},
+			{Op: "delete", ID: first.ID, Version: 2},
+		}, noteEntity)
+		assert.ErrorIs(t, err, crudstore.InvalidArgumentError)
+		assert.Equal(t, "invalid_request", results[1].Error)
+	})
+
+	t.Run("invalid operations", func(t *testing.T) {
+		results, status, err := ApplyBulk(client, nil, noteEntity)
+		assert.ErrorIs(t, err, crudstore.InvalidArgumentError)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Nil(t, results)
+
+		results, status, err = ApplyBulk(client, []*BulkOperation{
+			{Op: "create", Data: json.RawMessage(`{"Text":"not stored"}`)},
+			{Op: "rename", ID: first.ID},
+			{Op: "update", ID: "missing", Version: 1, Data: json.RawMessage(`{}`)},
+			{Op: "delete", ID: first.ID},
+		}, noteEntity)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, []string{"aborted", "invalid_request", "not_found", "invalid_request"},
+			[]string{results[0].Error, results[1].Error, results[2].Error, results[3].Error})
+		assert.Contains(t, results[3].Message, "version")
+	})
+}
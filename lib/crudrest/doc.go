@@ -0,0 +1,3 @@
+// Package crudrest has the parts of the REST APIs of the crud services which don't
+// depend on the entity, like the bulk operations and the paging of the asOf lists.
+package crudrest
@@ -0,0 +1,140 @@
+package crudstore
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+)
+
+const (
+	// asOfPageSize is the number of log entries ListAsOf fetches at once
+	asOfPageSize = 100
+)
+
+// asOfScanSize is the maximum number of log entries ListAsOf reads in a call, so a
+// listing of a long log is served in parts
+var asOfScanSize = 1000
+
+// GetAsOf returns the state the entity had at the given time, it's replayed from the
+// events which occurred until then. Entities created later return RecordNotFound and
+// the ones deleted at that time RecordDeleted.
+func (crud *CrudStoreProvider) GetAsOf(originatorID string, asOf time.Time) (string, *types.Originator, error) {
+	if originatorID == "" {
+		return "", nil, fmt.Errorf("empty originator id : %w", InvalidArgumentError)
+	}
+
+	events, err := crud.estore.Get(&types.Originator{ID: originatorID}, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return crud.replayAsOf(originatorID, events, asOf)
+}
+
+// replayAsOf folds the events of the stream which occurred until asOf
+func (crud *CrudStoreProvider) replayAsOf(originatorID string, events []*types.Event, asOf time.Time) (string, *types.Originator, error) {
+	if len(events) == 0 {
+		return "", nil, fmt.Errorf("%w", RecordNotFound)
+	}
+
+	// the purged entities don't have a past either
+	if eventstore.IsPurged(events[len(events)-1]) {
+		return "", nil, fmt.Errorf("purged : %w", RecordNotFound)
+	}
+
+	occurred := 0
+	for _, e := range events {
+		if e.OccurredOn.After(asOf) {
+			break
+		}
+		occurred++
+	}
+
+	if occurred == 0 {
+		return "", nil, fmt.Errorf("not created at %s : %w", asOf.Format(time.RFC3339), RecordNotFound)
+	}
+
+	return crud.replay(&types.Originator{ID: originatorID}, events[:occurred], false)
+}
+
+// ListAsOf returns up to size entities of entityType which existed and were not deleted
+// at the given time with the state they had then, in the order they were created. The
+// log is scanned from the cursor fromID, the returned cursor should be passed to the
+// next call and it's empty when the whole log was scanned. A call reads at most
+// asOfScanSize log entries, so it can return fewer than size entities (even none)
+// together with a cursor to continue from.
+func (crud *CrudStoreProvider) ListAsOf(entityType string, asOf time.Time, fromID string, size int) ([]*types.CrudEntity, string, error) {
+	entityType = crud.registry.Resolve(entityType)
+	if size <= 0 {
+		size = 10
+	}
+
+	var from uint64 = 1
+	if fromID != "" {
+		var err error
+		if from, err = strconv.ParseUint(fromID, 10, 64); err != nil || from == 0 {
+			return nil, "", fmt.Errorf("invalid fromID %s : %w", fromID, InvalidArgumentError)
+		}
+	}
+
+	entities := []*types.CrudEntity{}
+	for scanned := 0; ; {
+		if scanned >= asOfScanSize {
+			return entities, strconv.FormatUint(from, 10), nil
+		}
+
+		pageSize := asOfPageSize
+		if remaining := asOfScanSize - scanned; remaining < pageSize {
+			pageSize = remaining
+		}
+
+		logs, err := crud.logs(entityType, from, uint32(pageSize))
+		if err != nil {
+			return nil, "", err
+		}
+		scanned += len(logs)
+
+		if len(logs) == 0 {
+			return entities, "", nil
+		}
+
+		// the entities are found by the event which created them, the log is not strictly
+		// ordered by time across instances so the later entries are checked as well
+		var created []*types.AppLogEntry
+		var ids []string
+		for _, entry := range logs {
+			if entry.Event.Originator.Version != 1 || entry.Event.OccurredOn.After(asOf) ||
+				eventstore.IsTombstone(entry.Event) || eventstore.IsPurged(entry.Event) {
+				continue
+			}
+			created = append(created, entry)
+			ids = append(ids, entry.Event.Originator.ID)
+		}
+
+		streams, err := crud.estore.GetMany(ids)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, entry := range created {
+			id := entry.Event.Originator.ID
+			payload, originator, err := crud.replayAsOf(id, streams[id], asOf)
+			if IsErrNotFound(err) || IsErrDeleted(err) {
+				continue
+			}
+			if err != nil {
+				return nil, "", fmt.Errorf("%s : %w", id, err)
+			}
+
+			entities = append(entities, types.NewCrudEntity(entityType, originator, payload, false))
+			if len(entities) == size {
+				return entities, strconv.FormatUint(entry.ID+1, 10), nil
+			}
+		}
+
+		from = logs[len(logs)-1].ID + 1
+	}
+}
@@ -0,0 +1,150 @@
+package crudstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrudStoreProvider_AsOf(t *testing.T) {
+	estore := eventstore.NewInMemoryStore()
+	store, err := NewCrudStoreProvider(context.Background(), estore)
+	assert.NoError(t, err)
+
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+
+	events := []*types.Event{
+		{Originator: &types.Originator{ID: "user-1", Version: 1}, EventType: "User.Created", Payload: `{"Email":"first@example.com"}`, OccurredOn: at(0)},
+		{Originator: &types.Originator{ID: "user-2", Version: 1}, EventType: "User.Created", Payload: `{"Email":"other@example.com"}`, OccurredOn: at(5)},
+		{Originator: &types.Originator{ID: "user-1", Version: 2}, EventType: "User.Updated", Payload: `{"Email":"second@example.com"}`, OccurredOn: at(10)},
+		{Originator: &types.Originator{ID: "user-2", Version: 2}, EventType: "User.Deleted", Payload: `{}`, OccurredOn: at(20)},
+		{Originator: &types.Originator{ID: "user-3", Version: 1}, EventType: "User.Created", Payload: `{"Email":"late@example.com"}`, OccurredOn: at(30)},
+	}
+	for _, e := range events {
+		assert.NoError(t, estore.Append(e))
+	}
+
+	_, _, err = store.GetAsOf("", at(0))
+	assert.ErrorIs(t, err, InvalidArgumentError)
+
+	_, _, err = store.GetAsOf("user-1", at(-1))
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	payload, originator, err := store.GetAsOf("user-1", at(9))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Email":"first@example.com"}`, payload)
+	assert.Equal(t, uint64(1), originator.Version)
+
+	// the events which occurred exactly at the given time are included
+	payload, originator, err = store.GetAsOf("user-1", at(10))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Email":"second@example.com"}`, payload)
+	assert.Equal(t, uint64(2), originator.Version)
+
+	_, _, err = store.GetAsOf("user-2", at(25))
+	assert.ErrorIs(t, err, RecordDeleted)
+
+	asOf := func(minutes int) []string {
+		entities, _, err := store.ListAsOf("User", at(minutes), "", 0)
+		assert.NoError(t, err)
+
+		var ids []string
+		for _, e := range entities {
+			ids = append(ids, e.Originator.ID)
+		}
+		return ids
+	}
+
+	assert.Empty(t, asOf(-1))
+	assert.Equal(t, []string{"user-1", "user-2"}, asOf(15))
+	assert.Equal(t, []string{"user-1"}, asOf(25))
+	assert.Equal(t, []string{"user-1", "user-3"}, asOf(30))
+
+	t.Run("pages", func(t *testing.T) {
+		first, cursor, err := store.ListAsOf("User", at(30), "", 1)
+		assert.NoError(t, err)
+		assert.Len(t, first, 1)
+		assert.Equal(t, "user-1", first[0].Originator.ID)
+		assert.NotEmpty(t, cursor)
+
+		// the deleted user-2 is skipped
+		second, cursor, err := store.ListAsOf("User", at(30), cursor, 1)
+		assert.NoError(t, err)
+		assert.Len(t, second, 1)
+		assert.Equal(t, "user-3", second[0].Originator.ID)
+
+		last, cursor, err := store.ListAsOf("User", at(30), cursor, 1)
+		assert.NoError(t, err)
+		assert.Empty(t, last)
+		assert.Empty(t, cursor)
+
+		_, _, err = store.ListAsOf("User", at(30), "invalid", 1)
+		assert.ErrorIs(t, err, InvalidArgumentError)
+	})
+
+	t.Run("bounded scan", func(t *testing.T) {
+		defer func(size int) { asOfScanSize = size }(asOfScanSize)
+		asOfScanSize = 2
+
+		var ids []string
+		var pages int
+		cursor := ""
+		for {
+			entities, next, err := store.ListAsOf("User", at(30), cursor, 10)
+			assert.NoError(t, err)
+			for _, e := range entities {
+				ids = append(ids, e.Originator.ID)
+			}
+			pages++
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		// the updates of the entries 3 and 4 make a page without entities
+		assert.Equal(t, []string{"user-1", "user-3"}, ids)
+		assert.Equal(t, 3, pages)
+	})
+
+	t.Run("clients", func(t *testing.T) {
+		var user User
+		client := NewClientWithStore(store)
+		assert.NoError(t, client.GetAsOf("user-1", at(9), &user))
+		assert.Equal(t, "first@example.com", user.Email)
+		assert.Equal(t, uint64(1), user.Originator.Version)
+
+		var users []*User
+		_, err = client.ListAsOf(&users, at(15), "", 10)
+		assert.NoError(t, err)
+		assert.Len(t, users, 2)
+		assert.Equal(t, "second@example.com", users[0].Email)
+
+		typedClient, err := NewTypedClient[User](store)
+		assert.NoError(t, err)
+
+		typed, err := typedClient.GetAsOf("user-1", at(10))
+		assert.NoError(t, err)
+		assert.Equal(t, "second@example.com", typed.Email)
+
+		typedUsers, _, err := typedClient.ListAsOf(at(25), "", 10)
+		assert.NoError(t, err)
+		assert.Len(t, typedUsers, 1)
+	})
+
+	t.Run("purged entities", func(t *testing.T) {
+		_, err := store.Purge("user-1")
+		assert.NoError(t, err)
+
+		_, _, err = store.GetAsOf("user-1", at(15))
+		assert.ErrorIs(t, err, RecordNotFound)
+		assert.Equal(t, []string{"user-2"}, asOf(15))
+	})
+}
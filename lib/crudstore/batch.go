@@ -0,0 +1,242 @@
+package crudstore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"gopkg.in/evanphx/json-patch.v3"
+)
+
+// BatchAborted is the error of the batch operations which were valid but not stored
+// because another operation of the batch failed
+var BatchAborted = errors.New("batch aborted")
+
+type BatchOperationType string
+
+const (
+	BatchCreate BatchOperationType = "create"
+	BatchUpdate BatchOperationType = "update"
+	BatchDelete BatchOperationType = "delete"
+)
+
+// BatchOperation is a single change of a batch, it works like the Create, Update and
+// Delete methods of the CrudStore. Payload is the full state of the entity for create and
+// update, the originator of update should have the version the change is based on.
+type BatchOperation struct {
+	Type       BatchOperationType
+	EntityType string
+	Originator *types.Originator
+	Payload    string
+}
+
+// BatchResult is the outcome of a batch operation, Originator is the version it
+// created when the batch was stored
+type BatchResult struct {
+	Originator *types.Originator
+	Err        error
+}
+
+// batchState is the state of an entity after the operations of the batch seen so far
+type batchState struct {
+	payload string
+	head    *types.Originator
+	deleted bool
+}
+
+// Batch stores the operations atomically, either all of them are appended or none. An
+// entity can be changed by several operations of the same batch, they're applied in
+// order. The results are in the order of the operations, when the batch fails the failing
+// operations have their errors and the rest BatchAborted, the returned error is the one
+// of the first failing operation.
+func (crud *CrudStoreProvider) Batch(ops []*BatchOperation) ([]*BatchResult, error) {
+	appender, ok := crud.estore.(eventstore.BatchAppender)
+	if !ok {
+		return nil, fmt.Errorf("event store doesn't support batches")
+	}
+
+	results := make([]*BatchResult, len(ops))
+	entries := make([]*eventstore.BatchEntry, len(ops))
+	pending := map[string]*batchState{}
+
+	var firstErr error
+	for i, op := range ops {
+		results[i] = &BatchResult{}
+
+		entry, err := crud.prepareBatchOperation(op, pending)
+		if err != nil {
+			results[i].Err = err
+			if firstErr == nil {
+				firstErr = fmt.Errorf("operation %d : %w", i, err)
+			}
+			continue
+		}
+
+		entries[i] = entry
+		results[i].Originator = entry.Event.Originator
+	}
+
+	if firstErr != nil {
+		return abortBatch(results), firstErr
+	}
+
+	if len(entries) == 0 {
+		return results, nil
+	}
+
+	err := appender.AppendBatch(entries)
+
+	if crud.cache != nil {
+		for id := range pending {
+			crud.cache.remove(id)
+		}
+	}
+
+	if err != nil {
+		var batchErr *eventstore.BatchError
+		if errors.As(err, &batchErr) && batchErr.Index >= 0 && batchErr.Index < len(results) {
+			results[batchErr.Index].Err = batchErr.Err
+			return abortBatch(results), fmt.Errorf("operation %d : %w", batchErr.Index, batchErr.Err)
+		}
+		return abortBatch(results), err
+	}
+
+	return results, nil
+}
+
+// prepareBatchOperation returns the event and the new state of the operation based on
+// the state of the entity in the store or in the batch
+func (crud *CrudStoreProvider) prepareBatchOperation(op *BatchOperation, pending map[string]*batchState) (*eventstore.BatchEntry, error) {
+	if op == nil || op.EntityType == "" || op.Originator == nil || op.Originator.ID == "" {
+		return nil, fmt.Errorf("missing entity type or originator : %w", InvalidArgumentError)
+	}
+
+	entityType := crud.registry.Resolve(op.EntityType)
+	id := op.Originator.ID
+	current, inBatch := pending[id]
+
+	var event *types.Event
+	var state *batchState
+
+	switch op.Type {
+	case BatchCreate:
+		if inBatch {
+			return nil, fmt.Errorf("%s is already in the batch : %w", id, eventstore.ErrDuplicate)
+		}
+
+		originator := &types.Originator{ID: id, Version: op.Originator.Version}
+		if originator.Version == 0 {
+			originator.Version = 1
+		}
+
+		event = &types.Event{
+			Originator: originator,
+			EventType:  fmt.Sprintf("%s.Created", entityType),
+			Payload:    op.Payload,
+			OccurredOn: time.Now().UTC(),
+		}
+		state = &batchState{payload: op.Payload, head: originator}
+
+	case BatchUpdate:
+		if op.Originator.Version == 0 {
+			return nil, fmt.Errorf("missing version : %w", InvalidArgumentError)
+		}
+
+		var latestObj string
+		if inBatch {
+			if current.deleted {
+				return nil, fmt.Errorf("%w", RecordDeleted)
+			}
+			if current.head.Version != op.Originator.Version {
+				return nil, fmt.Errorf("version %d, batch version is %d for %s : %w", op.Originator.Version, current.head.Version, id, eventstore.ErrDuplicate)
+			}
+			latestObj = current.payload
+		} else {
+			payload, _, err := crud.Get(op.Originator, false)
+			if err != nil {
+				return nil, err
+			}
+			latestObj = payload
+		}
+
+		patch, err := jsonpatch.CreateMergePatch([]byte(latestObj), []byte(op.Payload))
+		if err != nil {
+			return nil, fmt.Errorf("patch creation failed : %v", err)
+		}
+
+		newObj, err := jsonpatch.MergePatch([]byte(latestObj), patch)
+		if err != nil {
+			return nil, fmt.Errorf("apply patch : %v", err)
+		}
+
+		newOriginator, err := common.IncrOriginator(op.Originator)
+		if err != nil {
+			return nil, err
+		}
+
+		event = &types.Event{
+			Originator: newOriginator,
+			EventType:  fmt.Sprintf("%s.Updated", entityType),
+			Payload:    string(patch),
+			OccurredOn: time.Now().UTC(),
+		}
+		state = &batchState{payload: string(newObj), head: newOriginator}
+
+	case BatchDelete:
+		var latestObj string
+		var head *types.Originator
+		if inBatch {
+			if current.deleted {
+				return nil, fmt.Errorf("%w", RecordDeleted)
+			}
+			latestObj, head = current.payload, current.head
+		} else {
+			payload, _, err := crud.Get(op.Originator, false)
+			if err != nil {
+				return nil, err
+			}
+
+			headEvent, err := crud.streamHead(op.Originator)
+			if err != nil {
+				return nil, err
+			}
+			latestObj, head = payload, headEvent.Originator
+		}
+
+		newOriginator, err := common.IncrOriginator(head)
+		if err != nil {
+			return nil, err
+		}
+
+		event = &types.Event{
+			Originator: newOriginator,
+			EventType:  fmt.Sprintf("%s.Deleted", entityType),
+			Payload:    "{}",
+			OccurredOn: time.Now().UTC(),
+		}
+		state = &batchState{payload: latestObj, head: newOriginator, deleted: true}
+
+	default:
+		return nil, fmt.Errorf("unknown operation %q : %w", op.Type, InvalidArgumentError)
+	}
+
+	pending[id] = state
+	return &eventstore.BatchEntry{
+		Event:  event,
+		Entity: types.NewCrudEntity(entityType, state.head, state.payload, state.deleted),
+	}, nil
+}
+
+// abortBatch marks the operations without an error as aborted
+func abortBatch(results []*BatchResult) []*BatchResult {
+	for _, result := range results {
+		result.Originator = nil
+		if result.Err == nil {
+			result.Err = fmt.Errorf("%w", BatchAborted)
+		}
+	}
+	return results
+}
@@ -0,0 +1,110 @@
+package crudstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Batch(t *testing.T) {
+	estore := eventstore.NewInMemoryStore()
+	crudStore, err := NewCrudStoreProvider(context.Background(), estore)
+	assert.NoError(t, err)
+	client := NewClientWithStore(crudStore)
+
+	existing := &User{Email: "existing@example.com"}
+	_, err = client.Create(existing)
+	assert.NoError(t, err)
+
+	results, err := client.Batch().Commit()
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	first := &User{Email: "first@example.com"}
+	second := &User{Email: "second@example.com", Active: true}
+	existing.Active = true
+
+	batch := client.Batch().
+		Create(first).
+		Create(second).
+		Update(existing).
+		Delete(&types.Originator{ID: existing.Originator.ID}, &User{})
+	assert.Equal(t, 4, batch.Len())
+
+	results, err = batch.Commit()
+	assert.NoError(t, err)
+	assert.Len(t, results, 4)
+	for _, result := range results {
+		assert.NoError(t, result.Err)
+	}
+
+	assert.Equal(t, results[0].Originator, first.Originator)
+	assert.Equal(t, uint64(1), first.Originator.Version)
+	assert.Equal(t, uint64(2), existing.Originator.Version)
+	assert.Equal(t, uint64(3), results[3].Originator.Version)
+
+	var fetched User
+	assert.NoError(t, client.Get(&types.Originator{ID: second.Originator.ID}, &fetched, false))
+	assert.Equal(t, "second@example.com", fetched.Email)
+
+	assert.ErrorIs(t, client.Get(&types.Originator{ID: existing.Originator.ID}, &fetched, false), RecordDeleted)
+	assert.NoError(t, client.Get(&types.Originator{ID: existing.Originator.ID}, &fetched, true))
+	assert.True(t, fetched.Active)
+
+	var users []*User
+	_, err = client.ListWithPagination(&users, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+
+	t.Run("failures abort the batch", func(t *testing.T) {
+		logs, err := estore.Logs(1, 100, "")
+		assert.NoError(t, err)
+
+		stale := &User{Originator: &types.Originator{ID: first.Originator.ID, Version: 1}, Email: "stale@example.com"}
+		first.Email = "changed@example.com"
+
+		results, err := client.Batch().
+			Create(&User{Email: "third@example.com"}).
+			Update(first).
+			Update(stale).
+			Update(&User{Originator: &types.Originator{ID: "missing", Version: 1}}).
+			Commit()
+		assert.ErrorIs(t, err, eventstore.ErrDuplicate)
+		assert.Len(t, results, 4)
+		assert.ErrorIs(t, results[0].Err, BatchAborted)
+		assert.ErrorIs(t, results[1].Err, BatchAborted)
+		assert.ErrorIs(t, results[2].Err, eventstore.ErrDuplicate)
+		assert.ErrorIs(t, results[3].Err, RecordNotFound)
+		assert.Nil(t, results[0].Originator)
+
+		after, err := estore.Logs(1, 100, "")
+		assert.NoError(t, err)
+		assert.Equal(t, len(logs), len(after))
+
+		// the store rejects the conflicts it sees at append time
+		results, err = client.Batch().
+			Create(&User{Email: "fourth@example.com"}).
+			Create(&User{Originator: &types.Originator{ID: second.Originator.ID, Version: 1}}).
+			Commit()
+		assert.ErrorIs(t, err, eventstore.ErrDuplicate)
+		assert.ErrorIs(t, results[0].Err, BatchAborted)
+		assert.ErrorIs(t, results[1].Err, eventstore.ErrDuplicate)
+
+		results, err = client.Batch().
+			Create(User{}).
+			Delete(nil, &User{}).
+			Commit()
+		assert.Error(t, err)
+		assert.Error(t, results[0].Err)
+		assert.ErrorIs(t, results[1].Err, InvalidArgumentError)
+	})
+
+	t.Run("stores without batches", func(t *testing.T) {
+		client := NewClientWithStore(&CrudStoreProvider{estore: logOnlyStore{estore}, registry: DefaultRegistry})
+		_, err := client.Batch().Create(&User{}).Commit()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,115 @@
+package crudstore
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cacheHits = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "eskit_crudstore_cache_hit_count",
+			Help: "Number of Get calls served from the state cache",
+		})
+
+	cacheMisses = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "eskit_crudstore_cache_miss_count",
+			Help: "Number of Get calls which replayed the full stream",
+		})
+
+	cacheEvictions = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "eskit_crudstore_cache_eviction_count",
+			Help: "Number of states evicted from the state cache",
+		})
+)
+
+// cachedState is the reconstructed state of an entity at the head of its stream
+type cachedState struct {
+	payload string
+	// originator is the version of the last event which changed the state
+	originator *types.Originator
+	// head is the version of the last event of the stream
+	head    uint64
+	deleted bool
+}
+
+// stateCache is a bounded LRU cache of the entity states keyed by the originator ID
+type stateCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheItem struct {
+	id    string
+	state *cachedState
+}
+
+func newStateCache(size int) *stateCache {
+	return &stateCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (c *stateCache) get(id string) (*cachedState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheItem).state, true
+}
+
+// put stores the state, a state older than the cached one is ignored so a slow reader
+// doesn't replace the result of a faster one
+func (c *stateCache) put(id string, state *cachedState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[id]; ok {
+		item := element.Value.(*cacheItem)
+		if item.state.head <= state.head {
+			item.state = state
+		}
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[id] = c.order.PushFront(&cacheItem{id: id, state: state})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheItem).id)
+		cacheEvictions.Inc()
+	}
+}
+
+func (c *stateCache) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[id]; ok {
+		c.order.Remove(element)
+		delete(c.entries, id)
+	}
+}
+
+func (c *stateCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
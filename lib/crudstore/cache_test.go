@@ -0,0 +1,126 @@
+package crudstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateCache(t *testing.T) {
+	cache := newStateCache(2)
+
+	cache.put("user-1", &cachedState{payload: "1", head: 1})
+	cache.put("user-2", &cachedState{payload: "2", head: 1})
+
+	// reading user-1 makes user-2 the least recently used one
+	_, ok := cache.get("user-1")
+	assert.True(t, ok)
+
+	evictions := testutil.ToFloat64(cacheEvictions)
+	cache.put("user-3", &cachedState{payload: "3", head: 1})
+	assert.Equal(t, 2, cache.len())
+	assert.Equal(t, evictions+1, testutil.ToFloat64(cacheEvictions))
+
+	_, ok = cache.get("user-2")
+	assert.False(t, ok)
+
+	// older states don't replace the newer ones
+	cache.put("user-1", &cachedState{payload: "new", head: 3})
+	cache.put("user-1", &cachedState{payload: "old", head: 2})
+	state, ok := cache.get("user-1")
+	assert.True(t, ok)
+	assert.Equal(t, "new", state.payload)
+
+	cache.remove("user-1")
+	_, ok = cache.get("user-1")
+	assert.False(t, ok)
+}
+
+func TestCrudStoreProvider_Cache(t *testing.T) {
+	_, err := NewCrudStoreProviderWithCache(context.Background(), eventstore.NewInMemoryStore(), DefaultRegistry, 0)
+	assert.ErrorIs(t, err, InvalidArgumentError)
+
+	estore := eventstore.NewInMemoryStore()
+	store, err := NewCrudStoreProviderWithCache(context.Background(), estore, DefaultRegistry, 10)
+	assert.NoError(t, err)
+
+	// another instance of the service sharing the same database
+	other, err := NewCrudStoreProviderWithCache(context.Background(), estore, DefaultRegistry, 10)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Create("User", &types.Originator{ID: "user-1", Version: 1}, `{"name":"first"}`))
+
+	hits, misses := testutil.ToFloat64(cacheHits), testutil.ToFloat64(cacheMisses)
+
+	payload, originator, err := store.Get(&types.Originator{ID: "user-1"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"first"}`, payload)
+	assert.Equal(t, uint64(1), originator.Version)
+	assert.Equal(t, misses+1, testutil.ToFloat64(cacheMisses))
+
+	// Update reads the state at the version it's given
+	updatedOriginator, err := store.Update("User", originator, `{"name":"second"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, hits+1, testutil.ToFloat64(cacheHits))
+
+	payload, originator, err = store.Get(&types.Originator{ID: "user-1"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"second"}`, payload)
+	assert.Equal(t, updatedOriginator, originator)
+
+	payload, _, err = store.Get(&types.Originator{ID: "user-1"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"second"}`, payload)
+	assert.Equal(t, hits+2, testutil.ToFloat64(cacheHits))
+
+	t.Run("changes made by other instances", func(t *testing.T) {
+		_, err := other.Update("User", &types.Originator{ID: "user-1", Version: 2}, `{"name":"third"}`)
+		assert.NoError(t, err)
+
+		payload, originator, err := store.Get(&types.Originator{ID: "user-1"}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"third"}`, payload)
+		assert.Equal(t, uint64(3), originator.Version)
+
+		_, err = other.Delete("User", &types.Originator{ID: "user-1"})
+		assert.NoError(t, err)
+
+		_, _, err = store.Get(&types.Originator{ID: "user-1"}, false)
+		assert.ErrorIs(t, err, RecordDeleted)
+
+		payload, originator, err = store.Get(&types.Originator{ID: "user-1"}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"third"}`, payload)
+		assert.Equal(t, uint64(3), originator.Version)
+
+		_, err = other.Undelete("User", &types.Originator{ID: "user-1"})
+		assert.NoError(t, err)
+
+		payload, originator, err = store.Get(&types.Originator{ID: "user-1"}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"third"}`, payload)
+		assert.Equal(t, uint64(5), originator.Version)
+	})
+
+	t.Run("older versions", func(t *testing.T) {
+		payload, originator, err := store.Get(&types.Originator{ID: "user-1", Version: 1}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"first"}`, payload)
+		assert.Equal(t, uint64(1), originator.Version)
+
+		_, _, err = store.Get(&types.Originator{ID: "user-1", Version: 10}, false)
+		assert.ErrorIs(t, err, RecordNotFound)
+	})
+
+	t.Run("purged by other instances", func(t *testing.T) {
+		_, err := other.Purge("user-1")
+		assert.NoError(t, err)
+
+		_, _, err = store.Get(&types.Originator{ID: "user-1"}, true)
+		assert.ErrorIs(t, err, RecordNotFound)
+	})
+}
@@ -8,8 +8,8 @@ import (
 	eventstore2 "github.com/makkalot/eskit/lib/eventstore"
 	"github.com/makkalot/eskit/lib/types"
 	uuid "github.com/satori/go.uuid"
-	"log"
 	"reflect"
+	"time"
 )
 
 var (
@@ -22,6 +22,17 @@ type Client interface {
 	Update(msg interface{}) (*types.Originator, error)
 	Delete(originator *types.Originator, msg interface{}) (*types.Originator, error)
 	ListWithPagination(result interface{}, fromID string, size int) (string, error)
+	ListWithQuery(result interface{}, query *eventstore2.EntityQuery) (string, error)
+	History(originatorID string) ([]*HistoryEntry, error)
+	HistoryMany(originatorIDs []string) (map[string][]*HistoryEntry, error)
+	Undelete(originator *types.Originator, msg interface{}) (*types.Originator, error)
+	RevertTo(originator *types.Originator, version uint64, msg interface{}) (*types.Originator, error)
+	Patch(originator *types.Originator, ops []PatchOperation, msg interface{}) (*types.Originator, error)
+	Apply(originator *types.Originator, eventName string, payload interface{}) (*types.Originator, error)
+	Purge(originatorID string) (*types.Originator, error)
+	GetAsOf(originatorID string, asOf time.Time, msg interface{}) error
+	ListAsOf(result interface{}, asOf time.Time, fromID string, size int) (string, error)
+	Batch() *Batch
 }
 
 type clientProvider struct {
@@ -29,6 +40,12 @@ type clientProvider struct {
 }
 
 func NewClient(ctx context.Context, dbUri string) (*clientProvider, error) {
+	return NewClientWithCache(ctx, dbUri, 0)
+}
+
+// NewClientWithCache creates a client which caches the latest state of up to cacheSize
+// entities, the cache is disabled when cacheSize is 0
+func NewClientWithCache(ctx context.Context, dbUri string, cacheSize int) (*clientProvider, error) {
 	var estore eventstore2.Store
 	var err error
 
@@ -41,7 +58,19 @@ func NewClient(ctx context.Context, dbUri string) (*clientProvider, error) {
 		}
 	}
 
-	crudStore, err := NewCrudStoreProvider(ctx, estore)
+	return NewClientWithStoreAndCache(ctx, estore, cacheSize)
+}
+
+// NewClientWithStoreAndCache creates a client on an existing event store, so it can be
+// shared with consumers, caching the latest state of up to cacheSize entities
+func NewClientWithStoreAndCache(ctx context.Context, estore eventstore2.Store, cacheSize int) (*clientProvider, error) {
+	var crudStore CrudStore
+	var err error
+	if cacheSize > 0 {
+		crudStore, err = NewCrudStoreProviderWithCache(ctx, estore, DefaultRegistry, cacheSize)
+	} else {
+		crudStore, err = NewCrudStoreProvider(ctx, estore)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("creating crud store failed : %v", err)
 	}
@@ -128,6 +157,24 @@ func (client *clientProvider) Get(originator *types.Originator, msg interface{},
 	return nil
 }
 
+// GetAsOf fills msg with the state the entity had at the given time
+func (client *clientProvider) GetAsOf(originatorID string, asOf time.Time, msg interface{}) error {
+	if err := client.checkIfPtr(msg); err != nil {
+		return err
+	}
+
+	payload, originator, err := client.crudStore.GetAsOf(originatorID, asOf)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(payload), msg); err != nil {
+		return fmt.Errorf("restoring the payload : %w", err)
+	}
+
+	return client.setOriginatorForMsg(msg, originator)
+}
+
 // Update updates the object, it should have the originator set
 func (client *clientProvider) Update(msg interface{}) (*types.Originator, error) {
 	var originator *types.Originator
@@ -182,7 +229,123 @@ func (client *clientProvider) Delete(originator *types.Originator, msg interface
 	return deletedOriginator, nil
 }
 
+// Undelete restores the deleted entity, msg is used for the entity type and gets
+// filled with the restored state
+func (client *clientProvider) Undelete(originator *types.Originator, msg interface{}) (*types.Originator, error) {
+	if originator == nil {
+		return nil, fmt.Errorf("empty originator : %w", InvalidArgumentError)
+	}
+
+	if err := client.checkIfPtr(msg); err != nil {
+		return nil, err
+	}
+
+	restoredOriginator, err := client.crudStore.Undelete(EntityTypeFromStruct(msg), originator)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Get(restoredOriginator, msg, false); err != nil {
+		return nil, err
+	}
+
+	return restoredOriginator, nil
+}
+
+// RevertTo brings the entity back to the state it had at version, msg is used for the
+// entity type and gets filled with the reverted state
+func (client *clientProvider) RevertTo(originator *types.Originator, version uint64, msg interface{}) (*types.Originator, error) {
+	if originator == nil {
+		return nil, fmt.Errorf("empty originator : %w", InvalidArgumentError)
+	}
+
+	if err := client.checkIfPtr(msg); err != nil {
+		return nil, err
+	}
+
+	revertedOriginator, err := client.crudStore.RevertTo(EntityTypeFromStruct(msg), originator, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Get(revertedOriginator, msg, false); err != nil {
+		return nil, err
+	}
+
+	return revertedOriginator, nil
+}
+
+// Patch applies the JSON Patch operations to the entity, msg is used for the entity type
+// and gets filled with the patched state
+func (client *clientProvider) Patch(originator *types.Originator, ops []PatchOperation, msg interface{}) (*types.Originator, error) {
+	if originator == nil {
+		return nil, fmt.Errorf("empty originator : %w", InvalidArgumentError)
+	}
+
+	if err := client.checkIfPtr(msg); err != nil {
+		return nil, err
+	}
+
+	opsJSON, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	patchedOriginator, err := client.crudStore.Patch(EntityTypeFromStruct(msg), originator, string(opsJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Get(patchedOriginator, msg, false); err != nil {
+		return nil, err
+	}
+
+	return patchedOriginator, nil
+}
+
+// Apply stores the custom domain event eventName for the entity, payload is marshalled to
+// JSON and folded into the state with the reducer registered for the event
+func (client *clientProvider) Apply(originator *types.Originator, eventName string, payload interface{}) (*types.Originator, error) {
+	if originator == nil {
+		return nil, fmt.Errorf("empty originator : %w", InvalidArgumentError)
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.crudStore.Apply(originator, eventName, string(payloadJSON))
+}
+
 func (client *clientProvider) ListWithPagination(result interface{}, fromID string, size int) (string, error) {
+	return client.ListWithQuery(result, &eventstore2.EntityQuery{
+		Cursor: fromID,
+		Size:   size,
+	})
+}
+
+// ListWithQuery lists the entities matching the query into result which should be the
+// address of a slice of struct pointers ie. *[]*User. It returns the cursor for the next page.
+func (client *clientProvider) ListWithQuery(result interface{}, query *eventstore2.EntityQuery) (string, error) {
+	return client.listInto(result, func(entityType string) ([]*types.CrudEntity, string, error) {
+		return client.crudStore.ListEntities(entityType, query)
+	})
+}
+
+// ListAsOf lists up to size entities which existed at the given time with the state they
+// had then into result, which should be the address of a slice of struct pointers ie.
+// *[]*User. The returned cursor should be passed as fromID to the next call, a page can
+// be shorter than size before the end and the cursor is empty after the last one.
+func (client *clientProvider) ListAsOf(result interface{}, asOf time.Time, fromID string, size int) (string, error) {
+	return client.listInto(result, func(entityType string) ([]*types.CrudEntity, string, error) {
+		return client.crudStore.ListAsOf(entityType, asOf, fromID, size)
+	})
+}
+
+// listInto decodes the entities returned by list into result, list gets the entity type
+// of the slice elements
+func (client *clientProvider) listInto(result interface{}, list func(entityType string) ([]*types.CrudEntity, string, error)) (string, error) {
 	resultv := reflect.ValueOf(result)
 	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
 		return "", fmt.Errorf("result argument must be a slice address")
@@ -196,96 +359,74 @@ func (client *clientProvider) ListWithPagination(result interface{}, fromID stri
 		return "", fmt.Errorf("the slice should contain addresses to objects ie. []*Object")
 	}
 
-	elemp := reflect.New(elemt.Elem())
-	msg := elemp.Interface()
-
-	entityType := EntityTypeFromStruct(msg)
-	results, lastID, err := client.crudStore.List(
-		entityType,
-		fromID,
-		size,
-	)
+	accessor, err := newOriginatorAccessor(elemt)
+	if err != nil {
+		return "", err
+	}
 
+	entityType := entityTypeOf(elemt.Elem())
+	entities, lastID, err := list(entityType)
 	if err != nil {
 		return "", err
 	}
 
 	i := 0
-	var latestOriginator *types.Originator
-	for _, resOriginator := range results {
+	for _, entity := range entities {
 		elemp := reflect.New(elemt.Elem())
-		msg := elemp.Interface()
-
-		p, originator, err := client.crudStore.Get(resOriginator, false)
-		if err != nil {
-			log.Printf("Skipping originator : %+v because of : %v \n", originator, err)
-			continue
-		}
-		latestOriginator = originator
-
-		if err := json.Unmarshal([]byte(p), msg); err != nil {
-			return "", fmt.Errorf("list : payload : %s, entityType : %s: %w", p, entityType, err)
+		if err := json.Unmarshal([]byte(entity.Payload), elemp.Interface()); err != nil {
+			return "", fmt.Errorf("list : payload : %s, entityType : %s: %w", entity.Payload, entityType, err)
 		}
-
-		if err := client.setOriginatorForMsg(msg, originator); err != nil {
-			return "", err
-		}
-
-		msgValue := reflect.ValueOf(msg)
+		accessor.set(elemp, entity.Originator)
 
 		if slicev.Len() == i {
-			slicev = reflect.Append(slicev, msgValue)
+			slicev = reflect.Append(slicev, elemp)
 			slicev = slicev.Slice(0, slicev.Cap())
 		} else {
-			slicev.Index(i).Set(msgValue)
+			slicev.Index(i).Set(elemp)
 		}
 
 		i++
 	}
 	resultv.Elem().Set(slicev.Slice(0, i))
-	if latestOriginator == nil {
+	if i == 0 {
 		return "", nil
 	}
 	return lastID, nil
 }
 
-func (client *clientProvider) setOriginatorForMsg(msg interface{}, originator *types.Originator) error {
-	s := reflect.ValueOf(msg).Elem()
-	typeOfT := s.Type()
+// History returns all of the versions of the entity, use HistoryEntry.DecodeState to
+// get the state of a version as a struct
+func (client *clientProvider) History(originatorID string) ([]*HistoryEntry, error) {
+	return client.crudStore.History(originatorID)
+}
 
-	for i := 0; i < s.NumField(); i++ {
-		f := s.Field(i)
+// HistoryMany returns the histories of the entities grouped by their IDs, the missing
+// and purged entities are left out
+func (client *clientProvider) HistoryMany(originatorIDs []string) (map[string][]*HistoryEntry, error) {
+	return client.crudStore.HistoryMany(originatorIDs)
+}
 
-		if typeOfT.Field(i).Name == "Originator" {
-			originatorValue := reflect.ValueOf(originator)
-			f.Set(originatorValue)
-			return nil
-		}
+// Purge erases the events and the state of the entity, it returns the originator of the
+// event recording the purge
+func (client *clientProvider) Purge(originatorID string) (*types.Originator, error) {
+	return client.crudStore.Purge(originatorID)
+}
+
+func (client *clientProvider) setOriginatorForMsg(msg interface{}, originator *types.Originator) error {
+	accessor, err := newOriginatorAccessor(reflect.TypeOf(msg))
+	if err != nil {
+		return err
 	}
 
-	return fmt.Errorf("originator field was not found in the message")
+	accessor.set(reflect.ValueOf(msg), originator)
+	return nil
 }
 
 func (client *clientProvider) extractOriginatorFromMsg(msg interface{}) (*types.Originator, bool) {
-	s := reflect.ValueOf(msg).Elem()
-	typeOfT := s.Type()
-
-	for i := 0; i < s.NumField(); i++ {
-		f := s.Field(i)
-
-		//log.Println("Checking the field : ", typeOfT.Field(i).Name)
-		if typeOfT.Field(i).Name == "Originator" {
-			i := f.Interface()
-			originator, ok := i.(*types.Originator)
-			//log.Println("Found the originator inside the message : ", originator)
-			return originator, ok
-		}
+	accessor, err := newOriginatorAccessor(reflect.TypeOf(msg))
+	if err != nil {
+		return nil, false
 	}
 
-	return nil, false
-}
-
-func EntityTypeFromStruct(msg interface{}) string {
-	t := reflect.TypeOf(msg)
-	return t.Elem().Name()
+	return accessor.get(reflect.ValueOf(msg)), true
 }
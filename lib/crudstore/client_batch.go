@@ -0,0 +1,134 @@
+package crudstore
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/makkalot/eskit/lib/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Batch collects the changes of several entities which are stored atomically by Commit, ie:
+//
+//	results, err := client.Batch().
+//	    Create(&user).
+//	    Create(&workspace).
+//	    Commit()
+type Batch struct {
+	client *clientProvider
+	ops    []*BatchOperation
+	// msgs get the new originators after the commit, nil for deletes
+	msgs []interface{}
+	// errs are the errors found while collecting the operations
+	errs []error
+}
+
+// Batch starts a new batch of operations
+func (client *clientProvider) Batch() *Batch {
+	return &Batch{client: client}
+}
+
+// Create adds the creation of msg to the batch, an originator is generated when msg doesn't have one
+func (b *Batch) Create(msg interface{}) *Batch {
+	if err := b.client.checkIfPtr(msg); err != nil {
+		return b.add(nil, nil, err)
+	}
+
+	originator, _ := b.client.extractOriginatorFromMsg(msg)
+	if originator == nil {
+		originator = &types.Originator{
+			ID:      uuid.Must(uuid.NewV4()).String(),
+			Version: 1,
+		}
+	}
+
+	payloadJSON, err := json.Marshal(msg)
+	if err != nil {
+		return b.add(nil, nil, err)
+	}
+
+	return b.add(&BatchOperation{
+		Type:       BatchCreate,
+		EntityType: EntityTypeFromStruct(msg),
+		Originator: originator,
+		Payload:    string(payloadJSON),
+	}, msg, nil)
+}
+
+// Update adds the update of msg to the batch, msg should have its originator set
+func (b *Batch) Update(msg interface{}) *Batch {
+	originator, ok := b.client.extractOriginatorFromMsg(msg)
+	if !ok || originator == nil {
+		return b.add(nil, nil, fmt.Errorf("empty originator found inside the message : %w", InvalidArgumentError))
+	}
+
+	payloadJSON, err := json.Marshal(msg)
+	if err != nil {
+		return b.add(nil, nil, err)
+	}
+
+	return b.add(&BatchOperation{
+		Type:       BatchUpdate,
+		EntityType: EntityTypeFromStruct(msg),
+		Originator: originator,
+		Payload:    string(payloadJSON),
+	}, msg, nil)
+}
+
+// Delete adds the deletion of the entity to the batch, msg is only used for the entity type
+func (b *Batch) Delete(originator *types.Originator, msg interface{}) *Batch {
+	if originator == nil {
+		return b.add(nil, nil, fmt.Errorf("empty originator : %w", InvalidArgumentError))
+	}
+
+	return b.add(&BatchOperation{
+		Type:       BatchDelete,
+		EntityType: EntityTypeFromStruct(msg),
+		Originator: originator,
+	}, nil, nil)
+}
+
+// Len returns the number of operations in the batch
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Commit stores all of the operations or none of them, the results are in the order the
+// operations were added. On success the created and updated messages get their new originators.
+func (b *Batch) Commit() ([]*BatchResult, error) {
+	for i, err := range b.errs {
+		if err == nil {
+			continue
+		}
+
+		results := make([]*BatchResult, len(b.ops))
+		for j := range results {
+			results[j] = &BatchResult{Err: b.errs[j]}
+		}
+		return abortBatch(results), fmt.Errorf("operation %d : %w", i, err)
+	}
+
+	results, err := b.client.crudStore.Batch(b.ops)
+	if err != nil {
+		return results, err
+	}
+
+	for i, msg := range b.msgs {
+		if msg == nil {
+			continue
+		}
+
+		if err := b.client.setOriginatorForMsg(msg, results[i].Originator); err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+func (b *Batch) add(op *BatchOperation, msg interface{}, err error) *Batch {
+	b.ops = append(b.ops, op)
+	b.msgs = append(b.msgs, msg)
+	b.errs = append(b.errs, err)
+	return b
+}
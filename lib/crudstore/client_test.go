@@ -264,20 +264,7 @@ func TestCrudList(t *testing.T) {
 		assert.Equal(t, updateOriginator, users[0].Originator, "user updated originator mismatch")
 		assert.Equal(t, user.FirstName, users[0].FirstName, "haven't fetched the last one")
 
-		// it should be again the same record
-		lastOffsetID, listErr = client.ListWithPagination(&users, lastOffsetID, 1)
-		t.Logf("the last offset id is like : %+v", lastOffsetID)
-
-		assert.NoError(t, listErr, "listing failed")
-		assert.NotEmpty(t, lastOffsetID, "originator should not be empty")
-		assert.Len(t, users, 1, "users should be at length 2")
-
-		// check the first user details
-		assert.Equal(t, user.Originator, users[0].Originator, "user originator mismatch")
-		assert.Equal(t, updateOriginator, users[0].Originator, "user updated originator mismatch")
-		assert.Equal(t, user.FirstName, users[0].FirstName, "haven't fetched the last one")
-
-		// now we should we fetch the last record
+		// the update of the first record doesn't move it so we should fetch the last record
 		lastOffsetID, listErr = client.ListWithPagination(&users, lastOffsetID, 1)
 		t.Logf("the last offset id is like : %+v", lastOffsetID)
 
@@ -376,3 +363,87 @@ func TestCrudDelete(t *testing.T) {
 
 	})
 }
+
+func TestCrudUndeleteAndRevert(t *testing.T) {
+	crudStore, err := NewCrudStoreProvider(context.Background(), eventstore2.NewInMemoryStore())
+	assert.NoError(t, err)
+
+	client := NewClientWithStore(crudStore)
+
+	user := User{Email: "makkalotrevert@gmail.com", Active: true}
+	originator, err := client.Create(&user)
+	assert.NoError(t, err)
+
+	user.Active = false
+	_, err = client.Update(&user)
+	assert.NoError(t, err)
+
+	var reverted User
+	revertedOriginator, err := client.RevertTo(&types.Originator{ID: originator.ID}, 1, &reverted)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), revertedOriginator.Version)
+	assert.True(t, reverted.Active)
+	assert.Equal(t, revertedOriginator, reverted.Originator)
+
+	_, err = client.Delete(revertedOriginator, &User{})
+	assert.NoError(t, err)
+
+	var restored User
+	restoredOriginator, err := client.Undelete(&types.Originator{ID: originator.ID}, &restored)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), restoredOriginator.Version)
+	assert.Equal(t, reverted.Email, restored.Email)
+	assert.True(t, restored.Active)
+
+	var users []*User
+	_, err = client.ListWithPagination(&users, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+}
+
+func TestCrudListWithQuery(t *testing.T) {
+	crudStore, err := NewCrudStoreProvider(context.Background(), eventstore2.NewInMemoryStore())
+	assert.NoError(t, err)
+
+	client := NewClientWithStore(crudStore)
+	for _, u := range []*User{
+		{Email: "b@gmail.com", Active: true},
+		{Email: "a@gmail.com", Active: true},
+		{Email: "c@gmail.com", Active: false},
+	} {
+		_, err := client.Create(u)
+		assert.NoError(t, err)
+	}
+
+	var users []*User
+	cursor, err := client.ListWithQuery(&users, &eventstore2.EntityQuery{
+		Filters: []eventstore2.EntityFilter{{Field: "Active", Value: true}},
+		OrderBy: "Email",
+		Size:    1,
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cursor)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "a@gmail.com", users[0].Email)
+	assert.NotNil(t, users[0].Originator)
+
+	cursor, err = client.ListWithQuery(&users, &eventstore2.EntityQuery{
+		Filters: []eventstore2.EntityFilter{{Field: "Active", Value: true}},
+		OrderBy: "Email",
+		Size:    1,
+		Cursor:  cursor,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "b@gmail.com", users[0].Email)
+
+	cursor, err = client.ListWithQuery(&users, &eventstore2.EntityQuery{
+		Filters: []eventstore2.EntityFilter{{Field: "Active", Value: true}},
+		OrderBy: "Email",
+		Size:    1,
+		Cursor:  cursor,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, cursor)
+	assert.Len(t, users, 0)
+}
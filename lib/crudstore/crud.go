@@ -8,8 +8,11 @@ import (
 	"github.com/makkalot/eskit/lib/eventstore"
 	"github.com/makkalot/eskit/lib/types"
 	"gopkg.in/evanphx/json-patch.v3"
+	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,6 +21,10 @@ var (
 	RecordDeleted  = errors.New("deleted")
 )
 
+// RevertedToMetadataKey is the metadata key of Reverted events holding the version
+// the entity was reverted to
+const RevertedToMetadataKey = "reverted_to_version"
+
 func IsErrNotFound(err error) bool {
 	return errors.Is(err, RecordNotFound)
 }
@@ -36,20 +43,66 @@ type CrudStore interface {
 	Get(originator *types.Originator, deleted bool) (string, *types.Originator, error)
 	Delete(entityType string, originator *types.Originator) (*types.Originator, error)
 	List(entityType, fromID string, size int) ([]*types.Originator, string, error)
+	ListEntities(entityType string, query *eventstore.EntityQuery) ([]*types.CrudEntity, string, error)
+	RebuildEntities(entityType string) error
+	History(originatorID string) ([]*HistoryEntry, error)
+	HistoryMany(originatorIDs []string) (map[string][]*HistoryEntry, error)
+	Undelete(entityType string, originator *types.Originator) (*types.Originator, error)
+	RevertTo(entityType string, originator *types.Originator, version uint64) (*types.Originator, error)
+	Patch(entityType string, originator *types.Originator, ops string) (*types.Originator, error)
+	Apply(originator *types.Originator, eventName string, payload string) (*types.Originator, error)
+	Purge(originatorID string) (*types.Originator, error)
+	GetAsOf(originatorID string, asOf time.Time) (string, *types.Originator, error)
+	ListAsOf(entityType string, asOf time.Time, fromID string, size int) ([]*types.CrudEntity, string, error)
+	Batch(ops []*BatchOperation) ([]*BatchResult, error)
 }
 
 type CrudStoreProvider struct {
-	ctx    context.Context
-	estore eventstore.Store
+	ctx      context.Context
+	estore   eventstore.Store
+	registry *EntityRegistry
+	// cache keeps the latest states of the entities, it's nil when caching is disabled
+	cache *stateCache
+
+	rebuiltMu sync.Mutex
+	// rebuilt are the entity types known to have all of their entities in the event store
+	rebuilt map[string]bool
 }
 
 func NewCrudStoreProvider(ctx context.Context, estore eventstore.Store) (CrudStore, error) {
+	return NewCrudStoreProviderWithRegistry(ctx, estore, DefaultRegistry)
+}
+
+// NewCrudStoreProviderWithRegistry creates a crud store which resolves entity type aliases
+// from the given registry instead of the DefaultRegistry
+func NewCrudStoreProviderWithRegistry(ctx context.Context, estore eventstore.Store, registry *EntityRegistry) (CrudStore, error) {
+	if registry == nil {
+		return nil, fmt.Errorf("empty registry")
+	}
+
 	return &CrudStoreProvider{
-		ctx:    ctx,
-		estore: estore,
+		ctx:      ctx,
+		estore:   estore,
+		registry: registry,
 	}, nil
 }
 
+// NewCrudStoreProviderWithCache creates a crud store which keeps the latest state of up to
+// cacheSize entities in memory, Get only reads the events appended after the cached version
+func NewCrudStoreProviderWithCache(ctx context.Context, estore eventstore.Store, registry *EntityRegistry, cacheSize int) (CrudStore, error) {
+	if cacheSize <= 0 {
+		return nil, fmt.Errorf("invalid cache size %d : %w", cacheSize, InvalidArgumentError)
+	}
+
+	crudStore, err := NewCrudStoreProviderWithRegistry(ctx, estore, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	crudStore.(*CrudStoreProvider).cache = newStateCache(cacheSize)
+	return crudStore, nil
+}
+
 func (crud *CrudStoreProvider) Create(entityType string, originator *types.Originator, payload string) error {
 	if originator == nil {
 		return fmt.Errorf("empty originator")
@@ -61,13 +114,13 @@ func (crud *CrudStoreProvider) Create(entityType string, originator *types.Origi
 
 	event := &types.Event{
 		Originator: originator,
-		EventType:  fmt.Sprintf("%s.Created", entityType),
+		EventType:  fmt.Sprintf("%s.Created", crud.registry.Resolve(entityType)),
 		Payload:    payload,
 		OccurredOn: time.Now().UTC(),
 	}
 
 	//log.Printf("Appending Create Event : %s", spew.Sdump(event))
-	return crud.estore.Append(event)
+	return crud.append(event, types.NewCrudEntity(crud.registry.Resolve(entityType), originator, payload, false))
 }
 
 func (crud *CrudStoreProvider) Update(entityType string, originator *types.Originator, payload string) (*types.Originator, error) {
@@ -94,14 +147,19 @@ func (crud *CrudStoreProvider) Update(entityType string, originator *types.Origi
 	//log.Println("Patch : payload : ", string(payload))
 	//log.Println("Patch : patch : ", string(patch))
 
+	newObj, err := jsonpatch.MergePatch([]byte(latestObj), patch)
+	if err != nil {
+		return nil, fmt.Errorf("apply patch : %v", err)
+	}
+
 	event := &types.Event{
 		Originator: newOriginator,
-		EventType:  fmt.Sprintf("%s.Updated", entityType),
+		EventType:  fmt.Sprintf("%s.Updated", crud.registry.Resolve(entityType)),
 		Payload:    string(patch),
 		OccurredOn: time.Now().UTC(),
 	}
 
-	err = crud.estore.Append(event)
+	err = crud.append(event, types.NewCrudEntity(crud.registry.Resolve(entityType), newOriginator, string(newObj), false))
 	if err != nil {
 		return nil, err
 	}
@@ -109,16 +167,29 @@ func (crud *CrudStoreProvider) Update(entityType string, originator *types.Origi
 }
 
 func (crud *CrudStoreProvider) Get(originator *types.Originator, deleted bool) (string, *types.Originator, error) {
+	if crud.cache != nil {
+		return crud.cachedGet(originator, deleted)
+	}
+
 	events, err := crud.estore.Get(originator, false)
 	if err != nil {
 		return "", nil, err
 	}
 
+	return crud.replay(originator, events, deleted)
+}
+
+// replay folds the events into the state of the entity at originator's version
+func (crud *CrudStoreProvider) replay(originator *types.Originator, events []*types.Event, deleted bool) (string, *types.Originator, error) {
 	if events == nil || len(events) == 0 {
 		return "", nil, fmt.Errorf("%w", RecordNotFound)
 	}
 
 	latestEvent := events[len(events)-1]
+	if eventstore.IsPurged(latestEvent) {
+		return "", nil, fmt.Errorf("purged : %w", RecordNotFound)
+	}
+
 	if crud.isEventDeleted(latestEvent) && !deleted {
 		return "", nil, fmt.Errorf("%w", RecordDeleted)
 	}
@@ -138,10 +209,11 @@ func (crud *CrudStoreProvider) Get(originator *types.Originator, deleted bool) (
 		return string(currentPayload), currentOriginator, nil
 	}
 
+	var err error
 	for _, e := range events[1:] {
 
-		// ignore non crud events
-		if !crud.isCrudEvent(e) {
+		// ignore the events which don't change the state
+		if !crud.isStateEvent(e) {
 			continue
 		}
 
@@ -149,9 +221,9 @@ func (crud *CrudStoreProvider) Get(originator *types.Originator, deleted bool) (
 			continue
 		}
 
-		currentPayload, err = jsonpatch.MergePatch([]byte(currentPayload), []byte(e.Payload))
+		currentPayload, err = crud.applyEvent(currentPayload, e)
 		if err != nil {
-			return "", nil, fmt.Errorf("apply patch : %v", err)
+			return "", nil, err
 		}
 
 		currentOriginator = e.Originator
@@ -161,7 +233,330 @@ func (crud *CrudStoreProvider) Get(originator *types.Originator, deleted bool) (
 
 }
 
+// cachedGet serves Get from the state cache, the cached state is brought up to date with
+// the events appended after it so the states changed by other instances are never stale
+func (crud *CrudStoreProvider) cachedGet(originator *types.Originator, deleted bool) (string, *types.Originator, error) {
+	state, ok := crud.cache.get(originator.ID)
+	if !ok || (originator.Version != 0 && originator.Version < state.head) {
+		cacheMisses.Inc()
+		return crud.getAndCache(originator, deleted)
+	}
+
+	newer, err := crud.estore.Get(&types.Originator{ID: originator.ID, Version: state.head + 1}, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(newer) > 0 {
+		state, err = crud.catchUp(state, newer)
+		if err != nil {
+			crud.cache.remove(originator.ID)
+			return "", nil, err
+		}
+		crud.cache.put(originator.ID, state)
+	}
+
+	if originator.Version != 0 && originator.Version < state.head {
+		// an older version was asked for, it's not cached
+		cacheMisses.Inc()
+		return crud.getAndCache(originator, deleted)
+	}
+
+	cacheHits.Inc()
+	return stateResult(originator, state, deleted)
+}
+
+// getAndCache replays the full stream, caches the state at its head and returns the
+// state at originator's version
+func (crud *CrudStoreProvider) getAndCache(originator *types.Originator, deleted bool) (string, *types.Originator, error) {
+	events, err := crud.estore.Get(&types.Originator{ID: originator.ID}, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	payload, stateOriginator, err := crud.replay(&types.Originator{ID: originator.ID}, events, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	head := events[len(events)-1]
+	state := &cachedState{
+		payload:    payload,
+		originator: stateOriginator,
+		head:       head.Originator.Version,
+		deleted:    crud.isEventDeleted(head),
+	}
+	crud.cache.put(originator.ID, state)
+
+	if originator.Version != 0 && originator.Version < state.head {
+		var older []*types.Event
+		for _, e := range events {
+			if e.Originator.Version <= originator.Version {
+				older = append(older, e)
+			}
+		}
+		return crud.replay(originator, older, deleted)
+	}
+
+	return stateResult(originator, state, deleted)
+}
+
+// catchUp returns the state after applying the newer events of the stream
+func (crud *CrudStoreProvider) catchUp(state *cachedState, newer []*types.Event) (*cachedState, error) {
+	payload := []byte(state.payload)
+	next := &cachedState{originator: state.originator}
+
+	var err error
+	for _, e := range newer {
+		if eventstore.IsPurged(e) {
+			return nil, fmt.Errorf("purged : %w", RecordNotFound)
+		}
+
+		next.head = e.Originator.Version
+		next.deleted = crud.isEventDeleted(e)
+
+		if !crud.isStateEvent(e) || next.deleted {
+			continue
+		}
+
+		payload, err = crud.applyEvent(payload, e)
+		if err != nil {
+			return nil, err
+		}
+		next.originator = e.Originator
+	}
+
+	next.payload = string(payload)
+	return next, nil
+}
+
+// stateResult returns the cached state the same way replay does
+func stateResult(originator *types.Originator, state *cachedState, deleted bool) (string, *types.Originator, error) {
+	if state.deleted && !deleted {
+		return "", nil, fmt.Errorf("%w", RecordDeleted)
+	}
+
+	// the version we're looking for is not created yet
+	if originator.Version > state.head {
+		return "", nil, fmt.Errorf("%w", RecordNotFound)
+	}
+
+	return state.payload, &types.Originator{ID: state.originator.ID, Version: state.originator.Version}, nil
+}
+
+// applyEvent returns the state of the entity after the crud event, the created and reverted
+// events carry the full state, updated a merge patch, patched JSON Patch operations and the
+// deleted and restored ones don't change it. Custom events are folded in with their
+// registered reducer.
+func (crud *CrudStoreProvider) applyEvent(state []byte, event *types.Event) ([]byte, error) {
+	switch strings.ToLower(common.ExtractEventType(event)) {
+	case "created", "reverted":
+		return []byte(event.Payload), nil
+	case "updated":
+		newState, err := jsonpatch.MergePatch(state, []byte(event.Payload))
+		if err != nil {
+			return nil, fmt.Errorf("apply patch : %v", err)
+		}
+		return newState, nil
+	case "patched":
+		return applyPatch(state, event.Payload)
+	}
+
+	if reducer, ok := crud.registry.reducerOf(event); ok {
+		newState, err := reducer(string(state), event.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("applying %s : %w", event.EventType, err)
+		}
+		return []byte(newState), nil
+	}
+
+	return state, nil
+}
+
+// List returns the originators of the entities of entityType, when the event store keeps
+// the current state of the entities it's listed from there otherwise from the application log
 func (crud *CrudStoreProvider) List(entityType, fromID string, size int) ([]*types.Originator, string, error) {
+	if _, ok := crud.estore.(eventstore.EntityStore); !ok {
+		return crud.listFromLogs(entityType, fromID, size)
+	}
+
+	entities, cursor, err := crud.ListEntities(entityType, &eventstore.EntityQuery{
+		Cursor: fromID,
+		Size:   size,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var results []*types.Originator
+	for _, e := range entities {
+		results = append(results, e.Originator)
+	}
+
+	return results, cursor, nil
+}
+
+// ListEntities lists the current state of the entities of entityType including the ones
+// stored under its aliases. Filtering and ordering need an event store implementing
+// eventstore.EntityStore, the others can only list in log order.
+func (crud *CrudStoreProvider) ListEntities(entityType string, query *eventstore.EntityQuery) ([]*types.CrudEntity, string, error) {
+	if query == nil {
+		query = &eventstore.EntityQuery{}
+	}
+
+	entityType = crud.registry.Resolve(entityType)
+	if entityStore, ok := crud.estore.(eventstore.EntityStore); ok {
+		if err := crud.ensureEntities(entityType); err != nil {
+			return nil, "", err
+		}
+		return entityStore.ListEntities(crud.registry.partitions(entityType), query)
+	}
+
+	if len(query.Filters) > 0 || query.OrderBy != "" || query.Descending || query.Deleted {
+		return nil, "", fmt.Errorf("event store can't filter or order entities : %w", eventstore.ErrInvalidQuery)
+	}
+
+	originators, cursor, err := crud.listFromLogs(entityType, query.Cursor, query.Size)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids := make([]string, 0, len(originators))
+	for _, o := range originators {
+		ids = append(ids, o.ID)
+	}
+
+	streams, err := crud.estore.GetMany(ids)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var results []*types.CrudEntity
+	for _, o := range originators {
+		payload, latestOriginator, err := crud.replay(o, streams[o.ID], false)
+		if err != nil {
+			log.Printf("Skipping originator : %+v because of : %v \n", o, err)
+			continue
+		}
+		results = append(results, types.NewCrudEntity(entityType, latestOriginator, payload, false))
+	}
+
+	if len(results) == 0 {
+		return results, "", nil
+	}
+
+	return results, cursor, nil
+}
+
+// ensureEntities rebuilds the entities of entityType the first time they're listed from a
+// store which can hold events appended before it kept the entities
+func (crud *CrudStoreProvider) ensureEntities(entityType string) error {
+	tracker, ok := crud.estore.(eventstore.EntityRebuildTracker)
+	if !ok {
+		return nil
+	}
+
+	crud.rebuiltMu.Lock()
+	defer crud.rebuiltMu.Unlock()
+	if crud.rebuilt[entityType] {
+		return nil
+	}
+
+	rebuilt, err := tracker.EntitiesRebuilt(entityType)
+	if err != nil {
+		return err
+	}
+
+	if !rebuilt {
+		log.Printf("rebuilding the entities of %s from the log", entityType)
+		if err := crud.RebuildEntities(entityType); err != nil {
+			return err
+		}
+	}
+
+	if crud.rebuilt == nil {
+		crud.rebuilt = map[string]bool{}
+	}
+	crud.rebuilt[entityType] = true
+	return nil
+}
+
+// RebuildEntities replays the application log of entityType and saves the current
+// state of every entity, it's used to fill the entity table of the events stored
+// before the store kept track of them. The entities are rebuilt automatically the first
+// time they're listed, it can be called before to avoid the delay.
+func (crud *CrudStoreProvider) RebuildEntities(entityType string) error {
+	entityStore, ok := crud.estore.(eventstore.EntityStore)
+	if !ok {
+		return fmt.Errorf("event store doesn't keep the entities")
+	}
+
+	entityType = crud.registry.Resolve(entityType)
+
+	// the log ID each entity was created at, it's the default listing order
+	var originators []string
+	seqs := map[string]uint64{}
+
+	var fromID uint64 = 1
+	for {
+		logs, err := crud.logs(entityType, fromID, 100)
+		if err != nil {
+			return err
+		}
+
+		if len(logs) == 0 {
+			break
+		}
+
+		for _, entry := range logs {
+			id := entry.Event.Originator.ID
+			if _, ok := seqs[id]; !ok {
+				seqs[id] = entry.ID
+				originators = append(originators, id)
+			}
+		}
+
+		fromID = logs[len(logs)-1].ID + 1
+	}
+
+	for start := 0; start < len(originators); start += 100 {
+		end := start + 100
+		if end > len(originators) {
+			end = len(originators)
+		}
+
+		streams, err := crud.estore.GetMany(originators[start:end])
+		if err != nil {
+			return err
+		}
+
+		for _, id := range originators[start:end] {
+			events := streams[id]
+			payload, _, err := crud.replay(&types.Originator{ID: id}, events, true)
+			if IsErrNotFound(err) {
+				// purged entities don't have a state anymore
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("rebuilding %s : %w", id, err)
+			}
+
+			latestEvent := events[len(events)-1]
+			entity := types.NewCrudEntity(entityType, latestEvent.Originator, payload, crud.isEventDeleted(latestEvent))
+			if err := entityStore.SaveEntity(entity, seqs[id]); err != nil {
+				return fmt.Errorf("rebuilding %s : %w", id, err)
+			}
+		}
+	}
+
+	if tracker, ok := crud.estore.(eventstore.EntityRebuildTracker); ok {
+		return tracker.MarkEntitiesRebuilt(entityType)
+	}
+	return nil
+}
+
+// listFromLogs scans the application log for the originators of entityType
+func (crud *CrudStoreProvider) listFromLogs(entityType, fromID string, size int) ([]*types.Originator, string, error) {
 	if fromID == "" {
 		fromID = "0"
 	}
@@ -178,9 +573,7 @@ func (crud *CrudStoreProvider) List(entityType, fromID string, size int) ([]*typ
 		return nil, "", fmt.Errorf("invalid fromID : %v", err)
 	}
 
-	logs, err := crud.estore.Logs(fromIDInt,
-		uint32(eventSize),
-		entityType)
+	logs, err := crud.logs(crud.registry.Resolve(entityType), fromIDInt, uint32(eventSize))
 
 	if err != nil {
 		return nil, "", err
@@ -191,11 +584,17 @@ func (crud *CrudStoreProvider) List(entityType, fromID string, size int) ([]*typ
 	}
 
 	found := map[string]bool{}
+	seen := map[string]bool{}
 	var preResults []*types.Originator
 	var results []*types.Originator
 	var lastID uint64
 
 	for _, entry := range logs {
+		lastID = entry.ID
+		if eventstore.IsTombstone(entry.Event) || eventstore.IsPurged(entry.Event) {
+			continue
+		}
+
 		originatorID := entry.Event.Originator.ID
 		if _, ok := found[originatorID]; ok {
 			if crud.isEventDeleted(entry.Event) {
@@ -204,13 +603,16 @@ func (crud *CrudStoreProvider) List(entityType, fromID string, size int) ([]*typ
 		} else {
 			if !crud.isEventDeleted(entry.Event) {
 				found[originatorID] = true
-				preResults = append(preResults, &types.Originator{
-					ID: originatorID,
-				})
+				// restored entities are found again, keep their first position
+				if !seen[originatorID] {
+					seen[originatorID] = true
+					preResults = append(preResults, &types.Originator{
+						ID: originatorID,
+					})
+				}
 			}
 		}
 
-		lastID = entry.ID
 		if len(found) >= size {
 			break
 		}
@@ -230,15 +632,69 @@ func (crud *CrudStoreProvider) List(entityType, fromID string, size int) ([]*typ
 	return results, strconv.FormatUint(lastID, 10), nil
 }
 
+// append appends the event together with the new state of the entity when the
+// event store keeps track of the entities
+func (crud *CrudStoreProvider) append(event *types.Event, entity *types.CrudEntity) error {
+	if crud.cache != nil {
+		defer crud.cache.remove(event.Originator.ID)
+	}
+
+	if entityStore, ok := crud.estore.(eventstore.EntityStore); ok {
+		return entityStore.AppendWithEntity(event, entity)
+	}
+	return crud.estore.Append(event)
+}
+
+// logs fetches the log entries of entityType including the ones stored under its aliases
+func (crud *CrudStoreProvider) logs(entityType string, fromID uint64, size uint32) ([]*types.AppLogEntry, error) {
+	partitions := crud.registry.partitions(entityType)
+	if len(partitions) == 1 {
+		return crud.estore.Logs(fromID, size, entityType)
+	}
+
+	var merged []*types.AppLogEntry
+	for _, partition := range partitions {
+		logs, err := crud.estore.Logs(fromID, size, partition)
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, logs...)
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].ID < merged[j].ID
+	})
+
+	if len(merged) > int(size) {
+		merged = merged[:size]
+	}
+
+	return merged, nil
+}
+
 func (crud *CrudStoreProvider) isEventDeleted(event *types.Event) bool {
 	eventType := common.ExtractEventType(event)
 	return strings.ToLower(eventType) == "deleted"
 }
 
 func (crud *CrudStoreProvider) isCrudEvent(event *types.Event) bool {
-	eventType := common.ExtractEventType(event)
-	switch strings.ToLower(eventType) {
-	case "created", "updated", "deleted":
+	return isCrudEventName(common.ExtractEventType(event))
+}
+
+// isStateEvent returns true for the events replayed into the state of the entity, the crud
+// events and the custom ones with a registered reducer
+func (crud *CrudStoreProvider) isStateEvent(event *types.Event) bool {
+	if crud.isCrudEvent(event) {
+		return true
+	}
+
+	_, ok := crud.registry.reducerOf(event)
+	return ok
+}
+
+func isCrudEventName(eventName string) bool {
+	switch strings.ToLower(eventName) {
+	case "created", "updated", "patched", "deleted", "restored", "reverted":
 		return true
 	default:
 		return false
@@ -246,24 +702,31 @@ func (crud *CrudStoreProvider) isCrudEvent(event *types.Event) bool {
 }
 
 func (crud *CrudStoreProvider) Delete(entityType string, originator *types.Originator) (*types.Originator, error) {
-	_, latestOriginator, err := crud.Get(originator, false)
+	// the state at the head is kept in the entity, the stream can have events after the
+	// last state change
+	latestObj, _, err := crud.Get(&types.Originator{ID: originator.ID}, false)
 	if err != nil {
 		return nil, err
 	}
 
-	newOriginator, err := common.IncrOriginator(latestOriginator)
+	head, err := crud.streamHead(originator)
+	if err != nil {
+		return nil, err
+	}
+
+	newOriginator, err := crud.nextOriginator(originator, head)
 	if err != nil {
 		return nil, err
 	}
 
 	event := &types.Event{
 		Originator: newOriginator,
-		EventType:  fmt.Sprintf("%s.Deleted", entityType),
+		EventType:  fmt.Sprintf("%s.Deleted", crud.registry.Resolve(entityType)),
 		Payload:    "{}",
 		OccurredOn: time.Now().UTC(),
 	}
 
-	err = crud.estore.Append(event)
+	err = crud.append(event, types.NewCrudEntity(crud.registry.Resolve(entityType), newOriginator, latestObj, true))
 	if err != nil {
 		return nil, err
 	}
@@ -271,3 +734,148 @@ func (crud *CrudStoreProvider) Delete(entityType string, originator *types.Origi
 	return newOriginator, nil
 
 }
+
+// Undelete restores a deleted entity with its last state by appending a Restored event.
+// When originator has a version it should be the version of the Deleted event.
+func (crud *CrudStoreProvider) Undelete(entityType string, originator *types.Originator) (*types.Originator, error) {
+	head, err := crud.streamHead(originator)
+	if err != nil {
+		return nil, err
+	}
+
+	if !crud.isEventDeleted(head) {
+		return nil, fmt.Errorf("%s is not deleted : %w", originator.ID, InvalidArgumentError)
+	}
+
+	latestObj, _, err := crud.Get(&types.Originator{ID: originator.ID}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	newOriginator, err := crud.nextOriginator(originator, head)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &types.Event{
+		Originator: newOriginator,
+		EventType:  fmt.Sprintf("%s.Restored", crud.registry.Resolve(entityType)),
+		Payload:    "{}",
+		OccurredOn: time.Now().UTC(),
+	}
+
+	err = crud.append(event, types.NewCrudEntity(crud.registry.Resolve(entityType), newOriginator, latestObj, false))
+	if err != nil {
+		return nil, err
+	}
+
+	return newOriginator, nil
+}
+
+// RevertTo brings the entity back to the state it had at version by appending a Reverted
+// event with that state, the versions in between stay in the history. When originator has
+// a version it should be the latest version of the entity.
+func (crud *CrudStoreProvider) RevertTo(entityType string, originator *types.Originator, version uint64) (*types.Originator, error) {
+	if version == 0 {
+		return nil, fmt.Errorf("missing version to revert to : %w", InvalidArgumentError)
+	}
+
+	head, err := crud.streamHead(originator)
+	if err != nil {
+		return nil, err
+	}
+
+	if crud.isEventDeleted(head) {
+		return nil, fmt.Errorf("%w", RecordDeleted)
+	}
+
+	if version >= head.Originator.Version {
+		return nil, fmt.Errorf("version %d is not older than the latest version %d : %w", version, head.Originator.Version, InvalidArgumentError)
+	}
+
+	revertedObj, _, err := crud.Get(&types.Originator{ID: originator.ID, Version: version}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	newOriginator, err := crud.nextOriginator(originator, head)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &types.Event{
+		Originator: newOriginator,
+		EventType:  fmt.Sprintf("%s.Reverted", crud.registry.Resolve(entityType)),
+		Payload:    revertedObj,
+		OccurredOn: time.Now().UTC(),
+		Metadata: map[string]string{
+			RevertedToMetadataKey: strconv.FormatUint(version, 10),
+		},
+	}
+
+	err = crud.append(event, types.NewCrudEntity(crud.registry.Resolve(entityType), newOriginator, revertedObj, false))
+	if err != nil {
+		return nil, err
+	}
+
+	return newOriginator, nil
+}
+
+// Purge erases the events and the state of the entity for compliance, the events are
+// replaced with tombstones so the application log keeps its IDs and the purge is recorded
+// as a <EntityType>.Purged event. The purged entity is not found afterwards.
+func (crud *CrudStoreProvider) Purge(originatorID string) (*types.Originator, error) {
+	if originatorID == "" {
+		return nil, fmt.Errorf("empty originator id : %w", InvalidArgumentError)
+	}
+
+	purger, ok := crud.estore.(eventstore.Purger)
+	if !ok {
+		return nil, fmt.Errorf("event store doesn't support purging")
+	}
+
+	purgedOriginator, err := purger.Purge(originatorID)
+	if crud.cache != nil {
+		crud.cache.remove(originatorID)
+	}
+	if errors.Is(err, eventstore.ErrStreamNotFound) {
+		return nil, fmt.Errorf("%v : %w", err, RecordNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return purgedOriginator, nil
+}
+
+// streamHead returns the last event stored for the entity
+func (crud *CrudStoreProvider) streamHead(originator *types.Originator) (*types.Event, error) {
+	if originator == nil || originator.ID == "" {
+		return nil, fmt.Errorf("empty originator : %w", InvalidArgumentError)
+	}
+
+	events, err := crud.estore.Get(&types.Originator{ID: originator.ID}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, fmt.Errorf("%w", RecordNotFound)
+	}
+
+	head := events[len(events)-1]
+	if eventstore.IsPurged(head) {
+		return nil, fmt.Errorf("purged : %w", RecordNotFound)
+	}
+
+	return head, nil
+}
+
+// nextOriginator returns the version of the next event, when the caller passed a version
+// it's used so a stale one fails with a duplicate error just like Update does
+func (crud *CrudStoreProvider) nextOriginator(originator *types.Originator, head *types.Event) (*types.Originator, error) {
+	if originator.Version != 0 {
+		return common.IncrOriginator(originator)
+	}
+	return common.IncrOriginator(head.Originator)
+}
@@ -3,6 +3,9 @@ package crudstore
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
 	"testing"
 
 	"github.com/makkalot/eskit/lib/eventstore"
@@ -670,6 +673,16 @@ func TestCrudStoreProvider_isCrudEvent(t *testing.T) {
 			eventType: "User.Deleted",
 			expected:  true,
 		},
+		{
+			name:      "restored event",
+			eventType: "User.Restored",
+			expected:  true,
+		},
+		{
+			name:      "reverted event",
+			eventType: "User.Reverted",
+			expected:  true,
+		},
 		{
 			name:      "custom event",
 			eventType: "User.CustomEvent",
@@ -710,3 +723,340 @@ func TestIsDuplicate(t *testing.T) {
 	assert.False(t, IsDuplicate(RecordNotFound))
 	assert.False(t, IsDuplicate(nil))
 }
+
+// logOnlyStore hides the entity table of the wrapped store
+type logOnlyStore struct {
+	eventstore.Store
+}
+
+func TestCrudStoreProvider_ListEntities(t *testing.T) {
+	estore := eventstore.NewInMemoryStore()
+	store, err := NewCrudStoreProvider(context.Background(), estore)
+	assert.NoError(t, err)
+
+	for i, name := range []string{"c", "a", "b"} {
+		err := store.Create("User", &types.Originator{ID: fmt.Sprintf("user-%d", i)}, fmt.Sprintf(`{"name":"%s","age":%d}`, name, i%2))
+		assert.NoError(t, err)
+	}
+
+	_, err = store.Update("User", &types.Originator{ID: "user-0", Version: 1}, `{"name":"d","age":0}`)
+	assert.NoError(t, err)
+
+	_, err = store.Delete("User", &types.Originator{ID: "user-2"})
+	assert.NoError(t, err)
+
+	entities, cursor, err := store.ListEntities("User", &eventstore.EntityQuery{OrderBy: "name"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cursor)
+	assert.Len(t, entities, 2)
+	assert.JSONEq(t, `{"age":1,"name":"a"}`, entities[0].Payload)
+	assert.JSONEq(t, `{"age":0,"name":"d"}`, entities[1].Payload)
+	assert.Equal(t, uint64(2), entities[1].Originator.Version)
+
+	entities, _, err = store.ListEntities("User", &eventstore.EntityQuery{
+		Filters: []eventstore.EntityFilter{{Field: "age", Value: 0}},
+		Deleted: true,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, entities, 2)
+	assert.Equal(t, "user-0", entities[0].Originator.ID)
+	assert.Equal(t, "user-2", entities[1].Originator.ID)
+	assert.True(t, entities[1].Deleted)
+
+	t.Run("log only store", func(t *testing.T) {
+		logStore, err := NewCrudStoreProvider(context.Background(), &logOnlyStore{estore})
+		assert.NoError(t, err)
+
+		entities, _, err := logStore.ListEntities("User", &eventstore.EntityQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, entities, 2)
+		assert.JSONEq(t, `{"age":0,"name":"d"}`, entities[0].Payload)
+
+		_, _, err = logStore.ListEntities("User", &eventstore.EntityQuery{OrderBy: "name"})
+		assert.ErrorIs(t, err, eventstore.ErrInvalidQuery)
+
+		assert.Error(t, logStore.RebuildEntities("User"))
+	})
+}
+
+func TestCrudStoreProvider_RebuildEntities(t *testing.T) {
+	estore := eventstore.NewInMemoryStore()
+
+	// entities written before the store kept track of them
+	logStore, err := NewCrudStoreProvider(context.Background(), &logOnlyStore{estore})
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, logStore.Create("User", &types.Originator{ID: fmt.Sprintf("user-%d", i)}, `{"name":"user"}`))
+	}
+	_, err = logStore.Update("User", &types.Originator{ID: "user-0", Version: 1}, `{"name":"updated"}`)
+	assert.NoError(t, err)
+	_, err = logStore.Delete("User", &types.Originator{ID: "user-1"})
+	assert.NoError(t, err)
+
+	store, err := NewCrudStoreProvider(context.Background(), estore)
+	assert.NoError(t, err)
+
+	entities, _, err := store.ListEntities("User", &eventstore.EntityQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, entities, 0)
+
+	assert.NoError(t, store.RebuildEntities("User"))
+
+	entities, _, err = store.ListEntities("User", &eventstore.EntityQuery{Deleted: true})
+	assert.NoError(t, err)
+	assert.Len(t, entities, 3)
+	assert.Equal(t, "user-0", entities[0].Originator.ID)
+	assert.Equal(t, uint64(2), entities[0].Originator.Version)
+	assert.JSONEq(t, `{"name":"updated"}`, entities[0].Payload)
+	assert.True(t, entities[1].Deleted)
+	assert.Equal(t, uint64(2), entities[1].Originator.Version)
+	assert.False(t, entities[2].Deleted)
+}
+
+func TestCrudStoreProvider_ListEntitiesBackfill(t *testing.T) {
+	sqlStore, err := eventstore.NewSqlStore("sqlite3", "backfill.db")
+	assert.NoError(t, err)
+	assert.NoError(t, sqlStore.Cleanup())
+
+	t.Cleanup(func() {
+		if _, err := os.Stat("backfill.db"); err == nil {
+			assert.NoError(t, os.Remove("backfill.db"))
+		}
+	})
+
+	// entities written before the store kept track of them
+	logStore, err := NewCrudStoreProvider(context.Background(), &logOnlyStore{sqlStore})
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, logStore.Create("User", &types.Originator{ID: fmt.Sprintf("user-%d", i)}, `{"name":"user"}`))
+	}
+	_, err = logStore.Update("User", &types.Originator{ID: "user-0", Version: 1}, `{"name":"updated"}`)
+	assert.NoError(t, err)
+
+	store, err := NewCrudStoreProvider(context.Background(), sqlStore)
+	assert.NoError(t, err)
+
+	entities, _, err := store.ListEntities("User", &eventstore.EntityQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, entities, 3)
+	assert.Equal(t, uint64(2), entities[0].Originator.Version)
+	assert.JSONEq(t, `{"name":"updated"}`, entities[0].Payload)
+
+	rebuilt, err := sqlStore.EntitiesRebuilt("User")
+	assert.NoError(t, err)
+	assert.True(t, rebuilt)
+
+	// a new provider doesn't rebuild the entities again
+	assert.NoError(t, logStore.Create("User", &types.Originator{ID: "user-3"}, `{"name":"user"}`))
+	store, err = NewCrudStoreProvider(context.Background(), sqlStore)
+	assert.NoError(t, err)
+
+	entities, _, err = store.ListEntities("User", &eventstore.EntityQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, entities, 3)
+
+	t.Run("legacy cursor", func(t *testing.T) {
+		// the cursors of the log listing are the id of the next log entry
+		_, cursor, err := logStore.ListEntities("User", &eventstore.EntityQuery{Size: 1})
+		assert.NoError(t, err)
+		_, err = strconv.ParseUint(cursor, 10, 64)
+		assert.NoError(t, err)
+
+		entities, _, err := store.ListEntities("User", &eventstore.EntityQuery{Cursor: cursor})
+		assert.NoError(t, err)
+		assert.Len(t, entities, 2)
+		assert.Equal(t, "user-1", entities[0].Originator.ID)
+
+		_, _, err = store.ListEntities("User", &eventstore.EntityQuery{Cursor: cursor, OrderBy: "name"})
+		assert.ErrorIs(t, err, eventstore.ErrInvalidQuery)
+	})
+}
+
+func TestCrudStoreProvider_Undelete(t *testing.T) {
+	testCases := []struct {
+		name   string
+		estore eventstore.Store
+	}{
+		{"entity store", eventstore.NewInMemoryStore()},
+		{"log only store", logOnlyStore{eventstore.NewInMemoryStore()}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := NewCrudStoreProvider(context.Background(), tc.estore)
+			assert.NoError(t, err)
+
+			_, err = store.Undelete("User", &types.Originator{ID: "missing"})
+			assert.ErrorIs(t, err, RecordNotFound)
+
+			originator := &types.Originator{ID: "user-1", Version: 1}
+			assert.NoError(t, store.Create("User", originator, `{"name":"first"}`))
+
+			_, err = store.Undelete("User", &types.Originator{ID: "user-1"})
+			assert.ErrorIs(t, err, InvalidArgumentError)
+
+			deletedOriginator, err := store.Delete("User", &types.Originator{ID: "user-1"})
+			assert.NoError(t, err)
+
+			// a stale version fails like a concurrent update
+			_, err = store.Undelete("User", originator)
+			assert.ErrorIs(t, err, eventstore.ErrDuplicate)
+
+			restoredOriginator, err := store.Undelete("User", deletedOriginator)
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(3), restoredOriginator.Version)
+
+			payload, latestOriginator, err := store.Get(&types.Originator{ID: "user-1"}, false)
+			assert.NoError(t, err)
+			assert.Equal(t, `{"name":"first"}`, payload)
+			assert.Equal(t, restoredOriginator, latestOriginator)
+
+			originators, _, err := store.List("User", "", 10)
+			assert.NoError(t, err)
+			assert.Equal(t, []*types.Originator{{ID: "user-1"}}, stripVersions(originators))
+
+			history, err := store.History("user-1")
+			assert.NoError(t, err)
+			assert.Len(t, history, 3)
+			assert.Equal(t, "User.Restored", history[2].EventType)
+			assert.False(t, history[2].Deleted)
+			assert.Empty(t, history[2].Changes)
+		})
+	}
+}
+
+func TestCrudStoreProvider_RevertTo(t *testing.T) {
+	estore := eventstore.NewInMemoryStore()
+	store, err := NewCrudStoreProvider(context.Background(), estore)
+	assert.NoError(t, err)
+
+	_, err = store.RevertTo("User", &types.Originator{ID: "missing"}, 1)
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	originator := &types.Originator{ID: "user-1", Version: 1}
+	assert.NoError(t, store.Create("User", originator, `{"name":"first","age":20}`))
+
+	originator, err = store.Update("User", originator, `{"name":"second","age":20}`)
+	assert.NoError(t, err)
+
+	originator, err = store.Update("User", originator, `{"name":"third","age":30}`)
+	assert.NoError(t, err)
+
+	_, err = store.RevertTo("User", &types.Originator{ID: "user-1"}, 0)
+	assert.ErrorIs(t, err, InvalidArgumentError)
+
+	_, err = store.RevertTo("User", &types.Originator{ID: "user-1"}, 3)
+	assert.ErrorIs(t, err, InvalidArgumentError)
+
+	revertedOriginator, err := store.RevertTo("User", originator, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), revertedOriginator.Version)
+
+	payload, _, err := store.Get(&types.Originator{ID: "user-1"}, false)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"first","age":20}`, payload)
+
+	// the old versions are still there
+	payload, _, err = store.Get(&types.Originator{ID: "user-1", Version: 3}, false)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"third","age":30}`, payload)
+
+	entities, _, err := store.ListEntities("User", nil)
+	assert.NoError(t, err)
+	assert.Len(t, entities, 1)
+	assert.JSONEq(t, `{"name":"first","age":20}`, entities[0].Payload)
+
+	history, err := store.History("user-1")
+	assert.NoError(t, err)
+	assert.Len(t, history, 4)
+	assert.Equal(t, "User.Reverted", history[3].EventType)
+	assert.Equal(t, "1", history[3].Metadata[RevertedToMetadataKey])
+	assert.Equal(t, []FieldChange{
+		{Path: "age", OldValue: float64(30), NewValue: float64(20)},
+		{Path: "name", OldValue: "third", NewValue: "first"},
+	}, history[3].Changes)
+
+	// updates continue from the reverted state
+	_, err = store.Update("User", revertedOriginator, `{"name":"fourth","age":20}`)
+	assert.NoError(t, err)
+
+	payload, _, err = store.Get(&types.Originator{ID: "user-1"}, false)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"fourth","age":20}`, payload)
+
+	_, err = store.Delete("User", &types.Originator{ID: "user-1"})
+	assert.NoError(t, err)
+
+	_, err = store.RevertTo("User", &types.Originator{ID: "user-1"}, 1)
+	assert.ErrorIs(t, err, RecordDeleted)
+}
+
+func stripVersions(originators []*types.Originator) []*types.Originator {
+	var results []*types.Originator
+	for _, o := range originators {
+		results = append(results, &types.Originator{ID: o.ID})
+	}
+	return results
+}
+
+func TestCrudStoreProvider_Purge(t *testing.T) {
+	estore := eventstore.NewInMemoryStore()
+	store, err := NewCrudStoreProvider(context.Background(), estore)
+	assert.NoError(t, err)
+
+	_, err = store.Purge("")
+	assert.ErrorIs(t, err, InvalidArgumentError)
+
+	_, err = store.Purge("missing")
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	assert.NoError(t, store.Create("User", &types.Originator{ID: "user-1", Version: 1}, `{"name":"first"}`))
+	_, err = store.Update("User", &types.Originator{ID: "user-1", Version: 1}, `{"name":"second"}`)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Create("User", &types.Originator{ID: "user-2", Version: 1}, `{"name":"other"}`))
+
+	purgedOriginator, err := store.Purge("user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), purgedOriginator.Version)
+
+	_, _, err = store.Get(&types.Originator{ID: "user-1"}, true)
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	_, err = store.History("user-1")
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	_, err = store.Update("User", &types.Originator{ID: "user-1", Version: 3}, `{"name":"third"}`)
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	_, err = store.Delete("User", &types.Originator{ID: "user-1"})
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	_, err = store.Purge("user-1")
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	originators, _, err := store.List("User", "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.Originator{{ID: "user-2"}}, stripVersions(originators))
+
+	originators, _, err = store.(*CrudStoreProvider).listFromLogs("User", "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.Originator{{ID: "user-2"}}, stripVersions(originators))
+
+	assert.NoError(t, store.RebuildEntities("User"))
+	entities, _, err := store.ListEntities("User", &eventstore.EntityQuery{Deleted: true})
+	assert.NoError(t, err)
+	assert.Len(t, entities, 1)
+
+	// the purge is recorded in the stream
+	events, err := estore.Get(&types.Originator{ID: "user-1"}, false)
+	assert.NoError(t, err)
+	assert.Len(t, events, 3)
+	assert.Equal(t, "User.Purged", events[2].EventType)
+
+	logOnly, err := NewCrudStoreProvider(context.Background(), logOnlyStore{estore})
+	assert.NoError(t, err)
+	_, err = logOnly.Purge("user-2")
+	assert.Error(t, err)
+}
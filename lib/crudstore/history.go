@@ -0,0 +1,196 @@
+package crudstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// HistoryEntry is a single version of an entity
+type HistoryEntry struct {
+	// Originator is the version of the entity the event created
+	Originator *types.Originator `json:"originator"`
+	EventType  string            `json:"event_type"`
+	OccurredOn time.Time         `json:"occurred_on"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	// State is the full JSON state of the entity after the event
+	State string `json:"state"`
+	// Deleted is true when the entity was deleted at this version
+	Deleted bool `json:"deleted"`
+	// Changes are the fields changed compared to the previous version
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// DecodeState decodes the state of the entry into msg
+func (entry *HistoryEntry) DecodeState(msg interface{}) error {
+	if err := json.Unmarshal([]byte(entry.State), msg); err != nil {
+		return fmt.Errorf("restoring the state : %w", err)
+	}
+	return nil
+}
+
+// FieldChange is a changed value inside of the entity. Path is the JSON path of the
+// value, nested fields are separated with dots and array items are indexed, ie. Address.City
+// or Workspaces[1]. OldValue is nil for the added fields and NewValue for the removed ones.
+type FieldChange struct {
+	Path     string      `json:"path"`
+	OldValue interface{} `json:"old_value"`
+	NewValue interface{} `json:"new_value"`
+}
+
+// History returns every version of the entity with its full state and the changes
+// compared to the previous version, oldest first
+func (crud *CrudStoreProvider) History(originatorID string) ([]*HistoryEntry, error) {
+	if originatorID == "" {
+		return nil, fmt.Errorf("empty originator id : %w", InvalidArgumentError)
+	}
+
+	events, err := crud.estore.Get(&types.Originator{ID: originatorID}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, fmt.Errorf("%w", RecordNotFound)
+	}
+
+	if eventstore.IsPurged(events[len(events)-1]) {
+		return nil, fmt.Errorf("purged : %w", RecordNotFound)
+	}
+
+	return crud.history(events)
+}
+
+// HistoryMany returns the history of every entity like History, grouped by their IDs.
+// The streams are read with a single GetMany, the missing and purged entities are
+// left out of the result.
+func (crud *CrudStoreProvider) HistoryMany(originatorIDs []string) (map[string][]*HistoryEntry, error) {
+	streams, err := crud.estore.GetMany(originatorIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	histories := make(map[string][]*HistoryEntry, len(streams))
+	for id, events := range streams {
+		if len(events) == 0 || eventstore.IsPurged(events[len(events)-1]) {
+			continue
+		}
+
+		entries, err := crud.history(events)
+		if err != nil {
+			return nil, fmt.Errorf("%s : %w", id, err)
+		}
+		histories[id] = entries
+	}
+
+	return histories, nil
+}
+
+// history folds the events of a stream into its versions
+func (crud *CrudStoreProvider) history(events []*types.Event) ([]*HistoryEntry, error) {
+	var entries []*HistoryEntry
+	state := []byte("{}")
+	for _, e := range events {
+		// ignore the events which don't change the state like Get does
+		if !crud.isStateEvent(e) {
+			continue
+		}
+
+		newState, err := crud.applyEvent(state, e)
+		if err != nil {
+			return nil, fmt.Errorf("version %d : %w", e.Originator.Version, err)
+		}
+
+		changes, err := diffJSON(state, newState)
+		if err != nil {
+			return nil, fmt.Errorf("version %d : %w", e.Originator.Version, err)
+		}
+
+		entries = append(entries, &HistoryEntry{
+			Originator: e.Originator,
+			EventType:  e.EventType,
+			OccurredOn: e.OccurredOn,
+			Metadata:   e.Metadata,
+			State:      string(newState),
+			Deleted:    crud.isEventDeleted(e),
+			Changes:    changes,
+		})
+
+		state = newState
+	}
+
+	return entries, nil
+}
+
+// diffJSON returns the changes between two JSON documents sorted by path
+func diffJSON(oldDoc, newDoc []byte) ([]FieldChange, error) {
+	var oldValue, newValue interface{}
+	if err := json.Unmarshal(oldDoc, &oldValue); err != nil {
+		return nil, fmt.Errorf("decoding old state : %v", err)
+	}
+
+	if err := json.Unmarshal(newDoc, &newValue); err != nil {
+		return nil, fmt.Errorf("decoding new state : %v", err)
+	}
+
+	var changes []FieldChange
+	diffValues("", oldValue, newValue, &changes)
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, nil
+}
+
+func diffValues(path string, oldValue, newValue interface{}, changes *[]FieldChange) {
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := map[string]struct{}{}
+		for k := range oldMap {
+			keys[k] = struct{}{}
+		}
+		for k := range newMap {
+			keys[k] = struct{}{}
+		}
+
+		for k := range keys {
+			fieldPath := k
+			if path != "" {
+				fieldPath = path + "." + k
+			}
+			diffValues(fieldPath, oldMap[k], newMap[k], changes)
+		}
+		return
+	}
+
+	oldSlice, oldIsSlice := oldValue.([]interface{})
+	newSlice, newIsSlice := newValue.([]interface{})
+	if oldIsSlice && newIsSlice {
+		for i := 0; i < len(oldSlice) || i < len(newSlice); i++ {
+			var o, n interface{}
+			if i < len(oldSlice) {
+				o = oldSlice[i]
+			}
+			if i < len(newSlice) {
+				n = newSlice[i]
+			}
+			diffValues(path+"["+strconv.Itoa(i)+"]", o, n, changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*changes = append(*changes, FieldChange{
+			Path:     path,
+			OldValue: oldValue,
+			NewValue: newValue,
+		})
+	}
+}
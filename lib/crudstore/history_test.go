@@ -0,0 +1,120 @@
+package crudstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrudStoreProvider_History(t *testing.T) {
+	estore := eventstore.NewInMemoryStore()
+	store, err := NewCrudStoreProvider(context.Background(), estore)
+	assert.NoError(t, err)
+
+	_, err = store.History("missing")
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	_, err = store.History("")
+	assert.ErrorIs(t, err, InvalidArgumentError)
+
+	originator := &types.Originator{ID: "user-1", Version: 1}
+	assert.NoError(t, store.Create("User", originator, `{"name":"first","tags":["a"],"address":{"city":"sofia"}}`))
+
+	_, err = store.Update("User", originator, `{"name":"second","tags":["a","b"],"address":{"city":"berlin"}}`)
+	assert.NoError(t, err)
+
+	_, err = store.Delete("User", &types.Originator{ID: "user-1"})
+	assert.NoError(t, err)
+
+	// non crud events are skipped like in Get
+	assert.NoError(t, estore.Append(&types.Event{
+		Originator: &types.Originator{ID: "user-1", Version: 4},
+		EventType:  "User.Noticed",
+		Payload:    `{}`,
+	}))
+
+	history, err := store.History("user-1")
+	assert.NoError(t, err)
+	assert.Len(t, history, 3)
+
+	created := history[0]
+	assert.Equal(t, "User.Created", created.EventType)
+	assert.Equal(t, uint64(1), created.Originator.Version)
+	assert.False(t, created.OccurredOn.IsZero())
+	assert.Equal(t, []FieldChange{
+		{Path: "address", OldValue: nil, NewValue: map[string]interface{}{"city": "sofia"}},
+		{Path: "name", OldValue: nil, NewValue: "first"},
+		{Path: "tags", OldValue: nil, NewValue: []interface{}{"a"}},
+	}, created.Changes)
+
+	updated := history[1]
+	assert.Equal(t, "User.Updated", updated.EventType)
+	assert.Equal(t, uint64(2), updated.Originator.Version)
+	assert.JSONEq(t, `{"name":"second","tags":["a","b"],"address":{"city":"berlin"}}`, updated.State)
+	assert.Equal(t, []FieldChange{
+		{Path: "address.city", OldValue: "sofia", NewValue: "berlin"},
+		{Path: "name", OldValue: "first", NewValue: "second"},
+		{Path: "tags[1]", OldValue: nil, NewValue: "b"},
+	}, updated.Changes)
+
+	deleted := history[2]
+	assert.Equal(t, "User.Deleted", deleted.EventType)
+	assert.Equal(t, uint64(3), deleted.Originator.Version)
+	assert.True(t, deleted.Deleted)
+	assert.Empty(t, deleted.Changes)
+	assert.Equal(t, updated.State, deleted.State)
+
+	t.Run("decode state", func(t *testing.T) {
+		var state struct {
+			Name string `json:"name"`
+		}
+		assert.NoError(t, updated.DecodeState(&state))
+		assert.Equal(t, "second", state.Name)
+	})
+}
+
+func TestCrudStoreProvider_HistoryMany(t *testing.T) {
+	store, err := NewCrudStoreProvider(context.Background(), eventstore.NewInMemoryStore())
+	assert.NoError(t, err)
+
+	first := &types.Originator{ID: "user-1", Version: 1}
+	assert.NoError(t, store.Create("User", first, `{"name":"first"}`))
+	_, err = store.Update("User", first, `{"name":"updated"}`)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Create("User", &types.Originator{ID: "user-2", Version: 1}, `{"name":"second"}`))
+	assert.NoError(t, store.Create("User", &types.Originator{ID: "user-3", Version: 1}, `{"name":"purged"}`))
+	_, err = store.Purge("user-3")
+	assert.NoError(t, err)
+
+	histories, err := store.HistoryMany([]string{"user-1", "user-2", "user-3", "missing"})
+	assert.NoError(t, err)
+	assert.Len(t, histories, 2)
+
+	history, err := store.History("user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, history, histories["user-1"])
+	assert.Len(t, histories["user-2"], 1)
+}
+
+func TestDiffJSON(t *testing.T) {
+	changes, err := diffJSON([]byte(`{"a":1,"b":{"c":[1,2]},"d":"x"}`), []byte(`{"a":1,"b":{"c":[1]},"e":true}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []FieldChange{
+		{Path: "b.c[1]", OldValue: float64(2), NewValue: nil},
+		{Path: "d", OldValue: "x", NewValue: nil},
+		{Path: "e", OldValue: nil, NewValue: true},
+	}, changes)
+
+	changes, err = diffJSON([]byte(`{"a":{"b":1}}`), []byte(`{"a":"flat"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []FieldChange{
+		{Path: "a", OldValue: map[string]interface{}{"b": float64(1)}, NewValue: "flat"},
+	}, changes)
+
+	_, err = diffJSON([]byte(`{`), []byte(`{}`))
+	assert.Error(t, err)
+}
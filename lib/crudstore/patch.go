@@ -0,0 +1,152 @@
+package crudstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/makkalot/eskit/lib/types"
+	"gopkg.in/evanphx/json-patch.v3"
+	"strings"
+	"time"
+)
+
+var (
+	// PatchFailed is returned when a JSON Patch can't be applied to the entity, ie. a test
+	// operation didn't match or a path is missing
+	PatchFailed = errors.New("patch failed")
+)
+
+func IsErrPatchFailed(err error) bool {
+	return errors.Is(err, PatchFailed)
+}
+
+// PatchOperation is a single RFC 6902 JSON Patch operation, Op is one of add, remove,
+// replace, move, copy and test. From is only used by move and copy.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+func (op PatchOperation) validate() error {
+	switch op.Op {
+	case "add", "remove", "replace", "test":
+	case "move", "copy":
+		if !strings.HasPrefix(op.From, "/") {
+			return fmt.Errorf("%s operation needs a from path : %w", op.Op, InvalidArgumentError)
+		}
+	default:
+		return fmt.Errorf("unknown patch operation %q : %w", op.Op, InvalidArgumentError)
+	}
+
+	if !strings.HasPrefix(op.Path, "/") {
+		return fmt.Errorf("invalid patch path %q : %w", op.Path, InvalidArgumentError)
+	}
+
+	return nil
+}
+
+// Patch applies the RFC 6902 operations in ops to the entity and stores them as a Patched
+// event. The operations are applied atomically, if any of them fails (ie. a test) nothing
+// is stored. When originator has no version the latest one is patched.
+func (crud *CrudStoreProvider) Patch(entityType string, originator *types.Originator, ops string) (*types.Originator, error) {
+	var operations []PatchOperation
+	if err := json.Unmarshal([]byte(ops), &operations); err != nil {
+		return nil, fmt.Errorf("decoding patch : %v : %w", err, InvalidArgumentError)
+	}
+
+	if len(operations) == 0 {
+		return nil, fmt.Errorf("empty patch : %w", InvalidArgumentError)
+	}
+
+	for _, op := range operations {
+		if err := op.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	head, err := crud.streamHead(originator)
+	if err != nil {
+		return nil, err
+	}
+
+	latestObj, _, err := crud.Get(originator, false)
+	if err != nil {
+		return nil, err
+	}
+
+	newObj, err := applyPatch([]byte(latestObj), ops)
+	if err != nil {
+		return nil, err
+	}
+
+	newOriginator, err := crud.nextOriginator(originator, head)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &types.Event{
+		Originator: newOriginator,
+		EventType:  fmt.Sprintf("%s.Patched", crud.registry.Resolve(entityType)),
+		Payload:    ops,
+		OccurredOn: time.Now().UTC(),
+	}
+
+	err = crud.append(event, types.NewCrudEntity(crud.registry.Resolve(entityType), newOriginator, string(newObj), false))
+	if err != nil {
+		return nil, err
+	}
+
+	return newOriginator, nil
+}
+
+func applyPatch(state []byte, ops string) ([]byte, error) {
+	patch, err := jsonpatch.DecodePatch([]byte(ops))
+	if err != nil {
+		return nil, fmt.Errorf("decoding patch : %v : %w", err, InvalidArgumentError)
+	}
+
+	newState, err := patch.Apply(state)
+	if err != nil {
+		return nil, fmt.Errorf("%v : %w", err, PatchFailed)
+	}
+
+	return newState, nil
+}
+
+// RenamePatchFields rewrites the top level field of the paths in ops using fields, it's
+// useful when the API exposes the entity with different field names than it's stored with.
+// Fields missing from the map are rejected.
+func RenamePatchFields(ops []PatchOperation, fields map[string]string) ([]PatchOperation, error) {
+	rename := func(path string) (string, error) {
+		if path == "" {
+			return path, nil
+		}
+
+		segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+		field, ok := fields[segments[0]]
+		if !ok {
+			return "", fmt.Errorf("unknown field %q : %w", segments[0], InvalidArgumentError)
+		}
+
+		segments[0] = field
+		return "/" + strings.Join(segments, "/"), nil
+	}
+
+	results := make([]PatchOperation, 0, len(ops))
+	for _, op := range ops {
+		var err error
+		if op.Path, err = rename(op.Path); err != nil {
+			return nil, err
+		}
+
+		if op.From, err = rename(op.From); err != nil {
+			return nil, err
+		}
+
+		results = append(results, op)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,134 @@
+package crudstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrudStoreProvider_Patch(t *testing.T) {
+	store, err := NewCrudStoreProvider(context.Background(), eventstore.NewInMemoryStore())
+	assert.NoError(t, err)
+
+	originator := &types.Originator{ID: "user-1", Version: 1}
+	assert.NoError(t, store.Create("User", originator, `{"Email":"first@gmail.com","Workspaces":["a"]}`))
+
+	testCases := []struct {
+		name        string
+		ops         string
+		expectedErr error
+	}{
+		{"invalid json", `{`, InvalidArgumentError},
+		{"empty patch", `[]`, InvalidArgumentError},
+		{"unknown operation", `[{"op":"merge","path":"/Email"}]`, InvalidArgumentError},
+		{"invalid path", `[{"op":"add","path":"Email","value":"x"}]`, InvalidArgumentError},
+		{"move without from", `[{"op":"move","path":"/Email"}]`, InvalidArgumentError},
+		{"failing test", `[{"op":"test","path":"/Email","value":"other@gmail.com"},{"op":"replace","path":"/Email","value":"x"}]`, PatchFailed},
+		{"missing path", `[{"op":"replace","path":"/Missing/Field","value":"x"}]`, PatchFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := store.Patch("User", &types.Originator{ID: "user-1"}, tc.ops)
+			assert.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+
+	// nothing was stored by the failed patches
+	_, latestOriginator, err := store.Get(&types.Originator{ID: "user-1"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), latestOriginator.Version)
+
+	_, err = store.Patch("User", &types.Originator{ID: "missing"}, `[{"op":"add","path":"/Email","value":"x"}]`)
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	patchedOriginator, err := store.Patch("User", &types.Originator{ID: "user-1"}, `[
+		{"op":"test","path":"/Email","value":"first@gmail.com"},
+		{"op":"add","path":"/Workspaces/-","value":"b"},
+		{"op":"replace","path":"/Email","value":"second@gmail.com"}
+	]`)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), patchedOriginator.Version)
+
+	// a stale version fails like a concurrent update
+	_, err = store.Patch("User", originator, `[{"op":"add","path":"/Workspaces/-","value":"c"}]`)
+	assert.ErrorIs(t, err, eventstore.ErrDuplicate)
+
+	payload, _, err := store.Get(&types.Originator{ID: "user-1"}, false)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Email":"second@gmail.com","Workspaces":["a","b"]}`, payload)
+
+	// merge patches and json patches replay together
+	updatedOriginator, err := store.Update("User", patchedOriginator, `{"Email":"third@gmail.com","Workspaces":["a","b"]}`)
+	assert.NoError(t, err)
+
+	_, err = store.Patch("User", updatedOriginator, `[{"op":"remove","path":"/Workspaces/0"}]`)
+	assert.NoError(t, err)
+
+	payload, _, err = store.Get(&types.Originator{ID: "user-1"}, false)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Email":"third@gmail.com","Workspaces":["b"]}`, payload)
+
+	entities, _, err := store.ListEntities("User", nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, payload, entities[0].Payload)
+
+	history, err := store.History("user-1")
+	assert.NoError(t, err)
+	assert.Len(t, history, 4)
+	assert.Equal(t, "User.Patched", history[1].EventType)
+	assert.Equal(t, []FieldChange{
+		{Path: "Email", OldValue: "first@gmail.com", NewValue: "second@gmail.com"},
+		{Path: "Workspaces[1]", OldValue: nil, NewValue: "b"},
+	}, history[1].Changes)
+}
+
+func TestClient_Patch(t *testing.T) {
+	crudStore, err := NewCrudStoreProvider(context.Background(), eventstore.NewInMemoryStore())
+	assert.NoError(t, err)
+
+	client := NewClientWithStore(crudStore)
+
+	user := User{Email: "patch@gmail.com"}
+	originator, err := client.Create(&user)
+	assert.NoError(t, err)
+
+	var patched User
+	patchedOriginator, err := client.Patch(originator, []PatchOperation{
+		{Op: "test", Path: "/Active", Value: false},
+		{Op: "replace", Path: "/Active", Value: true},
+		{Op: "copy", From: "/Email", Path: "/FirstName"},
+	}, &patched)
+	assert.NoError(t, err)
+	assert.Equal(t, patchedOriginator, patched.Originator)
+	assert.True(t, patched.Active)
+	assert.Equal(t, "patch@gmail.com", patched.FirstName)
+
+	typedClient, err := NewTypedClient[User](crudStore)
+	assert.NoError(t, err)
+
+	typed, err := typedClient.Patch(&types.Originator{ID: originator.ID}, PatchOperation{Op: "remove", Path: "/FirstName"})
+	assert.NoError(t, err)
+	assert.Empty(t, typed.FirstName)
+	assert.Equal(t, uint64(3), typed.Originator.Version)
+}
+
+func TestRenamePatchFields(t *testing.T) {
+	fields := map[string]string{"email": "Email", "workspaces": "Workspaces"}
+
+	ops, err := RenamePatchFields([]PatchOperation{
+		{Op: "add", Path: "/workspaces/-", Value: "a"},
+		{Op: "copy", From: "/email", Path: "/workspaces/0"},
+	}, fields)
+	assert.NoError(t, err)
+	assert.Equal(t, []PatchOperation{
+		{Op: "add", Path: "/Workspaces/-", Value: "a"},
+		{Op: "copy", From: "/Email", Path: "/Workspaces/0"},
+	}, ops)
+
+	_, err = RenamePatchFields([]PatchOperation{{Op: "remove", Path: "/originator"}}, fields)
+	assert.ErrorIs(t, err, InvalidArgumentError)
+}
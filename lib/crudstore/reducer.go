@@ -0,0 +1,139 @@
+package crudstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/types"
+	"strings"
+	"time"
+)
+
+// Reducer folds the payload of a custom domain event into the JSON state of an entity
+// and returns the new state
+type Reducer func(state string, payload string) (string, error)
+
+// NewReducer creates a Reducer from a function working on the entity struct and the
+// decoded event payload, ie:
+//
+//	crudstore.NewReducer(func(u *User, e *Deactivated) error {
+//	    u.Active = false
+//	    return nil
+//	})
+func NewReducer[T any, P any](fn func(entity *T, payload *P) error) Reducer {
+	return func(state string, payload string) (string, error) {
+		entity := new(T)
+		if err := json.Unmarshal([]byte(state), entity); err != nil {
+			return "", fmt.Errorf("restoring the state : %w", err)
+		}
+
+		event := new(P)
+		if err := json.Unmarshal([]byte(payload), event); err != nil {
+			return "", fmt.Errorf("restoring the payload : %w", err)
+		}
+
+		if err := fn(entity, event); err != nil {
+			return "", err
+		}
+
+		newState, err := json.Marshal(entity)
+		if err != nil {
+			return "", err
+		}
+
+		return string(newState), nil
+	}
+}
+
+// RegisterReducer registers the reducer replaying the eventName events of entityType,
+// ie. RegisterReducer("User", "Deactivated", ...) for User.Deactivated events. The crud
+// event names (Created, Updated ...) can't be used.
+func (r *EntityRegistry) RegisterReducer(entityType, eventName string, reducer Reducer) error {
+	if entityType == "" || eventName == "" || strings.Contains(eventName, ".") {
+		return fmt.Errorf("invalid event %q for %q : %w", eventName, entityType, InvalidArgumentError)
+	}
+
+	if reducer == nil {
+		return fmt.Errorf("empty reducer : %w", InvalidArgumentError)
+	}
+
+	if isCrudEventName(eventName) {
+		return fmt.Errorf("%s is a crud event : %w", eventName, InvalidArgumentError)
+	}
+
+	entityType = r.Resolve(entityType)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.reducers[entityType][eventName]; ok {
+		return fmt.Errorf("reducer for %s.%s is already registered : %w", entityType, eventName, InvalidArgumentError)
+	}
+
+	if r.reducers[entityType] == nil {
+		r.reducers[entityType] = map[string]Reducer{}
+	}
+	r.reducers[entityType][eventName] = reducer
+
+	return nil
+}
+
+// reducerOf returns the reducer of the event, events stored under an alias use the
+// reducers of the current entity type
+func (r *EntityRegistry) reducerOf(event *types.Event) (Reducer, bool) {
+	entityType := r.Resolve(common.ExtractEntityType(event))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reducer, ok := r.reducers[entityType][common.ExtractEventType(event)]
+	return reducer, ok
+}
+
+// Apply appends the custom domain event eventName to the entity and folds it into its
+// state with the reducer registered for it. The entity type is taken from the stored
+// events. When originator has a version it should be the latest version of the entity.
+func (crud *CrudStoreProvider) Apply(originator *types.Originator, eventName string, payload string) (*types.Originator, error) {
+	head, err := crud.streamHead(originator)
+	if err != nil {
+		return nil, err
+	}
+
+	if crud.isEventDeleted(head) {
+		return nil, fmt.Errorf("%w", RecordDeleted)
+	}
+
+	entityType := crud.registry.Resolve(common.ExtractEntityType(head))
+	event := &types.Event{
+		EventType:  fmt.Sprintf("%s.%s", entityType, eventName),
+		Payload:    payload,
+		OccurredOn: time.Now().UTC(),
+	}
+
+	reducer, ok := crud.registry.reducerOf(event)
+	if !ok || isCrudEventName(eventName) {
+		return nil, fmt.Errorf("no reducer registered for %s : %w", event.EventType, InvalidArgumentError)
+	}
+
+	latestObj, _, err := crud.Get(&types.Originator{ID: originator.ID}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	newObj, err := reducer(latestObj, payload)
+	if err != nil {
+		return nil, fmt.Errorf("applying %s : %w", event.EventType, err)
+	}
+
+	event.Originator, err = crud.nextOriginator(originator, head)
+	if err != nil {
+		return nil, err
+	}
+
+	err = crud.append(event, types.NewCrudEntity(entityType, event.Originator, newObj, false))
+	if err != nil {
+		return nil, err
+	}
+
+	return event.Originator, nil
+}
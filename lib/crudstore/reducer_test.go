@@ -0,0 +1,140 @@
+package crudstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type userDeactivated struct {
+	Reason string
+}
+
+func deactivateUser(u *User, e *userDeactivated) error {
+	if e.Reason == "" {
+		return errors.New("missing reason")
+	}
+	u.Active = false
+	u.LastName = e.Reason
+	return nil
+}
+
+func TestEntityRegistry_RegisterReducer(t *testing.T) {
+	registry := NewEntityRegistry()
+	reducer := NewReducer(deactivateUser)
+
+	assert.NoError(t, registry.RegisterReducer("User", "Deactivated", reducer))
+	assert.ErrorIs(t, registry.RegisterReducer("User", "Deactivated", reducer), InvalidArgumentError)
+	assert.ErrorIs(t, registry.RegisterReducer("User", "Updated", reducer), InvalidArgumentError)
+	assert.ErrorIs(t, registry.RegisterReducer("User", "Some.Deactivated", reducer), InvalidArgumentError)
+	assert.ErrorIs(t, registry.RegisterReducer("", "Deactivated", reducer), InvalidArgumentError)
+	assert.ErrorIs(t, registry.RegisterReducer("User", "Activated", nil), InvalidArgumentError)
+
+	// events stored under an alias use the reducers of the current type
+	assert.NoError(t, registry.RegisterAlias("User", "Account"))
+	_, ok := registry.reducerOf(&types.Event{EventType: "Account.Deactivated"})
+	assert.True(t, ok)
+
+	_, ok = registry.reducerOf(&types.Event{EventType: "User.Activated"})
+	assert.False(t, ok)
+}
+
+func TestCrudStoreProvider_Apply(t *testing.T) {
+	registry := NewEntityRegistry()
+	assert.NoError(t, registry.RegisterReducer("User", "Deactivated", NewReducer(deactivateUser)))
+
+	estore := eventstore.NewInMemoryStore()
+	crudStore, err := NewCrudStoreProviderWithRegistry(context.Background(), estore, registry)
+	assert.NoError(t, err)
+
+	client := NewClientWithStore(crudStore)
+
+	_, err = client.Apply(&types.Originator{ID: "missing"}, "Deactivated", userDeactivated{Reason: "left"})
+	assert.ErrorIs(t, err, RecordNotFound)
+
+	user := User{Email: "reducer@gmail.com", Active: true}
+	originator, err := client.Create(&user)
+	assert.NoError(t, err)
+
+	_, err = client.Apply(originator, "Suspended", userDeactivated{Reason: "left"})
+	assert.ErrorIs(t, err, InvalidArgumentError)
+
+	_, err = client.Apply(originator, "Updated", userDeactivated{Reason: "left"})
+	assert.ErrorIs(t, err, InvalidArgumentError)
+
+	// the reducer errors are returned and nothing is stored
+	_, err = client.Apply(originator, "Deactivated", userDeactivated{})
+	assert.Error(t, err)
+
+	appliedOriginator, err := client.Apply(originator, "Deactivated", userDeactivated{Reason: "left"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), appliedOriginator.Version)
+
+	events, err := estore.Get(&types.Originator{ID: originator.ID}, false)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "User.Deactivated", events[1].EventType)
+	assert.JSONEq(t, `{"Reason":"left"}`, events[1].Payload)
+
+	var fetched User
+	assert.NoError(t, client.Get(&types.Originator{ID: originator.ID}, &fetched, false))
+	assert.False(t, fetched.Active)
+	assert.Equal(t, "left", fetched.LastName)
+	assert.Equal(t, appliedOriginator, fetched.Originator)
+
+	var users []*User
+	_, err = client.ListWithPagination(&users, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.False(t, users[0].Active)
+
+	history, err := client.History(originator.ID)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "User.Deactivated", history[1].EventType)
+	assert.Equal(t, []FieldChange{
+		{Path: "Active", OldValue: true, NewValue: false},
+		{Path: "LastName", OldValue: "", NewValue: "left"},
+	}, history[1].Changes)
+
+	t.Run("events without reducers", func(t *testing.T) {
+		assert.NoError(t, estore.Append(&types.Event{
+			Originator: &types.Originator{ID: originator.ID, Version: 3},
+			EventType:  "User.Noticed",
+			Payload:    `{}`,
+		}))
+
+		// they don't change the state but the next events come after them, so a delete
+		// with the version of the last state is stale
+		_, err := client.Delete(&types.Originator{ID: originator.ID, Version: 2}, &User{})
+		assert.ErrorIs(t, err, eventstore.ErrDuplicate)
+
+		var notDeleted User
+		assert.NoError(t, client.Get(&types.Originator{ID: originator.ID}, &notDeleted, false))
+
+		deletedOriginator, err := client.Delete(&types.Originator{ID: originator.ID}, &User{})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(4), deletedOriginator.Version)
+
+		_, err = client.Apply(&types.Originator{ID: originator.ID}, "Deactivated", userDeactivated{Reason: "again"})
+		assert.ErrorIs(t, err, RecordDeleted)
+	})
+
+	t.Run("typed client", func(t *testing.T) {
+		typedClient, err := NewTypedClient[User](crudStore)
+		assert.NoError(t, err)
+
+		created := &User{Email: "typed-reducer@gmail.com", Active: true}
+		_, err = typedClient.Create(created)
+		assert.NoError(t, err)
+
+		applied, err := typedClient.Apply(&types.Originator{ID: created.Originator.ID}, "Deactivated", userDeactivated{Reason: "typed"})
+		assert.NoError(t, err)
+		assert.False(t, applied.Active)
+		assert.Equal(t, uint64(2), applied.Originator.Version)
+	})
+}
@@ -0,0 +1,195 @@
+package crudstore
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// EntityTypeTag is the struct tag put on the Originator field to give an entity a
+// stable type name, ie:
+//
+//	type User struct {
+//	    Originator *types.Originator `entityType:"com.example.User"`
+//	}
+const EntityTypeTag = "entityType"
+
+// EntityTyper can be implemented by entities which want to control the entity type
+// they're stored under, it takes precedence over EntityTypeTag
+type EntityTyper interface {
+	EntityType() string
+}
+
+// EntityTypeFromStruct returns the entity type msg is stored under. It's resolved in order from :
+// the EntityTyper interface, the EntityTypeTag on the Originator field and lastly the struct name.
+func EntityTypeFromStruct(msg interface{}) string {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return entityTypeOf(t)
+}
+
+func entityTypeOf(t reflect.Type) string {
+	// use a zero value so it works with nil pointers and value receivers
+	if typer, ok := reflect.New(t).Interface().(EntityTyper); ok {
+		if entityType := typer.EntityType(); entityType != "" {
+			return entityType
+		}
+	}
+
+	if t.Kind() == reflect.Struct {
+		if f, ok := t.FieldByName("Originator"); ok {
+			if entityType := f.Tag.Get(EntityTypeTag); entityType != "" {
+				return entityType
+			}
+		}
+	}
+
+	return t.Name()
+}
+
+// DefaultRegistry is used by the crud stores created via NewCrudStoreProvider
+var DefaultRegistry = NewEntityRegistry()
+
+// EntityRegistry keeps track of the Go types stored in the crudstore and of the
+// old names (aliases) their events were stored under. When a type is renamed the old
+// name should be registered as an alias so its history is still found.
+type EntityRegistry struct {
+	mu sync.RWMutex
+	// entity type -> go type
+	types map[string]reflect.Type
+	// alias -> entity type
+	aliases map[string]string
+	// entity type -> event name -> reducer
+	reducers map[string]map[string]Reducer
+}
+
+func NewEntityRegistry() *EntityRegistry {
+	return &EntityRegistry{
+		types:    map[string]reflect.Type{},
+		aliases:  map[string]string{},
+		reducers: map[string]map[string]Reducer{},
+	}
+}
+
+// Register registers the Go type of msg under its entity type together with the
+// aliases it was stored under before. It fails if another Go type already uses the
+// same entity type, which usually means two packages have a struct with the same name.
+func (r *EntityRegistry) Register(msg interface{}, aliases ...string) (string, error) {
+	t := reflect.TypeOf(msg)
+	if t == nil {
+		return "", fmt.Errorf("empty message : %w", InvalidArgumentError)
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	entityType := entityTypeOf(t)
+	if entityType == "" {
+		return "", fmt.Errorf("could not resolve entity type for %s : %w", t, InvalidArgumentError)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.types[entityType]; ok && existing != t {
+		return "", fmt.Errorf("entity type %s is already registered for %s : %w", entityType, existing, InvalidArgumentError)
+	}
+
+	// nothing is registered when one of the aliases is invalid
+	if err := r.validateAliases(entityType, aliases); err != nil {
+		return "", err
+	}
+
+	r.types[entityType] = t
+	for _, alias := range aliases {
+		r.aliases[alias] = entityType
+	}
+
+	return entityType, nil
+}
+
+// RegisterAlias makes the events stored under the aliases replay as entityType, none of
+// them is registered when one is invalid
+func (r *EntityRegistry) RegisterAlias(entityType string, aliases ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.validateAliases(entityType, aliases); err != nil {
+		return err
+	}
+
+	for _, alias := range aliases {
+		r.aliases[alias] = entityType
+	}
+	return nil
+}
+
+// validateAliases checks the aliases can be registered for entityType, r.mu should be held
+func (r *EntityRegistry) validateAliases(entityType string, aliases []string) error {
+	if existing, ok := r.aliases[entityType]; ok {
+		return fmt.Errorf("entity type %s is an alias of %s : %w", entityType, existing, InvalidArgumentError)
+	}
+
+	for _, alias := range aliases {
+		if alias == "" || alias == entityType {
+			return fmt.Errorf("invalid alias %q for %s : %w", alias, entityType, InvalidArgumentError)
+		}
+
+		if existing, ok := r.aliases[alias]; ok && existing != entityType {
+			return fmt.Errorf("alias %s is already used by %s : %w", alias, existing, InvalidArgumentError)
+		}
+
+		if _, ok := r.types[alias]; ok {
+			return fmt.Errorf("alias %s is a registered entity type : %w", alias, InvalidArgumentError)
+		}
+	}
+
+	return nil
+}
+
+// Resolve returns the current entity type for the given name, names which are not
+// aliases are returned as they are
+func (r *EntityRegistry) Resolve(name string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if entityType, ok := r.aliases[name]; ok {
+		return entityType
+	}
+	return name
+}
+
+// Aliases returns the sorted old names of entityType
+func (r *EntityRegistry) Aliases(entityType string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var aliases []string
+	for alias, current := range r.aliases {
+		if current == entityType {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	sort.Strings(aliases)
+	return aliases
+}
+
+// TypeOf returns the Go type registered for the entity type or one of its aliases
+func (r *EntityRegistry) TypeOf(name string) (reflect.Type, bool) {
+	entityType := r.Resolve(name)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.types[entityType]
+	return t, ok
+}
+
+// partitions returns all the names the events of entityType can be found under
+func (r *EntityRegistry) partitions(entityType string) []string {
+	return append([]string{entityType}, r.Aliases(entityType)...)
+}
@@ -0,0 +1,165 @@
+package crudstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type taggedUser struct {
+	Originator *types.Originator `entityType:"com.example.User"`
+	Email      string
+}
+
+type typedUser struct {
+	Originator *types.Originator `entityType:"ignored.User"`
+	Email      string
+}
+
+func (u typedUser) EntityType() string {
+	return "com.example.TypedUser"
+}
+
+func TestEntityTypeFromStruct(t *testing.T) {
+	testCases := []struct {
+		name     string
+		msg      interface{}
+		expected string
+	}{
+		{"struct name", &User{}, "User"},
+		{"non pointer", User{}, "User"},
+		{"struct tag", &taggedUser{}, "com.example.User"},
+		{"interface", &typedUser{}, "com.example.TypedUser"},
+		{"nil pointer with interface", (*typedUser)(nil), "com.example.TypedUser"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, EntityTypeFromStruct(tc.msg))
+		})
+	}
+}
+
+func TestEntityRegistry(t *testing.T) {
+	registry := NewEntityRegistry()
+
+	entityType, err := registry.Register(&taggedUser{}, "User", "legacy.User")
+	assert.NoError(t, err)
+	assert.Equal(t, "com.example.User", entityType)
+
+	// registering the same type again is fine
+	_, err = registry.Register(taggedUser{})
+	assert.NoError(t, err)
+
+	t.Run("collision", func(t *testing.T) {
+		type other struct {
+			Originator *types.Originator `entityType:"com.example.User"`
+		}
+		_, err := registry.Register(&other{})
+		assert.ErrorIs(t, err, InvalidArgumentError)
+	})
+
+	t.Run("alias used by another type", func(t *testing.T) {
+		err := registry.RegisterAlias("com.example.Other", "User")
+		assert.ErrorIs(t, err, InvalidArgumentError)
+
+		err = registry.RegisterAlias("com.example.Other", "com.example.User")
+		assert.ErrorIs(t, err, InvalidArgumentError)
+	})
+
+	t.Run("entity type used as an alias", func(t *testing.T) {
+		type legacyUser struct {
+			Originator *types.Originator `entityType:"legacy.User"`
+		}
+		_, err := registry.Register(&legacyUser{})
+		assert.ErrorIs(t, err, InvalidArgumentError)
+
+		_, ok := registry.TypeOf("legacy.User")
+		assert.True(t, ok)
+		assert.Equal(t, "com.example.User", registry.Resolve("legacy.User"))
+
+		err = registry.RegisterAlias("User", "OldUser")
+		assert.ErrorIs(t, err, InvalidArgumentError)
+	})
+
+	t.Run("invalid alias registers nothing", func(t *testing.T) {
+		type invoice struct {
+			Originator *types.Originator `entityType:"com.example.Invoice"`
+		}
+		_, err := registry.Register(&invoice{}, "Invoice", "User")
+		assert.ErrorIs(t, err, InvalidArgumentError)
+
+		_, ok := registry.TypeOf("com.example.Invoice")
+		assert.False(t, ok)
+		assert.Equal(t, "Invoice", registry.Resolve("Invoice"))
+
+		err = registry.RegisterAlias("com.example.Other", "Other", "")
+		assert.ErrorIs(t, err, InvalidArgumentError)
+		assert.Equal(t, "Other", registry.Resolve("Other"))
+	})
+
+	assert.Equal(t, "com.example.User", registry.Resolve("User"))
+	assert.Equal(t, "com.example.User", registry.Resolve("com.example.User"))
+	assert.Equal(t, "Unknown", registry.Resolve("Unknown"))
+	assert.Equal(t, []string{"User", "legacy.User"}, registry.Aliases("com.example.User"))
+
+	goType, ok := registry.TypeOf("legacy.User")
+	assert.True(t, ok)
+	assert.Equal(t, "taggedUser", goType.Name())
+
+	_, ok = registry.TypeOf("Unknown")
+	assert.False(t, ok)
+}
+
+func TestCrudStoreProvider_Aliases(t *testing.T) {
+	estore := eventstore.NewInMemoryStore()
+	registry := NewEntityRegistry()
+
+	// history written before the type was renamed
+	oldStore, err := NewCrudStoreProviderWithRegistry(context.Background(), estore, registry)
+	assert.NoError(t, err)
+	oldClient := NewClientWithStore(oldStore)
+
+	old := &User{Email: "old@gmail.com"}
+	_, err = oldClient.Create(old)
+	assert.NoError(t, err)
+
+	_, err = registry.Register(&taggedUser{}, "User")
+	assert.NoError(t, err)
+
+	store, err := NewCrudStoreProviderWithRegistry(context.Background(), estore, registry)
+	assert.NoError(t, err)
+	client, err := NewTypedClient[taggedUser](store)
+	assert.NoError(t, err)
+
+	renamed := &taggedUser{Email: "new@gmail.com"}
+	_, err = client.Create(renamed)
+	assert.NoError(t, err)
+
+	users, _, err := client.List("", 10)
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+	assert.Equal(t, old.Email, users[0].Email)
+	assert.Equal(t, renamed.Email, users[1].Email)
+
+	// updates of the old entities are written under the new name
+	users[0].Email = "updated@gmail.com"
+	_, err = client.Update(users[0])
+	assert.NoError(t, err)
+
+	events, err := estore.Get(&types.Originator{ID: old.Originator.ID}, false)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "User.Created", events[0].EventType)
+	assert.Equal(t, "com.example.User.Updated", events[1].EventType)
+
+	fetched, err := client.Get(old.Originator.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "updated@gmail.com", fetched.Email)
+
+	_, err = NewCrudStoreProviderWithRegistry(context.Background(), estore, nil)
+	assert.Error(t, err)
+}
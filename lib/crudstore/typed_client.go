@@ -0,0 +1,325 @@
+package crudstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	uuid "github.com/satori/go.uuid"
+	"reflect"
+	"time"
+)
+
+var originatorType = reflect.TypeOf(&types.Originator{})
+
+// originatorAccessor knows where the Originator field lives inside of an entity struct,
+// it's resolved once per type so the field doesn't have to be searched by name on every call
+type originatorAccessor struct {
+	index int
+}
+
+func newOriginatorAccessor(t reflect.Type) (*originatorAccessor, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct : %w", t, InvalidArgumentError)
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name != "Originator" {
+			continue
+		}
+
+		if f.Type != originatorType {
+			return nil, fmt.Errorf("%s.Originator should be of type %s : %w", t.Name(), originatorType, InvalidArgumentError)
+		}
+
+		return &originatorAccessor{index: i}, nil
+	}
+
+	return nil, fmt.Errorf("originator field was not found in %s : %w", t.Name(), InvalidArgumentError)
+}
+
+// get returns the originator of the struct v points to
+func (a *originatorAccessor) get(v reflect.Value) *types.Originator {
+	return v.Elem().Field(a.index).Interface().(*types.Originator)
+}
+
+// set sets the originator of the struct v points to
+func (a *originatorAccessor) set(v reflect.Value, originator *types.Originator) {
+	v.Elem().Field(a.index).Set(reflect.ValueOf(originator))
+}
+
+// TypedClient is the type safe version of Client, T should be a struct with
+// an `Originator *types.Originator` field. All of the reflection work is done
+// once in NewTypedClient so misconfigured types fail early instead of on every call.
+type TypedClient[T any] struct {
+	crudStore  CrudStore
+	entityType string
+	originator *originatorAccessor
+}
+
+// NewTypedClient creates a TypedClient for entities of type T
+func NewTypedClient[T any](crudStore CrudStore) (*TypedClient[T], error) {
+	if crudStore == nil {
+		return nil, fmt.Errorf("empty crud store : %w", InvalidArgumentError)
+	}
+
+	accessor, err := newOriginatorAccessor(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedClient[T]{
+		crudStore:  crudStore,
+		entityType: EntityTypeFromStruct((*T)(nil)),
+		originator: accessor,
+	}, nil
+}
+
+// EntityType returns the entity type the client stores T under
+func (client *TypedClient[T]) EntityType() string {
+	return client.entityType
+}
+
+// Create stores msg as a new entity, if msg has no originator a new one is generated
+// and set on msg
+func (client *TypedClient[T]) Create(msg *T) (*types.Originator, error) {
+	if msg == nil {
+		return nil, fmt.Errorf("empty message : %w", InvalidArgumentError)
+	}
+
+	originator := client.originator.get(reflect.ValueOf(msg))
+	if originator == nil {
+		originator = &types.Originator{
+			ID:      uuid.Must(uuid.NewV4()).String(),
+			Version: 1,
+		}
+	}
+
+	payloadJSON, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.crudStore.Create(client.entityType, originator, string(payloadJSON)); err != nil {
+		return nil, err
+	}
+
+	client.originator.set(reflect.ValueOf(msg), originator)
+	return originator, nil
+}
+
+// Get fetches the entity with the given id, version 0 means the latest version.
+// Deleted entities return RecordDeleted, use GetDeleted to fetch them.
+func (client *TypedClient[T]) Get(id string, version uint64) (*T, error) {
+	return client.get(&types.Originator{ID: id, Version: version}, false)
+}
+
+// GetDeleted is like Get but returns the last state of the entity even if it was deleted
+func (client *TypedClient[T]) GetDeleted(id string, version uint64) (*T, error) {
+	return client.get(&types.Originator{ID: id, Version: version}, true)
+}
+
+func (client *TypedClient[T]) get(originator *types.Originator, deleted bool) (*T, error) {
+	if originator.ID == "" {
+		return nil, fmt.Errorf("empty originator id : %w", InvalidArgumentError)
+	}
+
+	payload, latestOriginator, err := client.crudStore.Get(originator, deleted)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.decode(payload, latestOriginator)
+}
+
+// GetAsOf fetches the state the entity with the given id had at the given time
+func (client *TypedClient[T]) GetAsOf(id string, asOf time.Time) (*T, error) {
+	payload, originator, err := client.crudStore.GetAsOf(id, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.decode(payload, originator)
+}
+
+// Update stores the changes in msg, msg should have its originator set. On success
+// the originator of msg is bumped to the new version.
+func (client *TypedClient[T]) Update(msg *T) (*types.Originator, error) {
+	if msg == nil {
+		return nil, fmt.Errorf("empty message : %w", InvalidArgumentError)
+	}
+
+	originator := client.originator.get(reflect.ValueOf(msg))
+	if originator == nil {
+		return nil, fmt.Errorf("empty originator found inside the message : %w", InvalidArgumentError)
+	}
+
+	payloadJSON, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	updatedOriginator, err := client.crudStore.Update(client.entityType, originator, string(payloadJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	client.originator.set(reflect.ValueOf(msg), updatedOriginator)
+	return updatedOriginator, nil
+}
+
+// Delete soft deletes the entity with the given id
+func (client *TypedClient[T]) Delete(id string, version uint64) (*types.Originator, error) {
+	if id == "" {
+		return nil, fmt.Errorf("empty originator id : %w", InvalidArgumentError)
+	}
+
+	return client.crudStore.Delete(client.entityType, &types.Originator{ID: id, Version: version})
+}
+
+// Undelete restores the deleted entity with the given id and returns its state
+func (client *TypedClient[T]) Undelete(id string) (*T, error) {
+	if id == "" {
+		return nil, fmt.Errorf("empty originator id : %w", InvalidArgumentError)
+	}
+
+	restoredOriginator, err := client.crudStore.Undelete(client.entityType, &types.Originator{ID: id})
+	if err != nil {
+		return nil, err
+	}
+
+	return client.get(restoredOriginator, false)
+}
+
+// RevertTo brings the entity back to the state it had at version and returns that state,
+// it's stored as a new version so the versions in between are kept
+func (client *TypedClient[T]) RevertTo(id string, version uint64) (*T, error) {
+	if id == "" {
+		return nil, fmt.Errorf("empty originator id : %w", InvalidArgumentError)
+	}
+
+	revertedOriginator, err := client.crudStore.RevertTo(client.entityType, &types.Originator{ID: id}, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.get(revertedOriginator, false)
+}
+
+// Patch applies the JSON Patch operations to the entity and returns the patched state,
+// when originator has no version the latest one is patched
+func (client *TypedClient[T]) Patch(originator *types.Originator, ops ...PatchOperation) (*T, error) {
+	if originator == nil || originator.ID == "" {
+		return nil, fmt.Errorf("empty originator : %w", InvalidArgumentError)
+	}
+
+	opsJSON, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	patchedOriginator, err := client.crudStore.Patch(client.entityType, originator, string(opsJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	return client.get(patchedOriginator, false)
+}
+
+// Apply stores the custom domain event eventName for the entity and returns the state
+// after its reducer folded it in
+func (client *TypedClient[T]) Apply(originator *types.Originator, eventName string, payload interface{}) (*T, error) {
+	if originator == nil || originator.ID == "" {
+		return nil, fmt.Errorf("empty originator : %w", InvalidArgumentError)
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedOriginator, err := client.crudStore.Apply(originator, eventName, string(payloadJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	return client.get(appliedOriginator, false)
+}
+
+// List returns up to size entities starting from cursor, the returned cursor should
+// be passed to the next call. An empty cursor means there are no more results.
+func (client *TypedClient[T]) List(cursor string, size int) ([]*T, string, error) {
+	return client.ListWithQuery(&eventstore.EntityQuery{
+		Cursor: cursor,
+		Size:   size,
+	})
+}
+
+// ListWithQuery lists the entities matching the filters of the query in its order
+func (client *TypedClient[T]) ListWithQuery(query *eventstore.EntityQuery) ([]*T, string, error) {
+	entities, nextCursor, err := client.crudStore.ListEntities(client.entityType, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	results := make([]*T, 0, len(entities))
+	for _, e := range entities {
+		msg, err := client.decode(e.Payload, e.Originator)
+		if err != nil {
+			return nil, "", fmt.Errorf("list : entityType : %s: %w", client.entityType, err)
+		}
+		results = append(results, msg)
+	}
+
+	if len(results) == 0 {
+		return results, "", nil
+	}
+
+	return results, nextCursor, nil
+}
+
+// ListAsOf lists up to size entities which existed at the given time with the state they
+// had then, the returned cursor should be passed to the next call. A page can be shorter
+// than size before the end, the cursor is empty after the last one.
+func (client *TypedClient[T]) ListAsOf(asOf time.Time, cursor string, size int) ([]*T, string, error) {
+	entities, nextCursor, err := client.crudStore.ListAsOf(client.entityType, asOf, cursor, size)
+	if err != nil {
+		return nil, "", err
+	}
+
+	results := make([]*T, 0, len(entities))
+	for _, e := range entities {
+		msg, err := client.decode(e.Payload, e.Originator)
+		if err != nil {
+			return nil, "", fmt.Errorf("list : entityType : %s: %w", client.entityType, err)
+		}
+		results = append(results, msg)
+	}
+
+	return results, nextCursor, nil
+}
+
+// History returns all of the versions of the entity, oldest first
+func (client *TypedClient[T]) History(id string) ([]*HistoryEntry, error) {
+	return client.crudStore.History(id)
+}
+
+// Purge erases the events and the state of the entity with the given id
+func (client *TypedClient[T]) Purge(id string) (*types.Originator, error) {
+	return client.crudStore.Purge(id)
+}
+
+func (client *TypedClient[T]) decode(payload string, originator *types.Originator) (*T, error) {
+	msg := new(T)
+	if err := json.Unmarshal([]byte(payload), msg); err != nil {
+		return nil, fmt.Errorf("restoring the payload : %w", err)
+	}
+
+	client.originator.set(reflect.ValueOf(msg), originator)
+	return msg, nil
+}
@@ -0,0 +1,161 @@
+package crudstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type noOriginator struct {
+	Email string
+}
+
+type wrongOriginator struct {
+	Originator string
+}
+
+func newTestTypedClient(t *testing.T) *TypedClient[User] {
+	crudStore, err := NewCrudStoreProvider(context.Background(), eventstore.NewInMemoryStore())
+	assert.NoError(t, err)
+
+	client, err := NewTypedClient[User](crudStore)
+	assert.NoError(t, err)
+	assert.NotNil(t, client)
+	return client
+}
+
+func TestNewTypedClient(t *testing.T) {
+	crudStore, err := NewCrudStoreProvider(context.Background(), eventstore.NewInMemoryStore())
+	assert.NoError(t, err)
+
+	t.Run("missing originator", func(t *testing.T) {
+		_, err := NewTypedClient[noOriginator](crudStore)
+		assert.ErrorIs(t, err, InvalidArgumentError)
+	})
+
+	t.Run("wrong originator type", func(t *testing.T) {
+		_, err := NewTypedClient[wrongOriginator](crudStore)
+		assert.ErrorIs(t, err, InvalidArgumentError)
+	})
+
+	t.Run("non struct", func(t *testing.T) {
+		_, err := NewTypedClient[string](crudStore)
+		assert.ErrorIs(t, err, InvalidArgumentError)
+	})
+
+	t.Run("entity type", func(t *testing.T) {
+		client, err := NewTypedClient[User](crudStore)
+		assert.NoError(t, err)
+		assert.Equal(t, "User", client.EntityType())
+	})
+}
+
+func TestTypedClient_CRUD(t *testing.T) {
+	client := newTestTypedClient(t)
+
+	user := &User{Email: "typed@gmail.com", Active: true}
+	originator, err := client.Create(user)
+	assert.NoError(t, err)
+	assert.NotNil(t, originator)
+	assert.Equal(t, originator, user.Originator)
+
+	fetched, err := client.Get(originator.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, user, fetched)
+
+	user.FirstName = "Typed"
+	updatedOriginator, err := client.Update(user)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), updatedOriginator.Version)
+	assert.Equal(t, updatedOriginator, user.Originator)
+
+	fetched, err = client.Get(originator.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "Typed", fetched.FirstName)
+
+	fetched, err = client.Get(originator.ID, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, fetched.FirstName)
+	assert.Equal(t, uint64(1), fetched.Originator.Version)
+
+	deletedOriginator, err := client.Delete(originator.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), deletedOriginator.Version)
+
+	_, err = client.Get(originator.ID, 0)
+	assert.ErrorIs(t, err, RecordDeleted)
+
+	fetched, err = client.GetDeleted(originator.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "Typed", fetched.FirstName)
+
+	restored, err := client.Undelete(originator.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Typed", restored.FirstName)
+	assert.Equal(t, uint64(4), restored.Originator.Version)
+
+	reverted, err := client.RevertTo(originator.ID, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, reverted.FirstName)
+	assert.Equal(t, uint64(5), reverted.Originator.Version)
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		_, err := client.Create(nil)
+		assert.ErrorIs(t, err, InvalidArgumentError)
+
+		_, err = client.Update(&User{})
+		assert.ErrorIs(t, err, InvalidArgumentError)
+
+		_, err = client.Get("", 0)
+		assert.ErrorIs(t, err, InvalidArgumentError)
+
+		_, err = client.Delete("", 0)
+		assert.ErrorIs(t, err, InvalidArgumentError)
+
+		_, err = client.Undelete("")
+		assert.ErrorIs(t, err, InvalidArgumentError)
+
+		_, err = client.RevertTo("", 1)
+		assert.ErrorIs(t, err, InvalidArgumentError)
+	})
+}
+
+func TestTypedClient_List(t *testing.T) {
+	client := newTestTypedClient(t)
+
+	users, cursor, err := client.List("", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, cursor)
+	assert.Len(t, users, 0)
+
+	first := &User{Email: "first@gmail.com"}
+	_, err = client.Create(first)
+	assert.NoError(t, err)
+
+	second := &User{Email: "second@gmail.com"}
+	_, err = client.Create(second)
+	assert.NoError(t, err)
+
+	users, cursor, err = client.List("", 1)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cursor)
+	assert.Equal(t, []*User{first}, users)
+
+	users, cursor, err = client.List(cursor, 1)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cursor)
+	assert.Equal(t, []*User{second}, users)
+
+	users, cursor, err = client.List(cursor, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, cursor)
+	assert.Len(t, users, 0)
+
+	users, _, err = client.List("", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*User{first, second}, users)
+	assert.IsType(t, &types.Originator{}, users[0].Originator)
+}
@@ -0,0 +1,67 @@
+package eskittest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/makkalot/eskit/lib/aggregate"
+	"github.com/makkalot/eskit/lib/types"
+)
+
+// Event builds an event of eventType for the originator, payload can be a JSON string
+// or any value which is marshalled to JSON. An empty payload isn't compared in Then.
+func Event(eventType string, originatorID string, payload interface{}) *types.Event {
+	return &types.Event{
+		Originator: &types.Originator{ID: originatorID},
+		EventType:  eventType,
+		Payload:    encodePayload(payload),
+	}
+}
+
+// AtVersion sets the version of the event, it's compared in Then
+func AtVersion(event *types.Event, version uint64) *types.Event {
+	event.Originator.Version = version
+	return event
+}
+
+// Created builds the crudstore Created event of the entity
+func Created(entityType, originatorID string, payload interface{}) *types.Event {
+	return Event(entityType+".Created", originatorID, payload)
+}
+
+// Updated builds the crudstore Updated event of the entity, patch is the JSON Merge
+// Patch the crud store computes from the previous state
+func Updated(entityType, originatorID string, patch interface{}) *types.Event {
+	return Event(entityType+".Updated", originatorID, patch)
+}
+
+// Deleted builds the crudstore Deleted event of the entity
+func Deleted(entityType, originatorID string) *types.Event {
+	return Event(entityType+".Deleted", originatorID, "{}")
+}
+
+// AggregateEvent builds the event the aggregate.Repository stores for event, it panics
+// when the event is not registered in events
+func AggregateEvent(events *aggregate.EventRegistry, aggregateType, originatorID string, event interface{}) *types.Event {
+	name, err := events.NameOf(event)
+	if err != nil {
+		panic(fmt.Sprintf("eskittest : %v", err))
+	}
+	return Event(fmt.Sprintf("%s.%s", aggregateType, name), originatorID, event)
+}
+
+func encodePayload(payload interface{}) string {
+	switch p := payload.(type) {
+	case nil:
+		return ""
+	case string:
+		return p
+	case []byte:
+		return string(p)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		panic(fmt.Sprintf("eskittest : encoding payload : %v", err))
+	}
+	return string(data)
+}
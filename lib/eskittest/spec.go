@@ -0,0 +1,270 @@
+// Package eskittest provides Given/When/Then specifications for event sourced behaviour.
+// A Spec runs on an in-memory event store: Given appends the prior events, When runs the
+// command under test and Then compares the events it appended with the expected ones,
+// printing a readable diff when they don't match. It works with crudstore entities and
+// with aggregates built on the same store:
+//
+//	spec := eskittest.New(t)
+//	spec.Given(eskittest.Created("User", "user-1", `{"Email":"john@example.com"}`)).
+//	    When(func() error {
+//	        _, err := spec.CrudStore().Delete("User", &types.Originator{ID: "user-1"})
+//	        return err
+//	    }).
+//	    Then(eskittest.Deleted("User", "user-1"))
+package eskittest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Spec is a single Given/When/Then specification
+type Spec struct {
+	t         testing.TB
+	store     eventstore.Store
+	registry  *crudstore.EntityRegistry
+	crudStore crudstore.CrudStore
+	// lastID is the application log ID of the last given event
+	lastID uint64
+
+	ran bool
+	err error
+	// errExpected is set by ThenError so Then can check the events of failed commands
+	errExpected bool
+	events      []*types.Event
+}
+
+// New creates a spec running on a new in-memory store
+func New(t testing.TB) *Spec {
+	return NewWithStore(t, eventstore.NewInMemoryStore())
+}
+
+// NewWithStore creates a spec running on the given store, it should be empty
+func NewWithStore(t testing.TB, store eventstore.Store) *Spec {
+	return &Spec{
+		t:        t,
+		store:    store,
+		registry: crudstore.DefaultRegistry,
+	}
+}
+
+// WithRegistry makes the crud store of the spec use registry instead of the
+// crudstore.DefaultRegistry, it should be called before Given
+func (s *Spec) WithRegistry(registry *crudstore.EntityRegistry) *Spec {
+	s.registry = registry
+	s.crudStore = nil
+	return s
+}
+
+// Store returns the event store of the spec, repositories and clients under test
+// should be created on top of it
+func (s *Spec) Store() eventstore.Store {
+	return s.store
+}
+
+// CrudStore returns a crud store on top of the store of the spec
+func (s *Spec) CrudStore() crudstore.CrudStore {
+	s.t.Helper()
+
+	if s.crudStore == nil {
+		crudStore, err := crudstore.NewCrudStoreProviderWithRegistry(context.Background(), s.store, s.registry)
+		if err != nil {
+			s.t.Fatalf("creating crud store : %v", err)
+		}
+		s.crudStore = crudStore
+	}
+
+	return s.crudStore
+}
+
+// Given appends the prior events, the events without a version get the next
+// version of their originator
+func (s *Spec) Given(events ...*types.Event) *Spec {
+	s.t.Helper()
+
+	versions := map[string]uint64{}
+	var crudTypes []string
+	for _, event := range events {
+		e := *event
+		originator := *event.Originator
+		e.Originator = &originator
+
+		if e.Originator.Version == 0 {
+			if _, ok := versions[originator.ID]; !ok {
+				stored, err := s.store.Get(&types.Originator{ID: originator.ID}, false)
+				if err != nil {
+					s.t.Fatalf("given : %v", err)
+				}
+				versions[originator.ID] = uint64(len(stored))
+			}
+			e.Originator.Version = versions[originator.ID] + 1
+		}
+		versions[originator.ID] = e.Originator.Version
+
+		if e.OccurredOn.IsZero() {
+			e.OccurredOn = time.Now().UTC()
+		}
+
+		if err := s.store.Append(&e); err != nil {
+			s.t.Fatalf("given %s %s : %v", e.EventType, e.Originator.ID, err)
+		}
+
+		if e.Originator.Version == 1 && strings.EqualFold(common.ExtractEventType(&e), "created") {
+			crudTypes = append(crudTypes, common.ExtractEntityType(&e))
+		}
+	}
+
+	// index the given crud entities like the crud store would so they can be listed
+	if _, ok := s.store.(eventstore.EntityStore); ok {
+		for _, entityType := range crudTypes {
+			if err := s.CrudStore().RebuildEntities(entityType); err != nil {
+				s.t.Fatalf("given : indexing %s : %v", entityType, err)
+			}
+		}
+	}
+
+	newEvents, lastID := s.eventsAfter(s.lastID)
+	if len(newEvents) > 0 {
+		s.lastID = lastID
+	}
+
+	return s
+}
+
+// When runs the command under test, the events it appends are compared in Then
+func (s *Spec) When(command func() error) *Spec {
+	s.t.Helper()
+
+	s.err = command()
+	s.errExpected = false
+	s.ran = true
+	s.events, _ = s.eventsAfter(s.lastID)
+	return s
+}
+
+// Then expects the command to succeed and append exactly the expected events in order.
+// The parts left empty in an expected event are not compared: the originator ID, the
+// version and the payload. Payloads are compared as JSON. Calling it without events
+// expects no events. After ThenError it checks the events of the failed command.
+func (s *Spec) Then(expected ...*types.Event) *Spec {
+	s.t.Helper()
+
+	if !s.ran {
+		s.t.Errorf("Then called before When")
+		return s
+	}
+
+	if s.err != nil && !s.errExpected {
+		s.t.Errorf("expected events but the command failed : %v", s.err)
+		return s
+	}
+
+	var expectedLines, actualLines []string
+	for i, e := range expected {
+		expectedLines = append(expectedLines, formatEvent(e, e))
+		if i < len(s.events) {
+			actualLines = append(actualLines, formatEvent(s.events[i], e))
+		}
+	}
+
+	for i := len(expected); i < len(s.events); i++ {
+		actualLines = append(actualLines, formatEvent(s.events[i], nil))
+	}
+
+	assert.Equal(s.t, expectedLines, actualLines, "events appended by the command")
+	return s
+}
+
+// ThenError expects the command to fail with an error matching target via errors.Is
+func (s *Spec) ThenError(target error) *Spec {
+	s.t.Helper()
+
+	if !s.ran {
+		s.t.Errorf("ThenError called before When")
+		return s
+	}
+
+	if s.err == nil {
+		s.t.Errorf("expected error %v but the command succeeded", target)
+		return s
+	}
+
+	assert.ErrorIs(s.t, s.err, target)
+	s.errExpected = true
+	return s
+}
+
+// Events returns the events appended by the command
+func (s *Spec) Events() []*types.Event {
+	return s.events
+}
+
+// eventsAfter returns the events appended after the log ID and the ID of the last one
+func (s *Spec) eventsAfter(fromID uint64) ([]*types.Event, uint64) {
+	s.t.Helper()
+
+	var events []*types.Event
+	lastID := fromID
+	for {
+		logs, err := s.store.Logs(lastID+1, 100, "")
+		if err != nil {
+			s.t.Fatalf("fetching the appended events : %v", err)
+		}
+
+		if len(logs) == 0 {
+			return events, lastID
+		}
+
+		for _, entry := range logs {
+			events = append(events, entry.Event)
+			lastID = entry.ID
+		}
+	}
+}
+
+// formatEvent renders the event as a single line leaving out the parts which
+// are empty in mask, nil mask renders everything
+func formatEvent(event *types.Event, mask *types.Event) string {
+	var parts []string
+	parts = append(parts, event.EventType)
+
+	if event.Originator != nil {
+		if mask == nil || (mask.Originator != nil && mask.Originator.ID != "") {
+			parts = append(parts, event.Originator.ID)
+		}
+
+		if mask == nil || (mask.Originator != nil && mask.Originator.Version != 0) {
+			parts = append(parts, fmt.Sprintf("v%d", event.Originator.Version))
+		}
+	}
+
+	if mask == nil || mask.Payload != "" {
+		parts = append(parts, normalizeJSON(event.Payload))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// normalizeJSON re-encodes the JSON document so the key order and the whitespace
+// don't matter, invalid documents are returned as they are
+func normalizeJSON(payload string) string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(payload), &value); err != nil {
+		return payload
+	}
+
+	normalized, err := json.Marshal(value)
+	if err != nil {
+		return payload
+	}
+	return string(normalized)
+}
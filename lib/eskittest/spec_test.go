@@ -0,0 +1,183 @@
+package eskittest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/aggregate"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type Deposited struct {
+	Amount int
+}
+
+type Withdrawn struct {
+	Amount int
+}
+
+var errInsufficientFunds = errors.New("insufficient funds")
+
+type Account struct {
+	aggregate.Root
+	Balance int
+}
+
+func (a *Account) Apply(event interface{}) error {
+	switch e := event.(type) {
+	case *Deposited:
+		a.Balance += e.Amount
+	case *Withdrawn:
+		a.Balance -= e.Amount
+	}
+	return nil
+}
+
+func (a *Account) Withdraw(amount int) error {
+	if amount > a.Balance {
+		return errInsufficientFunds
+	}
+	return aggregate.Raise(a, &Withdrawn{Amount: amount})
+}
+
+// recordingT records the failures instead of failing the test
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Name() string {
+	return "recording"
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestSpec_CrudStore(t *testing.T) {
+	spec := New(t)
+	spec.Given(
+		Created("User", "user-1", `{"Email":"john@example.com","Active":true}`),
+		Updated("User", "user-1", `{"Active":false}`),
+	).When(func() error {
+		_, err := spec.CrudStore().Delete("User", &types.Originator{ID: "user-1"})
+		return err
+	}).Then(
+		AtVersion(Deleted("User", "user-1"), 3),
+	)
+
+	t.Run("given entities are listed", func(t *testing.T) {
+		spec := New(t)
+		spec.Given(Created("User", "user-1", `{"Email":"john@example.com"}`)).
+			When(func() error {
+				entities, _, err := spec.CrudStore().ListEntities("User", nil)
+				assert.Len(t, entities, 1)
+				return err
+			}).
+			Then()
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		spec := New(t)
+		spec.Given(Created("User", "user-1", `{}`), Deleted("User", "user-1")).
+			When(func() error {
+				_, err := spec.CrudStore().Update("User", &types.Originator{ID: "user-1", Version: 2}, `{}`)
+				return err
+			}).
+			ThenError(crudstore.RecordDeleted).
+			Then()
+	})
+}
+
+func TestSpec_Aggregate(t *testing.T) {
+	events := aggregate.NewEventRegistry()
+	assert.NoError(t, events.Register(Deposited{}, Withdrawn{}))
+
+	spec := New(t)
+	repository, err := aggregate.NewRepository(spec.Store(), "Account", events, func() *Account { return &Account{} })
+	assert.NoError(t, err)
+
+	withdraw := func(amount int) func() error {
+		return func() error {
+			account, err := repository.Load("account-1")
+			if err != nil {
+				return err
+			}
+
+			if err := account.Withdraw(amount); err != nil {
+				return err
+			}
+			return repository.Save(account)
+		}
+	}
+
+	spec.Given(AggregateEvent(events, "Account", "account-1", &Deposited{Amount: 10})).
+		When(withdraw(4)).
+		Then(AggregateEvent(events, "Account", "account-1", &Withdrawn{Amount: 4}))
+
+	spec = New(t)
+	repository, err = aggregate.NewRepository(spec.Store(), "Account", events, func() *Account { return &Account{} })
+	assert.NoError(t, err)
+
+	spec.Given(AggregateEvent(events, "Account", "account-1", &Deposited{Amount: 10})).
+		When(withdraw(20)).
+		ThenError(errInsufficientFunds).
+		Then()
+}
+
+func TestSpec_Failures(t *testing.T) {
+	t.Run("event diff", func(t *testing.T) {
+		rt := &recordingT{}
+		spec := New(rt)
+		spec.Given(Created("User", "user-1", `{"Email":"john@example.com"}`)).
+			When(func() error {
+				_, err := spec.CrudStore().Update("User", &types.Originator{ID: "user-1", Version: 1}, `{"Email":"jane@example.com"}`)
+				return err
+			}).
+			Then(Updated("User", "user-1", `{"Email":"other@example.com"}`))
+
+		assert.Len(t, rt.errors, 1)
+		assert.Contains(t, rt.errors[0], "--- Expected")
+		assert.Contains(t, rt.errors[0], `- (string) (len=49) "User.Updated user-1 {\"Email\":\"other@example.com\"}"`)
+		assert.Contains(t, rt.errors[0], `+ (string) (len=48) "User.Updated user-1 {\"Email\":\"jane@example.com\"}"`)
+	})
+
+	t.Run("unexpected events", func(t *testing.T) {
+		rt := &recordingT{}
+		spec := New(rt)
+		spec.Given(Created("User", "user-1", `{}`)).
+			When(func() error {
+				_, err := spec.CrudStore().Delete("User", &types.Originator{ID: "user-1"})
+				return err
+			}).
+			Then()
+
+		assert.Len(t, rt.errors, 1)
+		assert.True(t, strings.Contains(rt.errors[0], "User.Deleted user-1 v2 {}"), rt.errors[0])
+	})
+
+	t.Run("unexpected error", func(t *testing.T) {
+		rt := &recordingT{}
+		New(rt).When(func() error { return errInsufficientFunds }).Then()
+		assert.Len(t, rt.errors, 1)
+		assert.Contains(t, rt.errors[0], "the command failed : insufficient funds")
+	})
+
+	t.Run("missing error", func(t *testing.T) {
+		rt := &recordingT{}
+		New(rt).When(func() error { return nil }).ThenError(errInsufficientFunds)
+		assert.Len(t, rt.errors, 1)
+	})
+
+	t.Run("then before when", func(t *testing.T) {
+		rt := &recordingT{}
+		New(rt).Then()
+		assert.Len(t, rt.errors, 1)
+	})
+}
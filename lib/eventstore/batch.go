@@ -0,0 +1,47 @@
+package eventstore
+
+import (
+	"fmt"
+	"github.com/makkalot/eskit/lib/types"
+)
+
+// BatchEntry is an event appended by AppendBatch, Entity is the new state of its
+// originator and it's optional like in AppendWithEntity
+type BatchEntry struct {
+	Event  *types.Event
+	Entity *types.CrudEntity
+}
+
+// BatchError is returned by AppendBatch when one of the entries can't be appended,
+// Index is the position of the entry in the batch
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch entry %d : %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BatchAppender is implemented by the stores which can append the events of several
+// originators atomically, either all of the entries are stored or none of them. The
+// entries are appended in order so an originator can have more than one event in a batch.
+type BatchAppender interface {
+	AppendBatch(entries []*BatchEntry) error
+}
+
+func (entry *BatchEntry) validate() error {
+	if entry == nil || entry.Event == nil || entry.Event.Originator == nil {
+		return fmt.Errorf("empty event")
+	}
+
+	if entry.Entity != nil && entry.Entity.Originator == nil {
+		return fmt.Errorf("empty entity")
+	}
+
+	return nil
+}
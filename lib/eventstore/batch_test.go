@@ -0,0 +1,89 @@
+package eventstore
+
+import (
+	"os"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchAppender(tm *testing.T) {
+	sqlStore, err := NewSqlStore("sqlite3", "batch.db")
+	assert.NoError(tm, err)
+	assert.NoError(tm, sqlStore.Cleanup())
+
+	tm.Cleanup(func() {
+		if _, err := os.Stat("batch.db"); err == nil {
+			assert.NoError(tm, os.Remove("batch.db"))
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		store interface {
+			Store
+			EntityStore
+			BatchAppender
+		}
+	}{
+		{"sql store", sqlStore},
+		{"inmemory store", NewInMemoryStore().(*InMemoryStore)},
+	}
+
+	entry := func(id string, version uint64, eventType, payload string) *BatchEntry {
+		originator := &types.Originator{ID: id, Version: version}
+		return &BatchEntry{
+			Event:  &types.Event{Originator: originator, EventType: eventType, Payload: payload},
+			Entity: types.NewCrudEntity("User", originator, payload, false),
+		}
+	}
+
+	for _, tc := range testCases {
+		store := tc.store
+		tm.Run(tc.name, func(t *testing.T) {
+			assert.NoError(t, store.AppendBatch(nil))
+
+			assert.NoError(t, store.AppendBatch([]*BatchEntry{
+				entry("user-1", 1, "User.Created", `{"name":"first"}`),
+				entry("user-2", 1, "User.Created", `{"name":"other"}`),
+				entry("user-1", 2, "User.Updated", `{"name":"second"}`),
+			}))
+
+			logs, err := store.Logs(1, 100, "")
+			assert.NoError(t, err)
+			assert.Len(t, logs, 3)
+
+			events, err := store.Get(&types.Originator{ID: "user-1"}, false)
+			assert.NoError(t, err)
+			assert.Len(t, events, 2)
+
+			entities, _, err := store.ListEntities([]string{"User"}, nil)
+			assert.NoError(t, err)
+			assert.Len(t, entities, 2)
+			assert.Equal(t, `{"name":"second"}`, entities[0].Payload)
+
+			// a duplicate version fails the whole batch
+			err = store.AppendBatch([]*BatchEntry{
+				entry("user-3", 1, "User.Created", `{"name":"new"}`),
+				entry("user-2", 1, "User.Created", `{"name":"duplicate"}`),
+			})
+			assert.ErrorIs(t, err, ErrDuplicate)
+			var batchErr *BatchError
+			assert.ErrorAs(t, err, &batchErr)
+			assert.Equal(t, 1, batchErr.Index)
+
+			logs, err = store.Logs(1, 100, "")
+			assert.NoError(t, err)
+			assert.Len(t, logs, 3)
+
+			events, err = store.Get(&types.Originator{ID: "user-3"}, false)
+			assert.NoError(t, err)
+			assert.Empty(t, events)
+
+			err = store.AppendBatch([]*BatchEntry{entry("user-4", 1, "User.Created", `{}`), {}})
+			assert.ErrorAs(t, err, &batchErr)
+			assert.Equal(t, 1, batchErr.Index)
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package eventstore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/makkalot/eskit/lib/types"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// EntityStore is implemented by the stores which keep the current state of the crud
+// entities next to their events. The state is updated in the same transaction as the
+// event is appended so it never drifts from the event stream.
+type EntityStore interface {
+	// AppendWithEntity appends the event and saves entity as the current state of
+	// its originator atomically
+	AppendWithEntity(event *types.Event, entity *types.CrudEntity) error
+	// SaveEntity saves the entity without appending any event, it's used when rebuilding
+	// the entities from the event stream. seq is the application log ID the entity
+	// was created at and it defines the default listing order.
+	SaveEntity(entity *types.CrudEntity, seq uint64) error
+	// ListEntities lists the entities of the given types, the returned cursor should be
+	// passed to the next query. It's empty when there are no more results.
+	ListEntities(entityTypes []string, query *EntityQuery) ([]*types.CrudEntity, string, error)
+}
+
+// EntityRebuildTracker is implemented by the entity stores which can hold events appended
+// before they kept the entities, ie. a database created by an older version. The entity
+// types whose entities were rebuilt from the log are recorded so they're rebuilt only once.
+type EntityRebuildTracker interface {
+	// EntitiesRebuilt returns true when the entities of entityType were rebuilt
+	EntitiesRebuilt(entityType string) (bool, error)
+	// MarkEntitiesRebuilt records that the entities of entityType were rebuilt
+	MarkEntitiesRebuilt(entityType string) error
+}
+
+// EntityFilter matches the entities whose payload field equals Value
+type EntityFilter struct {
+	// Field is the JSON field in the payload, nested fields are separated with dots (ie. Address.City)
+	Field string
+	Value interface{}
+}
+
+// EntityQuery describes which entities should be listed and in what order
+type EntityQuery struct {
+	// Filters are combined with AND
+	Filters []EntityFilter
+	// OrderBy is the JSON field to order by, when empty entities are listed in creation order.
+	// The field should be present in all of the entities of the type.
+	OrderBy    string
+	Descending bool
+	// Cursor is the value returned from the previous query, empty starts from the beginning
+	Cursor string
+	// Size is the maximum number of entities to return, defaults to 10
+	Size int
+	// Deleted includes the deleted entities as well
+	Deleted bool
+}
+
+func (q *EntityQuery) size() int {
+	if q.Size <= 0 {
+		return 10
+	}
+	return q.Size
+}
+
+var fieldPathRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+func (q *EntityQuery) validate() error {
+	for _, f := range q.Filters {
+		if !fieldPathRegexp.MatchString(f.Field) {
+			return fmt.Errorf("filter field %q : %w", f.Field, ErrInvalidQuery)
+		}
+	}
+
+	if q.OrderBy != "" && !fieldPathRegexp.MatchString(q.OrderBy) {
+		return fmt.Errorf("order field %q : %w", q.OrderBy, ErrInvalidQuery)
+	}
+
+	return nil
+}
+
+// entityCursor is the position of the last listed entity, it's encoded as base64 json
+// so clients treat it as an opaque value
+type entityCursor struct {
+	Seq   uint64          `json:"s"`
+	Value json.RawMessage `json:"v,omitempty"`
+}
+
+func encodeEntityCursor(seq uint64, payload string, orderBy string) (string, error) {
+	cursor := &entityCursor{Seq: seq}
+	if orderBy != "" {
+		value, err := json.Marshal(jsonFieldValue(payload, orderBy))
+		if err != nil {
+			return "", err
+		}
+		cursor.Value = value
+	}
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeEntityCursor decodes the cursors returned by ListEntities, the numeric cursors
+// of the lists from the application log are accepted as well. They're the log ID the
+// next page starts from so they continue with the entities created from there.
+func decodeEntityCursor(cursor string, query *EntityQuery) (*entityCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	if fromID, err := strconv.ParseUint(cursor, 10, 64); err == nil {
+		if query.OrderBy != "" || query.Descending {
+			return nil, fmt.Errorf("log cursor can't be used with ordering : %w", ErrInvalidQuery)
+		}
+		if fromID > 0 {
+			fromID--
+		}
+		return &entityCursor{Seq: fromID}, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("cursor : %w", ErrInvalidQuery)
+	}
+
+	c := &entityCursor{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("cursor : %w", ErrInvalidQuery)
+	}
+
+	return c, nil
+}
+
+// jsonFieldValue returns the value at the dotted path inside of the json payload,
+// nil if it's missing
+func jsonFieldValue(payload string, path string) interface{} {
+	var current interface{}
+	if err := json.Unmarshal([]byte(payload), &current); err != nil {
+		return nil
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+
+	return current
+}
+
+// normalizeJSONValue converts the value to what encoding/json would decode it to,
+// so ie. ints and float64s can be compared
+func normalizeJSONValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+
+	return normalized, nil
+}
+
+// compareJSONValues orders the decoded json values, values of different types are
+// ordered by type : null, bool, number, string and the rest
+func compareJSONValues(a, b interface{}) int {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case bool:
+			return 1
+		case float64:
+			return 2
+		case string:
+			return 3
+		default:
+			return 4
+		}
+	}
+
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+
+	switch av := a.(type) {
+	case bool:
+		bv := b.(bool)
+		if av == bv {
+			return 0
+		}
+		if !av {
+			return -1
+		}
+		return 1
+	case float64:
+		bv := b.(float64)
+		if av < bv {
+			return -1
+		}
+		if av > bv {
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(av, b.(string))
+	case nil:
+		return 0
+	default:
+		aj, _ := json.Marshal(a)
+		bj, _ := json.Marshal(b)
+		return strings.Compare(string(aj), string(bj))
+	}
+}
@@ -0,0 +1,181 @@
+package eventstore
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntityStore(tm *testing.T) {
+	sqlStore, err := NewSqlStore("sqlite3", "entities.db")
+	assert.NoError(tm, err)
+	assert.NoError(tm, sqlStore.Cleanup())
+
+	tm.Cleanup(func() {
+		if _, err := os.Stat("entities.db"); err == nil {
+			assert.NoError(tm, os.Remove("entities.db"))
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		store interface {
+			Store
+			EntityStore
+		}
+	}{
+		{"sql store", sqlStore},
+		{"inmemory store", NewInMemoryStore().(*InMemoryStore)},
+	}
+
+	for _, tc := range testCases {
+		store := tc.store
+		tm.Run(tc.name, func(t *testing.T) {
+			ages := []int{30, 20, 40, 20}
+			for i, age := range ages {
+				originator := &types.Originator{ID: fmt.Sprintf("user-%d", i), Version: 1}
+				payload := fmt.Sprintf(`{"Name":"user-%d","Age":%d,"Active":%t,"Address":{"City":"city-%d"}}`, i, age, i%2 == 0, i%2)
+				err := store.AppendWithEntity(&types.Event{
+					Originator: originator,
+					EventType:  "User.Created",
+					Payload:    payload,
+					OccurredOn: time.Now().UTC(),
+				}, types.NewCrudEntity("User", originator, payload, false))
+				assert.NoError(t, err)
+			}
+
+			// the event is appended as well
+			events, err := store.Get(&types.Originator{ID: "user-0"}, false)
+			assert.NoError(t, err)
+			assert.Len(t, events, 1)
+
+			// other entity types are not listed
+			otherOriginator := &types.Originator{ID: "project-0", Version: 1}
+			assert.NoError(t, store.AppendWithEntity(&types.Event{
+				Originator: otherOriginator,
+				EventType:  "Project.Created",
+				Payload:    `{"Name":"project"}`,
+			}, types.NewCrudEntity("Project", otherOriginator, `{"Name":"project"}`, false)))
+
+			ids := func(entities []*types.CrudEntity) []string {
+				var results []string
+				for _, e := range entities {
+					results = append(results, e.Originator.ID)
+				}
+				return results
+			}
+
+			t.Run("creation order with cursor", func(t *testing.T) {
+				entities, cursor, err := store.ListEntities([]string{"User"}, &EntityQuery{Size: 3})
+				assert.NoError(t, err)
+				assert.NotEmpty(t, cursor)
+				assert.Equal(t, []string{"user-0", "user-1", "user-2"}, ids(entities))
+
+				entities, cursor, err = store.ListEntities([]string{"User"}, &EntityQuery{Size: 3, Cursor: cursor})
+				assert.NoError(t, err)
+				assert.NotEmpty(t, cursor)
+				assert.Equal(t, []string{"user-3"}, ids(entities))
+
+				entities, cursor, err = store.ListEntities([]string{"User"}, &EntityQuery{Size: 3, Cursor: cursor})
+				assert.NoError(t, err)
+				assert.Empty(t, cursor)
+				assert.Len(t, entities, 0)
+			})
+
+			t.Run("order by field", func(t *testing.T) {
+				entities, cursor, err := store.ListEntities([]string{"User"}, &EntityQuery{OrderBy: "Age", Size: 2})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"user-1", "user-3"}, ids(entities))
+
+				entities, _, err = store.ListEntities([]string{"User"}, &EntityQuery{OrderBy: "Age", Size: 2, Cursor: cursor})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"user-0", "user-2"}, ids(entities))
+
+				entities, cursor, err = store.ListEntities([]string{"User"}, &EntityQuery{OrderBy: "Age", Descending: true, Size: 2})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"user-2", "user-0"}, ids(entities))
+
+				entities, _, err = store.ListEntities([]string{"User"}, &EntityQuery{OrderBy: "Age", Descending: true, Size: 2, Cursor: cursor})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"user-3", "user-1"}, ids(entities))
+			})
+
+			t.Run("filters", func(t *testing.T) {
+				entities, _, err := store.ListEntities([]string{"User"}, &EntityQuery{
+					Filters: []EntityFilter{{Field: "Age", Value: 20}},
+				})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"user-1", "user-3"}, ids(entities))
+
+				entities, _, err = store.ListEntities([]string{"User"}, &EntityQuery{
+					Filters: []EntityFilter{{Field: "Active", Value: true}, {Field: "Address.City", Value: "city-0"}},
+				})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"user-0", "user-2"}, ids(entities))
+
+				entities, _, err = store.ListEntities([]string{"User", "Project"}, &EntityQuery{
+					Filters: []EntityFilter{{Field: "Name", Value: "project"}},
+				})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"project-0"}, ids(entities))
+			})
+
+			t.Run("updates and deletes", func(t *testing.T) {
+				originator := &types.Originator{ID: "user-1", Version: 2}
+				payload := `{"Name":"user-1","Age":50,"Active":false,"Address":{"City":"city-1"}}`
+				assert.NoError(t, store.AppendWithEntity(&types.Event{
+					Originator: originator,
+					EventType:  "User.Updated",
+					Payload:    `{"Age":50}`,
+				}, types.NewCrudEntity("User", originator, payload, false)))
+
+				// the position of the entity doesn't change on update
+				entities, _, err := store.ListEntities([]string{"User"}, &EntityQuery{})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"user-0", "user-1", "user-2", "user-3"}, ids(entities))
+				assert.Equal(t, uint64(2), entities[1].Originator.Version)
+				assert.Equal(t, payload, entities[1].Payload)
+
+				originator = &types.Originator{ID: "user-1", Version: 3}
+				assert.NoError(t, store.AppendWithEntity(&types.Event{
+					Originator: originator,
+					EventType:  "User.Deleted",
+					Payload:    `{}`,
+				}, types.NewCrudEntity("User", originator, payload, true)))
+
+				entities, _, err = store.ListEntities([]string{"User"}, &EntityQuery{})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"user-0", "user-2", "user-3"}, ids(entities))
+
+				entities, _, err = store.ListEntities([]string{"User"}, &EntityQuery{Deleted: true})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"user-0", "user-1", "user-2", "user-3"}, ids(entities))
+				assert.True(t, entities[1].Deleted)
+
+				// duplicates don't touch the entity
+				err = store.AppendWithEntity(&types.Event{
+					Originator: originator,
+					EventType:  "User.Updated",
+					Payload:    `{"Age":60}`,
+				}, types.NewCrudEntity("User", originator, `{"Age":60}`, false))
+				assert.ErrorIs(t, err, ErrDuplicate)
+
+				entities, _, err = store.ListEntities([]string{"User"}, &EntityQuery{Deleted: true, Filters: []EntityFilter{{Field: "Age", Value: 60}}})
+				assert.NoError(t, err)
+				assert.Len(t, entities, 0)
+			})
+
+			t.Run("invalid query", func(t *testing.T) {
+				_, _, err := store.ListEntities([]string{"User"}, &EntityQuery{OrderBy: "Age; DROP TABLE"})
+				assert.ErrorIs(t, err, ErrInvalidQuery)
+
+				_, _, err = store.ListEntities([]string{"User"}, &EntityQuery{Cursor: "not a cursor"})
+				assert.ErrorIs(t, err, ErrInvalidQuery)
+			})
+		})
+	}
+}
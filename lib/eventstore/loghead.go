@@ -0,0 +1,31 @@
+package eventstore
+
+import "fmt"
+
+// LogHeadReader is implemented by the stores which can return the ID of the last
+// application log entry without reading the log
+type LogHeadReader interface {
+	// LogHead returns 0 when the log is empty
+	LogHead() (uint64, error)
+}
+
+// LogHead returns the ID of the last application log entry, the stores which don't
+// implement LogHeadReader are paged through
+func LogHead(store Store) (uint64, error) {
+	if reader, ok := store.(LogHeadReader); ok {
+		return reader.LogHead()
+	}
+
+	var head uint64
+	for {
+		results, err := store.Logs(head+1, 1000, "")
+		if err != nil {
+			return 0, fmt.Errorf("fetch logs : %w", err)
+		}
+
+		if len(results) == 0 {
+			return head, nil
+		}
+		head = results[len(results)-1].ID
+	}
+}
@@ -1,20 +1,32 @@
 package eventstore
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/makkalot/eskit/lib/common"
 	"github.com/makkalot/eskit/lib/types"
+	"sort"
 )
 
 type InMemoryStore struct {
 	eventStore map[string][]*types.Event
 	logs       []*types.AppLogEntry
+	entities   map[string]*memoryEntity
+	snapshots  map[string]*Snapshot
+}
+
+// memoryEntity is the current state of a crud entity with the log ID it was created at
+type memoryEntity struct {
+	entity *types.CrudEntity
+	seq    uint64
 }
 
 func NewInMemoryStore() StoreWithCleanup {
 	return &InMemoryStore{
 		eventStore: map[string][]*types.Event{},
 		logs:       []*types.AppLogEntry{},
+		entities:   map[string]*memoryEntity{},
+		snapshots:  map[string]*Snapshot{},
 	}
 }
 
@@ -22,6 +34,8 @@ func NewInMemoryStore() StoreWithCleanup {
 func (s *InMemoryStore) Cleanup() error {
 	s.eventStore = map[string][]*types.Event{}
 	s.logs = []*types.AppLogEntry{}
+	s.entities = map[string]*memoryEntity{}
+	s.snapshots = map[string]*Snapshot{}
 	return nil
 }
 
@@ -94,6 +108,16 @@ func (s *InMemoryStore) Get(originator *types.Originator, fromVersion bool) ([]*
 	return results, nil
 }
 
+func (s *InMemoryStore) GetMany(originatorIDs []string) (map[string][]*types.Event, error) {
+	results := map[string][]*types.Event{}
+	for _, id := range originatorIDs {
+		if events := s.eventStore[id]; len(events) > 0 {
+			results[id] = events
+		}
+	}
+	return results, nil
+}
+
 func (s *InMemoryStore) Logs(fromID uint64, size uint32, pipelineID string) ([]*types.AppLogEntry, error) {
 	if s.logs == nil || len(s.logs) == 0 {
 		return []*types.AppLogEntry{}, nil
@@ -108,25 +132,277 @@ func (s *InMemoryStore) Logs(fromID uint64, size uint32, pipelineID string) ([]*
 		fromID--
 	}
 
-	var results []*types.AppLogEntry
-	//log.Println("logs : ", spew.Sdump(s.logs))
-	if len(s.logs)-int(fromID) > int(size) {
-		//log.Printf("fetching : %d:%d\n", int(fromID), int(fromID)+int(size))
-		results = s.logs[fromID : int(fromID)+int(size)]
-	} else {
-		results = s.logs[fromID:]
-	}
-
 	if pipelineID == "" {
-		return results, nil
+		//log.Println("logs : ", spew.Sdump(s.logs))
+		if len(s.logs)-int(fromID) > int(size) {
+			return s.logs[fromID : int(fromID)+int(size)], nil
+		}
+		return s.logs[fromID:], nil
 	}
 
+	// filter first so the page is filled with the entries of the pipeline like the sql store does
 	var finalResults []*types.AppLogEntry
-	for _, r := range results {
-		if common.ExtractEntityType(r.Event) == pipelineID {
-			finalResults = append(finalResults, r)
+	for _, r := range s.logs[fromID:] {
+		if common.ExtractEntityType(r.Event) != pipelineID {
+			continue
+		}
+
+		finalResults = append(finalResults, r)
+		if len(finalResults) == int(size) {
+			break
 		}
 	}
 
 	return finalResults, nil
 }
+
+func (s *InMemoryStore) AppendWithEntity(event *types.Event, entity *types.CrudEntity) error {
+	if err := s.Append(event); err != nil {
+		return err
+	}
+
+	return s.SaveEntity(entity, s.logs[len(s.logs)-1].ID)
+}
+
+func (s *InMemoryStore) AppendBatch(entries []*BatchEntry) error {
+	// check all of the versions first so nothing is appended when one of them fails
+	heads := map[string]uint64{}
+	for i, entry := range entries {
+		if err := entry.validate(); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+
+		id := entry.Event.Originator.ID
+		head, ok := heads[id]
+		if !ok {
+			if events := s.eventStore[id]; len(events) > 0 {
+				head = events[len(events)-1].Originator.Version
+			}
+		}
+
+		if entry.Event.Originator.Version <= head {
+			return &BatchError{
+				Index: i,
+				Err:   fmt.Errorf("you apply version : %d, db version is : %d for %s: %w", entry.Event.Originator.Version, head, id, ErrDuplicate),
+			}
+		}
+		heads[id] = entry.Event.Originator.Version
+	}
+
+	for i, entry := range entries {
+		if err := s.Append(entry.Event); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+
+		if entry.Entity != nil {
+			if err := s.SaveEntity(entry.Entity, s.logs[len(s.logs)-1].ID); err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *InMemoryStore) SaveEntity(entity *types.CrudEntity, seq uint64) error {
+	if entity == nil || entity.Originator == nil {
+		return fmt.Errorf("empty entity")
+	}
+
+	if existing, ok := s.entities[entity.Originator.ID]; ok {
+		seq = existing.seq
+	}
+
+	s.entities[entity.Originator.ID] = &memoryEntity{
+		entity: entity,
+		seq:    seq,
+	}
+
+	return nil
+}
+
+func (s *InMemoryStore) ListEntities(entityTypes []string, query *EntityQuery) ([]*types.CrudEntity, string, error) {
+	if query == nil {
+		query = &EntityQuery{}
+	}
+
+	if err := query.validate(); err != nil {
+		return nil, "", err
+	}
+
+	cursor, err := decodeEntityCursor(query.Cursor, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	filters := make([]interface{}, len(query.Filters))
+	for i, f := range query.Filters {
+		if filters[i], err = normalizeJSONValue(f.Value); err != nil {
+			return nil, "", fmt.Errorf("filter %s : %w", f.Field, ErrInvalidQuery)
+		}
+	}
+
+	var cursorValue interface{}
+	if cursor != nil && len(cursor.Value) > 0 {
+		if err := json.Unmarshal(cursor.Value, &cursorValue); err != nil {
+			return nil, "", fmt.Errorf("cursor : %w", ErrInvalidQuery)
+		}
+	}
+
+	wantedTypes := map[string]bool{}
+	for _, t := range entityTypes {
+		wantedTypes[t] = true
+	}
+
+	type candidate struct {
+		*memoryEntity
+		orderValue interface{}
+	}
+
+	// compare orders the candidates by the order field and then by their creation
+	compare := func(value interface{}, seq uint64, other interface{}, otherSeq uint64) int {
+		result := 0
+		if query.OrderBy != "" {
+			result = compareJSONValues(value, other)
+		}
+		if result == 0 {
+			switch {
+			case seq < otherSeq:
+				result = -1
+			case seq > otherSeq:
+				result = 1
+			}
+		}
+		if query.Descending {
+			result = -result
+		}
+		return result
+	}
+
+	var candidates []*candidate
+	for _, e := range s.entities {
+		if !wantedTypes[e.entity.EntityType] {
+			continue
+		}
+
+		if e.entity.Deleted && !query.Deleted {
+			continue
+		}
+
+		matches := true
+		for i, f := range query.Filters {
+			if compareJSONValues(jsonFieldValue(e.entity.Payload, f.Field), filters[i]) != 0 {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		c := &candidate{memoryEntity: e}
+		if query.OrderBy != "" {
+			c.orderValue = jsonFieldValue(e.entity.Payload, query.OrderBy)
+		}
+
+		if cursor != nil && compare(c.orderValue, c.seq, cursorValue, cursor.Seq) <= 0 {
+			continue
+		}
+
+		candidates = append(candidates, c)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return compare(candidates[i].orderValue, candidates[i].seq, candidates[j].orderValue, candidates[j].seq) < 0
+	})
+
+	if len(candidates) > query.size() {
+		candidates = candidates[:query.size()]
+	}
+
+	if len(candidates) == 0 {
+		return []*types.CrudEntity{}, "", nil
+	}
+
+	results := make([]*types.CrudEntity, 0, len(candidates))
+	for _, c := range candidates {
+		results = append(results, c.entity)
+	}
+
+	last := candidates[len(candidates)-1]
+	nextCursor, err := encodeEntityCursor(last.seq, last.entity.Payload, query.OrderBy)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return results, nextCursor, nil
+}
+
+// SaveSnapshot replaces the snapshot of the originator unless the stored one is newer
+func (s *InMemoryStore) SaveSnapshot(snapshot *Snapshot) error {
+	if snapshot == nil || snapshot.Originator == nil {
+		return fmt.Errorf("empty snapshot")
+	}
+
+	if existing, ok := s.snapshots[snapshot.Originator.ID]; ok && existing.Originator.Version > snapshot.Originator.Version {
+		return nil
+	}
+
+	s.snapshots[snapshot.Originator.ID] = snapshot
+	return nil
+}
+
+func (s *InMemoryStore) GetSnapshot(originatorID string) (*Snapshot, error) {
+	snapshot, ok := s.snapshots[originatorID]
+	if !ok {
+		return nil, fmt.Errorf("%s : %w", originatorID, ErrSnapshotNotFound)
+	}
+	return snapshot, nil
+}
+
+func (s *InMemoryStore) Purge(originatorID string) (*types.Originator, error) {
+	events := s.eventStore[originatorID]
+	if len(events) == 0 {
+		return nil, fmt.Errorf("%s : %w", originatorID, ErrStreamNotFound)
+	}
+
+	latest := events[len(events)-1]
+	if IsPurged(latest) {
+		return nil, fmt.Errorf("%s is already purged : %w", originatorID, ErrStreamNotFound)
+	}
+
+	tombstones := map[uint64]*types.Event{}
+	purged := make([]*types.Event, 0, len(events))
+	for _, e := range events {
+		tombstone := tombstoneOf(e)
+		tombstones[e.Originator.Version] = tombstone
+		purged = append(purged, tombstone)
+	}
+	s.eventStore[originatorID] = purged
+
+	for _, entry := range s.logs {
+		if entry.Event.Originator.ID != originatorID {
+			continue
+		}
+		if tombstone, ok := tombstones[entry.Event.Originator.Version]; ok {
+			entry.Event = tombstone
+		}
+	}
+
+	delete(s.entities, originatorID)
+	delete(s.snapshots, originatorID)
+
+	purgedEvent := purgedEvent(latest, len(events))
+	if err := s.Append(purgedEvent); err != nil {
+		return nil, err
+	}
+
+	return purgedEvent.Originator, nil
+}
+
+func (s *InMemoryStore) LogHead() (uint64, error) {
+	if len(s.logs) == 0 {
+		return 0, nil
+	}
+	return s.logs[len(s.logs)-1].ID, nil
+}
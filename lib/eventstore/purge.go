@@ -0,0 +1,63 @@
+package eventstore
+
+import (
+	"errors"
+	"fmt"
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/types"
+	"time"
+)
+
+const (
+	// TombstoneEventType replaces the type of the purged events, ie. User.Created becomes User.Tombstone
+	TombstoneEventType = "Tombstone"
+	// PurgedEventType is the type of the event recording the purge of a stream, ie. User.Purged
+	PurgedEventType = "Purged"
+)
+
+var ErrStreamNotFound = errors.New("stream not found")
+
+// Purger is implemented by the stores which can erase the data of a stream for compliance.
+// Purge replaces the events of the originator and their application log entries with
+// tombstones keeping their versions and log IDs, so the consumers reading the log don't
+// break, removes the current state of the entity and appends a <EntityType>.Purged event
+// recording the erasure. It returns the originator of the Purged event.
+type Purger interface {
+	Purge(originatorID string) (*types.Originator, error)
+}
+
+// IsTombstone returns true for the events erased by Purge
+func IsTombstone(event *types.Event) bool {
+	return common.ExtractEventType(event) == TombstoneEventType
+}
+
+// IsPurged returns true for the event recording the purge of a stream
+func IsPurged(event *types.Event) bool {
+	return common.ExtractEventType(event) == PurgedEventType
+}
+
+// tombstoneOf returns the event without its payload and metadata
+func tombstoneOf(event *types.Event) *types.Event {
+	return &types.Event{
+		Originator: &types.Originator{
+			ID:      event.Originator.ID,
+			Version: event.Originator.Version,
+		},
+		EventType:  fmt.Sprintf("%s.%s", common.ExtractEntityType(event), TombstoneEventType),
+		Payload:    "{}",
+		OccurredOn: event.OccurredOn,
+	}
+}
+
+// purgedEvent returns the event recording the purge of the stream ending with latest
+func purgedEvent(latest *types.Event, purgedVersions int) *types.Event {
+	return &types.Event{
+		Originator: &types.Originator{
+			ID:      latest.Originator.ID,
+			Version: latest.Originator.Version + 1,
+		},
+		EventType:  fmt.Sprintf("%s.%s", common.ExtractEntityType(latest), PurgedEventType),
+		Payload:    fmt.Sprintf(`{"purged_versions":%d}`, purgedVersions),
+		OccurredOn: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,113 @@
+package eventstore
+
+import (
+	"os"
+	"testing"
+
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurger(tm *testing.T) {
+	sqlStore, err := NewSqlStore("sqlite3", "purge.db")
+	assert.NoError(tm, err)
+	assert.NoError(tm, sqlStore.Cleanup())
+
+	tm.Cleanup(func() {
+		if _, err := os.Stat("purge.db"); err == nil {
+			assert.NoError(tm, os.Remove("purge.db"))
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		store interface {
+			Store
+			EntityStore
+			Purger
+			SnapshotStore
+		}
+	}{
+		{"sql store", sqlStore},
+		{"inmemory store", NewInMemoryStore().(*InMemoryStore)},
+	}
+
+	for _, tc := range testCases {
+		store := tc.store
+		tm.Run(tc.name, func(t *testing.T) {
+			_, err := store.Purge("user_1")
+			assert.ErrorIs(t, err, ErrStreamNotFound)
+
+			// the underscore would match any character in a LIKE query
+			for _, id := range []string{"user_1", "userX1", "user_10"} {
+				assert.NoError(t, store.AppendWithEntity(&types.Event{
+					Originator: &types.Originator{ID: id, Version: 1},
+					EventType:  "User.Created",
+					Payload:    `{"email":"` + id + `@example.com"}`,
+				}, types.NewCrudEntity("User", &types.Originator{ID: id, Version: 1}, `{"email":"`+id+`@example.com"}`, false)))
+			}
+			assert.NoError(t, store.Append(&types.Event{
+				Originator: &types.Originator{ID: "user_1", Version: 2},
+				EventType:  "User.Updated",
+				Payload:    `{"email":"new@example.com"}`,
+				Metadata:   map[string]string{"ip": "127.0.0.1"},
+			}))
+
+			assert.NoError(t, store.SaveSnapshot(&Snapshot{
+				Originator: &types.Originator{ID: "user_1", Version: 2},
+				Payload:    `{"email":"new@example.com"}`,
+			}))
+
+			before, err := store.Logs(1, 100, "")
+			assert.NoError(t, err)
+			assert.Len(t, before, 4)
+
+			purgedOriginator, err := store.Purge("user_1")
+			assert.NoError(t, err)
+			assert.Equal(t, &types.Originator{ID: "user_1", Version: 3}, purgedOriginator)
+
+			events, err := store.Get(&types.Originator{ID: "user_1"}, false)
+			assert.NoError(t, err)
+			assert.Len(t, events, 3)
+			for _, e := range events[:2] {
+				assert.True(t, IsTombstone(e))
+				assert.Equal(t, "User.Tombstone", e.EventType)
+				assert.Equal(t, "{}", e.Payload)
+				assert.Empty(t, e.Metadata)
+			}
+			assert.Equal(t, uint64(2), events[1].Originator.Version)
+			assert.True(t, IsPurged(events[2]))
+			assert.JSONEq(t, `{"purged_versions":2}`, events[2].Payload)
+
+			// the log keeps its IDs and gets the purge appended
+			after, err := store.Logs(1, 100, "")
+			assert.NoError(t, err)
+			assert.Len(t, after, 5)
+			for i, entry := range before {
+				assert.Equal(t, entry.ID, after[i].ID)
+				if entry.Event.Originator.ID == "user_1" {
+					assert.True(t, IsTombstone(after[i].Event))
+					assert.Equal(t, entry.Event.Originator.Version, after[i].Event.Originator.Version)
+					assert.Equal(t, "{}", after[i].Event.Payload)
+				} else {
+					assert.Equal(t, entry.Event.Payload, after[i].Event.Payload)
+				}
+			}
+			assert.Equal(t, "User.Purged", after[4].Event.EventType)
+
+			entities, _, err := store.ListEntities([]string{"User"}, &EntityQuery{Deleted: true})
+			assert.NoError(t, err)
+			assert.Len(t, entities, 2)
+			for _, entity := range entities {
+				assert.NotEqual(t, "user_1", entity.Originator.ID)
+			}
+
+			// the snapshot holds the purged state as well
+			_, err = store.GetSnapshot("user_1")
+			assert.ErrorIs(t, err, ErrSnapshotNotFound)
+
+			_, err = store.Purge("user_1")
+			assert.ErrorIs(t, err, ErrStreamNotFound)
+		})
+	}
+}
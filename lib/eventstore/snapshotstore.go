@@ -0,0 +1,25 @@
+package eventstore
+
+import (
+	"errors"
+	"github.com/makkalot/eskit/lib/types"
+	"time"
+)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Snapshot is the serialized state of an originator at a version, replaying starts
+// from the events after it instead of the whole stream
+type Snapshot struct {
+	Originator *types.Originator
+	Payload    string
+	CreatedOn  time.Time
+}
+
+// SnapshotStore is implemented by the stores which can keep snapshots next to the
+// events, only the latest snapshot of an originator is kept
+type SnapshotStore interface {
+	SaveSnapshot(snapshot *Snapshot) error
+	// GetSnapshot returns ErrSnapshotNotFound when the originator has no snapshot
+	GetSnapshot(originatorID string) (*Snapshot, error)
+}
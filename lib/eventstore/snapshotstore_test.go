@@ -0,0 +1,71 @@
+package eventstore
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotStore(tm *testing.T) {
+	sqlStore, err := NewSqlStore("sqlite3", "snapshots.db")
+	assert.NoError(tm, err)
+	assert.NoError(tm, sqlStore.Cleanup())
+
+	tm.Cleanup(func() {
+		if _, err := os.Stat("snapshots.db"); err == nil {
+			assert.NoError(tm, os.Remove("snapshots.db"))
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		store SnapshotStore
+	}{
+		{"sql store", sqlStore},
+		{"inmemory store", NewInMemoryStore().(*InMemoryStore)},
+	}
+
+	for _, tc := range testCases {
+		store := tc.store
+		tm.Run(tc.name, func(t *testing.T) {
+			_, err := store.GetSnapshot("order-1")
+			assert.ErrorIs(t, err, ErrSnapshotNotFound)
+
+			assert.Error(t, store.SaveSnapshot(&Snapshot{}))
+
+			createdOn := time.Now().UTC().Truncate(time.Second)
+			assert.NoError(t, store.SaveSnapshot(&Snapshot{
+				Originator: &types.Originator{ID: "order-1", Version: 5},
+				Payload:    `{"Total":5}`,
+				CreatedOn:  createdOn,
+			}))
+
+			snapshot, err := store.GetSnapshot("order-1")
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(5), snapshot.Originator.Version)
+			assert.Equal(t, `{"Total":5}`, snapshot.Payload)
+			assert.True(t, createdOn.Equal(snapshot.CreatedOn))
+
+			assert.NoError(t, store.SaveSnapshot(&Snapshot{
+				Originator: &types.Originator{ID: "order-1", Version: 10},
+				Payload:    `{"Total":10}`,
+				CreatedOn:  createdOn,
+			}))
+
+			// older snapshots don't replace the newer ones
+			assert.NoError(t, store.SaveSnapshot(&Snapshot{
+				Originator: &types.Originator{ID: "order-1", Version: 7},
+				Payload:    `{"Total":7}`,
+				CreatedOn:  createdOn,
+			}))
+
+			snapshot, err = store.GetSnapshot("order-1")
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(10), snapshot.Originator.Version)
+			assert.Equal(t, `{"Total":10}`, snapshot.Payload)
+		})
+	}
+}
@@ -15,9 +15,11 @@ import (
 
 type StoredEvent struct {
 	OriginatorID      string `gorm:"primary_key; not null"`
-	OriginatorVersion uint   `gorm:"primary_key; not null"`
+	OriginatorVersion uint   `gorm:"primary_key; auto_increment:false; not null"`
 	EventType         string `gorm:"type:varchar(255); not null; index"`
 	Payload           string `gorm:"type:text"`
+	Metadata          string `gorm:"type:text"`
+	OccurredOn        time.Time
 	CreatedAt         time.Timer
 }
 
@@ -29,6 +31,32 @@ type StoredLogEntry struct {
 	CreatedAt     time.Timer
 }
 
+// StoredEntity is the current state of a crud entity, it's updated in the same
+// transaction as the event changing it
+type StoredEntity struct {
+	OriginatorID      string `gorm:"primary_key; not null"`
+	OriginatorVersion uint   `gorm:"not null"`
+	EntityType        string `gorm:"type:varchar(255); not null; index"`
+	Payload           string `gorm:"type:text"`
+	Deleted           bool   `gorm:"not null"`
+	// Seq is the application log ID the entity was created at
+	Seq uint64 `gorm:"type:bigint; not null; index"`
+}
+
+// StoredEntityRebuild records an entity type whose entities were rebuilt from the log
+type StoredEntityRebuild struct {
+	EntityType string `gorm:"primary_key; type:varchar(255); not null"`
+	RebuiltAt  time.Time
+}
+
+// StoredSnapshot is the latest snapshot of an originator
+type StoredSnapshot struct {
+	OriginatorID      string `gorm:"primary_key; not null"`
+	OriginatorVersion uint   `gorm:"not null"`
+	Payload           string `gorm:"type:text"`
+	CreatedOn         time.Time
+}
+
 type SqlStore struct {
 	db    *gorm.DB
 	dbURI string
@@ -58,6 +86,18 @@ func NewSqlStore(dialect string, dbURI string) (*SqlStore, error) {
 		return nil, fmt.Errorf("migrate log_entries : %v", result.Error)
 	}
 
+	if result := db.AutoMigrate(&StoredEntity{}); result.Error != nil {
+		return nil, fmt.Errorf("migrate stored_entities : %v", result.Error)
+	}
+
+	if result := db.AutoMigrate(&StoredSnapshot{}); result.Error != nil {
+		return nil, fmt.Errorf("migrate stored_snapshots : %v", result.Error)
+	}
+
+	if result := db.AutoMigrate(&StoredEntityRebuild{}); result.Error != nil {
+		return nil, fmt.Errorf("migrate stored_entity_rebuilds : %v", result.Error)
+	}
+
 	return &SqlStore{
 		db:    db,
 		dbURI: dbURI,
@@ -73,6 +113,18 @@ func (estore *SqlStore) Cleanup() error {
 		return fmt.Errorf("drop table failed : %v", result.Error)
 	}
 
+	if result := estore.db.DropTableIfExists(&StoredEntity{}); result.Error != nil {
+		return fmt.Errorf("drop table failed : %v", result.Error)
+	}
+
+	if result := estore.db.DropTableIfExists(&StoredSnapshot{}); result.Error != nil {
+		return fmt.Errorf("drop table failed : %v", result.Error)
+	}
+
+	if result := estore.db.DropTableIfExists(&StoredEntityRebuild{}); result.Error != nil {
+		return fmt.Errorf("drop table failed : %v", result.Error)
+	}
+
 	if result := estore.db.AutoMigrate(&StoredEvent{}); result.Error != nil {
 		return fmt.Errorf("migrate stored_events : %v", result.Error)
 	}
@@ -81,28 +133,67 @@ func (estore *SqlStore) Cleanup() error {
 		return fmt.Errorf("migrate log_entries : %v", result.Error)
 	}
 
+	if result := estore.db.AutoMigrate(&StoredEntity{}); result.Error != nil {
+		return fmt.Errorf("migrate stored_entities : %v", result.Error)
+	}
+
+	if result := estore.db.AutoMigrate(&StoredSnapshot{}); result.Error != nil {
+		return fmt.Errorf("migrate stored_snapshots : %v", result.Error)
+	}
+
+	if result := estore.db.AutoMigrate(&StoredEntityRebuild{}); result.Error != nil {
+		return fmt.Errorf("migrate stored_entity_rebuilds : %v", result.Error)
+	}
+
 	return nil
 }
 
 func (estore *SqlStore) Append(event *types.Event) error {
-	storedEvent := &StoredEvent{
-		OriginatorID:      event.Originator.ID,
-		OriginatorVersion: uint(event.Originator.Version),
-		EventType:         event.EventType,
-		Payload:           event.Payload,
-	}
+	return estore.AppendWithEntity(event, nil)
+}
 
-	//log.Println("stored event : ", spew.Sdump(storedEvent))
+// AppendWithEntity appends the event and when entity is not nil saves it as the
+// current state of the originator in the same transaction
+func (estore *SqlStore) AppendWithEntity(event *types.Event, entity *types.CrudEntity) error {
+	tx := estore.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
 
-	entityType := common.ExtractEntityType(event)
-	jsonEvent, err := json.Marshal(event)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	storedLogEntry, err := estore.appendTx(tx, event)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	storedLogEntry := &StoredLogEntry{
-		PartitionID:  entityType,
-		EventPayload: string(jsonEvent),
+	if entity != nil {
+		if err := estore.saveEntityTx(tx, entity, storedLogEntry.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	estore.observeAppend(storedLogEntry)
+	return nil
+}
+
+// AppendBatch inserts the entries and their log entries in a single transaction, when one
+// of them fails the transaction is rolled back and a BatchError with its index is returned
+func (estore *SqlStore) AppendBatch(entries []*BatchEntry) error {
+	for i, entry := range entries {
+		if err := entry.validate(); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
 	}
 
 	tx := estore.db.Begin()
@@ -113,33 +204,91 @@ func (estore *SqlStore) Append(event *types.Event) error {
 	}()
 
 	if tx.Error != nil {
+		return tx.Error
+	}
+
+	storedLogEntries := make([]*StoredLogEntry, 0, len(entries))
+	for i, entry := range entries {
+		storedLogEntry, err := estore.appendTx(tx, entry.Event)
+		if err != nil {
+			tx.Rollback()
+			return &BatchError{Index: i, Err: err}
+		}
+
+		if entry.Entity != nil {
+			if err := estore.saveEntityTx(tx, entry.Entity, storedLogEntry.ID); err != nil {
+				tx.Rollback()
+				return &BatchError{Index: i, Err: err}
+			}
+		}
+
+		storedLogEntries = append(storedLogEntries, storedLogEntry)
+	}
+
+	if err := tx.Commit().Error; err != nil {
 		return err
 	}
 
+	for _, storedLogEntry := range storedLogEntries {
+		estore.observeAppend(storedLogEntry)
+	}
+	return nil
+}
+
+// appendTx inserts the event and its log entry inside of tx
+func (estore *SqlStore) appendTx(tx *gorm.DB, event *types.Event) (*StoredLogEntry, error) {
+	storedEvent := &StoredEvent{
+		OriginatorID:      event.Originator.ID,
+		OriginatorVersion: uint(event.Originator.Version),
+		EventType:         event.EventType,
+		Payload:           event.Payload,
+		OccurredOn:        event.OccurredOn,
+	}
+
+	if len(event.Metadata) > 0 {
+		metadata, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		storedEvent.Metadata = string(metadata)
+	}
+
+	//log.Println("stored event : ", spew.Sdump(storedEvent))
+
+	entityType := common.ExtractEntityType(event)
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	storedLogEntry := &StoredLogEntry{
+		PartitionID:  entityType,
+		EventPayload: string(jsonEvent),
+	}
+
 	if err := tx.Create(storedEvent).Error; err != nil {
-		tx.Rollback()
 		if strings.Contains(strings.ToLower(err.Error()), "unique") {
-			return fmt.Errorf("stored_event: %w", ErrDuplicate)
+			return nil, fmt.Errorf("stored_event: %w", ErrDuplicate)
 		}
-		return fmt.Errorf("inserting stored event : %v", err)
+		return nil, fmt.Errorf("inserting stored event : %v", err)
 	}
 
-	result := tx.Create(storedLogEntry)
-	if err := result.Error; err != nil {
-		tx.Rollback()
+	if err := tx.Create(storedLogEntry).Error; err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "unique") {
-			return fmt.Errorf("stored_log_entry: %w", ErrDuplicate)
+			return nil, fmt.Errorf("stored_log_entry: %w", ErrDuplicate)
 		}
-		return fmt.Errorf("inserting stored log entry: %v", err)
-	} else {
-		g := lastStreamID.With(prometheus.Labels{"application_id": storedLogEntry.ApplicationID, "partition_id": storedLogEntry.PartitionID})
-		g.Set(float64(storedLogEntry.ID))
-
-		c := streamCounter.With(prometheus.Labels{"application_id": storedLogEntry.ApplicationID, "partition_id": storedLogEntry.PartitionID})
-		c.Inc()
+		return nil, fmt.Errorf("inserting stored log entry: %v", err)
 	}
 
-	return tx.Commit().Error
+	return storedLogEntry, nil
+}
+
+func (estore *SqlStore) observeAppend(storedLogEntry *StoredLogEntry) {
+	g := lastStreamID.With(prometheus.Labels{"application_id": storedLogEntry.ApplicationID, "partition_id": storedLogEntry.PartitionID})
+	g.Set(float64(storedLogEntry.ID))
+
+	c := streamCounter.With(prometheus.Labels{"application_id": storedLogEntry.ApplicationID, "partition_id": storedLogEntry.PartitionID})
+	c.Inc()
 }
 
 func (estore *SqlStore) Get(originator *types.Originator, fromVersion bool) ([]*types.Event, error) {
@@ -160,19 +309,77 @@ func (estore *SqlStore) Get(originator *types.Originator, fromVersion bool) ([]*
 
 	var events []*types.Event
 	for _, es := range storedEvents {
-		events = append(events, &types.Event{
-			Originator: &types.Originator{
-				ID:      es.OriginatorID,
-				Version: uint64(es.OriginatorVersion),
-			},
-			EventType: es.EventType,
-			Payload:   es.Payload,
-		})
+		event, err := es.toEvent()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
 	}
 
 	return events, nil
 }
 
+// getManyChunkSize keeps the IN queries of GetMany below the bind parameter limits
+const getManyChunkSize = 500
+
+func (estore *SqlStore) GetMany(originatorIDs []string) (map[string][]*types.Event, error) {
+	results := map[string][]*types.Event{}
+	for start := 0; start < len(originatorIDs); start += getManyChunkSize {
+		end := start + getManyChunkSize
+		if end > len(originatorIDs) {
+			end = len(originatorIDs)
+		}
+
+		storedEvents := []*StoredEvent{}
+		if err := estore.db.Where("originator_id IN (?)", originatorIDs[start:end]).
+			Order("originator_id").
+			Order("originator_version").
+			Find(&storedEvents).Error; err != nil {
+			return nil, fmt.Errorf("fetch : %v", err)
+		}
+
+		for _, es := range storedEvents {
+			event, err := es.toEvent()
+			if err != nil {
+				return nil, err
+			}
+			results[es.OriginatorID] = append(results[es.OriginatorID], event)
+		}
+	}
+
+	return results, nil
+}
+
+func (es *StoredEvent) toEvent() (*types.Event, error) {
+	event := &types.Event{
+		Originator: &types.Originator{
+			ID:      es.OriginatorID,
+			Version: uint64(es.OriginatorVersion),
+		},
+		EventType:  es.EventType,
+		Payload:    es.Payload,
+		OccurredOn: es.OccurredOn.UTC(),
+	}
+
+	if es.Metadata != "" {
+		if err := json.Unmarshal([]byte(es.Metadata), &event.Metadata); err != nil {
+			return nil, fmt.Errorf("unmarshall metadata : %v", err)
+		}
+	}
+
+	return event, nil
+}
+
+func (estore *SqlStore) LogHead() (uint64, error) {
+	var head struct {
+		ID uint64
+	}
+	if result := estore.db.Model(&StoredLogEntry{}).Select("COALESCE(MAX(id), 0) AS id").Scan(&head); result.Error != nil {
+		return 0, fmt.Errorf("fetching log head : %v", result.Error)
+	}
+	return head.ID, nil
+}
+
 func (estore *SqlStore) Logs(fromID uint64, size uint32, pipelineID string) ([]*types.AppLogEntry, error) {
 	storedLogs := []*StoredLogEntry{}
 	q := estore.db.Where("id >= ?", uint64(fromID))
@@ -181,7 +388,7 @@ func (estore *SqlStore) Logs(fromID uint64, size uint32, pipelineID string) ([]*
 	}
 
 	if pipelineID != "" {
-		q = estore.db.Where("partition_id = ?", pipelineID)
+		q = q.Where("partition_id = ?", pipelineID)
 	}
 
 	results := q.Order("id").Limit(size).Find(&storedLogs)
@@ -203,3 +410,369 @@ func (estore *SqlStore) Logs(fromID uint64, size uint32, pipelineID string) ([]*
 
 	return logs, nil
 }
+
+func (estore *SqlStore) SaveEntity(entity *types.CrudEntity, seq uint64) error {
+	if entity == nil || entity.Originator == nil {
+		return fmt.Errorf("empty entity")
+	}
+
+	return estore.saveEntityTx(estore.db, entity, seq)
+}
+
+// saveEntityTx upserts the entity, seq is only used when the entity is created
+func (estore *SqlStore) saveEntityTx(tx *gorm.DB, entity *types.CrudEntity, seq uint64) error {
+	existing := &StoredEntity{}
+	result := tx.Where("originator_id = ?", entity.Originator.ID).First(existing)
+	if result.Error != nil {
+		if !result.RecordNotFound() {
+			return fmt.Errorf("fetching stored entity : %v", result.Error)
+		}
+
+		if err := tx.Create(&StoredEntity{
+			OriginatorID:      entity.Originator.ID,
+			OriginatorVersion: uint(entity.Originator.Version),
+			EntityType:        entity.EntityType,
+			Payload:           entity.Payload,
+			Deleted:           entity.Deleted,
+			Seq:               seq,
+		}).Error; err != nil {
+			return fmt.Errorf("inserting stored entity : %v", err)
+		}
+		return nil
+	}
+
+	// using a map so the zero values like deleted=false are updated as well, a rebuild
+	// running next to the appends doesn't move an entity back to an older version
+	if err := tx.Model(&StoredEntity{}).Where("originator_id = ? AND originator_version <= ?", entity.Originator.ID, uint(entity.Originator.Version)).Updates(map[string]interface{}{
+		"originator_version": uint(entity.Originator.Version),
+		"entity_type":        entity.EntityType,
+		"payload":            entity.Payload,
+		"deleted":            entity.Deleted,
+	}).Error; err != nil {
+		return fmt.Errorf("updating stored entity : %v", err)
+	}
+
+	return nil
+}
+
+func (estore *SqlStore) EntitiesRebuilt(entityType string) (bool, error) {
+	var rebuilds []*StoredEntityRebuild
+	if err := estore.db.Where("entity_type = ?", entityType).Find(&rebuilds).Error; err != nil {
+		return false, fmt.Errorf("fetching entity rebuild : %v", err)
+	}
+	return len(rebuilds) > 0, nil
+}
+
+func (estore *SqlStore) MarkEntitiesRebuilt(entityType string) error {
+	if err := estore.db.Save(&StoredEntityRebuild{EntityType: entityType, RebuiltAt: time.Now().UTC()}).Error; err != nil {
+		return fmt.Errorf("saving entity rebuild : %v", err)
+	}
+	return nil
+}
+
+func (estore *SqlStore) ListEntities(entityTypes []string, query *EntityQuery) ([]*types.CrudEntity, string, error) {
+	if query == nil {
+		query = &EntityQuery{}
+	}
+
+	if err := query.validate(); err != nil {
+		return nil, "", err
+	}
+
+	cursor, err := decodeEntityCursor(query.Cursor, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	q := estore.db.Where("entity_type IN (?)", entityTypes)
+	if !query.Deleted {
+		q = q.Where("deleted = ?", false)
+	}
+
+	for _, f := range query.Filters {
+		if f.Value == nil {
+			q = q.Where(estore.jsonFieldExpr(f.Field) + " IS NULL")
+			continue
+		}
+
+		placeholder, arg, err := estore.jsonFieldArg(f.Value)
+		if err != nil {
+			return nil, "", fmt.Errorf("filter %s : %w", f.Field, ErrInvalidQuery)
+		}
+		q = q.Where(estore.jsonFieldExpr(f.Field)+" = "+placeholder, arg)
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if query.OrderBy == "" {
+		if cursor != nil {
+			q = q.Where("seq "+comparison+" ?", cursor.Seq)
+		}
+		q = q.Order("seq " + direction)
+	} else {
+		expr := estore.jsonFieldExpr(query.OrderBy)
+		if cursor != nil {
+			var value interface{}
+			if err := json.Unmarshal(cursor.Value, &value); err != nil {
+				return nil, "", fmt.Errorf("cursor : %w", ErrInvalidQuery)
+			}
+
+			placeholder, arg, err := estore.jsonFieldArg(value)
+			if err != nil {
+				return nil, "", fmt.Errorf("cursor : %w", ErrInvalidQuery)
+			}
+
+			q = q.Where(fmt.Sprintf("(%s %s %s OR (%s = %s AND seq %s ?))", expr, comparison, placeholder, expr, placeholder, comparison),
+				arg, arg, cursor.Seq)
+		}
+		q = q.Order(expr + " " + direction).Order("seq " + direction)
+	}
+
+	storedEntities := []*StoredEntity{}
+	if err := q.Limit(query.size()).Find(&storedEntities).Error; err != nil {
+		return nil, "", fmt.Errorf("fetch entities : %v", err)
+	}
+
+	if len(storedEntities) == 0 {
+		return []*types.CrudEntity{}, "", nil
+	}
+
+	results := make([]*types.CrudEntity, 0, len(storedEntities))
+	for _, se := range storedEntities {
+		results = append(results, &types.CrudEntity{
+			EntityType: se.EntityType,
+			Originator: &types.Originator{
+				ID:      se.OriginatorID,
+				Version: uint64(se.OriginatorVersion),
+			},
+			Payload: se.Payload,
+			Deleted: se.Deleted,
+		})
+	}
+
+	last := storedEntities[len(storedEntities)-1]
+	nextCursor, err := encodeEntityCursor(last.Seq, last.Payload, query.OrderBy)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return results, nextCursor, nil
+}
+
+// jsonFieldExpr returns the sql expression extracting the dotted path from the payload,
+// the path is validated by EntityQuery.validate before
+func (estore *SqlStore) jsonFieldExpr(path string) string {
+	if estore.db.Dialect().GetName() == "postgres" {
+		return fmt.Sprintf("(payload::jsonb #> '{%s}')", strings.ReplaceAll(path, ".", ","))
+	}
+	return fmt.Sprintf("json_extract(payload, '$.%s')", path)
+}
+
+// jsonFieldArg returns the placeholder and the argument to compare with jsonFieldExpr,
+// postgres compares jsonb values while sqlite compares the native ones
+func (estore *SqlStore) jsonFieldArg(value interface{}) (string, interface{}, error) {
+	if estore.db.Dialect().GetName() == "postgres" {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", nil, err
+		}
+		return "?::jsonb", string(data), nil
+	}
+
+	normalized, err := normalizeJSONValue(value)
+	if err != nil {
+		return "", nil, err
+	}
+	return "?", normalized, nil
+}
+
+// SaveSnapshot replaces the snapshot of the originator unless the stored one is newer
+func (estore *SqlStore) SaveSnapshot(snapshot *Snapshot) error {
+	if snapshot == nil || snapshot.Originator == nil {
+		return fmt.Errorf("empty snapshot")
+	}
+
+	existing := &StoredSnapshot{}
+	result := estore.db.Where("originator_id = ?", snapshot.Originator.ID).First(existing)
+	if result.Error != nil {
+		if !result.RecordNotFound() {
+			return fmt.Errorf("fetching stored snapshot : %v", result.Error)
+		}
+
+		if err := estore.db.Create(&StoredSnapshot{
+			OriginatorID:      snapshot.Originator.ID,
+			OriginatorVersion: uint(snapshot.Originator.Version),
+			Payload:           snapshot.Payload,
+			CreatedOn:         snapshot.CreatedOn,
+		}).Error; err != nil {
+			return fmt.Errorf("inserting stored snapshot : %v", err)
+		}
+		return nil
+	}
+
+	if existing.OriginatorVersion > uint(snapshot.Originator.Version) {
+		return nil
+	}
+
+	if err := estore.db.Model(&StoredSnapshot{}).Where("originator_id = ?", snapshot.Originator.ID).Updates(map[string]interface{}{
+		"originator_version": uint(snapshot.Originator.Version),
+		"payload":            snapshot.Payload,
+		"created_on":         snapshot.CreatedOn,
+	}).Error; err != nil {
+		return fmt.Errorf("updating stored snapshot : %v", err)
+	}
+
+	return nil
+}
+
+func (estore *SqlStore) GetSnapshot(originatorID string) (*Snapshot, error) {
+	stored := &StoredSnapshot{}
+	result := estore.db.Where("originator_id = ?", originatorID).First(stored)
+	if result.Error != nil {
+		if result.RecordNotFound() {
+			return nil, fmt.Errorf("%s : %w", originatorID, ErrSnapshotNotFound)
+		}
+		return nil, fmt.Errorf("fetching stored snapshot : %v", result.Error)
+	}
+
+	return &Snapshot{
+		Originator: &types.Originator{
+			ID:      stored.OriginatorID,
+			Version: uint64(stored.OriginatorVersion),
+		},
+		Payload:   stored.Payload,
+		CreatedOn: stored.CreatedOn.UTC(),
+	}, nil
+}
+
+func (estore *SqlStore) Purge(originatorID string) (*types.Originator, error) {
+	tx := estore.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	purgedEvent, storedLogEntry, err := estore.purgeTx(tx, originatorID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	estore.observeAppend(storedLogEntry)
+	return purgedEvent.Originator, nil
+}
+
+func (estore *SqlStore) purgeTx(tx *gorm.DB, originatorID string) (*types.Event, *StoredLogEntry, error) {
+	storedEvents := []*StoredEvent{}
+	if err := tx.Where("originator_id = ?", originatorID).Order("originator_version").Find(&storedEvents).Error; err != nil {
+		return nil, nil, fmt.Errorf("fetch : %v", err)
+	}
+
+	if len(storedEvents) == 0 {
+		return nil, nil, fmt.Errorf("%s : %w", originatorID, ErrStreamNotFound)
+	}
+
+	latestStored := storedEvents[len(storedEvents)-1]
+	latest := &types.Event{
+		Originator: &types.Originator{ID: originatorID, Version: uint64(latestStored.OriginatorVersion)},
+		EventType:  latestStored.EventType,
+	}
+	if IsPurged(latest) {
+		return nil, nil, fmt.Errorf("%s is already purged : %w", originatorID, ErrStreamNotFound)
+	}
+
+	partitions := map[string]bool{}
+	for _, es := range storedEvents {
+		tombstone := tombstoneOf(&types.Event{
+			Originator: &types.Originator{ID: es.OriginatorID, Version: uint64(es.OriginatorVersion)},
+			EventType:  es.EventType,
+		})
+		partitions[common.ExtractEntityType(tombstone)] = true
+
+		if err := tx.Model(&StoredEvent{}).
+			Where("originator_id = ? AND originator_version = ?", es.OriginatorID, es.OriginatorVersion).
+			Updates(map[string]interface{}{
+				"event_type": tombstone.EventType,
+				"payload":    tombstone.Payload,
+				"metadata":   "",
+			}).Error; err != nil {
+			return nil, nil, fmt.Errorf("purging stored event : %v", err)
+		}
+	}
+
+	if err := estore.purgeLogsTx(tx, originatorID, partitions); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Where("originator_id = ?", originatorID).Delete(&StoredEntity{}).Error; err != nil {
+		return nil, nil, fmt.Errorf("purging stored entity : %v", err)
+	}
+
+	if err := tx.Where("originator_id = ?", originatorID).Delete(&StoredSnapshot{}).Error; err != nil {
+		return nil, nil, fmt.Errorf("purging stored snapshot : %v", err)
+	}
+
+	purged := purgedEvent(latest, len(storedEvents))
+	storedLogEntry, err := estore.appendTx(tx, purged)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return purged, storedLogEntry, nil
+}
+
+// purgeLogsTx replaces the log entries of the originator with tombstones, the log doesn't
+// index the originators so the entries are searched by the prefix of their JSON payload
+func (estore *SqlStore) purgeLogsTx(tx *gorm.DB, originatorID string, partitions map[string]bool) error {
+	idJSON, err := json.Marshal(originatorID)
+	if err != nil {
+		return err
+	}
+
+	var partitionIDs []string
+	for partition := range partitions {
+		partitionIDs = append(partitionIDs, partition)
+	}
+
+	likeEscaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	prefix := likeEscaper.Replace(fmt.Sprintf(`{"originator":{"id":%s,`, idJSON)) + "%"
+
+	storedLogs := []*StoredLogEntry{}
+	if err := tx.Where("partition_id IN (?) AND event_payload LIKE ? ESCAPE '\\'", partitionIDs, prefix).Find(&storedLogs).Error; err != nil {
+		return fmt.Errorf("fetch logs : %v", err)
+	}
+
+	for _, sl := range storedLogs {
+		event := &types.Event{}
+		if err := json.Unmarshal([]byte(sl.EventPayload), event); err != nil {
+			return fmt.Errorf("unmarshall : %v", err)
+		}
+
+		if event.Originator == nil || event.Originator.ID != originatorID {
+			continue
+		}
+
+		tombstone, err := json.Marshal(tombstoneOf(event))
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&StoredLogEntry{}).Where("id = ?", sl.ID).Update("event_payload", string(tombstone)).Error; err != nil {
+			return fmt.Errorf("purging stored log entry : %v", err)
+		}
+	}
+
+	return nil
+}
@@ -32,6 +32,9 @@ var ErrDuplicate = errors.New("duplicate")
 type Store interface {
 	Append(event *types.Event) error
 	Get(originator *types.Originator, fromVersion bool) ([]*types.Event, error)
+	// GetMany returns the full streams of the originators grouped by their IDs, the
+	// originators without events are left out
+	GetMany(originatorIDs []string) (map[string][]*types.Event, error)
 	Logs(fromID uint64, size uint32, pipelineID string) ([]*types.AppLogEntry, error)
 }
 
@@ -1,6 +1,7 @@
 package eventstore
 
 import (
+	"fmt"
 	"github.com/makkalot/eskit/lib/types"
 	"github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
@@ -63,6 +64,7 @@ func TestSqlStore(tm *testing.T) {
 				EventType:  "Project.Created",
 				Payload:    "{}",
 				OccurredOn: time.Now().UTC(),
+				Metadata:   map[string]string{"user": "makkalot"},
 			}
 
 			err = currentStore.Append(e1)
@@ -78,6 +80,8 @@ func TestSqlStore(tm *testing.T) {
 			assert.Equal(t, e1.Originator.Version, events[0].Originator.Version)
 			assert.Equal(t, e1.EventType, events[0].EventType)
 			assert.Equal(t, e1.Payload, events[0].Payload)
+			assert.Equal(t, e1.Metadata, events[0].Metadata)
+			assert.WithinDuration(t, e1.OccurredOn, events[0].OccurredOn, time.Millisecond)
 
 			logs, err = currentStore.Logs(0, 20, "")
 			assert.NoError(t, err)
@@ -208,3 +212,110 @@ func TestSqlStore(tm *testing.T) {
 		})
 	}
 }
+
+func TestGetMany(tm *testing.T) {
+	sqlStore, err := NewSqlStore("sqlite3", "getmany.db")
+	assert.NoError(tm, err)
+	assert.NoError(tm, sqlStore.Cleanup())
+
+	tm.Cleanup(func() {
+		if _, err := os.Stat("getmany.db"); err == nil {
+			assert.NoError(tm, os.Remove("getmany.db"))
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		store Store
+	}{
+		{"sql store", sqlStore},
+		{"inmemory store", NewInMemoryStore()},
+	}
+
+	for _, tc := range testCases {
+		store := tc.store
+		tm.Run(tc.name, func(t *testing.T) {
+			streams, err := store.GetMany(nil)
+			assert.NoError(t, err)
+			assert.Empty(t, streams)
+
+			// more originators than a single query fetches
+			var ids []string
+			for i := 0; i < getManyChunkSize+10; i++ {
+				id := fmt.Sprintf("user-%d", i)
+				ids = append(ids, id)
+				assert.NoError(t, store.Append(&types.Event{
+					Originator: &types.Originator{ID: id, Version: 1},
+					EventType:  "User.Created",
+					Payload:    `{}`,
+					OccurredOn: time.Now().UTC(),
+				}))
+			}
+
+			assert.NoError(t, store.Append(&types.Event{
+				Originator: &types.Originator{ID: "user-0", Version: 2},
+				EventType:  "User.Updated",
+				Payload:    `{"name":"second"}`,
+				Metadata:   map[string]string{"ip": "127.0.0.1"},
+				OccurredOn: time.Now().UTC(),
+			}))
+
+			streams, err = store.GetMany(append(ids, "missing"))
+			assert.NoError(t, err)
+			assert.Len(t, streams, len(ids))
+
+			assert.Len(t, streams["user-0"], 2)
+			assert.Equal(t, uint64(1), streams["user-0"][0].Originator.Version)
+			assert.Equal(t, uint64(2), streams["user-0"][1].Originator.Version)
+			assert.Equal(t, "127.0.0.1", streams["user-0"][1].Metadata["ip"])
+			assert.Len(t, streams[ids[len(ids)-1]], 1)
+
+			_, ok := streams["missing"]
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestLogHead(tm *testing.T) {
+	sqlStore, err := NewSqlStore("sqlite3", "loghead.db")
+	assert.NoError(tm, err)
+	assert.NoError(tm, sqlStore.Cleanup())
+
+	tm.Cleanup(func() {
+		if _, err := os.Stat("loghead.db"); err == nil {
+			assert.NoError(tm, os.Remove("loghead.db"))
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		store Store
+	}{
+		{"sql store", sqlStore},
+		{"inmemory store", NewInMemoryStore()},
+		{"paged store", struct{ Store }{NewInMemoryStore()}},
+	}
+
+	for _, tc := range testCases {
+		store := tc.store
+		tm.Run(tc.name, func(t *testing.T) {
+			head, err := LogHead(store)
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(0), head)
+
+			for i := 1; i <= 3; i++ {
+				err := store.Append(&types.Event{
+					Originator: &types.Originator{ID: fmt.Sprintf("head-%d", i), Version: 1},
+					EventType:  "Project.Created",
+					Payload:    "{}",
+					OccurredOn: time.Now().UTC(),
+				})
+				assert.NoError(t, err)
+			}
+
+			head, err = LogHead(store)
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(3), head)
+		})
+	}
+}
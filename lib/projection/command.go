@@ -0,0 +1,72 @@
+package projection
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/makkalot/eskit/lib/eventstore"
+)
+
+const commandUsage = `usage:
+  list             prints the projections with their offsets and the log head
+  rebuild <name>   rebuilds the projection into shadow tables and swaps them when caught up`
+
+// RunCommand runs a projection management command with args, it's meant to be embedded
+// in the binaries which define the projections since the handlers live in their code, ie:
+//
+//	if len(os.Args) > 1 && os.Args[1] == "projections" {
+//	    if err := projection.RunCommand(ctx, os.Args[2:], os.Stdout, invoices, customers); err != nil {
+//	        log.Fatal(err)
+//	    }
+//	    return
+//	}
+func RunCommand(ctx context.Context, args []string, out io.Writer, projections ...*SQLProjection) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", commandUsage)
+	}
+
+	byName := map[string]*SQLProjection{}
+	for _, p := range projections {
+		byName[p.Name()] = p
+	}
+
+	switch args[0] {
+	case "list":
+		for _, p := range projections {
+			offset, err := p.Offset()
+			if err != nil {
+				return err
+			}
+
+			head, err := eventstore.LogHead(p.storeClient)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(out, "%s\toffset %d\thead %d\trebuildable %t\n", p.Name(), offset, head, len(p.models) > 0)
+		}
+		return nil
+
+	case "rebuild":
+		if len(args) != 2 {
+			return fmt.Errorf("missing projection name\n%s", commandUsage)
+		}
+
+		p, ok := byName[args[1]]
+		if !ok {
+			return fmt.Errorf("unknown projection %s", args[1])
+		}
+
+		return p.Rebuild(ctx, func(progress RebuildProgress) {
+			if progress.Swapped {
+				fmt.Fprintf(out, "%s rebuilt up to %d and swapped\n", progress.Projection, progress.Offset)
+				return
+			}
+			fmt.Fprintf(out, "%s %d/%d (%.1f%%)\n", progress.Projection, progress.Offset, progress.Head, progress.Percent())
+		})
+
+	default:
+		return fmt.Errorf("unknown command %s\n%s", args[0], commandUsage)
+	}
+}
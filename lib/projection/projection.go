@@ -0,0 +1,254 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// DefaultBatchSize is the number of log entries applied in a transaction
+	DefaultBatchSize = 10
+)
+
+var (
+	// ErrConcurrentUpdate is returned when another runner of the same projection moved
+	// the offset, the transaction is rolled back so no entry is applied twice
+	ErrConcurrentUpdate = errors.New("projection offset was updated concurrently")
+
+	projectionOffset = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eskit_projections_offset",
+			Help: "Last log entry applied to the projection",
+		}, []string{
+			"projection",
+		})
+
+	projectionAppliedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "eskit_projections_applied_count",
+			Help: "Number of log entries applied to the projection",
+		}, []string{
+			"projection",
+		})
+)
+
+// ProjectionOffset is the last log entry applied to a projection, it lives in the
+// database of the projection so it's updated in the same transaction as the read model
+type ProjectionOffset struct {
+	Name   string `gorm:"primary_key"`
+	LastID uint64 `gorm:"type:bigint; not null"`
+}
+
+// Handler applies the entry to the read model with tx, tx.CommonDB() returns the
+// underlying *sql.Tx for the handlers which don't use gorm
+type Handler func(tx *gorm.DB, entry *types.AppLogEntry) error
+
+// TargetHandler is the Handler of a rebuildable projection, it should write to the
+// tables returned by target.Table so the projection can be rebuilt into shadow tables
+type TargetHandler func(tx *gorm.DB, target Target, entry *types.AppLogEntry) error
+
+// Target resolves the table names of a projection, the live tables or their shadows
+type Target struct {
+	suffix string
+}
+
+// Table returns the name of the table in the target
+func (t Target) Table(name string) string {
+	return name + t.suffix
+}
+
+// SQLProjection keeps a read model in a SQL database up to date with the application log.
+// The changes of the handler and the offset are committed in the same transaction, so
+// after a crash the entries are applied effectively once.
+type SQLProjection struct {
+	name        string
+	selector    string
+	storeClient eventstore.Store
+	db          *gorm.DB
+	handler     TargetHandler
+	target      Target
+	// models are the gorm models of the tables, only the projections with models can be rebuilt
+	models    []interface{}
+	batchSize int
+}
+
+// NewSQLProjection creates the projection name, the entries matching selector are passed
+// to handler with a transaction of db
+func NewSQLProjection(storeClient eventstore.Store, db *gorm.DB, name string, selector string, handler Handler) (*SQLProjection, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("missing handler : %w", crudstore.InvalidArgumentError)
+	}
+
+	return NewSQLProjectionWithModels(storeClient, db, name, selector, nil, func(tx *gorm.DB, target Target, entry *types.AppLogEntry) error {
+		return handler(tx, entry)
+	})
+}
+
+// NewSQLProjectionWithModels creates a projection which can be rebuilt, the tables of
+// models are migrated and handler should access them through its target
+func NewSQLProjectionWithModels(storeClient eventstore.Store, db *gorm.DB, name string, selector string, models []interface{}, handler TargetHandler) (*SQLProjection, error) {
+	if name == "" || db == nil || handler == nil {
+		return nil, fmt.Errorf("missing name, db or handler : %w", crudstore.InvalidArgumentError)
+	}
+
+	if result := db.AutoMigrate(&ProjectionOffset{}); result.Error != nil {
+		return nil, fmt.Errorf("migrate projection_offsets : %v", result.Error)
+	}
+
+	if result := db.Where(ProjectionOffset{Name: name}).FirstOrCreate(&ProjectionOffset{}); result.Error != nil {
+		return nil, fmt.Errorf("creating offset of %s : %v", name, result.Error)
+	}
+
+	p := &SQLProjection{
+		name:        name,
+		selector:    selector,
+		storeClient: storeClient,
+		db:          db,
+		handler:     handler,
+		models:      models,
+		batchSize:   DefaultBatchSize,
+	}
+
+	if err := p.migrate(db); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// WithBatchSize sets the number of log entries applied in a transaction
+func (p *SQLProjection) WithBatchSize(size int) *SQLProjection {
+	if size > 0 {
+		p.batchSize = size
+	}
+	return p
+}
+
+// Name returns the name of the projection
+func (p *SQLProjection) Name() string {
+	return p.name
+}
+
+// Tables returns the names of the live tables of the projection
+func (p *SQLProjection) Tables() []string {
+	var tables []string
+	for _, model := range p.models {
+		tables = append(tables, p.db.NewScope(model).TableName())
+	}
+	return tables
+}
+
+// migrate creates the tables of the target
+func (p *SQLProjection) migrate(db *gorm.DB) error {
+	for i, table := range p.Tables() {
+		if result := db.Table(p.target.Table(table)).AutoMigrate(p.models[i]); result.Error != nil {
+			return fmt.Errorf("migrate %s : %v", p.target.Table(table), result.Error)
+		}
+	}
+	return nil
+}
+
+// Offset returns the last log entry applied to the projection
+func (p *SQLProjection) Offset() (uint64, error) {
+	offset := &ProjectionOffset{}
+	if result := p.db.Where("name = ?", p.name).First(offset); result.Error != nil {
+		if result.RecordNotFound() {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("fetching offset of %s : %v", p.name, result.Error)
+	}
+	return offset.LastID, nil
+}
+
+// Run applies the new log entries until ctx is done, it returns the first error of
+// the handler after rolling back its transaction. When the offset was moved by another
+// runner or a rebuild the batch is rolled back and the runner continues from the new offset.
+func (p *SQLProjection) Run(ctx context.Context) error {
+	offset, err := p.Offset()
+	if err != nil {
+		return err
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		results, err := p.storeClient.Logs(offset+1, uint32(p.batchSize), "")
+		if err != nil {
+			return fmt.Errorf("fetch logs : %w", err)
+		}
+
+		if len(results) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Millisecond * 100):
+			}
+			continue
+		}
+
+		next, err := p.apply(offset, results)
+		if errors.Is(err, ErrConcurrentUpdate) {
+			if offset, err = p.Offset(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		offset = next
+	}
+}
+
+// apply runs the handler for the entries and moves the offset from the last applied one
+// in a single transaction, it returns the new offset
+func (p *SQLProjection) apply(from uint64, entries []*types.AppLogEntry) (uint64, error) {
+	tx := p.db.Begin()
+	if tx.Error != nil {
+		return from, fmt.Errorf("starting transaction : %v", tx.Error)
+	}
+
+	applied := 0
+	for _, entry := range entries {
+		if !common.IsEventCompliant(entry.Event, p.selector) {
+			continue
+		}
+
+		if err := p.handler(tx, p.target, entry); err != nil {
+			tx.Rollback()
+			return from, fmt.Errorf("applying entry %d to %s : %w", entry.ID, p.name, err)
+		}
+		applied++
+	}
+
+	last := entries[len(entries)-1].ID
+	result := tx.Model(&ProjectionOffset{}).Where("name = ? AND last_id = ?", p.name, from).Update("last_id", last)
+	if result.Error != nil {
+		tx.Rollback()
+		return from, fmt.Errorf("updating offset of %s : %v", p.name, result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return from, fmt.Errorf("%s at %d : %w", p.name, from, ErrConcurrentUpdate)
+	}
+
+	if result := tx.Commit(); result.Error != nil {
+		return from, fmt.Errorf("commit failed : %v", result.Error)
+	}
+
+	projectionOffset.With(prometheus.Labels{"projection": p.name}).Set(float64(last))
+	projectionAppliedCount.With(prometheus.Labels{"projection": p.name}).Add(float64(applied))
+	return last, nil
+}
@@ -0,0 +1,132 @@
+package projection
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// eventCount is a read model counting the events per entity type
+type eventCount struct {
+	EntityType string `gorm:"primary_key"`
+	Count      int
+}
+
+func countEvent(tx *gorm.DB, entry *types.AppLogEntry) error {
+	entityType := common.ExtractEntityType(entry.Event)
+	row := &eventCount{}
+	if result := tx.Where(eventCount{EntityType: entityType}).FirstOrCreate(row); result.Error != nil {
+		return result.Error
+	}
+	return tx.Model(row).Update("count", row.Count+1).Error
+}
+
+func countOf(t *testing.T, db *gorm.DB, entityType string) int {
+	row := &eventCount{}
+	if result := db.Where("entity_type = ?", entityType).First(row); result.Error != nil {
+		if result.RecordNotFound() {
+			return 0
+		}
+		assert.NoError(t, result.Error)
+	}
+	return row.Count
+}
+
+func appendEvents(t *testing.T, estore eventstore.Store, eventType string, count int) {
+	for i := 0; i < count; i++ {
+		err := estore.Append(&types.Event{
+			Originator: &types.Originator{ID: fmt.Sprintf("%s-%d", eventType, i), Version: 1},
+			EventType:  eventType,
+			Payload:    "{}",
+			OccurredOn: time.Now().UTC(),
+		})
+		assert.NoError(t, err)
+	}
+}
+
+func TestSQLProjection(t *testing.T) {
+	defer os.Remove("projection.db")
+	db, err := gorm.Open("sqlite3", "projection.db")
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.AutoMigrate(&eventCount{}).Error)
+
+	estore := eventstore.NewInMemoryStore()
+	appendEvents(t, estore, "Invoice.Created", 5)
+	appendEvents(t, estore, "User.Created", 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	projection, err := NewSQLProjection(estore, db, "invoice-counts", "Invoice.*", func(tx *gorm.DB, entry *types.AppLogEntry) error {
+		if entry.ID == 5 {
+			cancel()
+		}
+		return countEvent(tx, entry)
+	})
+	assert.NoError(t, err)
+	projection.WithBatchSize(2)
+
+	assert.NoError(t, projection.Run(ctx))
+	assert.Equal(t, 5, countOf(t, db, "Invoice"))
+	assert.Equal(t, 0, countOf(t, db, "User"))
+
+	offset, err := projection.Offset()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(6), offset, "the batch of the last entry is committed")
+
+	t.Run("failing handler rolls back its changes", func(tt *testing.T) {
+		projection, err := NewSQLProjection(estore, db, "all-counts", "*", func(tx *gorm.DB, entry *types.AppLogEntry) error {
+			if err := countEvent(tx, entry); err != nil {
+				return err
+			}
+			if entry.ID == 7 {
+				return fmt.Errorf("crashed")
+			}
+			return nil
+		})
+		assert.NoError(tt, err)
+		projection.WithBatchSize(1)
+
+		err = projection.Run(context.Background())
+		assert.EqualError(tt, err, "applying entry 7 to all-counts : crashed")
+
+		offset, err := projection.Offset()
+		assert.NoError(tt, err)
+		assert.Equal(tt, uint64(6), offset)
+		assert.Equal(tt, 10, countOf(tt, db, "Invoice"))
+		assert.Equal(tt, 1, countOf(tt, db, "User"), "the change of entry 7 is rolled back")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		restarted, err := NewSQLProjection(estore, db, "all-counts", "*", func(tx *gorm.DB, entry *types.AppLogEntry) error {
+			if entry.ID == 8 {
+				cancel()
+			}
+			return countEvent(tx, entry)
+		})
+		assert.NoError(tt, err)
+
+		assert.NoError(tt, restarted.Run(ctx))
+		assert.Equal(tt, 10, countOf(tt, db, "Invoice"), "applied once")
+		assert.Equal(tt, 3, countOf(tt, db, "User"))
+	})
+
+	t.Run("concurrent runner", func(tt *testing.T) {
+		entries, err := estore.Logs(7, 2, "")
+		assert.NoError(tt, err)
+
+		_, err = projection.apply(2, entries)
+		assert.ErrorIs(tt, err, ErrConcurrentUpdate)
+
+		offset, err := projection.Offset()
+		assert.NoError(tt, err)
+		assert.Equal(tt, uint64(6), offset)
+	})
+}
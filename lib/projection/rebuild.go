@@ -0,0 +1,207 @@
+package projection
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+)
+
+const (
+	// rebuildSuffix is added to the names of the shadow tables and the shadow offset
+	rebuildSuffix = "_rebuild"
+	// swapSuffix is the name of the live tables while they're swapped
+	swapSuffix = "_swap"
+)
+
+// RebuildProgress is reported by Rebuild after every applied batch
+type RebuildProgress struct {
+	Projection string `json:"projection"`
+	// Offset is the last log entry applied to the shadow tables
+	Offset uint64 `json:"offset"`
+	// Head is the last log entry when the batch was applied
+	Head uint64 `json:"head"`
+	// Swapped is set on the last report after the shadow tables became the live ones
+	Swapped bool `json:"swapped"`
+}
+
+// Percent returns how much of the log was applied
+func (p RebuildProgress) Percent() float64 {
+	if p.Head == 0 || p.Offset >= p.Head {
+		return 100
+	}
+	return float64(p.Offset) * 100 / float64(p.Head)
+}
+
+// Rebuild builds the projection from the beginning of the log into shadow tables while
+// the live tables keep being served and updated by Run. When the shadow caught up with
+// the head of the log the tables are swapped and the live offset is moved in a single
+// transaction, a running Run continues from there on the new tables. progress is called
+// after every batch and can be nil. A cancelled rebuild leaves the live tables untouched.
+// The indexes of the models should not have explicit names because they're created on
+// the shadow tables too.
+func (p *SQLProjection) Rebuild(ctx context.Context, progress func(RebuildProgress)) error {
+	if len(p.models) == 0 {
+		return fmt.Errorf("projection %s has no models to rebuild : %w", p.name, crudstore.InvalidArgumentError)
+	}
+
+	shadow := p.shadow()
+	if err := shadow.reset(); err != nil {
+		return err
+	}
+
+	report := func(offset, head uint64, swapped bool) {
+		if progress != nil {
+			progress(RebuildProgress{Projection: p.name, Offset: offset, Head: head, Swapped: swapped})
+		}
+	}
+
+	var offset uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("rebuild of %s at %d : %w", p.name, offset, err)
+		}
+
+		head, err := eventstore.LogHead(p.storeClient)
+		if err != nil {
+			return err
+		}
+
+		if offset >= head {
+			if err := p.swap(offset); err != nil {
+				return err
+			}
+			report(offset, head, true)
+			log.Printf("projection %s rebuilt up to %d", p.name, offset)
+			return nil
+		}
+
+		results, err := p.storeClient.Logs(offset+1, uint32(p.batchSize), "")
+		if err != nil {
+			return fmt.Errorf("fetch logs : %w", err)
+		}
+
+		if len(results) == 0 {
+			// the head was purged
+			offset = head
+			continue
+		}
+
+		if offset, err = shadow.apply(offset, results); err != nil {
+			return err
+		}
+		report(offset, head, false)
+	}
+}
+
+// shadow returns the projection writing to the shadow tables with its own offset
+func (p *SQLProjection) shadow() *SQLProjection {
+	shadow := *p
+	shadow.name = p.name + rebuildSuffix
+	shadow.target = Target{suffix: rebuildSuffix}
+	return &shadow
+}
+
+// reset recreates the empty shadow tables and sets the shadow offset to the beginning
+func (p *SQLProjection) reset() error {
+	for _, table := range p.Tables() {
+		if result := p.db.DropTableIfExists(p.target.Table(table)); result.Error != nil {
+			return fmt.Errorf("dropping %s : %v", p.target.Table(table), result.Error)
+		}
+	}
+
+	if err := p.migrate(p.db); err != nil {
+		return err
+	}
+
+	if result := p.db.Where("name = ?", p.name).Delete(&ProjectionOffset{}); result.Error != nil {
+		return fmt.Errorf("resetting offset of %s : %v", p.name, result.Error)
+	}
+
+	if result := p.db.Create(&ProjectionOffset{Name: p.name}); result.Error != nil {
+		return fmt.Errorf("resetting offset of %s : %v", p.name, result.Error)
+	}
+
+	return nil
+}
+
+// swap replaces the live tables with the shadow ones and moves the live offset to the
+// shadow one in a transaction
+func (p *SQLProjection) swap(offset uint64) error {
+	shadow := p.shadow()
+	tx := p.db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("starting transaction : %v", tx.Error)
+	}
+
+	quote := tx.Dialect().Quote
+	for i, table := range p.Tables() {
+		statements := []string{
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quote(table), quote(table+swapSuffix)),
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quote(shadow.target.Table(table)), quote(table)),
+			fmt.Sprintf("DROP TABLE %s", quote(table+swapSuffix)),
+		}
+
+		for _, statement := range statements {
+			if result := tx.Exec(statement); result.Error != nil {
+				tx.Rollback()
+				return fmt.Errorf("swapping %s : %v", table, result.Error)
+			}
+		}
+
+		// the indexes keep the names of the shadow table, they're recreated with the
+		// live names so the next rebuild can create the shadow ones again
+		for _, index := range indexNames(tx, p.models[i], shadow.target.Table(table)) {
+			if result := tx.Table(table).RemoveIndex(index); result.Error != nil {
+				tx.Rollback()
+				return fmt.Errorf("removing index %s : %v", index, result.Error)
+			}
+		}
+
+		if result := tx.Table(table).AutoMigrate(p.models[i]); result.Error != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate %s : %v", table, result.Error)
+		}
+	}
+
+	if result := tx.Model(&ProjectionOffset{}).Where("name = ?", p.name).Update("last_id", offset); result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("updating offset of %s : %v", p.name, result.Error)
+	}
+
+	if result := tx.Where("name = ?", shadow.name).Delete(&ProjectionOffset{}); result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("removing offset of %s : %v", shadow.name, result.Error)
+	}
+
+	if result := tx.Commit(); result.Error != nil {
+		return fmt.Errorf("commit failed : %v", result.Error)
+	}
+
+	projectionOffset.WithLabelValues(p.name).Set(float64(offset))
+	return nil
+}
+
+// indexNames returns the names gorm gives to the unnamed indexes of the model in table
+func indexNames(db *gorm.DB, model interface{}, table string) []string {
+	var names []string
+	for _, field := range db.NewScope(model).GetModelStruct().StructFields {
+		for kind, setting := range map[string]string{"idx": "INDEX", "uix": "UNIQUE_INDEX"} {
+			value, ok := field.TagSettingsGet(setting)
+			if !ok {
+				continue
+			}
+
+			for _, name := range strings.Split(value, ",") {
+				if name == setting || name == "" {
+					names = append(names, db.Dialect().BuildKeyName(kind, table, field.DBName))
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,116 @@
+package projection
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// originatorRow is a read model with an index to check the swapped indexes
+type originatorRow struct {
+	ID         string `gorm:"primary_key"`
+	EntityType string `gorm:"index"`
+	Weight     int
+}
+
+func (originatorRow) TableName() string {
+	return "originator_rows"
+}
+
+func weightedHandler(weight int) TargetHandler {
+	return func(tx *gorm.DB, target Target, entry *types.AppLogEntry) error {
+		return tx.Table(target.Table("originator_rows")).Create(&originatorRow{
+			ID:         entry.Event.Originator.ID,
+			EntityType: common.ExtractEntityType(entry.Event),
+			Weight:     weight,
+		}).Error
+	}
+}
+
+func totalWeight(t *testing.T, db *gorm.DB) int {
+	var rows []*originatorRow
+	assert.NoError(t, db.Find(&rows).Error)
+	total := 0
+	for _, r := range rows {
+		total += r.Weight
+	}
+	return total
+}
+
+func TestSQLProjection_Rebuild(t *testing.T) {
+	defer os.Remove("rebuild.db")
+	db, err := gorm.Open("sqlite3", "rebuild.db")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	estore := eventstore.NewInMemoryStore()
+	appendEvents(t, estore, "Invoice.Created", 5)
+
+	models := []interface{}{&originatorRow{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	buggy, err := NewSQLProjectionWithModels(estore, db, "originators", "*", models, func(tx *gorm.DB, target Target, entry *types.AppLogEntry) error {
+		if entry.ID == 5 {
+			cancel()
+		}
+		return weightedHandler(2)(tx, target, entry)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"originator_rows"}, buggy.Tables())
+
+	assert.NoError(t, buggy.Run(ctx))
+	assert.Equal(t, 10, totalWeight(t, db))
+
+	fixed, err := NewSQLProjectionWithModels(estore, db, "originators", "*", models, weightedHandler(1))
+	assert.NoError(t, err)
+	fixed.WithBatchSize(2)
+
+	var reports []RebuildProgress
+	assert.NoError(t, fixed.Rebuild(context.Background(), func(progress RebuildProgress) {
+		reports = append(reports, progress)
+	}))
+
+	assert.Equal(t, 5, totalWeight(t, db))
+	if assert.Len(t, reports, 4) {
+		assert.Equal(t, RebuildProgress{Projection: "originators", Offset: 2, Head: 5}, reports[0])
+		assert.InDelta(t, 40, reports[0].Percent(), 0.01)
+		assert.Equal(t, RebuildProgress{Projection: "originators", Offset: 5, Head: 5, Swapped: true}, reports[3])
+	}
+
+	offset, err := fixed.Offset()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), offset)
+
+	assert.False(t, db.HasTable("originator_rows_rebuild"), "the shadow table became the live one")
+	assert.False(t, db.HasTable("originator_rows_swap"))
+	assert.True(t, db.Table("originator_rows").Dialect().HasIndex("originator_rows", "idx_originator_rows_entity_type"))
+
+	t.Run("rebuild again", func(tt *testing.T) {
+		appendEvents(tt, estore, "User.Created", 2)
+
+		var out bytes.Buffer
+		assert.NoError(tt, RunCommand(context.Background(), []string{"rebuild", "originators"}, &out, fixed))
+		assert.Contains(tt, out.String(), "originators 2/7 (28.6%)")
+		assert.Contains(tt, out.String(), "originators rebuilt up to 7 and swapped")
+		assert.Equal(tt, 7, totalWeight(tt, db))
+
+		out.Reset()
+		assert.NoError(tt, RunCommand(context.Background(), []string{"list"}, &out, fixed))
+		assert.Equal(tt, "originators\toffset 7\thead 7\trebuildable true\n", out.String())
+	})
+
+	t.Run("invalid", func(tt *testing.T) {
+		plain, err := NewSQLProjection(estore, db, "plain", "*", countEvent)
+		assert.NoError(tt, err)
+		assert.Error(tt, plain.Rebuild(context.Background(), nil))
+
+		assert.Error(tt, RunCommand(context.Background(), nil, &bytes.Buffer{}))
+		assert.Error(tt, RunCommand(context.Background(), []string{"rebuild", "missing"}, &bytes.Buffer{}, fixed))
+	})
+}
@@ -0,0 +1,146 @@
+package saga
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/makkalot/eskit/lib/aggregate"
+)
+
+// Status is the lifecycle of a process instance
+type Status string
+
+const (
+	StatusRunning Status = "running"
+	// StatusCompensating is a failed instance whose compensations didn't all run yet
+	StatusCompensating Status = "compensating"
+	StatusCompleted    Status = "completed"
+	StatusFailed       Status = "failed"
+)
+
+// The events of the instance streams, stored as <process name>.<event>
+
+// Started is the first event of an instance
+type Started struct {
+	Key string `json:"key"`
+}
+
+// EntryHandled records the log entry an instance handled so it's not handled twice
+type EntryHandled struct {
+	Entry uint64 `json:"entry"`
+}
+
+// StateChanged carries the whole state after a handler changed it
+type StateChanged struct {
+	State json.RawMessage `json:"state"`
+}
+
+type TimeoutScheduled struct {
+	Name string    `json:"name"`
+	At   time.Time `json:"at"`
+}
+
+type TimeoutCancelled struct {
+	Name string `json:"name"`
+}
+
+type TimeoutFired struct {
+	Name string `json:"name"`
+}
+
+// CompensationAdded records a compensation to run when the instance fails
+type CompensationAdded struct {
+	Name string `json:"name"`
+}
+
+// Compensated records a compensation which ran
+type Compensated struct {
+	Name string `json:"name"`
+}
+
+// Compensating records that the instance failed, its compensations run next
+type Compensating struct {
+	Reason string `json:"reason"`
+}
+
+type Completed struct{}
+
+type Failed struct {
+	Reason string `json:"reason"`
+}
+
+func newInstanceEvents() (*aggregate.EventRegistry, error) {
+	events := aggregate.NewEventRegistry()
+	err := events.Register(
+		Started{}, EntryHandled{}, StateChanged{},
+		TimeoutScheduled{}, TimeoutCancelled{}, TimeoutFired{},
+		CompensationAdded{}, Compensated{}, Compensating{}, Completed{}, Failed{},
+	)
+	return events, err
+}
+
+// Instance is a running process for a correlation key, its stream is the history of
+// the process
+type Instance[S any] struct {
+	aggregate.Root
+	Key    string `json:"key"`
+	State  S      `json:"state"`
+	Status Status `json:"status"`
+	// LastEntry is the last log entry the instance handled
+	LastEntry uint64 `json:"lastEntry"`
+	// Timeouts are the pending timeouts by name
+	Timeouts map[string]time.Time `json:"timeouts"`
+	// Compensations are the compensations to run in reverse order when the instance fails
+	Compensations []string `json:"compensations"`
+	Reason        string   `json:"reason,omitempty"`
+}
+
+func (i *Instance[S]) Apply(event interface{}) error {
+	switch e := event.(type) {
+	case *Started:
+		i.Key = e.Key
+		i.Status = StatusRunning
+		i.Timeouts = map[string]time.Time{}
+	case *EntryHandled:
+		i.LastEntry = e.Entry
+	case *StateChanged:
+		var state S
+		if err := json.Unmarshal(e.State, &state); err != nil {
+			return fmt.Errorf("decoding the state of %s : %v", i.Key, err)
+		}
+		i.State = state
+	case *TimeoutScheduled:
+		i.Timeouts[e.Name] = e.At
+	case *TimeoutCancelled:
+		delete(i.Timeouts, e.Name)
+	case *TimeoutFired:
+		delete(i.Timeouts, e.Name)
+	case *CompensationAdded:
+		i.Compensations = append(i.Compensations, e.Name)
+	case *Compensated:
+		for j := len(i.Compensations) - 1; j >= 0; j-- {
+			if i.Compensations[j] == e.Name {
+				i.Compensations = append(i.Compensations[:j], i.Compensations[j+1:]...)
+				break
+			}
+		}
+	case *Compensating:
+		i.Status = StatusCompensating
+		i.Reason = e.Reason
+		i.Timeouts = map[string]time.Time{}
+	case *Completed:
+		i.Status = StatusCompleted
+		i.Timeouts = map[string]time.Time{}
+	case *Failed:
+		i.Status = StatusFailed
+		i.Reason = e.Reason
+		i.Timeouts = map[string]time.Time{}
+	}
+	return nil
+}
+
+// Done returns true for the completed and the failed instances
+func (i *Instance[S]) Done() bool {
+	return i.Status == StatusCompleted || i.Status == StatusFailed
+}
@@ -0,0 +1,606 @@
+// Package saga runs process managers driven by the application log. A process manager
+// correlates the entries of the log to instances by a key, its handlers issue commands
+// through crudstore or aggregates and keep the state of the instance, which is event
+// sourced as its own stream. The handlers can schedule timeouts and record compensations
+// which run in reverse order when an instance fails.
+package saga
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/makkalot/eskit/lib/aggregate"
+	"github.com/makkalot/eskit/lib/common"
+	"github.com/makkalot/eskit/lib/consumer"
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// DefaultTimeoutInterval is how often Run fires the due timeouts
+	DefaultTimeoutInterval = time.Second
+	// conflictRetries is how many times an instance is reloaded when it was saved concurrently
+	conflictRetries = 3
+)
+
+var (
+	sagaTransitionCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "eskit_sagas_transition_count",
+			Help: "Number of process instances which started, completed or failed",
+		}, []string{
+			"process",
+			"status",
+		})
+)
+
+// CorrelateFunc returns the correlation key of the instance an entry belongs to, the
+// entries with an empty key are skipped
+type CorrelateFunc func(entry *types.AppLogEntry) (string, error)
+
+// ByOriginator correlates the entries by the ID of their originator
+func ByOriginator(entry *types.AppLogEntry) (string, error) {
+	return entry.Event.Originator.ID, nil
+}
+
+// ByPayloadField correlates the entries by a top level string field of their JSON payload
+func ByPayloadField(field string) CorrelateFunc {
+	return func(entry *types.AppLogEntry) (string, error) {
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(entry.Event.Payload), &payload); err != nil {
+			return "", fmt.Errorf("decoding the payload of entry %d : %v", entry.ID, err)
+		}
+
+		key, _ := payload[field].(string)
+		return key, nil
+	}
+}
+
+// Handler handles an entry, a timeout or a compensation of an instance. It changes the
+// state through c.State and returning an error leaves the instance untouched, so the
+// commands it issues should be idempotent.
+type Handler[S any] func(c *Context[S]) error
+
+// Context is passed to the handlers with the instance they run for
+type Context[S any] struct {
+	context.Context
+	Key   string
+	State *S
+	// Entry is the handled log entry, nil for the timeouts and the compensations
+	Entry *types.AppLogEntry
+	// Timeout is the name of the fired timeout
+	Timeout string
+	// Reason is why the instance failed, set for the compensations
+	Reason string
+
+	now       time.Time
+	events    []interface{}
+	completed bool
+	failed    *string
+}
+
+// ScheduleTimeout fires the timeout handler name after d unless it's cancelled, scheduling
+// it again moves it
+func (c *Context[S]) ScheduleTimeout(name string, d time.Duration) {
+	c.events = append(c.events, &TimeoutScheduled{Name: name, At: c.now.Add(d)})
+}
+
+// CancelTimeout cancels a scheduled timeout
+func (c *Context[S]) CancelTimeout(name string) {
+	c.events = append(c.events, &TimeoutCancelled{Name: name})
+}
+
+// AddCompensation records the compensation name to run if the instance fails later
+func (c *Context[S]) AddCompensation(name string) {
+	c.events = append(c.events, &CompensationAdded{Name: name})
+}
+
+// Complete finishes the instance, its next entries and timeouts are ignored
+func (c *Context[S]) Complete() {
+	c.completed = true
+}
+
+// Fail runs the recorded compensations in reverse order and finishes the instance
+func (c *Context[S]) Fail(reason string) {
+	c.failed = &reason
+}
+
+type route[S any] struct {
+	selector  string
+	correlate CorrelateFunc
+	handler   Handler[S]
+	// start creates the instance when there's none for the key
+	start bool
+}
+
+// ProcessManager runs the instances of a process with the state S, the instances are
+// stored as <name>-<key> streams with <name>.<event> events
+type ProcessManager[S any] struct {
+	name            string
+	store           eventstore.Store
+	consumer        *consumer.AppLogConsumer
+	repository      *aggregate.Repository[*Instance[S]]
+	routes          []route[S]
+	timeouts        map[string]Handler[S]
+	compensations   map[string]Handler[S]
+	timeoutInterval time.Duration
+
+	mu sync.Mutex
+	// pending are the IDs of the instances which have timeouts
+	pending map[string]bool
+	// pendingLoaded is set once the instances which scheduled timeouts were read from the log
+	pendingLoaded bool
+	now           func() time.Time
+}
+
+// NewProcessManager creates the process name, its progress in the log is saved in
+// consumerStore with the same name. The store has to be an eventstore.BatchAppender so
+// the events of a handled entry are saved atomically, otherwise an instance could record
+// the entry as handled without the changes of its handler.
+func NewProcessManager[S any](store eventstore.Store, consumerStore consumerstore.Store, name string) (*ProcessManager[S], error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing process name : %w", crudstore.InvalidArgumentError)
+	}
+
+	if _, ok := store.(eventstore.BatchAppender); !ok {
+		return nil, fmt.Errorf("store %T can't append atomically : %w", store, crudstore.InvalidArgumentError)
+	}
+
+	events, err := newInstanceEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	repository, err := aggregate.NewRepository(store, name, events, func() *Instance[S] { return &Instance[S]{} })
+	if err != nil {
+		return nil, err
+	}
+
+	logConsumer, err := consumer.NewAppLogConsumer(store, consumerStore, name, consumer.FromSaved, "*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProcessManager[S]{
+		name:            name,
+		store:           store,
+		consumer:        logConsumer,
+		repository:      repository,
+		timeouts:        map[string]Handler[S]{},
+		compensations:   map[string]Handler[S]{},
+		timeoutInterval: DefaultTimeoutInterval,
+		pending:         map[string]bool{},
+		now:             time.Now,
+	}, nil
+}
+
+// WithConsumer replaces the consumer of the log, ie with one with a retry policy or
+// leader election
+func (pm *ProcessManager[S]) WithConsumer(c *consumer.AppLogConsumer) *ProcessManager[S] {
+	pm.consumer = c
+	return pm
+}
+
+// WithTimeoutInterval sets how often Run fires the due timeouts
+func (pm *ProcessManager[S]) WithTimeoutInterval(d time.Duration) *ProcessManager[S] {
+	if d > 0 {
+		pm.timeoutInterval = d
+	}
+	return pm
+}
+
+// StartOn handles the entries matching selector, the instance of their key is started
+// when there's none
+func (pm *ProcessManager[S]) StartOn(selector string, correlate CorrelateFunc, handler Handler[S]) *ProcessManager[S] {
+	pm.routes = append(pm.routes, route[S]{selector: selector, correlate: correlate, handler: handler, start: true})
+	return pm
+}
+
+// On handles the entries matching selector for the running instances of their key
+func (pm *ProcessManager[S]) On(selector string, correlate CorrelateFunc, handler Handler[S]) *ProcessManager[S] {
+	pm.routes = append(pm.routes, route[S]{selector: selector, correlate: correlate, handler: handler})
+	return pm
+}
+
+// OnTimeout handles the timeout name when it's due
+func (pm *ProcessManager[S]) OnTimeout(name string, handler Handler[S]) *ProcessManager[S] {
+	pm.timeouts[name] = handler
+	return pm
+}
+
+// Compensation registers the compensation name the handlers can add with AddCompensation
+func (pm *ProcessManager[S]) Compensation(name string, handler Handler[S]) *ProcessManager[S] {
+	pm.compensations[name] = handler
+	return pm
+}
+
+// Name returns the name of the process
+func (pm *ProcessManager[S]) Name() string {
+	return pm.name
+}
+
+// Consumer returns the consumer of the log, ie to register it to the consumer admin
+func (pm *ProcessManager[S]) Consumer() *consumer.AppLogConsumer {
+	return pm.consumer
+}
+
+// Instance loads the instance of key, it returns aggregate.ErrNotFound when there's none
+func (pm *ProcessManager[S]) Instance(key string) (*Instance[S], error) {
+	return pm.repository.Load(pm.instanceID(key))
+}
+
+// Run consumes the log and fires the due timeouts until ctx is done
+func (pm *ProcessManager[S]) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pm.timeoutInterval):
+			}
+
+			if err := pm.FireTimeouts(ctx); err != nil {
+				errCh <- err
+				cancel()
+				return
+			}
+		}
+	}()
+
+	err := pm.consumer.Consume(ctx, func(entry *types.AppLogEntry) error {
+		return pm.Handle(ctx, entry)
+	})
+	cancel()
+	wg.Wait()
+
+	select {
+	case timeoutErr := <-errCh:
+		return timeoutErr
+	default:
+		return err
+	}
+}
+
+// Handle passes the entry to the matching handlers of the instances it correlates to,
+// the entries an instance already handled are skipped
+func (pm *ProcessManager[S]) Handle(ctx context.Context, entry *types.AppLogEntry) error {
+	// the own events of the process
+	if common.ExtractEntityType(entry.Event) == pm.name {
+		return nil
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, r := range pm.routes {
+		if !common.IsEventCompliant(entry.Event, r.selector) {
+			continue
+		}
+
+		key, err := r.correlate(entry)
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			continue
+		}
+
+		if err := pm.handle(ctx, key, r.start, entry, "", r.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FireTimeouts runs the handlers of the due timeouts, the instances with timeouts are
+// found by reading the log the first time
+func (pm *ProcessManager[S]) FireTimeouts(ctx context.Context) error {
+	// the log is read without the lock so Handle goes on meanwhile, the instances it
+	// saves are tracked in pending already
+	var scanned []string
+	pm.mu.Lock()
+	loaded := pm.pendingLoaded
+	pm.mu.Unlock()
+	if !loaded {
+		var err error
+		if scanned, err = pm.scanPending(); err != nil {
+			return err
+		}
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if !pm.pendingLoaded {
+		for _, id := range scanned {
+			pm.pending[id] = true
+		}
+		pm.pendingLoaded = true
+	}
+
+	ids := make([]string, 0, len(pm.pending))
+	for id := range pm.pending {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		instance, err := pm.repository.Load(id)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range dueTimeouts(instance.Timeouts, pm.now()) {
+			if err := pm.handle(ctx, instance.Key, false, nil, name, pm.timeouts[name]); err != nil {
+				return err
+			}
+		}
+
+		if _, ok := pm.pending[id]; ok && len(instance.Timeouts) == 0 {
+			delete(pm.pending, id)
+		}
+	}
+	return nil
+}
+
+// scanPending returns the IDs of the instances which scheduled timeouts in the log, the
+// ones which don't have any anymore are dropped by FireTimeouts
+func (pm *ProcessManager[S]) scanPending() ([]string, error) {
+	pending := map[string]bool{}
+	scheduled := pm.name + ".TimeoutScheduled"
+
+	var fromID uint64 = 1
+	for {
+		results, err := pm.store.Logs(fromID, 1000, "")
+		if err != nil {
+			return nil, fmt.Errorf("fetch logs : %w", err)
+		}
+		if len(results) == 0 {
+			break
+		}
+
+		for _, entry := range results {
+			if entry.Event.EventType == scheduled {
+				pending[entry.Event.Originator.ID] = true
+			}
+			fromID = entry.ID + 1
+		}
+	}
+
+	ids := make([]string, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// dueTimeouts returns the names of the timeouts due at now in the order they were due
+func dueTimeouts(timeouts map[string]time.Time, now time.Time) []string {
+	var names []string
+	for name, at := range timeouts {
+		if !at.After(now) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if timeouts[names[i]].Equal(timeouts[names[j]]) {
+			return names[i] < names[j]
+		}
+		return timeouts[names[i]].Before(timeouts[names[j]])
+	})
+	return names
+}
+
+func (pm *ProcessManager[S]) instanceID(key string) string {
+	return fmt.Sprintf("%s-%s", pm.name, key)
+}
+
+// handle runs handler for the instance of key, the instance is loaded again when it was
+// saved concurrently
+func (pm *ProcessManager[S]) handle(ctx context.Context, key string, start bool, entry *types.AppLogEntry, timeout string, handler Handler[S]) error {
+	for attempt := 1; ; attempt++ {
+		err := pm.handleOnce(ctx, key, start, entry, timeout, handler)
+		if errors.Is(err, aggregate.ErrConcurrencyConflict) && attempt < conflictRetries {
+			continue
+		}
+		return err
+	}
+}
+
+func (pm *ProcessManager[S]) handleOnce(ctx context.Context, key string, start bool, entry *types.AppLogEntry, timeout string, handler Handler[S]) error {
+	instance, err := pm.repository.Load(pm.instanceID(key))
+	switch {
+	case errors.Is(err, aggregate.ErrNotFound):
+		if !start {
+			return nil
+		}
+
+		instance = &Instance[S]{}
+		instance.SetID(pm.instanceID(key))
+		if err := aggregate.Raise(instance, &Started{Key: key}); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+
+	// a failing instance continues compensating after its last compensation failed
+	if instance.Status == StatusCompensating {
+		return pm.compensate(ctx, instance)
+	}
+
+	if instance.Done() {
+		return nil
+	}
+
+	if entry != nil {
+		if instance.LastEntry >= entry.ID {
+			return nil
+		}
+		if err := aggregate.Raise(instance, &EntryHandled{Entry: entry.ID}); err != nil {
+			return err
+		}
+	}
+
+	if timeout != "" {
+		if at, ok := instance.Timeouts[timeout]; !ok || at.After(pm.now()) {
+			return nil
+		}
+		if err := aggregate.Raise(instance, &TimeoutFired{Name: timeout}); err != nil {
+			return err
+		}
+	}
+
+	c := pm.newContext(ctx, instance)
+	c.Entry, c.Timeout = entry, timeout
+	if err := pm.run(c, instance, handler); err != nil {
+		return fmt.Errorf("%s %s : %w", pm.name, key, err)
+	}
+
+	for _, event := range c.events {
+		if err := aggregate.Raise(instance, event); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case c.failed != nil:
+		if err := aggregate.Raise(instance, &Compensating{Reason: *c.failed}); err != nil {
+			return err
+		}
+	case c.completed:
+		if err := aggregate.Raise(instance, &Completed{}); err != nil {
+			return err
+		}
+	}
+
+	if err := pm.save(instance); err != nil {
+		return err
+	}
+
+	if instance.Status == StatusCompensating {
+		return pm.compensate(ctx, instance)
+	}
+	return nil
+}
+
+// compensate runs the compensations of the instance in reverse order, each of them is
+// saved so a failing one continues from where it stopped
+func (pm *ProcessManager[S]) compensate(ctx context.Context, instance *Instance[S]) error {
+	for len(instance.Compensations) > 0 {
+		name := instance.Compensations[len(instance.Compensations)-1]
+		handler, ok := pm.compensations[name]
+		if !ok {
+			return fmt.Errorf("%s %s has no compensation %s : %w", pm.name, instance.Key, name, crudstore.InvalidArgumentError)
+		}
+
+		c := pm.newContext(ctx, instance)
+		c.Reason = instance.Reason
+		if err := pm.run(c, instance, handler); err != nil {
+			return fmt.Errorf("%s %s compensation %s : %w", pm.name, instance.Key, name, err)
+		}
+
+		if err := aggregate.Raise(instance, &Compensated{Name: name}); err != nil {
+			return err
+		}
+		if err := pm.save(instance); err != nil {
+			return err
+		}
+	}
+
+	if err := aggregate.Raise(instance, &Failed{Reason: instance.Reason}); err != nil {
+		return err
+	}
+	return pm.save(instance)
+}
+
+func (pm *ProcessManager[S]) newContext(ctx context.Context, instance *Instance[S]) *Context[S] {
+	return &Context[S]{
+		Context: ctx,
+		Key:     instance.Key,
+		now:     pm.now(),
+	}
+}
+
+// run calls handler with a copy of the state and raises StateChanged when it changed it
+func (pm *ProcessManager[S]) run(c *Context[S], instance *Instance[S], handler Handler[S]) error {
+	before, err := json.Marshal(instance.State)
+	if err != nil {
+		return fmt.Errorf("encoding the state : %v", err)
+	}
+
+	// the handler gets a copy so a failing one doesn't change the instance
+	var state S
+	if err := json.Unmarshal(before, &state); err != nil {
+		return fmt.Errorf("decoding the state : %v", err)
+	}
+	c.State = &state
+
+	if handler != nil {
+		if err := handler(c); err != nil {
+			return err
+		}
+	}
+
+	after, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding the state : %v", err)
+	}
+
+	if bytes.Equal(before, after) {
+		return nil
+	}
+	return aggregate.Raise(instance, &StateChanged{State: after})
+}
+
+// save stores the new events of the instance and keeps track of its timeouts
+func (pm *ProcessManager[S]) save(instance *Instance[S]) error {
+	var transitions []Status
+	for _, event := range instance.PendingEvents() {
+		switch event.(type) {
+		case *Started:
+			transitions = append(transitions, StatusRunning)
+		case *Completed:
+			transitions = append(transitions, StatusCompleted)
+		case *Failed:
+			transitions = append(transitions, StatusFailed)
+		}
+	}
+
+	if err := pm.repository.Save(instance); err != nil {
+		return err
+	}
+
+	for _, status := range transitions {
+		sagaTransitionCount.With(prometheus.Labels{"process": pm.name, "status": string(status)}).Inc()
+	}
+
+	if len(instance.Timeouts) > 0 {
+		pm.pending[instance.ID()] = true
+	} else {
+		delete(pm.pending, instance.ID())
+	}
+	return nil
+}
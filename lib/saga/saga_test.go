@@ -0,0 +1,276 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makkalot/eskit/lib/aggregate"
+	"github.com/makkalot/eskit/lib/consumerstore"
+	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
+	"github.com/makkalot/eskit/lib/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type Workspace struct {
+	Originator *types.Originator
+	Owner      string
+	Name       string
+}
+
+type InviteSent struct {
+	UserID string `json:"userId"`
+}
+
+func (InviteSent) EventName() string {
+	return "Sent"
+}
+
+type InviteAccepted struct {
+	UserID string `json:"userId"`
+}
+
+func (InviteAccepted) EventName() string {
+	return "Accepted"
+}
+
+type Invite struct {
+	aggregate.Root
+	UserID   string
+	Accepted bool
+}
+
+func (i *Invite) Apply(event interface{}) error {
+	switch e := event.(type) {
+	case *InviteSent:
+		i.UserID = e.UserID
+	case *InviteAccepted:
+		i.Accepted = true
+	}
+	return nil
+}
+
+type onboarding struct {
+	WorkspaceID string
+	InviteID    string
+}
+
+type onboardingFixture struct {
+	store      eventstore.Store
+	workspaces *crudstore.TypedClient[Workspace]
+	invites    *aggregate.Repository[*Invite]
+	process    *ProcessManager[onboarding]
+	now        time.Time
+	// fromID is the next log entry passed to the process
+	fromID uint64
+}
+
+func newOnboardingFixture(t *testing.T) *onboardingFixture {
+	store := eventstore.NewInMemoryStore()
+	crudStore, err := crudstore.NewCrudStoreProvider(context.Background(), store)
+	assert.NoError(t, err)
+
+	workspaces, err := crudstore.NewTypedClient[Workspace](crudStore)
+	assert.NoError(t, err)
+
+	inviteEvents := aggregate.NewEventRegistry()
+	assert.NoError(t, inviteEvents.Register(InviteSent{}, InviteAccepted{}))
+	invites, err := aggregate.NewRepository(store, "Invite", inviteEvents, func() *Invite { return &Invite{} })
+	assert.NoError(t, err)
+
+	f := &onboardingFixture{
+		store:      store,
+		workspaces: workspaces,
+		invites:    invites,
+		now:        time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+		fromID:     1,
+	}
+	f.process = f.newProcess(t)
+	return f
+}
+
+func (f *onboardingFixture) newProcess(t *testing.T) *ProcessManager[onboarding] {
+	process, err := NewProcessManager[onboarding](f.store, consumerstore.NewInMemoryConsumerApiProvider(), "Onboarding")
+	assert.NoError(t, err)
+	process.now = func() time.Time { return f.now }
+
+	return process.
+		StartOn("User.Created", ByOriginator, func(c *Context[onboarding]) error {
+			if c.State.WorkspaceID == "" {
+				originator, err := f.workspaces.Create(&Workspace{Owner: c.Key, Name: "default"})
+				if err != nil {
+					return err
+				}
+				c.State.WorkspaceID = originator.ID
+				c.AddCompensation("deleteWorkspace")
+			}
+
+			// the invite service refuses this one
+			if c.Key == "user-3" {
+				c.Fail("invite refused")
+				return nil
+			}
+
+			invite := &Invite{}
+			invite.SetID("invite-" + c.Key)
+			if err := aggregate.Raise(invite, &InviteSent{UserID: c.Key}); err != nil {
+				return err
+			}
+			if err := f.invites.Save(invite); err != nil && !errors.Is(err, aggregate.ErrConcurrencyConflict) {
+				return err
+			}
+
+			c.State.InviteID = invite.ID()
+			c.ScheduleTimeout("inviteExpired", time.Hour)
+			return nil
+		}).
+		On("Invite.Accepted", ByPayloadField("userId"), func(c *Context[onboarding]) error {
+			c.CancelTimeout("inviteExpired")
+			c.Complete()
+			return nil
+		}).
+		OnTimeout("inviteExpired", func(c *Context[onboarding]) error {
+			c.Fail("invite expired")
+			return nil
+		}).
+		Compensation("deleteWorkspace", func(c *Context[onboarding]) error {
+			workspace, err := f.workspaces.Get(c.State.WorkspaceID, 0)
+			if err != nil {
+				return err
+			}
+			if _, err := f.workspaces.Delete(workspace.Originator.ID, workspace.Originator.Version); err != nil {
+				return err
+			}
+			c.State.WorkspaceID = ""
+			return nil
+		})
+}
+
+// drain passes the new log entries to the process until it doesn't append any
+func (f *onboardingFixture) drain(t *testing.T) {
+	for {
+		results, err := f.store.Logs(f.fromID, 100, "")
+		assert.NoError(t, err)
+		if len(results) == 0 {
+			return
+		}
+
+		for _, entry := range results {
+			assert.NoError(t, f.process.Handle(context.Background(), entry))
+			f.fromID = entry.ID + 1
+		}
+	}
+}
+
+func (f *onboardingFixture) appendUser(t *testing.T, id string) {
+	assert.NoError(t, f.store.Append(&types.Event{
+		Originator: &types.Originator{ID: id, Version: 1},
+		EventType:  "User.Created",
+		Payload:    "{}",
+		OccurredOn: f.now,
+	}))
+}
+
+func (f *onboardingFixture) workspaceCount(t *testing.T) int {
+	workspaces, _, err := f.workspaces.List("", 100)
+	assert.NoError(t, err)
+	return len(workspaces)
+}
+
+func TestProcessManager(t *testing.T) {
+	f := newOnboardingFixture(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"user-1", "user-2", "user-3"} {
+		f.appendUser(t, id)
+	}
+	f.drain(t)
+
+	first, err := f.process.Instance("user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusRunning, first.Status)
+	assert.NotEmpty(t, first.State.WorkspaceID)
+	assert.Equal(t, "invite-user-1", first.State.InviteID)
+	assert.Equal(t, f.now.Add(time.Hour), first.Timeouts["inviteExpired"])
+
+	refused, err := f.process.Instance("user-3")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFailed, refused.Status)
+	assert.Equal(t, "invite refused", refused.Reason)
+	assert.Empty(t, refused.State.WorkspaceID, "compensated")
+	assert.Equal(t, 2, f.workspaceCount(t))
+
+	_, err = f.process.Instance("user-4")
+	assert.ErrorIs(t, err, aggregate.ErrNotFound)
+
+	t.Run("redelivered entries are skipped", func(t *testing.T) {
+		entries, err := f.store.Logs(1, 1, "")
+		assert.NoError(t, err)
+		assert.NoError(t, f.process.Handle(ctx, entries[0]))
+		assert.Equal(t, 2, f.workspaceCount(t))
+	})
+
+	t.Run("correlated entries", func(t *testing.T) {
+		invite, err := f.invites.Load("invite-user-1")
+		assert.NoError(t, err)
+		assert.NoError(t, aggregate.Raise(invite, &InviteAccepted{UserID: "user-1"}))
+		assert.NoError(t, f.invites.Save(invite))
+		f.drain(t)
+
+		first, err := f.process.Instance("user-1")
+		assert.NoError(t, err)
+		assert.Equal(t, StatusCompleted, first.Status)
+		assert.Empty(t, first.Timeouts)
+	})
+
+	t.Run("timeouts", func(t *testing.T) {
+		assert.NoError(t, f.process.FireTimeouts(ctx))
+		second, err := f.process.Instance("user-2")
+		assert.NoError(t, err)
+		assert.Equal(t, StatusRunning, second.Status, "not due yet")
+
+		f.now = f.now.Add(time.Hour * 2)
+		// a new process finds the pending timeouts in the log
+		f.process = f.newProcess(t)
+		assert.NoError(t, f.process.FireTimeouts(ctx))
+
+		second, err = f.process.Instance("user-2")
+		assert.NoError(t, err)
+		assert.Equal(t, StatusFailed, second.Status)
+		assert.Equal(t, "invite expired", second.Reason)
+		assert.Empty(t, second.Timeouts)
+		assert.Equal(t, 1, f.workspaceCount(t), "only the completed onboarding kept its workspace")
+
+		events, err := f.store.Get(&types.Originator{ID: "Onboarding-user-2"}, false)
+		assert.NoError(t, err)
+		var eventTypes []string
+		for _, e := range events {
+			eventTypes = append(eventTypes, e.EventType)
+		}
+		assert.Equal(t, []string{
+			"Onboarding.Started",
+			"Onboarding.EntryHandled",
+			"Onboarding.StateChanged",
+			"Onboarding.CompensationAdded",
+			"Onboarding.TimeoutScheduled",
+			"Onboarding.TimeoutFired",
+			"Onboarding.Compensating",
+			"Onboarding.StateChanged",
+			"Onboarding.Compensated",
+			"Onboarding.Failed",
+		}, eventTypes)
+	})
+}
+
+// appendOnlyStore hides AppendBatch of the wrapped store
+type appendOnlyStore struct {
+	eventstore.Store
+}
+
+func TestNewProcessManager_RequiresBatchAppender(t *testing.T) {
+	store := &appendOnlyStore{Store: eventstore.NewInMemoryStore()}
+	_, err := NewProcessManager[onboarding](store, consumerstore.NewInMemoryConsumerApiProvider(), "Onboarding")
+	assert.ErrorIs(t, err, crudstore.InvalidArgumentError)
+}
@@ -18,6 +18,10 @@ type Event struct {
 
 	// OccurredOn is the UTC timestamp when the event occurred
 	OccurredOn time.Time `json:"occurred_on" gorm:"column:occurred_on"`
+
+	// Metadata is optional information about the event which is not part of
+	// the payload (e.g. the user or the request that caused it)
+	Metadata map[string]string `json:"metadata,omitempty" gorm:"-"`
 }
 
 // NewEvent creates a new Event with the given parameters
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"github.com/go-ozzo/ozzo-validation"
+	"github.com/makkalot/eskit/lib/consumer"
+	"github.com/makkalot/eskit/lib/consumerstore"
 	"github.com/makkalot/eskit/lib/crudstore"
 	"github.com/makkalot/eskit/lib/eventstore"
 	"github.com/makkalot/eskit/services/camconfig/provider"
@@ -17,6 +19,11 @@ type CamConfigServiceConfig struct {
 	ListenAddr  string `json:"listenAddr" mapstructure:"listenAddr"`
 	DbUri       string `json:"dbUri" mapstructure:"dbUri"`
 	TemplateDir string `json:"templateDir" mapstructure:"templateDir"`
+	// StateCacheSize is the number of entity states cached in memory, 0 disables the cache
+	StateCacheSize int `json:"stateCacheSize" mapstructure:"stateCacheSize"`
+	// AdminToken is the bearer token of the consumer administration, it refuses all of
+	// the requests when empty
+	AdminToken string `json:"adminToken" mapstructure:"adminToken"`
 }
 
 func (c CamConfigServiceConfig) Validate() error {
@@ -30,7 +37,10 @@ func main() {
 	viper.SetDefault("listenAddr", ":8081")
 	viper.SetDefault("dbUri", "inmemory://")
 	viper.SetDefault("templateDir", "./web/templates")
+	viper.SetDefault("stateCacheSize", 1000)
 	viper.BindEnv("dbUri", "DB_URI")
+	viper.BindEnv("stateCacheSize", "STATE_CACHE_SIZE")
+	viper.BindEnv("adminToken", "ADMIN_TOKEN")
 
 	viper.SetConfigName("config")
 	viper.AddConfigPath("/etc/camconfig")
@@ -57,8 +67,10 @@ func main() {
 
 	// Create event store (in-memory for this example)
 	var estore eventstore.Store
+	var consumerStore consumerstore.Store
 	if config.DbUri == "inmemory://" {
 		estore = eventstore.NewInMemoryStore()
+		consumerStore = consumerstore.NewInMemoryConsumerApiProvider()
 		log.Println("Using in-memory event store")
 	} else {
 		var err error
@@ -66,18 +78,20 @@ func main() {
 		if err != nil {
 			log.Fatalf("failed to create event store: %v", err)
 		}
+
+		consumerStore, err = consumerstore.NewSQLConsumerApiProvider(config.DbUri)
+		if err != nil {
+			log.Fatalf("failed to create consumer store: %v", err)
+		}
 		log.Println("Using PostgreSQL event store")
 	}
 
-	// Create CRUD store from the same event store instance
-	crudStore, err := crudstore.NewCrudStoreProvider(context.Background(), estore)
+	// Create CRUD store client from the same event store instance
+	crudStoreClient, err := crudstore.NewClientWithStoreAndCache(context.Background(), estore, config.StateCacheSize)
 	if err != nil {
-		log.Fatalf("creating crud store provider failed : %v", err)
+		log.Fatalf("creating crud store client failed : %v", err)
 	}
 
-	// Create CRUD store client with the same event store
-	crudStoreClient := crudstore.NewClientWithStore(crudStore)
-
 	// Create service provider with the same event store
 	camConfigProvider, err := provider.NewCamConfigServiceProvider(crudStoreClient, estore)
 	if err != nil {
@@ -106,6 +120,8 @@ func main() {
 			camConfigProvider.GetCamConfigHandler(w, r)
 		case http.MethodPut:
 			camConfigProvider.UpdateCamConfigHandler(w, r)
+		case http.MethodPatch:
+			camConfigProvider.PatchCamConfigHandler(w, r)
 		case http.MethodDelete:
 			camConfigProvider.DeleteCamConfigHandler(w, r)
 		default:
@@ -113,6 +129,15 @@ func main() {
 		}
 	})
 
+	// Atomic bulk operations
+	mux.HandleFunc("/v1/camconfigs/bulk", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		camConfigProvider.BulkCamConfigsHandler(w, r)
+	})
+
 	// Web interface endpoints
 	mux.HandleFunc("/web/", camConfigProvider.WebIndexHandler)
 	mux.HandleFunc("/web/create", camConfigProvider.WebCreateHandler)
@@ -130,6 +155,19 @@ func main() {
 		}
 	})
 
+	// Consumer administration, the service doesn't run consumers so they can't be paused
+	// here, only the offsets in the consumer store are reset
+	consumerAdmin, err := consumer.NewAdminHandler(estore, consumerStore)
+	if err != nil {
+		log.Fatalf("consumer admin failed initializing : %v", err)
+	}
+	if config.AdminToken == "" {
+		log.Println("ADMIN_TOKEN is not set, the consumer administration refuses all requests")
+	}
+	consumerAdmin.WithToken(config.AdminToken)
+	mux.Handle("/admin/consumers", consumerAdmin)
+	mux.Handle("/admin/consumers/", consumerAdmin)
+
 	// Prometheus metrics endpoint
 	mux.Handle("/metrics", promhttp.Handler())
 
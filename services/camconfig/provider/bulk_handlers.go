@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"encoding/json"
+	"github.com/makkalot/eskit/lib/crudrest"
+	"net/http"
+)
+
+// BulkCamConfigOperation is a single change of a bulk request, Data is a CreateCamConfigRequest for
+// creates and an UpdateCamConfigRequest for updates. Updates and deletes need the id and version.
+type BulkCamConfigOperation = crudrest.BulkOperation
+
+type BulkCamConfigsRequest struct {
+	Operations []*BulkCamConfigOperation `json:"operations"`
+}
+
+type BulkResult = crudrest.BulkResult
+
+type BulkCamConfigsResponse struct {
+	Results []*BulkResult `json:"results"`
+}
+
+// BulkCamConfigsHandler applies all of the operations atomically, either every one of them is
+// stored or none. The response has a result for every operation in the request order.
+func (s *CamConfigServiceProvider) BulkCamConfigsHandler(w http.ResponseWriter, r *http.Request) {
+	var req BulkCamConfigsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON request body")
+		return
+	}
+
+	results, status, err := crudrest.ApplyBulk(s.crudStore, req.Operations, crudrest.BulkEntity[CamConfig]{
+		Create: func(data json.RawMessage) (*CamConfig, error) {
+			var create CreateCamConfigRequest
+			if err := json.Unmarshal(data, &create); err != nil {
+				return nil, err
+			}
+			return &CamConfig{
+				CameraID:   create.CameraID,
+				Name:       create.Name,
+				Gamma:      create.Gamma,
+				Exposure:   create.Exposure,
+				Saturation: create.Saturation,
+				Sharpness:  create.Sharpness,
+				Gain:       create.Gain,
+			}, nil
+		},
+		// the updates are applied to the current state like UpdateCamConfigHandler does
+		Update: func(config *CamConfig, data json.RawMessage) error {
+			var update UpdateCamConfigRequest
+			if err := json.Unmarshal(data, &update); err != nil {
+				return err
+			}
+			applyCamConfigUpdate(config, &update)
+			return nil
+		},
+	})
+	if results == nil {
+		_, code := crudrest.BulkErrorStatus(err)
+		writeError(w, status, code, err.Error())
+		return
+	}
+
+	writeJSON(w, status, BulkCamConfigsResponse{Results: results})
+}
@@ -3,10 +3,13 @@ package provider
 import (
 	"encoding/json"
 	"errors"
+	"github.com/makkalot/eskit/lib/crudrest"
 	"github.com/makkalot/eskit/lib/crudstore"
 	"github.com/makkalot/eskit/lib/types"
+	"mime"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 // REST API Request/Response types
@@ -42,6 +45,13 @@ type CamConfigResponse struct {
 	Gain       int               `json:"gain"`
 }
 
+type ListCamConfigsResponse struct {
+	CamConfigs []*CamConfigResponse `json:"camConfigs"`
+	// NextCursor is passed as the cursor parameter to get the next page, empty at the end.
+	// The asOf pages can be shorter than their size before the end.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message"`
@@ -82,6 +92,31 @@ func camConfigToResponse(c *CamConfig) *CamConfigResponse {
 	}
 }
 
+// applyCamConfigUpdate copies the fields set in the request to the config
+func applyCamConfigUpdate(config *CamConfig, req *UpdateCamConfigRequest) {
+	if req.CameraID != "" {
+		config.CameraID = req.CameraID
+	}
+	if req.Name != "" {
+		config.Name = req.Name
+	}
+	if req.Gamma != 0 {
+		config.Gamma = req.Gamma
+	}
+	if req.Exposure != 0 {
+		config.Exposure = req.Exposure
+	}
+	if req.Saturation != 0 {
+		config.Saturation = req.Saturation
+	}
+	if req.Sharpness != 0 {
+		config.Sharpness = req.Sharpness
+	}
+	if req.Gain != 0 {
+		config.Gain = req.Gain
+	}
+}
+
 // REST Handlers
 
 func (s *CamConfigServiceProvider) HealthHandler(w http.ResponseWriter, r *http.Request) {
@@ -123,6 +158,22 @@ func (s *CamConfigServiceProvider) GetCamConfigHandler(w http.ResponseWriter, r
 	versionStr := r.URL.Query().Get("version")
 	fetchDeleted := r.URL.Query().Get("fetchDeleted") == "true"
 
+	if asOfStr := r.URL.Query().Get("asOf"); asOfStr != "" {
+		if versionStr != "" || fetchDeleted {
+			writeError(w, http.StatusBadRequest, "invalid_request", "'asOf' can't be combined with 'version' or 'fetchDeleted'")
+			return
+		}
+
+		asOf, err := time.Parse(time.RFC3339, asOfStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Invalid 'asOf' parameter, it should be an RFC 3339 timestamp")
+			return
+		}
+
+		s.writeCamConfigsAsOf(w, r, id, asOf)
+		return
+	}
+
 	if id == "" {
 		writeError(w, http.StatusBadRequest, "invalid_request", "Missing 'id' parameter")
 		return
@@ -200,37 +251,90 @@ func (s *CamConfigServiceProvider) UpdateCamConfigHandler(w http.ResponseWriter,
 	}
 
 	// Update fields from request (only if provided)
-	if req.CameraID != "" {
-		retrievedConfig.CameraID = req.CameraID
+	applyCamConfigUpdate(retrievedConfig, &req)
+
+	// Update using library with native types
+	updatedOriginator, err := s.crudStore.Update(retrievedConfig)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "update_failed", err.Error())
+		return
 	}
-	if req.Name != "" {
-		retrievedConfig.Name = req.Name
+
+	retrievedConfig.Originator = updatedOriginator
+	writeJSON(w, http.StatusOK, camConfigToResponse(retrievedConfig))
+}
+
+// camConfigPatchFields maps the JSON Patch paths of the REST API to the stored CamConfig fields
+var camConfigPatchFields = map[string]string{
+	"cameraId":   "CameraID",
+	"name":       "Name",
+	"gamma":      "Gamma",
+	"exposure":   "Exposure",
+	"saturation": "Saturation",
+	"sharpness":  "Sharpness",
+	"gain":       "Gain",
+}
+
+func (s *CamConfigServiceProvider) PatchCamConfigHandler(w http.ResponseWriter, r *http.Request) {
+	// Get ID and Version from query parameters
+	id := r.URL.Query().Get("id")
+	versionStr := r.URL.Query().Get("version")
+
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Missing 'id' parameter")
+		return
 	}
-	if req.Gamma != 0 {
-		retrievedConfig.Gamma = req.Gamma
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json-patch+json" {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type should be application/json-patch+json")
+		return
 	}
-	if req.Exposure != 0 {
-		retrievedConfig.Exposure = req.Exposure
+
+	var version uint64
+	if versionStr != "" {
+		v, err := strconv.ParseUint(versionStr, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Invalid 'version' parameter")
+			return
+		}
+		version = v
 	}
-	if req.Saturation != 0 {
-		retrievedConfig.Saturation = req.Saturation
+
+	var ops []crudstore.PatchOperation
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON Patch request body")
+		return
 	}
-	if req.Sharpness != 0 {
-		retrievedConfig.Sharpness = req.Sharpness
+
+	ops, err = crudstore.RenamePatchFields(ops, camConfigPatchFields)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
 	}
-	if req.Gain != 0 {
-		retrievedConfig.Gain = req.Gain
+
+	nativeOriginator := &types.Originator{
+		ID:      id,
+		Version: version,
 	}
 
-	// Update using library with native types
-	updatedOriginator, err := s.crudStore.Update(retrievedConfig)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "update_failed", err.Error())
+	// Patch using library with native types
+	patchedConfig := &CamConfig{}
+	if _, err := s.crudStore.Patch(nativeOriginator, ops, patchedConfig); err != nil {
+		switch {
+		case errors.Is(err, crudstore.RecordNotFound) || errors.Is(err, crudstore.RecordDeleted):
+			writeError(w, http.StatusNotFound, "not_found", "Camera config not found")
+		case errors.Is(err, crudstore.InvalidArgumentError):
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		case errors.Is(err, crudstore.PatchFailed) || crudstore.IsDuplicate(err):
+			writeError(w, http.StatusConflict, "patch_failed", err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, "patch_failed", err.Error())
+		}
 		return
 	}
 
-	retrievedConfig.Originator = updatedOriginator
-	writeJSON(w, http.StatusOK, camConfigToResponse(retrievedConfig))
+	writeJSON(w, http.StatusOK, camConfigToResponse(patchedConfig))
 }
 
 func (s *CamConfigServiceProvider) DeleteCamConfigHandler(w http.ResponseWriter, r *http.Request) {
@@ -269,3 +373,45 @@ func (s *CamConfigServiceProvider) DeleteCamConfigHandler(w http.ResponseWriter,
 		"originator": deletedOriginator,
 	})
 }
+
+// writeCamConfigsAsOf writes the camera config with the given id as it was at asOf, or all of the camera configs
+// which existed then when the id is empty
+func (s *CamConfigServiceProvider) writeCamConfigsAsOf(w http.ResponseWriter, r *http.Request, id string, asOf time.Time) {
+	if id == "" {
+		size, err := crudrest.AsOfListSize(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+
+		var configs []*CamConfig
+		nextCursor, err := s.crudStore.ListAsOf(&configs, asOf, r.URL.Query().Get("cursor"), size)
+		if errors.Is(err, crudstore.InvalidArgumentError) {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Invalid 'cursor' parameter")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+
+		response := ListCamConfigsResponse{CamConfigs: []*CamConfigResponse{}, NextCursor: nextCursor}
+		for _, config := range configs {
+			response.CamConfigs = append(response.CamConfigs, camConfigToResponse(config))
+		}
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	retrieved := &CamConfig{}
+	if err := s.crudStore.GetAsOf(id, asOf, retrieved); err != nil {
+		if errors.Is(err, crudstore.RecordNotFound) || errors.Is(err, crudstore.RecordDeleted) {
+			writeError(w, http.StatusNotFound, "not_found", "Camera config not found or deleted at the given time")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, camConfigToResponse(retrieved))
+}
@@ -3,13 +3,15 @@ package provider
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/makkalot/eskit/lib/eventstore"
 	"github.com/makkalot/eskit/lib/types"
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
-	"gopkg.in/evanphx/json-patch.v3"
+	"time"
 )
 
 var templates *template.Template
@@ -177,13 +179,14 @@ func (s *CamConfigServiceProvider) WebDeleteHandler(w http.ResponseWriter, r *ht
 
 // AuditLogEntry represents a parsed audit log entry for display
 type AuditLogEntry struct {
-	ID         uint64
 	EventType  string
 	CameraID   string
 	ConfigID   string
 	Version    uint64
 	OccurredOn string
 	Changes    []FieldChange
+
+	occurredOn time.Time
 }
 
 // FieldChange represents a change to a field
@@ -193,86 +196,101 @@ type FieldChange struct {
 	NewValue string
 }
 
-// WebAuditLogHandler displays the audit log
+// auditPageSize is the number of config streams the audit log reads at once
+const auditPageSize = 100
+
+// WebAuditLogHandler displays the audit log built from the history of the configs
 func (s *CamConfigServiceProvider) WebAuditLogHandler(w http.ResponseWriter, r *http.Request) {
 	// Get filter parameters
 	filterID := r.URL.Query().Get("id")
 
-	// Get application logs
-	logs, err := s.eventStore.Logs(0, 1000, "CamConfig")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get logs: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	// Get all configs for dropdown
+	// Get all configs for dropdown, the deleted ones have history as well
 	var allConfigs []*CamConfig
-	s.crudStore.ListWithPagination(&allConfigs, "", 100)
+	cursor := ""
+	for {
+		var configs []*CamConfig
+		next, err := s.crudStore.ListWithQuery(&configs, &eventstore.EntityQuery{Cursor: cursor, Size: 100, Deleted: true})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list configs: %v", err), http.StatusInternalServerError)
+			return
+		}
 
-	// Parse logs and filter
-	var auditEntries []AuditLogEntry
-	previousStates := make(map[string]*CamConfig) // Store previous states by ID
+		allConfigs = append(allConfigs, configs...)
+		if len(configs) == 0 || next == "" {
+			break
+		}
+		cursor = next
+	}
 
-	for _, log := range logs {
-		event := log.Event
+	var auditEntries []AuditLogEntry
+	totalEvents := 0
+
+	// every event of a config increments its version, only the history of the
+	// selected config is read when filtered
+	var ids []string
+	for _, config := range allConfigs {
+		totalEvents += int(config.Originator.Version)
+		if filterID == "" || config.Originator.ID == filterID {
+			ids = append(ids, config.Originator.ID)
+		}
+	}
 
-		// Apply filter if specified
-		if filterID != "" && event.Originator.ID != filterID {
-			continue
+	// the histories are read in pages of streams instead of one replay per config
+	for start := 0; start < len(ids); start += auditPageSize {
+		end := start + auditPageSize
+		if end > len(ids) {
+			end = len(ids)
 		}
 
-		entry := AuditLogEntry{
-			ID:         log.ID,
-			EventType:  event.EventType,
-			ConfigID:   event.Originator.ID,
-			Version:    event.Originator.Version,
-			OccurredOn: event.OccurredOn.Format("2006-01-02 15:04:05"),
+		histories, err := s.crudStore.HistoryMany(ids[start:end])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get history: %v", err), http.StatusInternalServerError)
+			return
 		}
 
-		// Parse the payload to get the config state
-		var currentConfig CamConfig
-		if err := json.Unmarshal([]byte(event.Payload), &currentConfig); err == nil {
-			// For Updated events, the payload is a JSON Merge Patch
-			// We need to apply this patch to the previous state to get the complete current state
-			if strings.HasSuffix(event.EventType, ".Updated") {
-				if prevConfig, exists := previousStates[event.Originator.ID]; exists {
-					// Convert previous state to JSON
-					prevJSON, err := json.Marshal(prevConfig)
-					if err == nil {
-						// Apply the merge patch to get the new complete state
-						newJSON, err := jsonpatch.MergePatch(prevJSON, []byte(event.Payload))
-						if err == nil {
-							// Unmarshal the complete new state
-							if err := json.Unmarshal(newJSON, &currentConfig); err == nil {
-								entry.CameraID = currentConfig.CameraID
-								entry.Changes = calculateChanges(prevConfig, &currentConfig)
-							}
-						}
+		for _, id := range ids[start:end] {
+			for _, version := range histories[id] {
+				var state CamConfig
+				if err := version.DecodeState(&state); err != nil {
+					http.Error(w, fmt.Sprintf("Failed to decode history: %v", err), http.StatusInternalServerError)
+					return
+				}
+
+				entry := AuditLogEntry{
+					EventType:  version.EventType,
+					CameraID:   state.CameraID,
+					ConfigID:   version.Originator.ID,
+					Version:    version.Originator.Version,
+					OccurredOn: version.OccurredOn.Format("2006-01-02 15:04:05"),
+					occurredOn: version.OccurredOn,
+				}
+
+				// the created events would list every field, the state is enough for them
+				if !strings.HasSuffix(version.EventType, ".Created") {
+					for _, change := range version.Changes {
+						entry.Changes = append(entry.Changes, FieldChange{
+							Field:    change.Path,
+							OldValue: formatValue(change.OldValue),
+							NewValue: formatValue(change.NewValue),
+						})
 					}
-				} else {
-					// No previous state found, treat patch as complete state
-					entry.CameraID = currentConfig.CameraID
-					entry.Changes = calculateChanges(&CamConfig{}, &currentConfig)
 				}
-			} else {
-				// For Created events, the payload contains the complete state
-				entry.CameraID = currentConfig.CameraID
-			}
 
-			// Store current state for next iteration (but only if this is not a Delete event)
-			if !strings.HasSuffix(event.EventType, ".Deleted") {
-				previousStates[event.Originator.ID] = &currentConfig
+				auditEntries = append(auditEntries, entry)
 			}
 		}
-
-		auditEntries = append(auditEntries, entry)
 	}
 
+	// show the events of all configs in the order they happened
+	sort.SliceStable(auditEntries, func(i, j int) bool {
+		return auditEntries[i].occurredOn.Before(auditEntries[j].occurredOn)
+	})
+
 	data := map[string]interface{}{
 		"Entries":     auditEntries,
 		"FilterID":    filterID,
 		"AllConfigs":  allConfigs,
-		"TotalEvents": len(logs),
+		"TotalEvents": totalEvents,
 	}
 
 	if err := checkTemplates(); err != nil {
@@ -285,31 +303,20 @@ func (s *CamConfigServiceProvider) WebAuditLogHandler(w http.ResponseWriter, r *
 	}
 }
 
-// calculateChanges compares two configs and returns the changes
-func calculateChanges(old, new *CamConfig) []FieldChange {
-	var changes []FieldChange
-
-	if old.CameraID != new.CameraID {
-		changes = append(changes, FieldChange{"Camera ID", old.CameraID, new.CameraID})
-	}
-	if old.Name != new.Name {
-		changes = append(changes, FieldChange{"Name", old.Name, new.Name})
-	}
-	if old.Gamma != new.Gamma {
-		changes = append(changes, FieldChange{"Gamma", fmt.Sprintf("%.2f", old.Gamma), fmt.Sprintf("%.2f", new.Gamma)})
-	}
-	if old.Exposure != new.Exposure {
-		changes = append(changes, FieldChange{"Exposure", strconv.Itoa(old.Exposure), strconv.Itoa(new.Exposure)})
-	}
-	if old.Saturation != new.Saturation {
-		changes = append(changes, FieldChange{"Saturation", strconv.Itoa(old.Saturation), strconv.Itoa(new.Saturation)})
-	}
-	if old.Sharpness != new.Sharpness {
-		changes = append(changes, FieldChange{"Sharpness", strconv.Itoa(old.Sharpness), strconv.Itoa(new.Sharpness)})
-	}
-	if old.Gain != new.Gain {
-		changes = append(changes, FieldChange{"Gain", strconv.Itoa(old.Gain), strconv.Itoa(new.Gain)})
+// formatValue formats a value from the history changes for display
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
 	}
-
-	return changes
 }
@@ -3,7 +3,10 @@ package main
 import (
 	"context"
 	"github.com/go-ozzo/ozzo-validation"
+	"github.com/makkalot/eskit/lib/consumer"
+	"github.com/makkalot/eskit/lib/consumerstore"
 	"github.com/makkalot/eskit/lib/crudstore"
+	"github.com/makkalot/eskit/lib/eventstore"
 	"github.com/makkalot/eskit/services/users/provider"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
@@ -14,6 +17,11 @@ import (
 type UserStoreConfig struct {
 	ListenAddr string `json:"listenAddr" mapstructure:"listenAddr"`
 	DbUri      string `json:"dbUri" mapstructure:"dbUri"`
+	// StateCacheSize is the number of entity states cached in memory, 0 disables the cache
+	StateCacheSize int `json:"stateCacheSize" mapstructure:"stateCacheSize"`
+	// AdminToken is the bearer token of the consumer administration, it refuses all of
+	// the requests when empty
+	AdminToken string `json:"adminToken" mapstructure:"adminToken"`
 }
 
 func (c UserStoreConfig) Validate() error {
@@ -26,7 +34,10 @@ func (c UserStoreConfig) Validate() error {
 func main() {
 
 	viper.SetDefault("listenAddr", ":8080")
+	viper.SetDefault("stateCacheSize", 1000)
 	viper.BindEnv("dbUri", "DB_URI")
+	viper.BindEnv("stateCacheSize", "STATE_CACHE_SIZE")
+	viper.BindEnv("adminToken", "ADMIN_TOKEN")
 
 	viper.SetConfigName("config")
 	viper.AddConfigPath("/etc/userstore")
@@ -49,9 +60,27 @@ func main() {
 
 	log.Println("Going to listen on : ", config.ListenAddr)
 
-	crudStoreClient, err := crudstore.NewClient(context.Background(), config.DbUri)
+	// the event store is shared by the crud store and the consumer administration
+	var estore eventstore.Store
+	var consumerStore consumerstore.Store
+	if config.DbUri == "inmemory://" {
+		estore = eventstore.NewInMemoryStore()
+		consumerStore = consumerstore.NewInMemoryConsumerApiProvider()
+	} else {
+		estore, err = eventstore.NewSqlStore("postgres", config.DbUri)
+		if err != nil {
+			log.Fatalf("failed to create event store: %v", err)
+		}
+
+		consumerStore, err = consumerstore.NewSQLConsumerApiProvider(config.DbUri)
+		if err != nil {
+			log.Fatalf("failed to create consumer store: %v", err)
+		}
+	}
+
+	crudStoreClient, err := crudstore.NewClientWithStoreAndCache(context.Background(), estore, config.StateCacheSize)
 	if err != nil {
-		log.Fatalf("creating crudstore client failed : %v", err)
+		log.Fatalf("creating crud store client failed : %v", err)
 	}
 
 	userProvider, err := provider.NewUserServiceProvider(crudStoreClient)
@@ -74,6 +103,8 @@ func main() {
 			userProvider.GetUserHandler(w, r)
 		case http.MethodPut:
 			userProvider.UpdateUserHandler(w, r)
+		case http.MethodPatch:
+			userProvider.PatchUserHandler(w, r)
 		case http.MethodDelete:
 			userProvider.DeleteUserHandler(w, r)
 		default:
@@ -81,6 +112,28 @@ func main() {
 		}
 	})
 
+	// Atomic bulk operations
+	mux.HandleFunc("/v1/users/bulk", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userProvider.BulkUsersHandler(w, r)
+	})
+
+	// Consumer administration, the service doesn't run consumers so they can't be paused
+	// here, only the offsets in the consumer store are reset
+	consumerAdmin, err := consumer.NewAdminHandler(estore, consumerStore)
+	if err != nil {
+		log.Fatalf("consumer admin failed initializing : %v", err)
+	}
+	if config.AdminToken == "" {
+		log.Println("ADMIN_TOKEN is not set, the consumer administration refuses all requests")
+	}
+	consumerAdmin.WithToken(config.AdminToken)
+	mux.Handle("/admin/consumers", consumerAdmin)
+	mux.Handle("/admin/consumers/", consumerAdmin)
+
 	// Prometheus metrics endpoint
 	mux.Handle("/metrics", promhttp.Handler())
 
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"encoding/json"
+	"github.com/makkalot/eskit/lib/crudrest"
+	"net/http"
+)
+
+// BulkUserOperation is a single change of a bulk request, Data is a CreateUserRequest for
+// creates and an UpdateUserRequest for updates. Updates and deletes need the id and version.
+type BulkUserOperation = crudrest.BulkOperation
+
+type BulkUsersRequest struct {
+	Operations []*BulkUserOperation `json:"operations"`
+}
+
+type BulkResult = crudrest.BulkResult
+
+type BulkUsersResponse struct {
+	Results []*BulkResult `json:"results"`
+}
+
+// BulkUsersHandler applies all of the operations atomically, either every one of them is
+// stored or none. The response has a result for every operation in the request order.
+func (u *UserServiceProvider) BulkUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var req BulkUsersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON request body")
+		return
+	}
+
+	results, status, err := crudrest.ApplyBulk(u.crudStore, req.Operations, crudrest.BulkEntity[User]{
+		Create: func(data json.RawMessage) (*User, error) {
+			var create CreateUserRequest
+			if err := json.Unmarshal(data, &create); err != nil {
+				return nil, err
+			}
+			return &User{
+				Email:     create.Email,
+				FirstName: create.FirstName,
+				LastName:  create.LastName,
+			}, nil
+		},
+		// the updates are applied to the current state like UpdateUserHandler does
+		Update: func(user *User, data json.RawMessage) error {
+			var update UpdateUserRequest
+			if err := json.Unmarshal(data, &update); err != nil {
+				return err
+			}
+			applyUserUpdate(user, &update)
+			return nil
+		},
+	})
+	if results == nil {
+		_, code := crudrest.BulkErrorStatus(err)
+		writeError(w, status, code, err.Error())
+		return
+	}
+
+	writeJSON(w, status, BulkUsersResponse{Results: results})
+}
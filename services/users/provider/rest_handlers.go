@@ -3,10 +3,13 @@ package provider
 import (
 	"encoding/json"
 	"errors"
+	"github.com/makkalot/eskit/lib/crudrest"
 	"github.com/makkalot/eskit/lib/crudstore"
 	"github.com/makkalot/eskit/lib/types"
+	"mime"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 // REST API Request/Response types
@@ -34,6 +37,13 @@ type UserResponse struct {
 	Workspaces []string          `json:"workspaces"`
 }
 
+type ListUsersResponse struct {
+	Users []*UserResponse `json:"users"`
+	// NextCursor is passed as the cursor parameter to get the next page, empty at the end.
+	// The asOf pages can be shorter than their size before the end.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message"`
@@ -72,6 +82,23 @@ func userToResponse(u *User) *UserResponse {
 	}
 }
 
+// applyUserUpdate copies the fields set in the request to the user
+func applyUserUpdate(user *User, req *UpdateUserRequest) {
+	if req.Email != "" {
+		user.Email = req.Email
+	}
+	if req.FirstName != "" {
+		user.FirstName = req.FirstName
+	}
+	if req.LastName != "" {
+		user.LastName = req.LastName
+	}
+	user.Active = req.Active
+	if req.Workspaces != nil {
+		user.Workspaces = req.Workspaces
+	}
+}
+
 // REST Handlers
 
 func (u *UserServiceProvider) HealthHandler(w http.ResponseWriter, r *http.Request) {
@@ -109,6 +136,22 @@ func (u *UserServiceProvider) GetUserHandler(w http.ResponseWriter, r *http.Requ
 	versionStr := r.URL.Query().Get("version")
 	fetchDeleted := r.URL.Query().Get("fetchDeleted") == "true"
 
+	if asOfStr := r.URL.Query().Get("asOf"); asOfStr != "" {
+		if versionStr != "" || fetchDeleted {
+			writeError(w, http.StatusBadRequest, "invalid_request", "'asOf' can't be combined with 'version' or 'fetchDeleted'")
+			return
+		}
+
+		asOf, err := time.Parse(time.RFC3339, asOfStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Invalid 'asOf' parameter, it should be an RFC 3339 timestamp")
+			return
+		}
+
+		u.writeUsersAsOf(w, r, id, asOf)
+		return
+	}
+
 	if id == "" {
 		writeError(w, http.StatusBadRequest, "invalid_request", "Missing 'id' parameter")
 		return
@@ -186,19 +229,7 @@ func (u *UserServiceProvider) UpdateUserHandler(w http.ResponseWriter, r *http.R
 	}
 
 	// Update fields from request
-	if req.Email != "" {
-		retrievedUser.Email = req.Email
-	}
-	if req.FirstName != "" {
-		retrievedUser.FirstName = req.FirstName
-	}
-	if req.LastName != "" {
-		retrievedUser.LastName = req.LastName
-	}
-	retrievedUser.Active = req.Active
-	if req.Workspaces != nil {
-		retrievedUser.Workspaces = req.Workspaces
-	}
+	applyUserUpdate(retrievedUser, &req)
 
 	// Update using library with native types
 	updatedOriginator, err := u.crudStore.Update(retrievedUser)
@@ -211,6 +242,77 @@ func (u *UserServiceProvider) UpdateUserHandler(w http.ResponseWriter, r *http.R
 	writeJSON(w, http.StatusOK, userToResponse(retrievedUser))
 }
 
+// userPatchFields maps the JSON Patch paths of the REST API to the stored User fields
+var userPatchFields = map[string]string{
+	"email":      "Email",
+	"firstName":  "FirstName",
+	"lastName":   "LastName",
+	"active":     "Active",
+	"workspaces": "Workspaces",
+}
+
+func (u *UserServiceProvider) PatchUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get ID and Version from query parameters
+	id := r.URL.Query().Get("id")
+	versionStr := r.URL.Query().Get("version")
+
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Missing 'id' parameter")
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json-patch+json" {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type should be application/json-patch+json")
+		return
+	}
+
+	var version uint64
+	if versionStr != "" {
+		v, err := strconv.ParseUint(versionStr, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Invalid 'version' parameter")
+			return
+		}
+		version = v
+	}
+
+	var ops []crudstore.PatchOperation
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON Patch request body")
+		return
+	}
+
+	ops, err = crudstore.RenamePatchFields(ops, userPatchFields)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	nativeOriginator := &types.Originator{
+		ID:      id,
+		Version: version,
+	}
+
+	// Patch using library with native types
+	patchedUser := &User{}
+	if _, err := u.crudStore.Patch(nativeOriginator, ops, patchedUser); err != nil {
+		switch {
+		case errors.Is(err, crudstore.RecordNotFound) || errors.Is(err, crudstore.RecordDeleted):
+			writeError(w, http.StatusNotFound, "not_found", "User not found")
+		case errors.Is(err, crudstore.InvalidArgumentError):
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		case errors.Is(err, crudstore.PatchFailed) || crudstore.IsDuplicate(err):
+			writeError(w, http.StatusConflict, "patch_failed", err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, "patch_failed", err.Error())
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, userToResponse(patchedUser))
+}
+
 func (u *UserServiceProvider) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Get ID and Version from query parameters
 	id := r.URL.Query().Get("id")
@@ -247,3 +349,45 @@ func (u *UserServiceProvider) DeleteUserHandler(w http.ResponseWriter, r *http.R
 		"originator": deletedOriginator,
 	})
 }
+
+// writeUsersAsOf writes the user with the given id as it was at asOf, or all of the users
+// which existed then when the id is empty
+func (u *UserServiceProvider) writeUsersAsOf(w http.ResponseWriter, r *http.Request, id string, asOf time.Time) {
+	if id == "" {
+		size, err := crudrest.AsOfListSize(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+
+		var users []*User
+		nextCursor, err := u.crudStore.ListAsOf(&users, asOf, r.URL.Query().Get("cursor"), size)
+		if errors.Is(err, crudstore.InvalidArgumentError) {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Invalid 'cursor' parameter")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+
+		response := ListUsersResponse{Users: []*UserResponse{}, NextCursor: nextCursor}
+		for _, user := range users {
+			response.Users = append(response.Users, userToResponse(user))
+		}
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	retrieved := &User{}
+	if err := u.crudStore.GetAsOf(id, asOf, retrieved); err != nil {
+		if errors.Is(err, crudstore.RecordNotFound) || errors.Is(err, crudstore.RecordDeleted) {
+			writeError(w, http.StatusNotFound, "not_found", "User not found or deleted at the given time")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, userToResponse(retrieved))
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/makkalot/eskit/lib/types"
 	. "github.com/onsi/ginkgo/v2"
@@ -44,6 +46,28 @@ type CamConfigResponse struct {
 	Gain       int               `json:"gain"`
 }
 
+type ListCamConfigsResponse struct {
+	CamConfigs []*CamConfigResponse `json:"camConfigs"`
+	NextCursor string               `json:"nextCursor"`
+}
+
+type BulkCamConfigOperation struct {
+	Op      string      `json:"op"`
+	ID      string      `json:"id,omitempty"`
+	Version uint64      `json:"version,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type BulkResult struct {
+	Originator *types.Originator `json:"originator"`
+	Error      string            `json:"error"`
+	Message    string            `json:"message"`
+}
+
+type BulkCamConfigsResponse struct {
+	Results []*BulkResult `json:"results"`
+}
+
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message"`
@@ -442,4 +466,158 @@ var _ = Describe("CamConfig", func() {
 			Expect(errResp.Error).To(Equal("invalid_request"))
 		})
 	})
+
+	createConfig := func(req *CreateCamConfigRequest) *CamConfigResponse {
+		jsonData, err := json.Marshal(req)
+		Expect(err).To(BeNil())
+
+		resp, err := httpClient.Post(baseURL, "application/json", bytes.NewBuffer(jsonData))
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+		var createResp CamConfigResponse
+		Expect(json.NewDecoder(resp.Body).Decode(&createResp)).To(BeNil())
+		return &createResp
+	}
+
+	patchConfig := func(id string, version uint64, patch string) *http.Response {
+		patchURL := fmt.Sprintf("%s?id=%s&version=%d", baseURL, id, version)
+		req, err := http.NewRequest(http.MethodPatch, patchURL, bytes.NewBufferString(patch))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "application/json-patch+json")
+
+		resp, err := httpClient.Do(req)
+		Expect(err).To(BeNil())
+		return resp
+	}
+
+	getConfig := func(id string) *CamConfigResponse {
+		getResp, err := httpClient.Get(fmt.Sprintf("%s?id=%s", baseURL, id))
+		Expect(err).To(BeNil())
+		defer getResp.Body.Close()
+		Expect(getResp.StatusCode).To(Equal(http.StatusOK))
+
+		var config CamConfigResponse
+		Expect(json.NewDecoder(getResp.Body).Decode(&config)).To(BeNil())
+		return &config
+	}
+
+	Context("When CamConfig is Patched", func() {
+		It("Should refuse a stale version", func() {
+			created := createConfig(&CreateCamConfigRequest{
+				CameraID: "CAM-PATCH-TEST",
+				Name:     "Patch Test Camera",
+				Gamma:    1.0,
+			})
+
+			resp := patchConfig(created.Originator.ID, 1, `[{"op":"replace","path":"/gamma","value":1.5}]`)
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			// the patch is based on the version 1 which isn't the latest anymore
+			staleResp := patchConfig(created.Originator.ID, 1, `[{"op":"replace","path":"/gamma","value":2.2}]`)
+			defer staleResp.Body.Close()
+			Expect(staleResp.StatusCode).To(Equal(http.StatusConflict))
+
+			var errResp ErrorResponse
+			Expect(json.NewDecoder(staleResp.Body).Decode(&errResp)).To(BeNil())
+			Expect(errResp.Error).To(Equal("patch_failed"))
+
+			config := getConfig(created.Originator.ID)
+			Expect(config.Gamma).To(Equal(1.5))
+			Expect(config.Originator.Version).To(Equal(uint64(2)))
+		})
+	})
+
+	Context("When CamConfigs are changed in bulk", func() {
+		It("Should store nothing when an operation fails", func() {
+			var configs []*CamConfigResponse
+			for _, name := range []string{"First", "Second"} {
+				created := createConfig(&CreateCamConfigRequest{
+					CameraID: "CAM-BULK-" + name,
+					Name:     name,
+					Exposure: 1000,
+				})
+
+				resp := patchConfig(created.Originator.ID, 1, `[{"op":"replace","path":"/gain","value":40}]`)
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				configs = append(configs, created)
+			}
+
+			jsonData, err := json.Marshal(map[string]interface{}{
+				"operations": []*BulkCamConfigOperation{
+					{Op: "create", Data: &CreateCamConfigRequest{CameraID: "CAM-BULK-CREATED", Name: "Created"}},
+					{Op: "update", ID: configs[0].Originator.ID, Version: 2, Data: &UpdateCamConfigRequest{Exposure: 2000}},
+					// the version 1 is stale, it fails the whole request
+					{Op: "update", ID: configs[1].Originator.ID, Version: 1, Data: &UpdateCamConfigRequest{Exposure: 2000}},
+				},
+			})
+			Expect(err).To(BeNil())
+
+			bulkResp, err := httpClient.Post(baseURL+"/bulk", "application/json", bytes.NewBuffer(jsonData))
+			Expect(err).To(BeNil())
+			defer bulkResp.Body.Close()
+			Expect(bulkResp.StatusCode).To(Equal(http.StatusConflict))
+
+			var bulk BulkCamConfigsResponse
+			Expect(json.NewDecoder(bulkResp.Body).Decode(&bulk)).To(BeNil())
+			Expect(bulk.Results).To(HaveLen(3))
+			Expect(bulk.Results[0].Error).To(Equal("aborted"))
+			Expect(bulk.Results[1].Error).To(Equal("aborted"))
+			Expect(bulk.Results[2].Error).To(Equal("conflict"))
+
+			// the valid update was rolled back with the rest
+			for _, created := range configs {
+				config := getConfig(created.Originator.ID)
+				Expect(config.Exposure).To(Equal(1000))
+				Expect(config.Originator.Version).To(Equal(uint64(2)))
+			}
+		})
+	})
+
+	Context("When CamConfigs are listed asOf", func() {
+		It("Should return the state they had then", func() {
+			created := createConfig(&CreateCamConfigRequest{
+				CameraID: "CAM-ASOF-TEST",
+				Name:     "Before",
+			})
+			asOf := time.Now().UTC()
+
+			resp := patchConfig(created.Originator.ID, 1, `[{"op":"replace","path":"/name","value":"After"}]`)
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var found *CamConfigResponse
+			cursor := ""
+			for {
+				listURL := fmt.Sprintf("%s?asOf=%s&size=100&cursor=%s", baseURL, url.QueryEscape(asOf.Format(time.RFC3339Nano)), url.QueryEscape(cursor))
+				listResp, err := httpClient.Get(listURL)
+				Expect(err).To(BeNil())
+				Expect(listResp.StatusCode).To(Equal(http.StatusOK))
+
+				var list ListCamConfigsResponse
+				err = json.NewDecoder(listResp.Body).Decode(&list)
+				listResp.Body.Close()
+				Expect(err).To(BeNil())
+
+				for _, config := range list.CamConfigs {
+					if config.Originator.ID == created.Originator.ID {
+						found = config
+					}
+				}
+
+				if list.NextCursor == "" {
+					break
+				}
+				cursor = list.NextCursor
+			}
+
+			Expect(found).NotTo(BeNil())
+			Expect(found.Name).To(Equal("Before"))
+			Expect(found.Originator.Version).To(Equal(uint64(1)))
+			Expect(getConfig(created.Originator.ID).Name).To(Equal("After"))
+		})
+	})
 })
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/makkalot/eskit/lib/types"
 	. "github.com/onsi/ginkgo/v2"
@@ -28,6 +30,28 @@ type UserResponse struct {
 	Workspaces []string          `json:"workspaces"`
 }
 
+type ListUsersResponse struct {
+	Users      []*UserResponse `json:"users"`
+	NextCursor string          `json:"nextCursor"`
+}
+
+type BulkUserOperation struct {
+	Op      string      `json:"op"`
+	ID      string      `json:"id,omitempty"`
+	Version uint64      `json:"version,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type BulkResult struct {
+	Originator *types.Originator `json:"originator"`
+	Error      string            `json:"error"`
+	Message    string            `json:"message"`
+}
+
+type BulkUsersResponse struct {
+	Results []*BulkResult `json:"results"`
+}
+
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message"`
@@ -177,4 +201,159 @@ var _ = Describe("Users", func() {
 			Expect(errResp.Error).To(Equal("not_found"))
 		})
 	})
+
+	createUser := func(req *CreateUserRequest) *UserResponse {
+		jsonData, err := json.Marshal(req)
+		Expect(err).To(BeNil())
+
+		resp, err := httpClient.Post(baseURL, "application/json", bytes.NewBuffer(jsonData))
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+		var createResp UserResponse
+		Expect(json.NewDecoder(resp.Body).Decode(&createResp)).To(BeNil())
+		return &createResp
+	}
+
+	patchUser := func(id string, version uint64, patch string) *http.Response {
+		patchURL := fmt.Sprintf("%s?id=%s&version=%d", baseURL, id, version)
+		req, err := http.NewRequest(http.MethodPatch, patchURL, bytes.NewBufferString(patch))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "application/json-patch+json")
+
+		resp, err := httpClient.Do(req)
+		Expect(err).To(BeNil())
+		return resp
+	}
+
+	getUser := func(id string) *UserResponse {
+		getResp, err := httpClient.Get(fmt.Sprintf("%s?id=%s", baseURL, id))
+		Expect(err).To(BeNil())
+		defer getResp.Body.Close()
+		Expect(getResp.StatusCode).To(Equal(http.StatusOK))
+
+		var user UserResponse
+		Expect(json.NewDecoder(getResp.Body).Decode(&user)).To(BeNil())
+		return &user
+	}
+
+	Context("When User is Patched", func() {
+		It("Should refuse a stale version", func() {
+			created := createUser(&CreateUserRequest{
+				Email:     "patch@eskit.com",
+				FirstName: "Patch",
+				LastName:  "Eskit",
+			})
+
+			resp := patchUser(created.Originator.ID, 1, `[{"op":"replace","path":"/firstName","value":"Patched"}]`)
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			// the patch is based on the version 1 which isn't the latest anymore
+			staleResp := patchUser(created.Originator.ID, 1, `[{"op":"replace","path":"/firstName","value":"Stale"}]`)
+			defer staleResp.Body.Close()
+			Expect(staleResp.StatusCode).To(Equal(http.StatusConflict))
+
+			var errResp ErrorResponse
+			Expect(json.NewDecoder(staleResp.Body).Decode(&errResp)).To(BeNil())
+			Expect(errResp.Error).To(Equal("patch_failed"))
+
+			user := getUser(created.Originator.ID)
+			Expect(user.FirstName).To(Equal("Patched"))
+			Expect(user.Originator.Version).To(Equal(uint64(2)))
+		})
+	})
+
+	Context("When Users are changed in bulk", func() {
+		It("Should store nothing when an operation fails", func() {
+			var users []*UserResponse
+			for _, name := range []string{"First", "Second"} {
+				created := createUser(&CreateUserRequest{
+					Email:     "bulk-" + name + "@eskit.com",
+					FirstName: name,
+					LastName:  "Eskit",
+				})
+
+				resp := patchUser(created.Originator.ID, 1, `[{"op":"replace","path":"/lastName","value":"Updated"}]`)
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				users = append(users, created)
+			}
+
+			jsonData, err := json.Marshal(map[string]interface{}{
+				"operations": []*BulkUserOperation{
+					{Op: "create", Data: &CreateUserRequest{Email: "bulk-created@eskit.com"}},
+					{Op: "update", ID: users[0].Originator.ID, Version: 2, Data: map[string]string{"firstName": "Renamed"}},
+					// the version 1 is stale, it fails the whole request
+					{Op: "update", ID: users[1].Originator.ID, Version: 1, Data: map[string]string{"firstName": "Renamed"}},
+				},
+			})
+			Expect(err).To(BeNil())
+
+			bulkResp, err := httpClient.Post(baseURL+"/bulk", "application/json", bytes.NewBuffer(jsonData))
+			Expect(err).To(BeNil())
+			defer bulkResp.Body.Close()
+			Expect(bulkResp.StatusCode).To(Equal(http.StatusConflict))
+
+			var bulk BulkUsersResponse
+			Expect(json.NewDecoder(bulkResp.Body).Decode(&bulk)).To(BeNil())
+			Expect(bulk.Results).To(HaveLen(3))
+			Expect(bulk.Results[0].Error).To(Equal("aborted"))
+			Expect(bulk.Results[1].Error).To(Equal("aborted"))
+			Expect(bulk.Results[2].Error).To(Equal("conflict"))
+
+			// the valid update was rolled back with the rest
+			for i, name := range []string{"First", "Second"} {
+				user := getUser(users[i].Originator.ID)
+				Expect(user.FirstName).To(Equal(name))
+				Expect(user.Originator.Version).To(Equal(uint64(2)))
+			}
+		})
+	})
+
+	Context("When Users are listed asOf", func() {
+		It("Should return the state they had then", func() {
+			created := createUser(&CreateUserRequest{
+				Email:     "asof@eskit.com",
+				FirstName: "Before",
+				LastName:  "Eskit",
+			})
+			asOf := time.Now().UTC()
+
+			resp := patchUser(created.Originator.ID, 1, `[{"op":"replace","path":"/firstName","value":"After"}]`)
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var found *UserResponse
+			cursor := ""
+			for {
+				listURL := fmt.Sprintf("%s?asOf=%s&size=100&cursor=%s", baseURL, url.QueryEscape(asOf.Format(time.RFC3339Nano)), url.QueryEscape(cursor))
+				listResp, err := httpClient.Get(listURL)
+				Expect(err).To(BeNil())
+				Expect(listResp.StatusCode).To(Equal(http.StatusOK))
+
+				var list ListUsersResponse
+				err = json.NewDecoder(listResp.Body).Decode(&list)
+				listResp.Body.Close()
+				Expect(err).To(BeNil())
+
+				for _, user := range list.Users {
+					if user.Originator.ID == created.Originator.ID {
+						found = user
+					}
+				}
+
+				if list.NextCursor == "" {
+					break
+				}
+				cursor = list.NextCursor
+			}
+
+			Expect(found).NotTo(BeNil())
+			Expect(found.FirstName).To(Equal("Before"))
+			Expect(found.Originator.Version).To(Equal(uint64(1)))
+			Expect(getUser(created.Originator.ID).FirstName).To(Equal("After"))
+		})
+	})
 })